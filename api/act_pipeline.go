@@ -6,6 +6,7 @@ import (
 	"github.com/rotblauer/catd/daemon/tiled"
 	"github.com/rotblauer/catd/geo/act"
 	"github.com/rotblauer/catd/geo/clean"
+	"github.com/rotblauer/catd/geo/lap"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/stream"
 	"github.com/rotblauer/catd/types/activity"
@@ -15,6 +16,12 @@ import (
 )
 
 func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) error {
+	return c.catActPipeline(ctx, in, true, true)
+}
+
+// catActPipeline runs lap and/or nap detection.
+// A disabled detector's tracks are discarded and its state is left untouched.
+func (c *Cat) catActPipeline(ctx context.Context, in <-chan cattrack.CatTrack, doLaps, doNaps bool) error {
 
 	lapTracks := make(chan cattrack.CatTrack, params.DefaultChannelCap)
 	napTracks := make(chan cattrack.CatTrack, params.DefaultChannelCap)
@@ -22,7 +29,13 @@ func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) e
 	// TrackLaps will send completed laps. Incomplete laps are persisted in KV
 	// and restored on cat restart.
 	// Act-detection logic below will flush the last lap if the cat is sufficiently napping.
-	ls, completedLaps := c.TrackLaps(ctx, lapTracks)
+	var ls *lap.State
+	var completedLaps <-chan cattrack.CatLap
+	if doLaps {
+		ls, completedLaps = c.TrackLaps(ctx, lapTracks)
+	} else {
+		completedLaps = discardTo[cattrack.CatLap](lapTracks)
+	}
 
 	// Simplify the lap geometry.
 	simplified := stream.Transform(ctx, func(ct cattrack.CatLap) cattrack.CatLap {
@@ -48,7 +61,12 @@ func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) e
 
 	// TrackNaps will send completed naps. Incomplete naps are persisted in KV
 	// and restored on cat restart.
-	completedNaps := c.TrackNaps(ctx, napTracks)
+	var completedNaps <-chan cattrack.CatNap
+	if doNaps {
+		completedNaps = c.TrackNaps(ctx, napTracks)
+	} else {
+		completedNaps = discardTo[cattrack.CatNap](napTracks)
+	}
 	filteredNaps := stream.Filter(ctx, clean.FilterNaps, completedNaps)
 
 	// End of the line for all cat naps...
@@ -59,9 +77,18 @@ func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) e
 
 	expectedErrsN := 4 // 2 sink, 2 send.
 	errCh := make(chan error, expectedErrsN)
-	lapsNapsMap := map[string]<-chan cattrack.CatTrack{
-		params.LapsGZFileName: stream.Transform(ctx, cattrack.Lap2Track, sinkLaps),
-		params.NapsGZFileName: stream.Transform(ctx, cattrack.Nap2Track, sinkNaps),
+	lapsNapsMap := map[string]<-chan cattrack.CatTrack{}
+	if doLaps {
+		lapsNapsMap[params.LapsGZFileName] = stream.Transform(ctx, cattrack.Lap2Track, sinkLaps)
+	} else {
+		stream.Blackhole(sinkLaps)
+		errCh <- nil
+	}
+	if doNaps {
+		lapsNapsMap[params.NapsGZFileName] = stream.Transform(ctx, cattrack.Nap2Track, sinkNaps)
+	} else {
+		stream.Blackhole(sinkNaps)
+		errCh <- nil
 	}
 	for to, ch := range lapsNapsMap {
 		go func(ch <-chan cattrack.CatTrack, path string) {
@@ -171,7 +198,7 @@ func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) e
 				lapTracks <- ct
 			} else {
 				// Flush last lap if cat is sufficiently napping.
-				if !lastActiveTime.IsZero() &&
+				if ls != nil && !lastActiveTime.IsZero() &&
 					ct.MustTime().Sub(lastActiveTime) > params.DefaultLapConfig.Interval {
 					ls.Bump()
 					lastActiveTime = time.Time{}
@@ -186,3 +213,14 @@ func (c *Cat) CatActPipeline(ctx context.Context, in <-chan cattrack.CatTrack) e
 	notifyWG.Wait()
 	return nil
 }
+
+// discardTo drains in, returning a channel of T which closes when in does.
+func discardTo[T any](in <-chan cattrack.CatTrack) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for range in {
+		}
+	}()
+	return out
+}
@@ -33,6 +33,22 @@ type Cat struct {
 	logger        *slog.Logger
 	completedLaps event.FeedOf[cattrack.CatLap]
 	completedNaps event.FeedOf[cattrack.CatNap]
+
+	// storedTracks and storedSnaps send the tracks and snaps stored by Populate.
+	storedTracks event.FeedOf[cattrack.CatTrack]
+	storedSnaps  event.FeedOf[cattrack.CatTrack]
+
+	// geofenceEvents sends the events of the cat's geofences.
+	geofenceEvents event.FeedOf[GeofenceEvent]
+
+	// receipt, if non-nil, is the receipt of the running Populate.
+	receipt *PopulateReceipt
+
+	// privacyZones caches the zones read from state by PrivacyZones.
+	privacyZones PrivacyZones
+
+	// snaps, if non-nil, overrides the default snap store, see SetSnapStore.
+	snaps SnapStore
 }
 
 // NewCat inits a new Cat, but it does not access state.
@@ -53,6 +69,10 @@ func NewCat(catID conceptual.CatID, datadir string, backend *params.CatRPCServic
 		logger:        logger,
 		completedLaps: event.FeedOf[cattrack.CatLap]{},
 		completedNaps: event.FeedOf[cattrack.CatNap]{},
+
+		storedTracks:   event.FeedOf[cattrack.CatTrack]{},
+		storedSnaps:    event.FeedOf[cattrack.CatTrack]{},
+		geofenceEvents: event.FeedOf[GeofenceEvent]{},
 	}
 
 	if c.IsTilingRPCEnabled() {
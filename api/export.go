@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/export"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// matchSpan returns true if a lap or nap spanning start to end, with geometry g,
+// satisfies the query's time, bound, and activity filters.
+func (q *TracksQuery) matchSpan(start, end time.Time, g orb.Geometry, act activity.Activity) bool {
+	if !q.Start.IsZero() && end.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && start.After(q.End) {
+		return false
+	}
+	if q.Bound != nil && (g == nil || !q.Bound.Intersects(g.Bound())) {
+		return false
+	}
+	if len(q.Activities) > 0 && !slices.Contains(q.Activities, act) {
+		return false
+	}
+	return true
+}
+
+// spanOf returns the start and end times of a lap or nap.
+func spanOf(ct cattrack.CatTrack) (start, end time.Time) {
+	return time.Unix(int64(ct.Properties.MustFloat64("Time_Start_Unix", 0)), 0),
+		time.Unix(int64(ct.Properties.MustFloat64("Time_End_Unix", 0)), 0)
+}
+
+// readLapsOrNaps reads the cat's laps or naps file, sending those matching the query to fn.
+// A missing file has none.
+func (c *Cat) readLapsOrNaps(name string, q *TracksQuery, stationary bool, fn func(ct cattrack.CatTrack)) error {
+	c.getOrInitState(true)
+	r, err := c.State.Flat.NewGZFileReader(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for {
+		ct := cattrack.CatTrack{}
+		if err := dec.Decode(&ct); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		act := activity.TrackerStateStationary
+		if !stationary {
+			act = ct.MustActivity()
+		}
+		start, end := spanOf(ct)
+		if q.matchSpan(start, end, ct.Geometry, act) {
+			fn(ct)
+		}
+	}
+}
+
+// QueryLaps returns the cat's laps overlapping the query's time range and bound,
+// and having any of its activities. The query limit does not apply.
+func (c *Cat) QueryLaps(q *TracksQuery) ([]cattrack.CatLap, error) {
+	if q == nil {
+		q = &TracksQuery{}
+	}
+	laps := []cattrack.CatLap{}
+	err := c.readLapsOrNaps(params.LapsGZFileName, q, false, func(ct cattrack.CatTrack) {
+		laps = append(laps, cattrack.CatLap(ct))
+	})
+	return laps, err
+}
+
+// QueryNaps returns the cat's naps overlapping the query's time range and bound.
+// Naps are stationary, so are excluded by queries for other activities.
+// The query limit does not apply.
+func (c *Cat) QueryNaps(q *TracksQuery) ([]cattrack.CatNap, error) {
+	if q == nil {
+		q = &TracksQuery{}
+	}
+	naps := []cattrack.CatNap{}
+	err := c.readLapsOrNaps(params.NapsGZFileName, q, true, func(ct cattrack.CatTrack) {
+		naps = append(naps, cattrack.CatNap(ct))
+	})
+	return naps, err
+}
+
+// Export writes the cat's tracks, laps and naps matching the query in the export format.
+// Zones, if any, privatize everything written; see PrivacyZones.
+func (c *Cat) Export(ctx context.Context, w io.Writer, format export.Format, q *TracksQuery, zones PrivacyZones) error {
+	if q == nil {
+		q = &TracksQuery{}
+	}
+	laps, err := c.QueryLaps(q)
+	if err != nil {
+		return err
+	}
+	naps, err := c.QueryNaps(q)
+	if err != nil {
+		return err
+	}
+	ex := &export.Export{Name: c.CatID.String()}
+	for _, lap := range laps {
+		if lap, ok := privatizeFeature(zones, lap); ok {
+			ex.Laps = append(ex.Laps, lap)
+		}
+	}
+	for _, nap := range naps {
+		if nap, ok := privatizeFeature(zones, nap); ok {
+			ex.Naps = append(ex.Naps, nap)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tracks, errs := c.QueryTracks(ctx, q)
+	tracks = privatizeStream(ctx, zones, tracks)
+	if err := export.Write(w, format, ex, tracks); err != nil {
+		// Stop and drain the query.
+		cancel()
+		for range tracks {
+		}
+		<-errs
+		return err
+	}
+	return <-errs
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/rotblauer/catd/export"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"testing"
+	"time"
+)
+
+func TestCat_Export(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracks := syntheticTracks(t, start, time.Minute, 10)
+	if err := <-c.StoreTracksYYYYMM(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+
+	ptrs := func(tracks []cattrack.CatTrack) []*cattrack.CatTrack {
+		out := []*cattrack.CatTrack{}
+		for i := range tracks {
+			out = append(out, &tracks[i])
+		}
+		return out
+	}
+	write := func(name string, v any) {
+		w, err := c.State.Flat.NewGZFileWriter(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(params.LapsGZFileName, cattrack.NewCatLap(ptrs(tracks[3:7])))
+	write(params.NapsGZFileName, cattrack.NewCatNap(ptrs(tracks[7:10])))
+
+	laps, err := c.QueryLaps(&TracksQuery{End: start.Add(2 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(laps) != 0 {
+		t.Errorf("expected no laps before the lap, got %d", len(laps))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := c.Export(ctx, buf, export.FormatGPX, &TracksQuery{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	gpx := struct {
+		Wpts []struct{} `xml:"wpt"`
+		Trk  struct {
+			Segs []struct {
+				Pts []struct{} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &gpx); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if len(gpx.Wpts) != 1 {
+		t.Errorf("expected a nap waypoint, got %d", len(gpx.Wpts))
+	}
+	// Before, during, and after the lap.
+	want := []int{3, 4, 3}
+	if len(gpx.Trk.Segs) != len(want) {
+		t.Fatalf("expected %d segments, got %d", len(want), len(gpx.Trk.Segs))
+	}
+	for i, n := range want {
+		if got := len(gpx.Trk.Segs[i].Pts); got != n {
+			t.Errorf("segment %d: expected %d points, got %d", i, n, got)
+		}
+	}
+}
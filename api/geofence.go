@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/cattrack"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// GeofenceEventType is the type of geofence event.
+type GeofenceEventType string
+
+const (
+	// GeofenceEventEnter is emitted when a cat's track is first inside the fence.
+	GeofenceEventEnter GeofenceEventType = "enter"
+	// GeofenceEventExit is emitted when a cat's track is first outside the fence, after being inside.
+	GeofenceEventExit GeofenceEventType = "exit"
+	// GeofenceEventDwell is emitted once per visit, when a cat has been inside the fence for its dwell duration.
+	GeofenceEventDwell GeofenceEventType = "dwell"
+)
+
+// Geofence is a named polygon a cat's tracks are evaluated against.
+type Geofence struct {
+	Name    string      `json:"name"`
+	Polygon orb.Polygon `json:"polygon"`
+
+	// DwellSeconds, if positive, is how long a cat must stay inside
+	// the fence before a dwell event is emitted.
+	DwellSeconds float64 `json:"dwell_seconds,omitempty"`
+}
+
+// Validate returns an error if the fence is not usable.
+func (g Geofence) Validate() error {
+	if g.Name == "" {
+		return errors.New("geofence name is required")
+	}
+	if len(g.Polygon) == 0 || len(g.Polygon[0]) < 4 {
+		return fmt.Errorf("geofence %q: polygon ring needs at least 4 points", g.Name)
+	}
+	if g.DwellSeconds < 0 {
+		return fmt.Errorf("geofence %q: negative dwell", g.Name)
+	}
+	return nil
+}
+
+// Dwell returns the fence's dwell duration, or zero for none.
+func (g Geofence) Dwell() time.Duration {
+	return time.Duration(g.DwellSeconds * float64(time.Second))
+}
+
+// Geofences are a cat's geofences.
+type Geofences []Geofence
+
+// Validate returns the first invalid fence error, or an error for duplicate names.
+func (gs Geofences) Validate() error {
+	seen := map[string]bool{}
+	for _, g := range gs {
+		if err := g.Validate(); err != nil {
+			return err
+		}
+		if seen[g.Name] {
+			return fmt.Errorf("duplicate geofence name %q", g.Name)
+		}
+		seen[g.Name] = true
+	}
+	return nil
+}
+
+// GeofenceEvent is an event of a cat entering, exiting, or dwelling in a geofence.
+// Events carry no coordinates; the fence names the place.
+type GeofenceEvent struct {
+	CatID conceptual.CatID  `json:"cat"`
+	Fence string            `json:"fence"`
+	Type  GeofenceEventType `json:"type"`
+	// Time is the time of the track causing the event.
+	Time time.Time `json:"time"`
+	// Since is the time the cat entered the fence, for exit and dwell events.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// geofenceVisit is the persisted state of a cat with respect to one fence.
+type geofenceVisit struct {
+	Inside  bool      `json:"inside"`
+	Since   time.Time `json:"since"`
+	Dwelled bool      `json:"dwelled"`
+}
+
+// geofenceState is the persisted state of the geofence engine, by fence name.
+type geofenceState struct {
+	LastTime time.Time                 `json:"last_time"`
+	Visits   map[string]*geofenceVisit `json:"visits"`
+}
+
+// evaluate returns the events caused by the track at time t and point pt, updating the state.
+// Tracks older than the last evaluated track are ignored.
+func (st *geofenceState) evaluate(catID conceptual.CatID, fences Geofences, t time.Time, pt orb.Point) []GeofenceEvent {
+	if t.Before(st.LastTime) {
+		return nil
+	}
+	st.LastTime = t
+	var events []GeofenceEvent
+	for _, g := range fences {
+		inside := planar.PolygonContains(g.Polygon, pt)
+		v, ok := st.Visits[g.Name]
+		if !ok {
+			v = &geofenceVisit{}
+			st.Visits[g.Name] = v
+		}
+		switch {
+		case inside && !v.Inside:
+			*v = geofenceVisit{Inside: true, Since: t}
+			events = append(events, GeofenceEvent{CatID: catID, Fence: g.Name, Type: GeofenceEventEnter, Time: t})
+		case !inside && v.Inside:
+			since := v.Since
+			events = append(events, GeofenceEvent{CatID: catID, Fence: g.Name, Type: GeofenceEventExit, Time: t, Since: &since})
+			*v = geofenceVisit{}
+		}
+		if v.Inside && !v.Dwelled && g.Dwell() > 0 && t.Sub(v.Since) >= g.Dwell() {
+			v.Dwelled = true
+			since := v.Since
+			events = append(events, GeofenceEvent{CatID: catID, Fence: g.Name, Type: GeofenceEventDwell, Time: t, Since: &since})
+		}
+	}
+	// Forget the visits of removed fences.
+	for name := range st.Visits {
+		found := false
+		for _, g := range fences {
+			if g.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(st.Visits, name)
+		}
+	}
+	return events
+}
+
+// Geofences returns the cat's geofences. The cat state must be open.
+func (c *Cat) Geofences() (Geofences, error) {
+	if c.State == nil || !c.State.IsOpen() {
+		return nil, errors.New("cat state not open")
+	}
+	fences := Geofences{}
+	data, err := c.State.ReadKV(params.CatStateBucket, params.CatStateKey_Geofences)
+	if err != nil {
+		// No state bucket (new cat?), so no fences.
+		c.logger.Debug("Did not read geofences", "error", err)
+		return fences, nil
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fences); err != nil {
+			return nil, fmt.Errorf("geofences: %w", err)
+		}
+	}
+	return fences, nil
+}
+
+// SetGeofences validates and stores the cat's geofences, replacing any existing.
+// The cat state must be open for writing. Empty fences clears them.
+func (c *Cat) SetGeofences(fences Geofences) error {
+	if err := fences.Validate(); err != nil {
+		return err
+	}
+	if c.State == nil || !c.State.IsOpen() {
+		return errors.New("cat state not open")
+	}
+	if fences == nil {
+		fences = Geofences{}
+	}
+	return c.State.StoreKVMarshalJSON(params.CatStateBucket, params.CatStateKey_Geofences, fences)
+}
+
+// SubscribeGeofenceEvents subscribes the channel to the cat's geofence events.
+// Events are sent as tracks are evaluated, eg. during Populate.
+func (c *Cat) SubscribeGeofenceEvents(ch chan<- GeofenceEvent) event.Subscription {
+	return c.geofenceEvents.Subscribe(ch)
+}
+
+// GeofenceTracks evaluates tracks against the cat's geofences,
+// persisting and sending any events.
+// It is a blocking function.
+func (c *Cat) GeofenceTracks(ctx context.Context, in <-chan cattrack.CatTrack) error {
+	fences, err := c.Geofences()
+	if err != nil {
+		for range in {
+		}
+		return err
+	}
+	if len(fences) == 0 {
+		for range in {
+		}
+		return nil
+	}
+
+	st := &geofenceState{Visits: map[string]*geofenceVisit{}}
+	if err := c.State.ReadKVUnmarshalJSON(params.CatStateBucket, params.CatStateKey_GeofenceState, st); err != nil {
+		c.logger.Debug("Did not read geofence state (new cat?)", "error", err)
+	}
+	if st.Visits == nil {
+		st.Visits = map[string]*geofenceVisit{}
+	}
+
+	var events []GeofenceEvent
+	for ct := range in {
+		events = append(events, st.evaluate(c.CatID, fences, ct.MustTime(), ct.Point())...)
+	}
+	if err := c.storeGeofenceEvents(events); err != nil {
+		return err
+	}
+	if err := c.State.StoreKVMarshalJSON(params.CatStateBucket, params.CatStateKey_GeofenceState, st); err != nil {
+		return err
+	}
+	for _, e := range events {
+		c.logger.Info("Geofence event", "fence", e.Fence, "type", e.Type, "time", e.Time)
+		c.geofenceEvents.Send(e)
+	}
+	return nil
+}
+
+// geofenceEventKey orders events by time, then by sequence.
+func geofenceEventKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func (c *Cat) storeGeofenceEvents(events []GeofenceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return c.State.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(params.CatGeofenceEventsBucket)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(geofenceEventKey(e.Time, seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GeofenceEvents returns the cat's stored geofence events at or after since, oldest first.
+// A non-positive limit returns all. The cat state must be open.
+func (c *Cat) GeofenceEvents(since time.Time, limit int) ([]GeofenceEvent, error) {
+	if c.State == nil || !c.State.IsOpen() {
+		return nil, errors.New("cat state not open")
+	}
+	out := []GeofenceEvent{}
+	err := c.State.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(params.CatGeofenceEventsBucket)
+		if b == nil {
+			return nil
+		}
+		cur := b.Cursor()
+		k, v := cur.First()
+		if !since.IsZero() {
+			k, v = cur.Seek(geofenceEventKey(since, 0))
+		}
+		for ; k != nil; k, v = cur.Next() {
+			e := GeofenceEvent{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			out = append(out, e)
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// resetGeofences deletes the geofence engine state and stored events, but not the fences.
+func (c *Cat) resetGeofences() error {
+	if err := c.resetStateAndFiles(params.CatStateKey_GeofenceState); err != nil {
+		return err
+	}
+	return c.State.DB.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(params.CatGeofenceEventsBucket) == nil {
+			return nil
+		}
+		return tx.DeleteBucket(params.CatGeofenceEventsBucket)
+	})
+}
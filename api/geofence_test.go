@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/stream"
+	"testing"
+	"time"
+)
+
+func TestCat_GeofenceTracks(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracks := syntheticTracks(t, start, time.Minute, 10)
+	p0 := tracks[0].Point()
+
+	// The fence holds tracks 3 through 6.
+	lo, hi := orb.Point{p0.Lon() + 0.0025, p0.Lat() + 0.0025}, orb.Point{p0.Lon() + 0.0065, p0.Lat() + 0.0065}
+	home := Geofence{
+		Name:         "home",
+		Polygon:      orb.Polygon{{lo, {hi.Lon(), lo.Lat()}, hi, {lo.Lon(), hi.Lat()}, lo}},
+		DwellSeconds: 120,
+	}
+	if err := c.SetGeofences(Geofences{home, home}); err == nil {
+		t.Error("expected duplicate name error")
+	}
+	if err := c.SetGeofences(Geofences{home}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan GeofenceEvent)
+	sub := c.SubscribeGeofenceEvents(ch)
+	sent := []GeofenceEvent{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range ch {
+			sent = append(sent, e)
+		}
+	}()
+
+	// Evaluate in two batches; the visit carries over.
+	ctx := context.Background()
+	if err := c.GeofenceTracks(ctx, stream.Slice(ctx, tracks[:5])); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GeofenceTracks(ctx, stream.Slice(ctx, tracks[5:])); err != nil {
+		t.Fatal(err)
+	}
+	sub.Unsubscribe()
+	close(ch)
+	<-done
+
+	want := []struct {
+		typ GeofenceEventType
+		i   int
+	}{
+		{GeofenceEventEnter, 3},
+		{GeofenceEventDwell, 5},
+		{GeofenceEventExit, 7},
+	}
+	events, err := c.GeofenceEvents(time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(want) || len(sent) != len(want) {
+		t.Fatalf("expected %d events, got stored=%v sent=%v", len(want), events, sent)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Type != w.typ || e.Fence != "home" || !e.Time.Equal(tracks[w.i].MustTime()) {
+			t.Errorf("event %d: got %+v, want %s at track %d", i, e, w.typ, w.i)
+		}
+		if sent[i].Type != e.Type {
+			t.Errorf("event %d: sent %s, stored %s", i, sent[i].Type, e.Type)
+		}
+	}
+	if events[0].Since != nil {
+		t.Errorf("expected no since for enter, got %v", events[0].Since)
+	}
+	if events[2].Since == nil || !events[2].Since.Equal(tracks[3].MustTime()) {
+		t.Errorf("expected exit since enter, got %v", events[2].Since)
+	}
+
+	since, err := c.GeofenceEvents(tracks[5].MustTime(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 1 || since[0].Type != GeofenceEventDwell {
+		t.Errorf("expected dwell since track 5, got %v", since)
+	}
+
+	// Old tracks are ignored.
+	if err := c.GeofenceTracks(ctx, stream.Slice(ctx, tracks[3:4])); err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := c.GeofenceEvents(time.Time{}, 0); len(events) != len(want) {
+		t.Errorf("expected old tracks ignored, got %d events", len(events))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dustin/go-humanize"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/rotblauer/catd/daemon/tiled"
 	"github.com/rotblauer/catd/metrics/influxdb"
 	"github.com/rotblauer/catd/params"
@@ -92,6 +93,27 @@ func (c *Cat) SubscribeFancyLogs() {
 	}()
 }
 
+// SubscribeStoredTracks subscribes the channel to the tracks stored by Populate,
+// after validation, deduplication and sanitization, as each is written to the cat's tracks.
+func (c *Cat) SubscribeStoredTracks(ch chan<- cattrack.CatTrack) event.Subscription {
+	return c.storedTracks.Subscribe(ch)
+}
+
+// SubscribeStoredSnaps subscribes the channel to the snaps stored by Populate.
+func (c *Cat) SubscribeStoredSnaps(ch chan<- cattrack.CatTrack) event.Subscription {
+	return c.storedSnaps.Subscribe(ch)
+}
+
+// SubscribeCompletedLaps subscribes the channel to the cat's completed laps.
+func (c *Cat) SubscribeCompletedLaps(ch chan<- cattrack.CatLap) event.Subscription {
+	return c.completedLaps.Subscribe(ch)
+}
+
+// SubscribeCompletedNaps subscribes the channel to the cat's completed naps.
+func (c *Cat) SubscribeCompletedNaps(ch chan<- cattrack.CatNap) event.Subscription {
+	return c.completedNaps.Subscribe(ch)
+}
+
 func (c *Cat) ExportInfluxDB(tracks []cattrack.CatTrack) error {
 	if params.INFLUXDB_URL == "" {
 		return errors.New("InfluxDB not configured")
@@ -101,6 +123,18 @@ func (c *Cat) ExportInfluxDB(tracks []cattrack.CatTrack) error {
 
 // Populate persists incoming CatTracks for one cat.
 func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTrack) error {
+	_, err := c.PopulateWithReceipt(ctx, sort, in)
+	return err
+}
+
+// PopulateWithReceipt is Populate, returning a receipt summarizing the work done.
+// The receipt is returned even if Populate fails, reflecting the work done until then.
+func (c *Cat) PopulateWithReceipt(ctx context.Context, sort bool, in <-chan cattrack.CatTrack) (*PopulateReceipt, error) {
+	receipt := newPopulateReceipt(c.CatID)
+	return receipt, c.populate(ctx, sort, in, receipt)
+}
+
+func (c *Cat) populate(ctx context.Context, sort bool, in <-chan cattrack.CatTrack, receipt *PopulateReceipt) error {
 	var cancelCtx context.CancelFunc
 	ctx, cancelCtx = context.WithCancel(ctx)
 
@@ -113,6 +147,13 @@ func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTra
 		return err
 	}
 	c.logger.Info("Populate has the lock on state conn")
+	c.receipt = receipt
+
+	// Read privacy zones up front, so they are ready for any public outputs,
+	// including those made by the caller after populate, eg. broadcasts.
+	if _, err := c.PrivacyZones(); err != nil {
+		c.logger.Error("Failed to read privacy zones", "error", err)
+	}
 
 	started := time.Now()
 	defer func() {
@@ -121,12 +162,18 @@ func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTra
 			c.logger.Error("Failed to close cat state", "error", err)
 			l = c.logger.Error
 		}
+		c.receipt = nil
 		l("Populate done",
 			"elapsed", time.Since(started).Round(time.Millisecond))
 	}()
 
+	received := stream.Transform(ctx, func(ct cattrack.CatTrack) cattrack.CatTrack {
+		receipt.noteReceived()
+		return ct
+	}, in)
+
 	// Validate, dedupe, sanitize.
-	valid, invalid := c.Validate(ctx, in)
+	valid, invalid := c.Validate(ctx, received)
 	c.waitHandleInvalid(ctx, invalid, c.State.Waiting)
 	deduped := c.dedupe(ctx, params.DedupeCacheSize, valid)
 	sanitized := stream.Transform(ctx, cattrack.Sanitize, deduped)
@@ -176,7 +223,12 @@ func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTra
 
 	// Snap storage mutates the original snap tracks.
 	snapped, snapErrs := c.StoreSnaps(ctx, yesSnaps)
-	sinkSnaps, sendSnaps := stream.Tee(ctx, snapped)
+	receipted := stream.Transform(ctx, func(ct cattrack.CatTrack) cattrack.CatTrack {
+		receipt.noteSnap(ct.MustS3Key())
+		c.storedSnaps.Send(ct)
+		return ct
+	}, snapped)
+	sinkSnaps, sendSnaps := stream.Tee(ctx, receipted)
 
 	sinkSnapErrs := make(chan error, 1)
 	go func() {
@@ -223,12 +275,19 @@ func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTra
 	storeErrs := make(chan error, 1)
 	go func() {
 		defer close(storeErrs)
-		err := <-c.StoreTracksYYYYMM(ctx, storeCh)
+		tally := &storedTally{}
+		err := <-c.storeTracksYYYYMM(ctx, storeCh, func(ct cattrack.CatTrack) {
+			tally.add(ct)
+			c.storedTracks.Send(ct)
+		})
 		//err := <-c.StoreTracks(ctx, storeCh)
 		if err != nil {
 			c.logger.Error("Failed to store tracks", "error", err)
 			storeErrs <- err
+			return
 		}
+		// The store is done calling the tally.
+		receipt.noteStored(tally)
 	}()
 
 	pipeLineErrs := make(chan error, 1)
@@ -306,6 +365,7 @@ func (c *Cat) Populate(ctx context.Context, sort bool, in <-chan cattrack.CatTra
 }
 
 // sendToCatTileD sends a batch of features to the Cat RPC client.
+// Features are privatized by the cat's privacy zones first.
 // It is a blocking function.
 func sendToCatTileD[T any](ctx context.Context, c *Cat, args *tiled.PushFeaturesRequestArgs, in <-chan T) error {
 	if !c.IsTilingRPCEnabled() {
@@ -313,8 +373,14 @@ func sendToCatTileD[T any](ctx context.Context, c *Cat, args *tiled.PushFeatures
 		go stream.Sink(ctx, nil, in) // Black hole, does not block.
 		return nil
 	}
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		c.logger.Error("Failed to read privacy zones, not sending features", "source", args.SourceName, "error", err)
+		go stream.Sink(ctx, nil, in)
+		return err
+	}
 	buf := new(bytes.Buffer)
-	n, err := sinkStreamToJSONGZWriter(ctx, buf, in)
+	n, err := sinkStreamToJSONGZWriter(ctx, buf, privatizeStream(ctx, zones, in))
 	if err != nil {
 		c.logger.Error("Failed to sink stream to JSON GZ writer", "error", err)
 		return err
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/types/cattrack"
+	"sync"
+	"time"
+)
+
+// populateReceiptMaxErrors caps the number of distinct errors kept by a receipt.
+const populateReceiptMaxErrors = 100
+
+// PopulateReceipt summarizes what one Populate call did with its tracks,
+// so that clients can know without tailing logs.
+type PopulateReceipt struct {
+	CatID conceptual.CatID `json:"cat"`
+
+	// Received is the number of tracks read from the input.
+	Received int `json:"received"`
+	// Invalid is the number of tracks failing validation.
+	Invalid int `json:"invalid"`
+	// Deduped is the number of valid tracks dropped as duplicates.
+	Deduped int `json:"deduped"`
+	// Stored is the number of (non-snap) tracks written to the YYYY-MM archives.
+	Stored int `json:"stored"`
+
+	// FirstTime and LastTime are the min and max times of the stored tracks.
+	FirstTime *time.Time `json:"first_time,omitempty"`
+	LastTime  *time.Time `json:"last_time,omitempty"`
+
+	// Snaps are the keys of the snaps imported.
+	Snaps []string `json:"snaps"`
+
+	// Errors are distinct non-fatal errors, eg. invalid tracks and failed pushes to tiled.
+	// Fatal errors are returned by Populate.
+	Errors []string `json:"errors"`
+
+	mu sync.Mutex
+}
+
+func newPopulateReceipt(catID conceptual.CatID) *PopulateReceipt {
+	return &PopulateReceipt{
+		CatID:  catID,
+		Snaps:  []string{},
+		Errors: []string{},
+	}
+}
+
+// The note methods are safe to call on a nil receipt,
+// so that handlers can be used outside of Populate.
+
+func (r *PopulateReceipt) noteReceived() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Received++
+}
+
+func (r *PopulateReceipt) noteInvalid(reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Invalid++
+	r.mu.Unlock()
+	r.AddError("invalid track: " + reason)
+}
+
+func (r *PopulateReceipt) noteDeduped() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Deduped++
+}
+
+// storedTally tallies tracks as they are written to the YYYY-MM archives,
+// so that they are noted on the receipt only once the store succeeds.
+type storedTally struct {
+	n           int
+	first, last *time.Time
+}
+
+func (t *storedTally) add(ct cattrack.CatTrack) {
+	t.n++
+	tt, err := ct.Time()
+	if err != nil {
+		return
+	}
+	if t.first == nil || tt.Before(*t.first) {
+		t.first = &tt
+	}
+	if t.last == nil || tt.After(*t.last) {
+		t.last = &tt
+	}
+}
+
+func (r *PopulateReceipt) noteStored(t *storedTally) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Stored += t.n
+	if t.first != nil && (r.FirstTime == nil || t.first.Before(*r.FirstTime)) {
+		r.FirstTime = t.first
+	}
+	if t.last != nil && (r.LastTime == nil || t.last.After(*r.LastTime)) {
+		r.LastTime = t.last
+	}
+}
+
+func (r *PopulateReceipt) noteSnap(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Snaps = append(r.Snaps, key)
+}
+
+// AddError adds a distinct non-fatal error to the receipt, up to populateReceiptMaxErrors.
+// Like the note methods, it is safe to call on a nil receipt.
+func (r *PopulateReceipt) AddError(msg string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Errors) >= populateReceiptMaxErrors {
+		return
+	}
+	for _, e := range r.Errors {
+		if e == msg {
+			return
+		}
+	}
+	r.Errors = append(r.Errors, msg)
+}
@@ -15,6 +15,7 @@ import (
 	"log/slog"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSinkStreamToJSONWriter(t *testing.T) {
@@ -182,3 +183,65 @@ func testCat_Populate(t *testing.T, cat, source string, wantStoreCount, wantProd
 		t.Log(string(j))
 	}
 }
+
+func TestCat_PopulateWithReceipt(t *testing.T) {
+	defer common.SlogResetLevel(slog.Level(slog.LevelWarn + 1))()
+
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	start := time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local)
+	tracks := syntheticTracks(t, start, time.Minute, 20)
+
+	// 3 duplicates and 1 mismatched cat.
+	// Duplicates are fresh copies since populate mutates track properties.
+	tracks = append(tracks, syntheticTracks(t, start, time.Minute, 3)...)
+	other := syntheticTracks(t, start.Add(time.Hour), time.Minute, 1)[0]
+	other.SetPropertySafe("Name", "ia")
+	tracks = append(tracks, other)
+
+	receipt, err := c.PopulateWithReceipt(ctx, true, stream.Slice(ctx, tracks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.CatID != "rye" {
+		t.Errorf("cat: got %q, want rye", receipt.CatID)
+	}
+	if receipt.Received != 24 {
+		t.Errorf("received: got %d, want 24", receipt.Received)
+	}
+	if receipt.Invalid != 1 {
+		t.Errorf("invalid: got %d, want 1", receipt.Invalid)
+	}
+	if receipt.Deduped != 3 {
+		t.Errorf("deduped: got %d, want 3", receipt.Deduped)
+	}
+	if receipt.Stored != 20 {
+		t.Errorf("stored: got %d, want 20", receipt.Stored)
+	}
+	if receipt.FirstTime == nil || !receipt.FirstTime.Equal(start) {
+		t.Errorf("first time: got %v, want %v", receipt.FirstTime, start)
+	}
+	if want := start.Add(19 * time.Minute); receipt.LastTime == nil || !receipt.LastTime.Equal(want) {
+		t.Errorf("last time: got %v, want %v", receipt.LastTime, want)
+	}
+	if len(receipt.Errors) == 0 {
+		t.Error("expected an invalid track error")
+	}
+	if c.receipt != nil {
+		t.Error("expected the receipt to be cleared after populate")
+	}
+
+	// Errors are distinct and capped.
+	for i := 0; i < 2*populateReceiptMaxErrors; i++ {
+		receipt.AddError(fmt.Sprintf("error %d", i%(populateReceiptMaxErrors+10)))
+	}
+	if len(receipt.Errors) != populateReceiptMaxErrors {
+		t.Errorf("errors: got %d, want %d", len(receipt.Errors), populateReceiptMaxErrors)
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+	"github.com/rotblauer/catd/params"
+	catS2 "github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/types/cattrack"
+)
+
+// PrivacyZoneMode is what happens to points inside a privacy zone.
+type PrivacyZoneMode string
+
+const (
+	// PrivacyZoneModeSnap moves points inside the zone to the zone's center.
+	PrivacyZoneModeSnap PrivacyZoneMode = "snap"
+	// PrivacyZoneModeOmit drops points inside the zone.
+	PrivacyZoneModeOmit PrivacyZoneMode = "omit"
+)
+
+// PrivacyZone is an area, like home or work, where a cat's real whereabouts
+// should not be published. A zone is either a polygon or a radius around a center.
+//
+// Zones only apply to public outputs: tiled pushes, websocket broadcasts,
+// and the webd read endpoints. Stored tracks keep their real points.
+type PrivacyZone struct {
+	Name string `json:"name"`
+
+	// Polygon, if set, is the zone area.
+	Polygon orb.Polygon `json:"polygon,omitempty"`
+
+	// Center and RadiusMeters define a circular zone when no polygon is set.
+	// For polygon zones, Center, if non-zero, overrides the polygon centroid as the snap point.
+	Center       orb.Point `json:"center,omitempty"`
+	RadiusMeters float64   `json:"radius_meters,omitempty"`
+
+	Mode PrivacyZoneMode `json:"mode"`
+}
+
+// Validate returns an error if the zone is not usable.
+func (z PrivacyZone) Validate() error {
+	if z.Name == "" {
+		return errors.New("privacy zone name is required")
+	}
+	switch z.Mode {
+	case PrivacyZoneModeSnap, PrivacyZoneModeOmit:
+	default:
+		return fmt.Errorf("privacy zone %q: invalid mode %q (valid: %s, %s)",
+			z.Name, z.Mode, PrivacyZoneModeSnap, PrivacyZoneModeOmit)
+	}
+	if len(z.Polygon) > 0 {
+		if len(z.Polygon[0]) < 4 {
+			return fmt.Errorf("privacy zone %q: polygon ring needs at least 4 points", z.Name)
+		}
+		return nil
+	}
+	if z.RadiusMeters <= 0 {
+		return fmt.Errorf("privacy zone %q: polygon or positive radius is required", z.Name)
+	}
+	return nil
+}
+
+// Contains returns true if the point is inside the zone.
+func (z PrivacyZone) Contains(pt orb.Point) bool {
+	if len(z.Polygon) > 0 {
+		return planar.PolygonContains(z.Polygon, pt)
+	}
+	return geo.Distance(z.Center, pt) <= z.RadiusMeters
+}
+
+// Centroid returns the point that points inside the zone are snapped to.
+func (z PrivacyZone) Centroid() orb.Point {
+	if len(z.Polygon) > 0 && z.Center == (orb.Point{}) {
+		c, _ := planar.CentroidArea(z.Polygon)
+		return c
+	}
+	return z.Center
+}
+
+// Bound returns the bounding box of the zone.
+func (z PrivacyZone) Bound() orb.Bound {
+	if len(z.Polygon) > 0 {
+		return z.Polygon.Bound()
+	}
+	return geo.NewBoundAroundPoint(z.Center, z.RadiusMeters)
+}
+
+// PrivacyZones are a cat's privacy zones.
+type PrivacyZones []PrivacyZone
+
+// Validate returns the first invalid zone error, or an error for duplicate names.
+func (zs PrivacyZones) Validate() error {
+	seen := map[string]bool{}
+	for _, z := range zs {
+		if err := z.Validate(); err != nil {
+			return err
+		}
+		if seen[z.Name] {
+			return fmt.Errorf("duplicate privacy zone name %q", z.Name)
+		}
+		seen[z.Name] = true
+	}
+	return nil
+}
+
+// Find returns the first zone containing the point, if any.
+func (zs PrivacyZones) Find(pt orb.Point) (PrivacyZone, bool) {
+	for _, z := range zs {
+		if z.Contains(pt) {
+			return z, true
+		}
+	}
+	return PrivacyZone{}, false
+}
+
+// privatizePoint returns the public point for pt, or false if it should be omitted.
+func (zs PrivacyZones) privatizePoint(pt orb.Point) (orb.Point, bool) {
+	z, ok := zs.Find(pt)
+	if !ok {
+		return pt, true
+	}
+	if z.Mode == PrivacyZoneModeOmit {
+		return pt, false
+	}
+	return z.Centroid(), true
+}
+
+// privatizeLine snaps or drops the line vertices inside zones.
+// Consecutive duplicate vertices, as from snapping, are collapsed.
+func (zs PrivacyZones) privatizeLine(ls orb.LineString) orb.LineString {
+	out := make(orb.LineString, 0, len(ls))
+	for _, pt := range ls {
+		p, ok := zs.privatizePoint(pt)
+		if !ok {
+			continue
+		}
+		if len(out) > 0 && out[len(out)-1] == p {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Geometry returns the public version of the geometry, or false if it should be omitted.
+// Points and line vertices inside zones are snapped or omitted.
+// Other geometries, like polygons, are returned unchanged;
+// S2 cells are handled by PrivatizeCell.
+// The given geometry is not modified.
+func (zs PrivacyZones) Geometry(g orb.Geometry) (orb.Geometry, bool) {
+	if len(zs) == 0 {
+		return g, true
+	}
+	switch g := g.(type) {
+	case orb.Point:
+		return zs.privatizePoint(g)
+	case orb.MultiPoint:
+		out := make(orb.MultiPoint, 0, len(g))
+		for _, pt := range g {
+			if p, ok := zs.privatizePoint(pt); ok {
+				out = append(out, p)
+			}
+		}
+		return out, len(out) > 0
+	case orb.LineString:
+		out := zs.privatizeLine(g)
+		return out, len(out) > 1
+	case orb.MultiLineString:
+		out := make(orb.MultiLineString, 0, len(g))
+		for _, ls := range g {
+			if l := zs.privatizeLine(ls); len(l) > 1 {
+				out = append(out, l)
+			}
+		}
+		return out, len(out) > 0
+	}
+	return g, true
+}
+
+// Track returns the public version of the track, or false if it should be omitted.
+// The given track is not modified, though the returned track shares its properties.
+func (zs PrivacyZones) Track(ct cattrack.CatTrack) (cattrack.CatTrack, bool) {
+	g, ok := zs.Geometry(ct.Geometry)
+	ct.Geometry = g
+	return ct, ok
+}
+
+// Tracks returns the public versions of the tracks, omitting any as needed.
+func (zs PrivacyZones) Tracks(tracks []*cattrack.CatTrack) []*cattrack.CatTrack {
+	if len(zs) == 0 {
+		return tracks
+	}
+	out := make([]*cattrack.CatTrack, 0, len(tracks))
+	for _, ct := range tracks {
+		if pub, ok := zs.Track(*ct); ok {
+			out = append(out, &pub)
+		}
+	}
+	return out
+}
+
+// PrivatizeCell returns the public version of an S2-indexed track, or false if it should be omitted.
+// Indexed tracks stand for their cell, so omission only applies to the cells
+// smaller than the zone; a bigger cell tells nothing more than the zone does,
+// and its track is always snapped to the zone's center instead.
+// Snapped tracks stay in, or move to, the cell containing the zone's center.
+func (zs PrivacyZones) PrivatizeCell(ct cattrack.CatTrack, level catS2.CellLevel) (cattrack.CatTrack, bool) {
+	if len(zs) == 0 {
+		return ct, true
+	}
+	pt := ct.Point()
+	z, ok := zs.Find(pt)
+	if !ok {
+		return ct, true
+	}
+	cellBound := catS2.GetCellGeometry(pt, level).Bound()
+	zoneBound := z.Bound()
+	bigger := cellBound.Contains(zoneBound.Min) && cellBound.Contains(zoneBound.Max)
+	if !bigger && z.Mode == PrivacyZoneModeOmit {
+		return ct, false
+	}
+	ct.Geometry = z.Centroid()
+	return ct, true
+}
+
+// Cells returns the public versions of S2-indexed tracks, omitting any as needed.
+func (zs PrivacyZones) Cells(tracks []cattrack.CatTrack, level catS2.CellLevel) []cattrack.CatTrack {
+	if len(zs) == 0 {
+		return tracks
+	}
+	out := make([]cattrack.CatTrack, 0, len(tracks))
+	for _, ct := range tracks {
+		if pub, ok := zs.PrivatizeCell(ct, level); ok {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// privatizeFeature returns the public version of a feature-typed value,
+// or false if it should be omitted. Values of other types are returned unchanged.
+func privatizeFeature[T any](zs PrivacyZones, v T) (T, bool) {
+	switch f := any(v).(type) {
+	case cattrack.CatTrack:
+		f, ok := zs.Track(f)
+		return any(f).(T), ok
+	case cattrack.CatLap:
+		g, ok := zs.Geometry(f.Geometry)
+		f.Geometry = g
+		return any(f).(T), ok
+	case cattrack.CatNap:
+		g, ok := zs.Geometry(f.Geometry)
+		f.Geometry = g
+		return any(f).(T), ok
+	}
+	return v, true
+}
+
+// privatizeStream privatizes a stream of features, omitting any as needed.
+func privatizeStream[T any](ctx context.Context, zs PrivacyZones, in <-chan T) <-chan T {
+	if len(zs) == 0 {
+		return in
+	}
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			pub, ok := privatizeFeature(zs, v)
+			if !ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- pub:
+			}
+		}
+	}()
+	return out
+}
+
+// PrivacyZones returns the cat's privacy zones.
+// The cat state must be open. Zones are read once and cached on the cat.
+func (c *Cat) PrivacyZones() (PrivacyZones, error) {
+	if c.privacyZones != nil {
+		return c.privacyZones, nil
+	}
+	if c.State == nil || !c.State.IsOpen() {
+		return nil, errors.New("cat state not open")
+	}
+	zones := PrivacyZones{}
+	data, err := c.State.ReadKV(params.CatStateBucket, params.CatStateKey_PrivacyZones)
+	if err != nil {
+		// No state bucket (new cat?), so no zones.
+		c.logger.Debug("Did not read privacy zones", "error", err)
+	} else if len(data) > 0 {
+		if err := json.Unmarshal(data, &zones); err != nil {
+			return nil, fmt.Errorf("privacy zones: %w", err)
+		}
+	}
+	c.privacyZones = zones
+	return zones, nil
+}
+
+// SetPrivacyZones validates and stores the cat's privacy zones, replacing any existing.
+// The cat state must be open for writing. Empty zones clears them.
+func (c *Cat) SetPrivacyZones(zones PrivacyZones) error {
+	if err := zones.Validate(); err != nil {
+		return err
+	}
+	if c.State == nil || !c.State.IsOpen() {
+		return errors.New("cat state not open")
+	}
+	if zones == nil {
+		zones = PrivacyZones{}
+	}
+	if err := c.State.StoreKVMarshalJSON(params.CatStateBucket, params.CatStateKey_PrivacyZones, zones); err != nil {
+		return err
+	}
+	c.privacyZones = zones
+	return nil
+}
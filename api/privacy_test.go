@@ -0,0 +1,104 @@
+package api
+
+import (
+	"github.com/paulmach/orb"
+	catS2 "github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/types/cattrack"
+	"testing"
+)
+
+func TestPrivacyZones_Geometry(t *testing.T) {
+	home := orb.Point{-93.25, 44.98}
+	zones := PrivacyZones{
+		{Name: "home", Center: home, RadiusMeters: 200, Mode: PrivacyZoneModeSnap},
+		{Name: "work", Polygon: orb.Polygon{{{-93.10, 44.90}, {-93.09, 44.90}, {-93.09, 44.91}, {-93.10, 44.91}, {-93.10, 44.90}}},
+			Mode: PrivacyZoneModeOmit},
+	}
+	if err := zones.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	away := orb.Point{-93.20, 44.98}
+	nearHome := orb.Point{-93.2505, 44.9801}
+	atWork := orb.Point{-93.095, 44.905}
+
+	if g, ok := zones.Geometry(away); !ok || g != away {
+		t.Errorf("expected point outside zones unchanged, got %v %v", g, ok)
+	}
+	if g, ok := zones.Geometry(nearHome); !ok || g != home {
+		t.Errorf("expected point snapped to home, got %v %v", g, ok)
+	}
+	if _, ok := zones.Geometry(atWork); ok {
+		t.Error("expected point at work omitted")
+	}
+
+	line := orb.LineString{nearHome, home, away, atWork}
+	g, ok := zones.Geometry(line)
+	if !ok {
+		t.Fatal("expected line kept")
+	}
+	if want := (orb.LineString{home, away}); !orb.Equal(g, want) {
+		t.Errorf("expected line %v, got %v", want, g)
+	}
+	if line[0] != nearHome {
+		t.Error("expected original line unmodified")
+	}
+	if _, ok := zones.Geometry(orb.LineString{atWork, atWork}); ok {
+		t.Error("expected line inside omit zone omitted")
+	}
+
+	ct := cattrack.CatTrack{Geometry: nearHome}
+	pub, ok := privatizeFeature(zones, ct)
+	if !ok || pub.Point() != home || ct.Point() != nearHome {
+		t.Errorf("expected feature copy snapped, got %v, original %v", pub.Point(), ct.Point())
+	}
+}
+
+func TestPrivacyZones_PrivatizeCell(t *testing.T) {
+	atWork := orb.Point{-93.095, 44.905}
+	zones := PrivacyZones{
+		{Name: "work", Center: orb.Point{-93.095, 44.905}, RadiusMeters: 500, Mode: PrivacyZoneModeOmit},
+	}
+	ct := cattrack.CatTrack{Geometry: atWork}
+
+	// A cell bigger than the zone is kept, at the zone center.
+	if pub, ok := zones.PrivatizeCell(ct, catS2.CellLevel6); !ok || pub.Point() != zones[0].Centroid() {
+		t.Errorf("expected big cell kept, got %v %v", pub.Point(), ok)
+	}
+	// A cell smaller than the zone is omitted.
+	if _, ok := zones.PrivatizeCell(ct, catS2.CellLevel23); ok {
+		t.Error("expected small cell omitted")
+	}
+}
+
+func TestCat_PrivacyZones(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 0 {
+		t.Fatalf("expected no zones, got %v", zones)
+	}
+
+	if err := c.SetPrivacyZones(PrivacyZones{{Name: "home", Mode: "blur", RadiusMeters: 100}}); err == nil {
+		t.Error("expected invalid mode error")
+	}
+	want := PrivacyZones{{Name: "home", Center: orb.Point{-93.25, 44.98}, RadiusMeters: 100, Mode: PrivacyZoneModeSnap}}
+	if err := c.SetPrivacyZones(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read back from state, not the cache.
+	c.privacyZones = nil
+	zones, err = c.PrivacyZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 1 || zones[0].Name != "home" || zones[0].Center != want[0].Center {
+		t.Errorf("unexpected zones: %v", zones)
+	}
+}
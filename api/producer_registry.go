@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/rotblauer/catd/daemon/tiled"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"slices"
+	"sync"
+)
+
+// ProducerInput names the stage of the producer pipeline that a producer consumes.
+// Stages are ordered; each is derived from the one before it.
+type ProducerInput int
+
+const (
+	// ProducerInputRaw tracks are as stored, with time offsets.
+	ProducerInputRaw ProducerInput = iota
+	// ProducerInputCleaned tracks have been filtered for accuracy, speed, elevation and teleportation.
+	ProducerInputCleaned
+	// ProducerInputImproved tracks are cleaned and have had their activities improved.
+	ProducerInputImproved
+	// ProducerInputGrounded tracks are improved, and only those not flying.
+	ProducerInputGrounded
+)
+
+func (pi ProducerInput) String() string {
+	switch pi {
+	case ProducerInputRaw:
+		return "raw"
+	case ProducerInputCleaned:
+		return "cleaned"
+	case ProducerInputImproved:
+		return "improved"
+	case ProducerInputGrounded:
+		return "grounded"
+	}
+	return fmt.Sprintf("ProducerInput(%d)", int(pi))
+}
+
+// ProducerHandler is a CatHandler taking its cat as an argument,
+// so that Cat methods can be registered as method expressions, eg. (*Cat).S2IndexTracks.
+type ProducerHandler func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error
+
+// Producer is a named consumer of one stage of the producer pipeline.
+type Producer struct {
+	Name    string
+	Input   ProducerInput
+	Handler ProducerHandler
+
+	// Reset, if non-nil, deletes the producer's derived state, making it rebuildable.
+	// See Cat.Rebuild.
+	Reset func(c *Cat) error
+}
+
+// Producer names of the built-in producers.
+const (
+	ProducerTracks   = "tracks"   // Raw tracks, pushed to tiled.
+	ProducerImproved = "improved" // Improved tracks, pushed to tiled.
+	ProducerInfluxDB = "influxdb"
+	ProducerS2       = "s2"
+	ProducerRgeo     = "rgeo"
+	ProducerLaps     = "laps"
+	ProducerNaps     = "naps"
+	ProducerOffsets  = "offsets"
+	ProducerGeofence = "geofence"
+)
+
+var (
+	producersMu sync.RWMutex
+	producers   []*Producer
+)
+
+// RegisterProducer adds a producer to the registry.
+// It panics if the producer is invalid or its name is already registered.
+func RegisterProducer(p *Producer) {
+	if p == nil || p.Name == "" || p.Handler == nil {
+		panic("invalid producer")
+	}
+	if p.Input < ProducerInputRaw || p.Input > ProducerInputGrounded {
+		panic(fmt.Sprintf("producer %s: invalid input %v", p.Name, p.Input))
+	}
+	producersMu.Lock()
+	defer producersMu.Unlock()
+	for _, q := range producers {
+		if q.Name == p.Name {
+			panic(fmt.Sprintf("producer %s already registered", p.Name))
+		}
+	}
+	producers = append(producers, p)
+}
+
+// LookupProducer returns the registered producer by name.
+func LookupProducer(name string) (*Producer, bool) {
+	producersMu.RLock()
+	defer producersMu.RUnlock()
+	for _, p := range producers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// RegisteredProducers returns all registered producers in registration order.
+func RegisteredProducers() []*Producer {
+	producersMu.RLock()
+	defer producersMu.RUnlock()
+	out := make([]*Producer, len(producers))
+	copy(out, producers)
+	return out
+}
+
+func init() {
+	RegisterProducer(&Producer{
+		Name:    ProducerTracks,
+		Input:   ProducerInputRaw,
+		Handler: tiledTracksProducer("tracks"),
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerImproved,
+		Input:   ProducerInputImproved,
+		Handler: tiledTracksProducer("tracks-improved"),
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerInfluxDB,
+		Input:   ProducerInputImproved,
+		Handler: (*Cat).influxDBProducer,
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerS2,
+		Input:   ProducerInputGrounded,
+		Handler: (*Cat).S2IndexTracks,
+		Reset: func(c *Cat) error {
+			return c.resetCellIndexer(c.GetDefaultS2CellIndexer)
+		},
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerRgeo,
+		Input:   ProducerInputGrounded,
+		Handler: (*Cat).RGeoIndexTracks,
+		Reset: func(c *Cat) error {
+			return c.resetCellIndexer(c.GetDefaultRgeoIndexer)
+		},
+	})
+	RegisterProducer(&Producer{
+		Name:  ProducerLaps,
+		Input: ProducerInputImproved,
+		Handler: func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+			return c.catActPipeline(ctx, in, true, false)
+		},
+		Reset: func(c *Cat) error {
+			return c.resetStateAndFiles(params.CatStateKey_Laps, params.LapsGZFileName)
+		},
+	})
+	RegisterProducer(&Producer{
+		Name:  ProducerNaps,
+		Input: ProducerInputImproved,
+		Handler: func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+			return c.catActPipeline(ctx, in, false, true)
+		},
+		Reset: func(c *Cat) error {
+			return c.resetStateAndFiles(params.CatStateKey_Naps, params.NapsGZFileName)
+		},
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerOffsets,
+		Input:   ProducerInputImproved,
+		Handler: (*Cat).OffsetIndexer,
+		Reset: func(c *Cat) error {
+			return c.resetStateAndFiles(params.CatStateKey_OffsetIndexer)
+		},
+	})
+	RegisterProducer(&Producer{
+		Name:    ProducerGeofence,
+		Input:   ProducerInputCleaned,
+		Handler: (*Cat).GeofenceTracks,
+		Reset:   (*Cat).resetGeofences,
+	})
+}
+
+// combineActProducers replaces the laps and naps producers, if both are given,
+// with one producer running them in a single act pipeline.
+// Nap detection flushes the open lap, so laps and naps share the pipeline's state,
+// and the pipeline's tiling and notifications are not run twice.
+func combineActProducers(producers []*Producer) []*Producer {
+	laps := slices.IndexFunc(producers, func(p *Producer) bool { return p.Name == ProducerLaps })
+	naps := slices.IndexFunc(producers, func(p *Producer) bool { return p.Name == ProducerNaps })
+	if laps < 0 || naps < 0 {
+		return producers
+	}
+	out := make([]*Producer, 0, len(producers)-1)
+	for i, p := range producers {
+		switch i {
+		case naps:
+			continue
+		case laps:
+			p = &Producer{
+				Name:  ProducerLaps + "+" + ProducerNaps,
+				Input: ProducerInputImproved,
+				Handler: func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+					return c.catActPipeline(ctx, in, true, true)
+				},
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// tiledTracksProducer returns a handler pushing tracks to tiled as the named source and layer.
+// Push errors are logged, not returned; tiling is not critical to populate.
+func tiledTracksProducer(name string) ProducerHandler {
+	return func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+		if err := sendToCatTileD(ctx, c, &tiled.PushFeaturesRequestArgs{
+			SourceSchema: tiled.SourceSchema{
+				CatID:      c.CatID,
+				SourceName: name,
+				LayerName:  name,
+			},
+			TippeConfigName: params.TippeConfigNameTracks,
+			Versions:        []tiled.TileSourceVersion{tiled.SourceVersionCanonical, tiled.SourceVersionEdge},
+			SourceModes:     []tiled.SourceMode{tiled.SourceModeAppend, tiled.SourceModeAppend},
+		}, in); err != nil {
+			c.logger.Error("Failed to send tracks", "source", name, "error", err)
+			c.receipt.AddError(fmt.Sprintf("push %s to tiled: %v", name, err))
+		}
+		return nil
+	}
+}
+
+// influxDBProducer exports tracks to InfluxDB in batches, if configured.
+// Export errors are logged, not returned.
+func (c *Cat) influxDBProducer(ctx context.Context, in <-chan cattrack.CatTrack) error {
+	once := sync.Once{}
+	batches := stream.Batch(ctx, nil, func(tracks []cattrack.CatTrack) bool {
+		return len(tracks) >= 1000
+	}, in)
+	for batch := range batches {
+		if params.INFLUXDB_URL == "" {
+			once.Do(func() {
+				c.logger.Warn("InfluxDB not configured", "method", "ExportCatTracks")
+			})
+			continue
+		}
+		err := c.ExportInfluxDB(batch)
+		if err != nil {
+			// CHORE: Return error via chan.
+			c.logger.Error("Failed to post batch to InfluxDB", "error", err)
+			c.receipt.AddError(fmt.Sprintf("export to InfluxDB: %v", err))
+		} else {
+			c.logger.Debug("Batch InfluxDB export", "count", len(batch))
+		}
+	}
+	return nil
+}
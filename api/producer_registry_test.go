@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCat_producerPipelines(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	tracks := syntheticTracks(t, time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local), time.Minute, 100)
+
+	counts := map[ProducerInput]*atomic.Int64{}
+	producers := []*Producer{}
+	for _, input := range []ProducerInput{ProducerInputRaw, ProducerInputCleaned, ProducerInputImproved, ProducerInputGrounded} {
+		n := new(atomic.Int64)
+		counts[input] = n
+		producers = append(producers, &Producer{
+			Name:  input.String(),
+			Input: input,
+			Handler: func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+				for range in {
+					n.Add(1)
+				}
+				return nil
+			},
+		})
+	}
+	if err := c.producerPipelines(ctx, stream.Slice(ctx, tracks), producers); err != nil {
+		t.Fatal(err)
+	}
+	if got := counts[ProducerInputRaw].Load(); got != int64(len(tracks)) {
+		t.Errorf("raw producer got %d tracks, want %d", got, len(tracks))
+	}
+	for i := ProducerInputCleaned; i <= ProducerInputGrounded; i++ {
+		if counts[i].Load() > counts[i-1].Load() {
+			t.Errorf("%s producer got more tracks than %s", i, i-1)
+		}
+	}
+
+	// Producer errors are returned.
+	errBoom := errors.New("boom")
+	failing := &Producer{
+		Name:  "failing",
+		Input: ProducerInputImproved,
+		Handler: func(c *Cat, ctx context.Context, in <-chan cattrack.CatTrack) error {
+			stream.Blackhole(in)
+			return errBoom
+		},
+	}
+	if err := c.producerPipelines(ctx, stream.Slice(ctx, tracks), []*Producer{failing}); !errors.Is(err, errBoom) {
+		t.Errorf("expected boom, got %v", err)
+	}
+
+	// No producers drains the input.
+	if err := c.producerPipelines(ctx, stream.Slice(ctx, tracks), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCat_configuredProducers(t *testing.T) {
+	c, err := NewCat("rye", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		params.ProducersEnabled, params.ProducersDisabled = nil, nil
+	}()
+
+	if got := len(c.configuredProducers()); got != len(RegisteredProducers()) {
+		t.Errorf("expected all %d producers, got %d", len(RegisteredProducers()), got)
+	}
+	params.ProducersEnabled = []string{ProducerS2, ProducerLaps}
+	params.ProducersDisabled = []string{ProducerLaps, "heatmap"}
+	got := c.configuredProducers()
+	if len(got) != 1 || got[0].Name != ProducerS2 {
+		t.Errorf("expected only s2, got %v", got)
+	}
+}
+
+func TestCombineActProducers(t *testing.T) {
+	laps, _ := LookupProducer(ProducerLaps)
+	naps, _ := LookupProducer(ProducerNaps)
+	s2, _ := LookupProducer(ProducerS2)
+
+	got := combineActProducers([]*Producer{laps, s2, naps})
+	if len(got) != 2 || got[0].Name != ProducerLaps+"+"+ProducerNaps || got[1] != s2 {
+		t.Errorf("expected one act producer and s2, got %v", got)
+	}
+	got = combineActProducers([]*Producer{s2, naps})
+	if len(got) != 2 || got[1] != naps {
+		t.Errorf("expected naps alone unchanged, got %v", got)
+	}
+}
@@ -2,12 +2,12 @@ package api
 
 import (
 	"context"
-	"github.com/rotblauer/catd/daemon/tiled"
+	"fmt"
 	"github.com/rotblauer/catd/geo/clean"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/stream"
 	"github.com/rotblauer/catd/types/cattrack"
-	"sync"
+	"slices"
 )
 
 //func (c *Cat) TeeToFileGZ(ctx context.Context, in <-chan cattrack.CatTrack, path string) {
@@ -32,101 +32,89 @@ import (
 //}
 
 func (c *Cat) ProducerPipelines(ctx context.Context, in <-chan cattrack.CatTrack) error {
+	return c.producerPipelines(ctx, in, c.configuredProducers())
+}
 
-	c.logger.Info("Producer pipelines")
-	defer c.logger.Info("Producer pipelines complete")
-
-	// Clean and improve tracks for pipeline handlers.
-	wOffsets := cattrack.WithTimeOffset(ctx, in)
-	offsetsPass, offsetsDebug := stream.Tee(ctx, wOffsets)
-
-	//stream.Blackhole(offsetsDebug)
-	////// P.S. Don't send all tracks to tiled unless development?
-	go func() {
-		if err := sendToCatTileD(ctx, c, &tiled.PushFeaturesRequestArgs{
-			SourceSchema: tiled.SourceSchema{
-				CatID:      c.CatID,
-				SourceName: "tracks",
-				LayerName:  "tracks",
-			},
-			TippeConfigName: params.TippeConfigNameTracks,
-			Versions:        []tiled.TileSourceVersion{tiled.SourceVersionCanonical, tiled.SourceVersionEdge},
-			SourceModes:     []tiled.SourceMode{tiled.SourceModeAppend, tiled.SourceModeAppend},
-		}, offsetsDebug); err != nil {
-			c.logger.Error("Failed to send tracks", "error", err)
+// configuredProducers returns the registered producers enabled by
+// params.ProducersEnabled and params.ProducersDisabled.
+func (c *Cat) configuredProducers() []*Producer {
+	producers := []*Producer{}
+	for _, p := range RegisteredProducers() {
+		if len(params.ProducersEnabled) > 0 && !slices.Contains(params.ProducersEnabled, p.Name) {
+			continue
 		}
-	}()
-
-	cleaned := c.CleanTracks(ctx, offsetsPass)
-	//cleanDebug, cleanPass := stream.Tee[cattrack.CatTrack](ctx, cleaned)
-
-	//improved := c.ImprovedActTracks(ctx, cleaned)
-	improved := c.ImprovedActTracks(ctx, cleaned)
-	improvedA := make(chan cattrack.CatTrack)
-	improvedB := make(chan cattrack.CatTrack)
-	improvedC := make(chan cattrack.CatTrack)
-	stream.TeeMany(ctx, improved, improvedA, improvedB, improvedC)
-
-	////// P.S. Don't send all tracks to tiled unless development?
-	go func() {
-		if err := sendToCatTileD(ctx, c, &tiled.PushFeaturesRequestArgs{
-			SourceSchema: tiled.SourceSchema{
-				CatID:      c.CatID,
-				SourceName: "tracks-improved",
-				LayerName:  "tracks-improved",
-			},
-			TippeConfigName: params.TippeConfigNameTracks,
-			Versions:        []tiled.TileSourceVersion{tiled.SourceVersionCanonical, tiled.SourceVersionEdge},
-			SourceModes:     []tiled.SourceMode{tiled.SourceModeAppend, tiled.SourceModeAppend},
-		}, improvedA); err != nil {
-			c.logger.Error("Failed to send tracks", "error", err)
+		if slices.Contains(params.ProducersDisabled, p.Name) {
+			continue
 		}
-	}()
-
-	c.State.Waiting.Add(1)
-	go func() {
-		defer c.State.Waiting.Done()
-		once := sync.Once{}
-		batches := stream.Batch(ctx, nil, func(tracks []cattrack.CatTrack) bool {
-			return len(tracks) >= 1000
-		}, improvedB)
-		for batch := range batches {
-			if params.INFLUXDB_URL == "" {
-				once.Do(func() {
-					c.logger.Warn("InfluxDB not configured", "method", "ExportCatTracks")
-				})
-				continue
-			}
-			err := c.ExportInfluxDB(batch)
-			if err != nil {
-				// CHORE: Return error via chan.
-				c.logger.Error("Failed to post batch to InfluxDB", "error", err)
-			} else {
-				c.logger.Debug("Batch InfluxDB export", "count", len(batch))
-			}
+		producers = append(producers, p)
+	}
+	for _, name := range append(params.ProducersEnabled, params.ProducersDisabled...) {
+		if _, ok := LookupProducer(name); !ok {
+			c.logger.Warn("Unknown producer configured", "producer", name)
 		}
-	}()
+	}
+	return producers
+}
 
-	pipeliners := improvedC
-	//pipeliners := improved
-	areaPipeCh := make(chan cattrack.CatTrack, params.DefaultChannelCap)
-	vectorPipeCh := make(chan cattrack.CatTrack, params.DefaultChannelCap)
-	simpleIndexerCh := make(chan cattrack.CatTrack, params.DefaultChannelCap)
-	stream.TeeMany(ctx, pipeliners, areaPipeCh, vectorPipeCh, simpleIndexerCh)
+// producerPipelines cleans and improves tracks, fanning each stage of the pipeline out
+// to the producers consuming it. It blocks until all producers return,
+// returning the first error.
+// The laps and naps producers run as one act pipeline, see combineActProducers.
+func (c *Cat) producerPipelines(ctx context.Context, in <-chan cattrack.CatTrack, producers []*Producer) error {
+	producers = combineActProducers(producers)
 
-	groundedArea := stream.Filter[cattrack.CatTrack](ctx, clean.FilterGrounded, areaPipeCh)
-	g1, g2 := stream.Tee(ctx, groundedArea)
+	c.logger.Info("Producer pipelines", "producers", len(producers))
+	defer c.logger.Info("Producer pipelines complete")
 
-	nPipes := 4
-	errs := make(chan error, nPipes)
-	go func() { errs <- c.S2IndexTracks(ctx, g1) }()
-	go func() { errs <- c.RGeoIndexTracks(ctx, g2) }()
-	go func() { errs <- c.CatActPipeline(ctx, vectorPipeCh) }()
-	go func() { errs <- c.OffsetIndexer(ctx, simpleIndexerCh) }()
+	byInput := map[ProducerInput][]*Producer{}
+	for _, p := range producers {
+		byInput[p.Input] = append(byInput[p.Input], p)
+	}
+	// Each stage feeds its own producers, plus the next stage if any later stage has producers.
+	needs := func(input ProducerInput) bool {
+		for i := input; i <= ProducerInputGrounded; i++ {
+			if len(byInput[i]) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	errs := make(chan error, len(producers))
+	run := func(input ProducerInput, stage <-chan cattrack.CatTrack) <-chan cattrack.CatTrack {
+		n := len(byInput[input])
+		next := needs(input + 1)
+		if next {
+			n++
+		}
+		outs := stream.TeeManyN(ctx, stage, n)
+		for i, p := range byInput[input] {
+			go func(p *Producer, in <-chan cattrack.CatTrack) {
+				err := p.Handler(c, ctx, stream.Buffered(ctx, in, params.DefaultChannelCap))
+				if err != nil {
+					err = fmt.Errorf("producer %s: %w", p.Name, err)
+				}
+				errs <- err
+			}(p, outs[i])
+		}
+		if !next {
+			return nil
+		}
+		return outs[n-1]
+	}
+
+	// Clean and improve tracks for pipeline handlers.
+	if raw := run(ProducerInputRaw, cattrack.WithTimeOffset(ctx, in)); raw != nil {
+		if cleaned := run(ProducerInputCleaned, c.CleanTracks(ctx, raw)); cleaned != nil {
+			if improved := run(ProducerInputImproved, c.ImprovedActTracks(ctx, cleaned)); improved != nil {
+				run(ProducerInputGrounded, stream.Filter[cattrack.CatTrack](ctx, clean.FilterGrounded, improved))
+			}
+		}
+	}
 
 	c.logger.Debug("Producer pipelines waiting for completion")
 
-	for i := 0; i < nPipes; i++ {
+	for i := 0; i < len(producers); i++ {
 		select {
 		case err := <-errs:
 			if err != nil {
@@ -136,7 +124,6 @@ func (c *Cat) ProducerPipelines(ctx context.Context, in <-chan cattrack.CatTrack
 			return ctx.Err()
 		}
 	}
-	close(errs)
 	return nil
 }
 
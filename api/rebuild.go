@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/reducer"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RebuildableProducers returns the names of registered producers whose derived state
+// can be reset, and so rebuilt.
+// Producers pushing to external services (tiled, InfluxDB) are not rebuildable,
+// since replaying tracks to them would duplicate data.
+func RebuildableProducers() []string {
+	names := []string{}
+	for _, p := range RegisteredProducers() {
+		if p.Reset != nil {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// RebuildConfig configures Rebuild.
+type RebuildConfig struct {
+	// From is the time from which stored tracks are replayed. Zero replays all tracks.
+	// Reset state is not limited by From; derived state will reflect only tracks since From.
+	From time.Time
+
+	// Only selects the producers to rebuild. Empty selects all RebuildableProducers.
+	Only []string
+}
+
+// Rebuild resets derived state for the selected producers and regenerates it
+// by replaying the cat's own YYYY-MM track archives through them.
+// The tracks are not re-validated, deduped, or stored; they already were.
+func (c *Cat) Rebuild(ctx context.Context, config *RebuildConfig) error {
+	if config == nil {
+		config = &RebuildConfig{}
+	}
+	only := config.Only
+	if len(only) == 0 {
+		only = RebuildableProducers()
+	}
+	selected := []*Producer{}
+	for _, name := range only {
+		p, ok := LookupProducer(name)
+		if !ok || p.Reset == nil {
+			return fmt.Errorf("producer %q is not rebuildable (rebuildable: %v)", name, RebuildableProducers())
+		}
+		selected = append(selected, p)
+	}
+
+	if err := c.LockOrLoadState(false); err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			c.logger.Error("Failed to close cat state", "error", err)
+		}
+	}()
+
+	c.logger.Info("Rebuilding", "only", only, "from", config.From)
+	started := time.Now()
+
+	// The act improver state is always reset, since every rebuild replays tracks through it.
+	if err := c.resetStateAndFiles(params.CatStateKey_ActImprover); err != nil {
+		return err
+	}
+	for _, p := range selected {
+		c.logger.Info("Resetting producer state", "producer", p.Name)
+		if err := p.Reset(c); err != nil {
+			return fmt.Errorf("reset %s: %w", p.Name, err)
+		}
+	}
+
+	// Cancel the archive reader if the pipelines return early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracks, errs := c.QueryTracks(ctx, &TracksQuery{Start: config.From})
+	if err := c.producerPipelines(ctx, tracks, selected); err != nil {
+		return err
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	c.State.Waiting.Wait()
+
+	c.logger.Info("Rebuild done", "elapsed", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// resetStateAndFiles deletes the state key, if any, and the named flat files.
+func (c *Cat) resetStateAndFiles(key []byte, files ...string) error {
+	if key != nil {
+		if err := c.State.DeleteKV(params.CatStateBucket, key); err != nil {
+			return err
+		}
+	}
+	for _, name := range files {
+		err := os.Remove(filepath.Join(c.State.Flat.Path(), name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetCellIndexer deletes all buckets of the cell indexer.
+func (c *Cat) resetCellIndexer(get func() (*reducer.CellIndexer, error)) error {
+	ci, err := get()
+	if err != nil {
+		return err
+	}
+	if err := ci.ResetBuckets(); err != nil {
+		_ = ci.Close()
+		return err
+	}
+	return ci.Close()
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"testing"
+	"time"
+)
+
+func TestCat_Rebuild(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	start := time.Date(2024, 11, 20, 0, 0, 0, 0, time.Local)
+	tracks := syntheticTracks(t, start, time.Hour, 24*20)
+	if err := <-c.StoreTracksYYYYMM(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Rebuild(ctx, &RebuildConfig{Only: []string{ProducerTracks}}); err == nil {
+		t.Fatal("expected error for non-rebuildable producer")
+	}
+
+	readCount := func() int {
+		if err := c.LockOrLoadState(true); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		indexed := cattrack.CatTrack{}
+		if err := c.State.ReadKVUnmarshalJSON(params.CatStateBucket, params.CatStateKey_OffsetIndexer, &indexed); err != nil {
+			t.Fatal(err)
+		}
+		return indexed.Properties.MustInt("Count", 0)
+	}
+
+	// Rebuilding twice resets state rather than accumulating.
+	config := &RebuildConfig{Only: []string{ProducerOffsets, ProducerS2}}
+	counts := []int{}
+	for i := 0; i < 2; i++ {
+		if err := c.Rebuild(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, readCount())
+	}
+	if counts[0] == 0 || counts[0] != counts[1] {
+		t.Fatalf("unexpected offset index counts: %v", counts)
+	}
+
+	// Rebuilding from December replays fewer tracks.
+	config.From = time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local)
+	if err := c.Rebuild(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if n := readCount(); n == 0 || n >= counts[0] {
+		t.Fatalf("unexpected offset index count from December: %d (all: %d)", n, counts[0])
+	}
+
+	if err := c.LockOrLoadState(true); err != nil {
+		t.Fatal(err)
+	}
+	ci, err := c.GetDefaultS2CellIndexer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+	dump, errs := ci.DumpLevel(0)
+	if cells := stream.Collect(ctx, dump); len(cells) == 0 {
+		t.Fatal("no s2 cells indexed")
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/geo/s2"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/reducer"
+	catS2 "github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+)
+
+// allCatsS2 holds the open all-cats S2 indexers, by db path.
+// Cats populating at once in one process share them,
+// since the db can only be opened once.
+var allCatsS2 = struct {
+	sync.Mutex
+	indexers map[string]*sharedCellIndexer
+}{indexers: map[string]*sharedCellIndexer{}}
+
+type sharedCellIndexer struct {
+	*reducer.CellIndexer
+	refs int
+}
+
+// OpenAllCatsS2CellIndexer opens the all-cats S2 index, nested directly under the datadir root.
+// The index aggregates the cats' indexed tracks per cell, see cattrack.CatsIndexT.
+// The indexer is shared in-process; the returned release func must be called once done,
+// instead of closing the indexer.
+func OpenAllCatsS2CellIndexer(datadirRoot string) (*reducer.CellIndexer, func() error, error) {
+	dbPath := filepath.Join(datadirRoot, params.S2DBName)
+	allCatsS2.Lock()
+	defer allCatsS2.Unlock()
+	if shared, ok := allCatsS2.indexers[dbPath]; ok {
+		shared.refs++
+		return shared.CellIndexer, releaseAllCatsS2CellIndexer(dbPath), nil
+	}
+	bucketLevels := []reducer.Bucket{}
+	for _, level := range catS2.DefaultCellLevels {
+		bucketLevels = append(bucketLevels, reducer.Bucket(level))
+	}
+	ci, err := reducer.NewCellIndexer(&reducer.CellIndexerConfig{
+		CatID:           conceptual.AllCatsID,
+		DBPath:          dbPath,
+		BatchSize:       params.DefaultBatchSize,
+		Buckets:         bucketLevels,
+		DefaultIndexerT: &cattrack.CatsIndexT{},
+		BucketKeyFn:     catS2.CatKeyFn,
+		Logger:          slog.With("reducer", "s2", "cat", conceptual.AllCatsID),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	allCatsS2.indexers[dbPath] = &sharedCellIndexer{CellIndexer: ci, refs: 1}
+	return ci, releaseAllCatsS2CellIndexer(dbPath), nil
+}
+
+func releaseAllCatsS2CellIndexer(dbPath string) func() error {
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			allCatsS2.Lock()
+			defer allCatsS2.Unlock()
+			shared := allCatsS2.indexers[dbPath]
+			shared.refs--
+			if shared.refs == 0 {
+				delete(allCatsS2.indexers, dbPath)
+				err = shared.Close()
+			}
+		})
+		return err
+	}
+}
+
+// dataDirRoot returns the datadir root of the cat, whose datadir is conventionally
+// nested under the root's cats/ directory, or empty if it is not.
+func (c *Cat) dataDirRoot() string {
+	parent := filepath.Dir(filepath.Clean(c.DataDir))
+	if filepath.Base(parent) != params.CatsDir {
+		return ""
+	}
+	return filepath.Dir(parent)
+}
+
+// s2FeedAllCats subscribes the all-cats S2 index to the cat's indexed tracks, privatized
+// by the cat's privacy zones, so the all-cats index never holds a cat's private cells.
+// The returned done func must be called once the cat's indexer is done indexing.
+// It waits for the all-cats index to catch up, then sends to tiled the all-cats levels
+// to which the cat added new unique cells.
+// Cats without a datadir root, like test cats, do not feed the all-cats index.
+func (c *Cat) s2FeedAllCats(ctx context.Context, cellIndexer *reducer.CellIndexer) (done func() error, err error) {
+	done = func() error { return nil }
+	root := c.dataDirRoot()
+	if root == "" {
+		c.logger.Debug("No datadir root, not feeding all-cats S2 index")
+		return done, nil
+	}
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		return done, err
+	}
+	allCats, release, err := OpenAllCatsS2CellIndexer(root)
+	if err != nil {
+		return done, err
+	}
+
+	type fed struct {
+		level reducer.Bucket
+		// uniques is the number of cells the cat added to the all-cats index.
+		uniques int
+		err     error
+	}
+	results := make(chan fed, len(catS2.DefaultCellLevels))
+	chans := []chan []cattrack.CatTrack{}
+	subs := []func(){}
+	for _, level := range catS2.DefaultCellLevels {
+		feed, err := cellIndexer.FeedOfIndexedTracksForBucket(reducer.Bucket(level))
+		if err != nil {
+			for i, unsub := range subs {
+				unsub()
+				close(chans[i])
+			}
+			release()
+			return done, err
+		}
+		ch := make(chan []cattrack.CatTrack)
+		chans = append(chans, ch)
+		sub := feed.Subscribe(ch)
+		subs = append(subs, sub.Unsubscribe)
+		go func() {
+			out := fed{level: reducer.Bucket(level)}
+			for batch := range ch {
+				if out.err != nil {
+					continue // Drain.
+				}
+				uniques, err := allCats.IndexBatch(reducer.Bucket(level), zones.Cells(batch, level))
+				out.uniques += uniques
+				out.err = err
+			}
+			results <- out
+		}()
+	}
+
+	done = func() error {
+		defer release()
+		for i, unsub := range subs {
+			unsub()
+			close(chans[i])
+		}
+		var errs error
+		for range subs {
+			r := <-results
+			if r.err != nil {
+				errs = r.err
+				continue
+			}
+			if r.uniques == 0 || !c.IsTilingRPCEnabled() ||
+				catS2.CellLevel(r.level) < catS2.CellLevelTilingMinimum ||
+				catS2.CellLevel(r.level) > catS2.CellLevelTilingMaximum {
+				continue
+			}
+			if err := c.tiledDumpS2Level(ctx, allCats, conceptual.AllCatsID, nil, r.level); err != nil {
+				errs = err
+			}
+		}
+		return errs
+	}
+	return done, nil
+}
+
+// AllCatsS2CollectLevel returns all all-cats indexed tracks for a given S2 cell level.
+func AllCatsS2CollectLevel(ctx context.Context, datadirRoot string, level catS2.CellLevel) ([]cattrack.CatTrack, error) {
+	cellIndexer, release, err := OpenAllCatsS2CellIndexer(datadirRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dump, errs := cellIndexer.DumpLevel(reducer.Bucket(level))
+	out := stream.Collect(ctx, dump)
+	return out, <-errs
+}
+
+// AllCatsS2DumpLevel writes all all-cats indexed tracks for a given S2 cell level.
+func AllCatsS2DumpLevel(datadirRoot string, wr io.Writer, level catS2.CellLevel) error {
+	cellIndexer, release, err := OpenAllCatsS2CellIndexer(datadirRoot)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	enc := json.NewEncoder(wr)
+	dump, errs := cellIndexer.DumpLevel(reducer.Bucket(level))
+	for track := range dump {
+		if err := enc.Encode(track); err != nil {
+			// Drain the rest, so the dump can finish.
+			for range dump {
+			}
+			return err
+		}
+	}
+	return <-errs
+}
+
+// AllCatsS2QueryRegion returns the all-cats indexed tracks for a given S2 cell level
+// with cells intersecting the region. A positive limit limits the number of tracks.
+func AllCatsS2QueryRegion(ctx context.Context, datadirRoot string, level catS2.CellLevel, region s2.Region, limit int) ([]cattrack.CatTrack, error) {
+	cellIndexer, release, err := OpenAllCatsS2CellIndexer(datadirRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return s2ScanRegion(ctx, cellIndexer, level, region, limit)
+}
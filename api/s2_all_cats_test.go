@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	catS2 "github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"maps"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAllCatsS2(t *testing.T) {
+	root, err := os.MkdirTemp(os.TempDir(), "catd-all-cats-s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	ctx := context.Background()
+	tracks := syntheticTracks(t, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), time.Minute, 50)
+	for _, name := range []string{"rye", "ia"} {
+		c, err := NewCat(conceptual.CatID(name), params.DefaultCatDataDirRooted(root, name), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.LockOrLoadState(false); err != nil {
+			t.Fatal(err)
+		}
+		// Ia only walked the first 10 of rye's tracks.
+		catTracks := tracks
+		if name == "ia" {
+			catTracks = tracks[:10]
+		}
+		named := make([]cattrack.CatTrack, 0, len(catTracks))
+		for _, ct := range catTracks {
+			cp := ct
+			cp.Properties = maps.Clone(ct.Properties)
+			cp.Properties["Name"] = name
+			named = append(named, cp)
+		}
+		if err := c.S2IndexTracks(ctx, stream.Slice(ctx, named)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	level := catS2.CellLevel16
+	cells, err := AllCatsS2CollectLevel(ctx, root, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) < 40 {
+		t.Fatalf("got %d level %d cells", len(cells), level)
+	}
+	both := 0
+	for _, ct := range cells {
+		if ct.CatID() != conceptual.AllCatsID {
+			t.Errorf("got cell of cat %q", ct.CatID())
+		}
+		switch n := ct.Properties.MustInt("CatCount", 0); n {
+		case 2:
+			both++
+		case 1:
+		default:
+			t.Errorf("got cell with %d cats", n)
+		}
+	}
+	if both < 8 || both > 12 {
+		t.Errorf("got %d cells of both cats, want about 10", both)
+	}
+
+	first := tracks[0].Point()
+	near, err := AllCatsS2QueryRegion(ctx, root, level, catS2.RegionFromRadius(first, 50), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(near) != 1 || near[0].Properties.MustInt("CatCount", 0) != 2 {
+		t.Errorf("got %d cells near, want 1 of both cats", len(near))
+	}
+}
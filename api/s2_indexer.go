@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/golang/geo/s2"
+	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/daemon/tiled"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/reducer"
@@ -28,7 +30,7 @@ func (c *Cat) GetDefaultS2CellIndexer() (*reducer.CellIndexer, error) {
 		BatchSize:       params.DefaultBatchSize, // 10% of default batch size? Why? Reduce batch-y-ness.
 		Buckets:         bucketLevels,
 		DefaultIndexerT: catS2.DefaultIndexerT,
-		LevelIndexerT:   nil,
+		LevelIndexerT:   catS2.DefaultLevelIndexerT,
 		BucketKeyFn:     catS2.CatKeyFn,
 		Logger:          slog.With("reducer", "s2"),
 	})
@@ -89,11 +91,19 @@ func (c *Cat) S2IndexTracks(ctx context.Context, in <-chan cattrack.CatTrack) er
 		}()
 	}
 
+	allCatsDone, err := c.s2FeedAllCats(ctx, cellIndexer)
+	if err != nil {
+		c.logger.Error("Failed to feed all-cats S2 index, skipping", "error", err)
+	}
+
 	// Blocking.
 	c.logger.Info("Indexing S2 blocking")
 	if err := cellIndexer.Index(ctx, in); err != nil {
 		c.logger.Error("CellIndexer S2 errored", "error", err)
 	}
+	if err := allCatsDone(); err != nil {
+		c.logger.Error("All-cats S2 index errored", "error", err)
+	}
 
 	// Then wait for all our level callbacks to return.
 	for i, sub := range subs {
@@ -122,6 +132,15 @@ func (c *Cat) tiledDumpS2LevelIfUnique(ctx context.Context, cellIndexer *reducer
 		return nil
 	}
 
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		return err
+	}
+	return c.tiledDumpS2Level(ctx, cellIndexer, c.CatID, zones, level)
+}
+
+// tiledDumpS2Level sends all indexed tracks at a given level to tiled as the given cat's cells, with mode truncate.
+func (c *Cat) tiledDumpS2Level(ctx context.Context, cellIndexer *reducer.CellIndexer, catID conceptual.CatID, zones PrivacyZones, level reducer.Bucket) error {
 	levelZoomMin := catS2.TilingDefaultCellZoomLevels[catS2.CellLevel(level)][0]
 	levelZoomMax := catS2.TilingDefaultCellZoomLevels[catS2.CellLevel(level)][1]
 	levelTippeConfig, _ := params.LookupTippeConfig(params.TippeConfigNameCells, nil)
@@ -130,15 +149,19 @@ func (c *Cat) tiledDumpS2LevelIfUnique(ctx context.Context, cellIndexer *reducer
 	levelTippeConfig.MustSetPair("--minimum-zoom", fmt.Sprintf("%d", levelZoomMin))
 
 	// Dump all indexed tracks for the level.
+	// Privatize the indexed points before they become cells.
 	dump, errs := cellIndexer.DumpLevel(reducer.Bucket(level))
-	edit := stream.Transform[cattrack.CatTrack, cattrack.CatTrack](ctx, func(track cattrack.CatTrack) cattrack.CatTrack {
-		track.ID = track.MustTime().Unix()
-		track.Geometry = catS2.GetCellGeometry(track.Point(), catS2.CellLevel(level))
-		return track
+	public := stream.Filter(ctx, func(track cattrack.CatTrack) bool {
+		_, ok := zones.PrivatizeCell(track, catS2.CellLevel(level))
+		return ok
 	}, dump)
+	edit := stream.Transform[cattrack.CatTrack, cattrack.CatTrack](ctx, func(track cattrack.CatTrack) cattrack.CatTrack {
+		track, _ = zones.PrivatizeCell(track, catS2.CellLevel(level))
+		return s2TilingCell(track, catS2.CellLevel(level))
+	}, public)
 	err := sendToCatTileD(ctx, c, &tiled.PushFeaturesRequestArgs{
 		SourceSchema: tiled.SourceSchema{
-			CatID:      c.CatID,
+			CatID:      catID,
 			SourceName: "s2_cells",
 			LayerName:  fmt.Sprintf("level-%02d", level),
 		},
@@ -156,6 +179,17 @@ func (c *Cat) tiledDumpS2LevelIfUnique(ctx context.Context, cellIndexer *reducer
 	return err
 }
 
+// s2TilingCell returns the indexed track as a cell polygon for tiled,
+// without the catS2.TilingOmittedProperties.
+func s2TilingCell(track cattrack.CatTrack, level catS2.CellLevel) cattrack.CatTrack {
+	track.ID = track.MustTime().Unix()
+	track.Geometry = catS2.GetCellGeometry(track.Point(), level)
+	for _, key := range catS2.TilingOmittedProperties {
+		track.DeletePropertySafe(key)
+	}
+	return track
+}
+
 // sendUniqueTracksLevelAppending is not currently in use, but here
 // for reference in case you want to send unique tracks to tiled with source mode appending.
 func (c *Cat) sendUniqueTracksLevelAppending(ctx context.Context, level catS2.CellLevel, in <-chan []cattrack.CatTrack, awaitErr <-chan error) {
@@ -233,8 +267,13 @@ func (c *Cat) sendUniqueTracksLevelAppending(ctx context.Context, level catS2.Ce
 */
 
 // S2CollectLevel returns all indexed tracks for a given S2 cell level.
+// Tracks are privatized by the cat's privacy zones.
 func (c *Cat) S2CollectLevel(ctx context.Context, level catS2.CellLevel) ([]cattrack.CatTrack, error) {
 	c.getOrInitState(true)
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		return nil, err
+	}
 
 	cellIndexer, err := c.GetDefaultS2CellIndexer()
 	if err != nil {
@@ -245,12 +284,17 @@ func (c *Cat) S2CollectLevel(ctx context.Context, level catS2.CellLevel) ([]catt
 	out := []cattrack.CatTrack{}
 	dump, errs := cellIndexer.DumpLevel(reducer.Bucket(level))
 	out = stream.Collect(ctx, dump)
-	return out, <-errs
+	return zones.Cells(out, level), <-errs
 }
 
 // S2CollectLevel writes all indexed tracks for a given S2 cell level.
+// Tracks are privatized by the cat's privacy zones.
 func (c *Cat) S2DumpLevel(wr io.Writer, level catS2.CellLevel) error {
 	c.getOrInitState(true)
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		return err
+	}
 
 	cellIndexer, err := c.GetDefaultS2CellIndexer()
 	if err != nil {
@@ -262,6 +306,10 @@ func (c *Cat) S2DumpLevel(wr io.Writer, level catS2.CellLevel) error {
 	dump, errs := cellIndexer.DumpLevel(reducer.Bucket(level))
 	go func() {
 		for track := range dump {
+			track, ok := zones.PrivatizeCell(track, level)
+			if !ok {
+				continue
+			}
 			if err := enc.Encode(track); err != nil {
 				select {
 				case errs <- err:
@@ -273,3 +321,52 @@ func (c *Cat) S2DumpLevel(wr io.Writer, level catS2.CellLevel) error {
 	}()
 	return <-errs
 }
+
+// S2QueryRegion returns the indexed tracks for a given S2 cell level with cells intersecting the region.
+// Only the index key ranges covering the region are scanned.
+// Tracks are privatized by the cat's privacy zones.
+// A positive limit limits the number of tracks.
+func (c *Cat) S2QueryRegion(ctx context.Context, level catS2.CellLevel, region s2.Region, limit int) ([]cattrack.CatTrack, error) {
+	c.getOrInitState(true)
+	zones, err := c.PrivacyZones()
+	if err != nil {
+		return nil, err
+	}
+
+	cellIndexer, err := c.GetDefaultS2CellIndexer()
+	if err != nil {
+		return nil, err
+	}
+	defer cellIndexer.Close()
+
+	out, err := s2ScanRegion(ctx, cellIndexer, level, region, limit)
+	if err != nil {
+		return nil, err
+	}
+	return zones.Cells(out, level), nil
+}
+
+// s2ScanRegion returns the indexed tracks for a given S2 cell level with cells intersecting the region,
+// scanning only the index key ranges covering the region.
+// A positive limit limits the number of tracks.
+func s2ScanRegion(ctx context.Context, cellIndexer *reducer.CellIndexer, level catS2.CellLevel, region s2.Region, limit int) ([]cattrack.CatTrack, error) {
+	ranges := catS2.RegionKeyRanges(region, level, catS2.DefaultRegionMaxCells)
+	scan, errs := cellIndexer.ScanRanges(reducer.Bucket(level), ranges)
+	out := []cattrack.CatTrack{}
+	for track := range scan {
+		// Drain the rest, so the scan can finish, once done.
+		if ctx.Err() != nil || (limit > 0 && len(out) == limit) {
+			continue
+		}
+		if catS2.RegionIntersectsTrackCell(region, track, level) {
+			out = append(out, track)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	catS2 "github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCat_S2QueryRegion(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	tracks := syntheticTracks(t, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), time.Minute, 50)
+	if err := c.S2IndexTracks(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+
+	level := catS2.CellLevel16
+	all, err := c.S2CollectLevel(ctx, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 40 {
+		t.Fatalf("got %d level %d cells", len(all), level)
+	}
+
+	// The first 10 synthetic tracks, walking north-east.
+	first, last := tracks[0].Point(), tracks[9].Point()
+	bound := orb.Bound{Min: first, Max: last}.Pad(0.0001)
+	want := 0
+	for _, ct := range all {
+		if bound.Contains(ct.Point()) {
+			want++
+		}
+	}
+	got, err := c.S2QueryRegion(ctx, level, catS2.RegionFromBound(bound), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cells at the bound's edge can hold tracks just outside it.
+	if len(got) < want || len(got) > want+2 {
+		t.Errorf("got %d cells in bound, want %d", len(got), want)
+	}
+	for _, ct := range got {
+		if !bound.Pad(0.01).Contains(ct.Point()) {
+			t.Errorf("got cell out of bound: %v", ct.Point())
+		}
+	}
+
+	limited, err := c.S2QueryRegion(ctx, level, catS2.RegionFromBound(bound), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 3 {
+		t.Errorf("got %d limited cells", len(limited))
+	}
+
+	// A radius around the first track, and a triangle around it, either way around.
+	near, err := c.S2QueryRegion(ctx, level, catS2.RegionFromRadius(first, 50), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(near) != 1 {
+		t.Errorf("got %d cells near, want 1", len(near))
+	}
+	ring := orb.Ring{
+		{first.Lon() - 0.0005, first.Lat() - 0.0005},
+		{first.Lon() + 0.0005, first.Lat() - 0.0005},
+		{first.Lon(), first.Lat() + 0.0005},
+	}
+	for _, r := range []orb.Ring{ring, {ring[2], ring[1], ring[0]}} {
+		region, err := catS2.RegionFromRing(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inside, err := c.S2QueryRegion(ctx, level, region, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inside) != 1 {
+			t.Errorf("got %d cells in ring %v, want 1", len(inside), r)
+		}
+	}
+
+	// Far away.
+	none, err := c.S2QueryRegion(ctx, level, catS2.RegionFromRadius(orb.Point{0, 0}, 1000), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Errorf("got %d cells far away", len(none))
+	}
+}
+
+func TestS2TilingCell(t *testing.T) {
+	ix := catS2.DefaultMultiIndexerT
+	ct := *cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+	ct.SetPropertiesSafe(map[string]interface{}{
+		"Name":      "rye",
+		"UUID":      "a",
+		"Time":      time.Date(2024, 12, 7, 18, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		"Speed":     1.5,
+		"Elevation": 250.0,
+	})
+	indexed := ix.ApplyToCatTrack(ix.Index(nil, ix.FromCatTrack(ct)), ct)
+	for _, key := range catS2.TilingOmittedProperties {
+		if _, ok := indexed.Properties[key]; !ok {
+			t.Fatalf("expected indexed track to have %s", key)
+		}
+	}
+
+	cell := s2TilingCell(indexed, catS2.CellLevel16)
+	for key, v := range cell.Properties {
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			t.Errorf("tiled cell has %s: %v", key, v)
+		}
+	}
+	for _, key := range []string{"HourOfWeek.Peak", "Speed.P50", "DeviceCount"} {
+		if _, ok := cell.Properties[key]; !ok {
+			t.Errorf("tiled cell missing %s", key)
+		}
+	}
+	if _, ok := indexed.Properties["Devices"]; !ok {
+		t.Error("expected indexed track unmodified")
+	}
+	if _, ok := cell.Geometry.(orb.Polygon); !ok {
+		t.Errorf("expected cell polygon, got %T", cell.Geometry)
+	}
+}
@@ -1,19 +1,10 @@
 package api
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"errors"
 	"github.com/rotblauer/catd/common"
-	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/types/cattrack"
-	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -28,9 +19,9 @@ import (
 // The handler is idempotent and can be run multiple times on the same input.
 //
 // Cat Snaps are originally uploaded by the client encoded in base64 in a properties attribute 'imgB64'.
-// This handler attempts to decode the data, store it locally as a .jpg, and then upload it to S3.
+// This handler attempts to decode the data, store it locally as a .jpg, and then upload it to the snap store.
 // If decoding fails, the original track is forwarded to the output channel unmodified.
-// If upload is successful, the track is modified in-place to include the S3 URL in an attribute 'imgS3',
+// Unless the snap store is a no-op, the track is modified in-place to include its reference (bucket/key) in an attribute 'imgS3',
 // and the original `imgB64` attribute is removed.
 // If upload fails, the original track is forwarded to the output channel unmodified.
 // If the cat handler finds that the snap already exists in the cat state, it is not uploaded again, nor transformed.
@@ -96,19 +87,22 @@ func (c *Cat) importCatSnap(ct cattrack.CatTrack) (imported cattrack.CatTrack, e
 			return imported, err
 		}
 
-		// Attempt AWS S3 upload.
-		if params.AWS_BUCKETNAME == "" {
+		// Attempt the snap store upload.
+		store := c.snapStore()
+		key := imported.MustS3Key()
+		if ref := store.Ref(key); ref == "" {
 			imported.SetPropertySafe("imgS3_UPLOAD_SKIPPED", time.Now())
-			c.logger.Warn("Skipping S3 upload", "AWS_BUCKETNAME", params.AWS_BUCKETNAME,
-				"track", imported.StringPretty())
+			c.logger.Warn("Skipping snap upload, no snap store", "track", imported.StringPretty())
 
 		} else {
-			err = c.storeImageS3(imported.MustS3Key(), jpegBytes)
+			err = store.Put(context.Background(), key, jpegBytes)
 			if err != nil {
+				c.logger.Error("Failed to upload snap", "ref", ref, "error", err)
 				imported.SetPropertySafe("imgS3_UPLOAD_FAILED", time.Now())
+			} else {
+				c.logger.Info("Uploaded snap", "ref", ref)
 			}
-			imported.SetPropertySafe("imgS3", fmt.Sprintf("%s/%s",
-				params.AWS_BUCKETNAME, imported.MustS3Key()))
+			imported.SetPropertySafe("imgS3", ref)
 		}
 
 		err = c.State.StoreSnapImage(imported, jpegBytes)
@@ -121,13 +115,18 @@ func (c *Cat) importCatSnap(ct cattrack.CatTrack) (imported cattrack.CatTrack, e
 			imported.DeletePropertySafe("imgB64")
 		}
 
+		// Thumbnails can be made again from the image, so failing them fails nothing.
+		if err := c.storeSnapThumbnails(imported, jpegBytes); err != nil {
+			c.logger.Warn("Failed to store snap thumbnails", "error", err)
+		}
+
 		err = c.State.StoreSnapJSONFile(imported)
 		if err != nil {
 			c.logger.Error("Failed to store snap JSON file", "error", err)
 			return imported, err
 		}
 
-		err = c.State.StoreSnapKV(ct)
+		err = c.State.StoreSnapKV(imported)
 		if err != nil {
 			c.logger.Error("Failed to store snap KV", "error", err)
 			return imported, err
@@ -153,9 +152,9 @@ func (c *Cat) importCatSnap(ct cattrack.CatTrack) (imported cattrack.CatTrack, e
 		return imported, err
 	}
 
-	if params.AWS_BUCKETNAME == "" {
-		c.logger.Warn("Skipping S3 download", "AWS_BUCKETNAME", params.AWS_BUCKETNAME,
-			"track", imported.StringPretty())
+	store := c.snapStore()
+	if store.Ref(imported.MustS3Key()) == "" {
+		c.logger.Warn("Skipping snap download, no snap store", "track", imported.StringPretty())
 		return imported, nil
 	}
 
@@ -178,9 +177,16 @@ func (c *Cat) importCatSnap(ct cattrack.CatTrack) (imported cattrack.CatTrack, e
 		}
 		defer f.Close()
 		start := time.Now()
-		err = c.downloadImageS3(f, params.AWS_BUCKETNAME, snap.MustS3Key())
+		err = store.Get(context.Background(), snap.MustS3Key(), f)
 		if err != nil {
+			// Don't leave an empty or partial image to pass for the snap.
+			_ = os.Remove(target)
+			if errors.Is(err, ErrSnapNotStored) {
+				c.logger.Warn("Snap image not in cat state nor snap store", "track", snap.StringPretty())
+				return
+			}
 			c.logger.Error("Failed to download snap", "error", err)
+			return
 		}
 		if err := f.Sync(); err != nil {
 			return
@@ -191,79 +197,3 @@ func (c *Cat) importCatSnap(ct cattrack.CatTrack) (imported cattrack.CatTrack, e
 
 	return imported, nil
 }
-
-func (c *Cat) storeImageS3(key string, jpegBytes []byte) (err error) {
-
-	// S3
-
-	// All clients require a Session. The Session provides the client with
-	// shared configuration such as region, endpoint, and credentials. A
-	// Session should be shared where possible to take advantage of
-	// configuration and credential caching. See the session package for
-	// more information.
-	sess := session.Must(session.NewSession())
-
-	// Create a new instance of the service's client with a Session.
-	// Optional aws.Config values can also be provided as variadic arguments
-	// to the New function. This option allows you to provide service
-	// specific configuration.
-	svc := s3.New(sess)
-
-	// Create a context with a timeout that will abort the upload if it takes
-	// more than the passed in timeout.
-	ctx := context.Background()
-	var cancelFn func()
-	timeout := time.Second * 10
-	if timeout > 0 {
-		ctx, cancelFn = context.WithTimeout(ctx, timeout)
-	}
-
-	// Ensure the context is canceled to prevent leaking.
-	// See context package for more information, https://golang.org/pkg/context/
-	defer cancelFn()
-
-	// Uploads the object to S3. The Context will interrupt the request if the
-	// timeout expires.
-	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(params.AWS_BUCKETNAME),
-		Key:           aws.String(key),
-		Body:          bytes.NewReader(jpegBytes),
-		ContentType:   aws.String("image/jpeg"),
-		ContentLength: aws.Int64(int64(len(jpegBytes))),
-	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
-			// If the SDK can determine the request or retry delay was canceled
-			// by a context the CanceledErrorCode error code will be returned.
-			c.logger.Error("AWS S3 upload canceled due to timeout", "error", err)
-		} else {
-			c.logger.Error("Failed to upload object", "error", err)
-		}
-		return err
-	}
-
-	c.logger.Info("Uploaded image to AWS S3", "bucket", params.AWS_BUCKETNAME, "key", key)
-	return nil
-}
-
-// downloadImageS3 downloads an image from S3 and writes it to the provided writer.
-// The AWS library uses environment variables to configure itself.
-func (c *Cat) downloadImageS3(wr io.WriterAt, bucket, key string) error {
-
-	// The session the S3 Downloader will use
-	sess := session.Must(session.NewSession())
-
-	// Create a downloader with the session and default options
-	downloader := s3manager.NewDownloader(sess)
-
-	// Write the contents of S3 Object to the file
-	c.logger.Info("Downloading image from S3...", "bucket", bucket, "key", key)
-	_, err := downloader.Download(wr, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to download S3 file, %v", err)
-	}
-	return nil
-}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rotblauer/catd/params"
+	"io"
+	"sync"
+	"time"
+)
+
+// SnapStore stores cat snap images by their keys, see cattrack.CatTrack.MustS3Key.
+// Snaps are always stored in the cat state too; stores keep a copy elsewhere,
+// eg. in a bucket serving the snaps' imgS3 URLs.
+type SnapStore interface {
+	// Ref returns a snap's imgS3 value, conventionally bucket/key,
+	// or an empty string if the store does not name snaps.
+	Ref(key string) string
+
+	// Put stores a snap's JPEG image.
+	Put(ctx context.Context, key string, jpeg []byte) error
+
+	// Get writes a snap's JPEG image, or returns ErrSnapNotStored
+	// if the store has no copy besides the cat state.
+	Get(ctx context.Context, key string, w io.Writer) error
+}
+
+// ErrSnapNotStored is returned by stores that can't get a snap.
+var ErrSnapNotStored = errors.New("snap not in store")
+
+// DefaultLocalSnapBucket names the snaps of local stores without a bucket.
+const DefaultLocalSnapBucket = "local"
+
+// snapStoreTimeout limits each snap upload and download.
+const snapStoreTimeout = 10 * time.Second
+
+// NewSnapStore returns the store of a configuration.
+func NewSnapStore(config *params.SnapStoreConfig) (SnapStore, error) {
+	switch config.Kind {
+	case params.SnapStoreNoop, "":
+		return NoopSnapStore{}, nil
+	case params.SnapStoreLocal:
+		bucket := config.Bucket
+		if bucket == "" {
+			bucket = DefaultLocalSnapBucket
+		}
+		return LocalSnapStore{Bucket: bucket}, nil
+	case params.SnapStoreS3:
+		return NewS3SnapStore(config)
+	}
+	return nil, fmt.Errorf("unknown snap store: %q", config.Kind)
+}
+
+var (
+	defaultSnapStore     SnapStore
+	defaultSnapStoreErr  error
+	defaultSnapStoreOnce sync.Once
+)
+
+// snapStore returns the cat's snap store, defaulting to the store of params.SnapStore.
+func (c *Cat) snapStore() SnapStore {
+	if c.snaps != nil {
+		return c.snaps
+	}
+	defaultSnapStoreOnce.Do(func() {
+		defaultSnapStore, defaultSnapStoreErr = NewSnapStore(params.SnapStore)
+		if defaultSnapStoreErr != nil {
+			c.logger.Error("Invalid snap store, not storing snaps", "error", defaultSnapStoreErr)
+			defaultSnapStore = NoopSnapStore{}
+		}
+	})
+	return defaultSnapStore
+}
+
+// SetSnapStore sets the cat's snap store, overriding the default.
+func (c *Cat) SetSnapStore(store SnapStore) {
+	c.snaps = store
+}
+
+// NoopSnapStore stores snaps nowhere but the cat state. Snaps get no imgS3 URL.
+type NoopSnapStore struct{}
+
+func (NoopSnapStore) Ref(key string) string { return "" }
+
+func (NoopSnapStore) Put(ctx context.Context, key string, jpeg []byte) error { return nil }
+
+func (NoopSnapStore) Get(ctx context.Context, key string, w io.Writer) error {
+	return ErrSnapNotStored
+}
+
+// LocalSnapStore stores snaps nowhere but the cat state, at state.CatState.SnapPathImage,
+// but names them bucket/key like S3 stores do, so the snaps can be served
+// or synced to a bucket later.
+type LocalSnapStore struct {
+	Bucket string
+}
+
+func (s LocalSnapStore) Ref(key string) string { return s.Bucket + "/" + key }
+
+func (LocalSnapStore) Put(ctx context.Context, key string, jpeg []byte) error { return nil }
+
+func (LocalSnapStore) Get(ctx context.Context, key string, w io.Writer) error {
+	return ErrSnapNotStored
+}
+
+// S3SnapStore stores snaps in an S3 bucket, on AWS or any S3-compatible endpoint.
+// Credentials come from the AWS SDK's environment, eg. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type S3SnapStore struct {
+	bucket string
+	svc    *s3.S3
+}
+
+// NewS3SnapStore returns an S3 snap store, sharing one session for all uploads and downloads.
+func NewS3SnapStore(config *params.SnapStoreConfig) (*S3SnapStore, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 snap store requires a bucket")
+	}
+	awsConfig := aws.NewConfig().WithS3ForcePathStyle(config.PathStyle)
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	if config.Region != "" {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &S3SnapStore{bucket: config.Bucket, svc: s3.New(sess)}, nil
+}
+
+func (s *S3SnapStore) Ref(key string) string { return s.bucket + "/" + key }
+
+func (s *S3SnapStore) Put(ctx context.Context, key string, jpeg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, snapStoreTimeout)
+	defer cancel()
+	_, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(jpeg),
+		ContentType:   aws.String("image/jpeg"),
+		ContentLength: aws.Int64(int64(len(jpeg))),
+	})
+	return err
+}
+
+func (s *S3SnapStore) Get(ctx context.Context, key string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, snapStoreTimeout)
+	defer cancel()
+	out, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return err
+}
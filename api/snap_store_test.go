@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/cattrack"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSnapStore is a stub store keeping snaps in memory.
+type testSnapStore struct {
+	mu   sync.Mutex
+	put  map[string][]byte
+	fail bool
+}
+
+func (s *testSnapStore) Ref(key string) string { return "stub/" + key }
+
+func (s *testSnapStore) Put(ctx context.Context, key string, jpeg []byte) error {
+	if s.fail {
+		return errors.New("stub failure")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put[key] = jpeg
+	return nil
+}
+
+func (s *testSnapStore) Get(ctx context.Context, key string, w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.put[key]
+	if !ok {
+		return ErrSnapNotStored
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// testSnapTrack returns a snap of rye, with a w*h image.
+func testSnapTrack(t *testing.T, unix int64, pt orb.Point, w, h int) cattrack.CatTrack {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	ct := cattrack.NewCatTrack(pt)
+	ct.SetPropertySafe("Name", "rye")
+	ct.SetPropertySafe("UUID", "76170e959f967f40")
+	ct.SetPropertySafe("Time", time.Unix(unix, 0).UTC().Format(time.RFC3339))
+	ct.SetPropertySafe("UnixTime", unix)
+	ct.SetPropertySafe("imgB64", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	return *ct
+}
+
+func TestNewSnapStore(t *testing.T) {
+	for _, c := range []struct {
+		config *params.SnapStoreConfig
+		ref    string
+		err    bool
+	}{
+		{config: &params.SnapStoreConfig{}, ref: ""},
+		{config: &params.SnapStoreConfig{Kind: params.SnapStoreNoop, Bucket: "catsnaps"}, ref: ""},
+		{config: &params.SnapStoreConfig{Kind: params.SnapStoreLocal}, ref: "local/key"},
+		{config: &params.SnapStoreConfig{Kind: params.SnapStoreLocal, Bucket: "catsnaps"}, ref: "catsnaps/key"},
+		{config: &params.SnapStoreConfig{Kind: params.SnapStoreS3, Bucket: "catsnaps", Region: "us-east-1"}, ref: "catsnaps/key"},
+		{config: &params.SnapStoreConfig{Kind: params.SnapStoreS3}, err: true},
+		{config: &params.SnapStoreConfig{Kind: "ftp"}, err: true},
+	} {
+		store, err := NewSnapStore(c.config)
+		if (err != nil) != c.err {
+			t.Errorf("%+v: got error %v", c.config, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := store.Ref("key"); got != c.ref {
+			t.Errorf("%+v: got ref %q, want %q", c.config, got, c.ref)
+		}
+	}
+}
+
+func TestS3SnapStore(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	// A stub S3-compatible endpoint, addressed by path.
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = b
+		case http.MethodGet:
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	store, err := NewSnapStore(&params.SnapStoreConfig{
+		Kind:      params.SnapStoreS3,
+		Bucket:    "catsnaps",
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "rye_1", []byte("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(objects["/catsnaps/rye_1"]); got != "jpeg" {
+		t.Fatalf("got object %q, have %v", got, objects)
+	}
+	buf := &bytes.Buffer{}
+	if err := store.Get(ctx, "rye_1", buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "jpeg" {
+		t.Errorf("got %q", buf.String())
+	}
+	if err := store.Get(ctx, "rye_2", io.Discard); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCat_importCatSnap(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	defer tc.CloseAndDestroy()
+	c := tc.Cat()
+	store := &testSnapStore{put: map[string][]byte{}}
+	c.SetSnapStore(store)
+
+	imported, err := c.importCatSnap(testSnapTrack(t, 1731952467, orb.Point{-93.25, 44.98}, 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := imported.MustS3Key()
+	if got := imported.Properties.MustString("imgS3", ""); got != "stub/"+key {
+		t.Errorf("got imgS3 %q", got)
+	}
+	if imported.HasRawB64Image() {
+		t.Error("imgB64 not deleted")
+	}
+	if len(store.put[key]) == 0 {
+		t.Error("snap not put")
+	}
+	if err := c.State.ValidateSnapLocalStore(imported); err != nil {
+		t.Error(err)
+	}
+
+	// Snaps with an imgS3 reference but no local image are downloaded.
+	if err := os.Remove(c.State.SnapPathImage(imported)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.importCatSnap(imported); err != nil {
+		t.Fatal(err)
+	}
+	c.State.Waiting.Wait()
+	if b, err := os.ReadFile(c.State.SnapPathImage(imported)); err != nil || !bytes.Equal(b, store.put[key]) {
+		t.Errorf("got downloaded %d bytes, %v", len(b), err)
+	}
+
+	// Failed uploads are marked, but still referenced.
+	store.fail = true
+	failed, err := c.importCatSnap(testSnapTrack(t, 1731952467, orb.Point{-93.25, 44.98}, 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !failed.HasS3URL() || failed.Properties["imgS3_UPLOAD_FAILED"] == nil {
+		t.Errorf("got %v", failed.Properties)
+	}
+
+	// No-op stores skip the upload, and the reference.
+	c.SetSnapStore(NoopSnapStore{})
+	skipped, err := c.importCatSnap(testSnapTrack(t, 1731952467, orb.Point{-93.25, 44.98}, 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped.HasS3URL() || skipped.Properties["imgS3_UPLOAD_SKIPPED"] == nil {
+		t.Errorf("got %v", skipped.Properties)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/common"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/cattrack"
+	"os"
+	"sort"
+)
+
+// SnapsQuery filters a cat's snaps by time, bound and activity,
+// and pages them, most recent first.
+type SnapsQuery struct {
+	TracksQuery
+
+	// Offset skips that many matching snaps, to page with the limit.
+	Offset int
+}
+
+// SnapsPage is a page of a cat's snaps.
+type SnapsPage struct {
+	// Total is the number of matching snaps, in all pages.
+	Total  int                 `json:"total"`
+	Offset int                 `json:"offset"`
+	Limit  int                 `json:"limit"`
+	Snaps  []cattrack.CatTrack `json:"snaps"`
+}
+
+// QuerySnaps returns a page of the cat's stored snaps matching the query, most recent first.
+// Snaps are made public by the privacy zones before they are paged,
+// so that omitted snaps don't leave pages short.
+func (c *Cat) QuerySnaps(q *SnapsQuery, zones PrivacyZones) (*SnapsPage, error) {
+	c.getOrInitState(true)
+	if q == nil {
+		q = &SnapsQuery{}
+	}
+
+	matches := []cattrack.CatTrack{}
+	err := c.State.ScanSnapsKV(func(ct cattrack.CatTrack) error {
+		if !q.Match(ct) {
+			return nil
+		}
+		ct, ok := zones.Track(ct)
+		if !ok {
+			return nil
+		}
+		// Snaps stored before their image data was stripped still have it.
+		ct.DeletePropertySafe("imgB64")
+		matches = append(matches, ct)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		ti, tj := matches[i].MustTime(), matches[j].MustTime()
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return matches[i].MustS3Key() < matches[j].MustS3Key()
+	})
+
+	page := &SnapsPage{Total: len(matches), Offset: q.Offset, Limit: q.Limit}
+	lo := min(q.Offset, len(matches))
+	hi := len(matches)
+	if q.Limit > 0 {
+		hi = min(lo+q.Limit, hi)
+	}
+	page.Snaps = matches[lo:hi]
+	return page, nil
+}
+
+// storeSnapThumbnails stores the snap's thumbnails of all the params.SnapThumbnailSizes.
+func (c *Cat) storeSnapThumbnails(ct cattrack.CatTrack, jpegBytes []byte) error {
+	var errs error
+	for size, dim := range params.SnapThumbnailSizes {
+		thumb, err := common.JPGThumbnail(jpegBytes, dim)
+		if err == nil {
+			err = c.State.StoreSnapThumbnail(ct, size, thumb)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s thumbnail: %w", size, err))
+		}
+	}
+	return errs
+}
+
+// SnapThumbnail returns the path of the snap's thumbnail of the named size, see params.SnapThumbnailSizes.
+// Missing thumbnails, eg. of snaps stored before thumbnails were, are made from the snap's image and cached.
+func (c *Cat) SnapThumbnail(ct cattrack.CatTrack, size string) (string, error) {
+	c.getOrInitState(true)
+	dim, ok := params.SnapThumbnailSizes[size]
+	if !ok {
+		return "", fmt.Errorf("unknown thumbnail size: %q", size)
+	}
+	target := c.State.SnapPathThumbnail(ct, size)
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+	jpegBytes, err := os.ReadFile(c.State.SnapPathImage(ct))
+	if err != nil {
+		return "", err
+	}
+	thumb, err := common.JPGThumbnail(jpegBytes, dim)
+	if err != nil {
+		return "", err
+	}
+	if err := c.State.StoreSnapThumbnail(ct, size, thumb); err != nil {
+		return "", err
+	}
+	return target, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/types/cattrack"
+	"image/jpeg"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCat_QuerySnaps(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	defer tc.CloseAndDestroy()
+	c := tc.Cat()
+	c.SetSnapStore(NoopSnapStore{})
+
+	home := orb.Point{-93.25, 44.98}
+	away := orb.Point{-114.09, 46.93}
+	snaps := []cattrack.CatTrack{
+		testSnapTrack(t, 1731952467, home, 4, 4),
+		testSnapTrack(t, 1731952567, away, 4, 4),
+		testSnapTrack(t, 1731952667, home, 4, 4),
+	}
+	for _, snap := range snaps {
+		if _, err := c.importCatSnap(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := c.QuerySnaps(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Snaps) != 3 {
+		t.Fatalf("got %+v", page)
+	}
+	if got := page.Snaps[0].MustTime().Unix(); got != 1731952667 {
+		t.Errorf("got first %d, want most recent", got)
+	}
+	for _, snap := range page.Snaps {
+		if snap.HasRawB64Image() {
+			t.Errorf("got image data in %s", snap.StringPretty())
+		}
+	}
+
+	q := &SnapsQuery{
+		TracksQuery: TracksQuery{Bound: &orb.Bound{Min: orb.Point{-94, 44}, Max: orb.Point{-93, 45}}, Limit: 1},
+		Offset:      1,
+	}
+	page, err = c.QuerySnaps(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Snaps) != 1 || page.Snaps[0].MustTime().Unix() != 1731952467 {
+		t.Errorf("got %+v", page)
+	}
+
+	q = &SnapsQuery{TracksQuery: TracksQuery{End: time.Unix(1731952600, 0)}, Offset: 5}
+	page, err = c.QuerySnaps(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Snaps) != 0 {
+		t.Errorf("got %+v", page)
+	}
+
+	// Snaps in privacy zones are omitted.
+	zones := PrivacyZones{{Name: "home", Center: home, RadiusMeters: 1000, Mode: PrivacyZoneModeOmit}}
+	page, err = c.QuerySnaps(nil, zones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || !page.Snaps[0].Point().Equal(away) {
+		t.Errorf("got %+v", page)
+	}
+}
+
+func TestCat_SnapThumbnail(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	defer tc.CloseAndDestroy()
+	c := tc.Cat()
+	c.SetSnapStore(NoopSnapStore{})
+
+	imported, err := c.importCatSnap(testSnapTrack(t, 1731952467, orb.Point{-93.25, 44.98}, 600, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size, want := range map[string][2]int{"small": {128, 64}, "medium": {512, 256}, "large": {600, 300}} {
+		path := c.State.SnapPathThumbnail(imported, size)
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s: not made on import: %v", size, err)
+		}
+		// Missing thumbnails are made again.
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.SnapThumbnail(imported, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != want[0] || config.Height != want[1] {
+			t.Errorf("%s: got %dx%d, want %v", size, config.Width, config.Height, want)
+		}
+	}
+	if _, err := c.SnapThumbnail(imported, "huge"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/rotblauer/catd/catz"
@@ -80,6 +79,11 @@ func (c *Cat) StoreTracks(ctx context.Context, in <-chan cattrack.CatTrack) (err
 }
 
 func (c *Cat) StoreTracksYYYYMM(ctx context.Context, in <-chan cattrack.CatTrack) (errCh chan error) {
+	return c.storeTracksYYYYMM(ctx, in, nil)
+}
+
+// storeTracksYYYYMM is StoreTracksYYYYMM, calling stored, if not nil, with each track written.
+func (c *Cat) storeTracksYYYYMM(ctx context.Context, in <-chan cattrack.CatTrack, stored func(cattrack.CatTrack)) (errCh chan error) {
 	c.getOrInitState(false)
 
 	c.logger.Info("Storing cat tracks gz yyyy-mm", "cat", c.CatID, "path", c.State.Flat.Path())
@@ -104,6 +108,7 @@ func (c *Cat) StoreTracksYYYYMM(ctx context.Context, in <-chan cattrack.CatTrack
 
 	var ymWriter *catz.GZFileWriter
 	var ymEnc *json.Encoder
+	var ymIndex *tracksIndexer
 
 	count := metrics.NewCounter()
 	meter := metrics.NewMeter()
@@ -121,12 +126,17 @@ func (c *Cat) StoreTracksYYYYMM(ctx context.Context, in <-chan cattrack.CatTrack
 			return
 		}
 
-		ymPath := fmt.Sprintf("tracks/%s.geojson.gz", ct.MustTime().Format("2006-01"))
+		ymPath := tracksYYYYMMPath(ct.MustTime())
 
 		// Open new writer if needed, closing old one if exists.
 		if lastYMPath != ymPath || ymWriter == nil || ymEnc == nil {
 			// Last writer?
 			if ymWriter != nil {
+				if err := ymIndex.finish(); err != nil {
+					c.logger.Error("Failed to finish tracks index", "error", err)
+					errCh <- err
+					return
+				}
 				if err := ymWriter.Close(); err != nil {
 					c.logger.Error("Failed to close last-tracks writer", "error", err)
 					errCh <- err
@@ -142,21 +152,41 @@ func (c *Cat) StoreTracksYYYYMM(ctx context.Context, in <-chan cattrack.CatTrack
 			}
 			// New encoder.
 			ymEnc = json.NewEncoder(ymWriter)
+			ymIndex, err = newTracksIndexer(ymWriter)
+			if err != nil {
+				c.logger.Error("Failed to create tracks index", "error", err)
+				errCh <- err
+				return
+			}
 		}
 
 		lastYMPath = ymPath
 
+		if err := ymIndex.add(ct.MustTime()); err != nil {
+			c.logger.Error("Failed to index", "error", err)
+			errCh <- err
+			return
+		}
+
 		if err := ymEnc.Encode(ct); err != nil {
 			c.logger.Error("Failed to write", "error", err)
 			errCh <- err
 			return
 		}
+		if stored != nil {
+			stored(ct)
+		}
 		count.Inc(1)
 		meter.Mark(1)
 	}
 
 	// Guard this because there may weirdly be no tracks, which is not this logic's problem.
 	if ymWriter != nil {
+		if err := ymIndex.finish(); err != nil {
+			c.logger.Error("Failed to finish tracks index", "error", err)
+			errCh <- err
+			return
+		}
 		if err := ymWriter.Close(); err != nil {
 			c.logger.Error("Failed to close last-tracks writer", "error", err)
 			errCh <- err
@@ -6,6 +6,7 @@ import (
 	"github.com/rotblauer/catd/stream"
 	"github.com/rotblauer/catd/testing/testdata"
 	"github.com/rotblauer/catd/types/cattrack"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -133,3 +134,34 @@ func TestCat_StoreTracksYYYYMM_Heavy(t *testing.T) {
 	t.Log("found all track files",
 		len(matches), matches[0], "...", matches[len(matches)-1])
 }
+
+func TestCat_storeTracksYYYYMM_stored(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	start := time.Date(2024, 11, 30, 12, 0, 0, 0, time.UTC)
+	tracks := syntheticTracks(t, start, time.Hour, 24)
+
+	// December's archive can't be written, so only November's tracks are stored.
+	december := filepath.Join(c.State.Flat.Path(), tracksYYYYMMPath(start.Add(24*time.Hour)))
+	if err := os.MkdirAll(december, 0770); err != nil {
+		t.Fatal(err)
+	}
+	stored := []cattrack.CatTrack{}
+	err := <-c.storeTracksYYYYMM(ctx, stream.Slice(ctx, tracks), func(ct cattrack.CatTrack) {
+		stored = append(stored, ct)
+	})
+	if err == nil {
+		t.Fatal("expected error writing December")
+	}
+	if len(stored) != 12 {
+		t.Fatalf("expected 12 stored tracks, got %d", len(stored))
+	}
+	for i, ct := range stored {
+		if !ct.MustTime().Equal(tracks[i].MustTime()) {
+			t.Errorf("stored track %d: got %v, want %v", i, ct.MustTime(), tracks[i].MustTime())
+		}
+	}
+}
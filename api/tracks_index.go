@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/catz"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// TracksIndexEntry describes one gzip member of a YYYY-MM tracks archive.
+// Entries are stored as NDJSON in a sidecar file next to the archive,
+// letting readers seek directly to the members overlapping a time range.
+type TracksIndexEntry struct {
+	// Offset and Length are the compressed byte range of the member in the archive.
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	// Start and End are the min and max track unix times in the member.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Count int   `json:"count"`
+}
+
+// Overlaps returns true if the entry's time range overlaps [start, end].
+// Zero times are unbounded.
+func (e TracksIndexEntry) Overlaps(start, end time.Time) bool {
+	if !start.IsZero() && e.End < start.Unix() {
+		return false
+	}
+	if !end.IsZero() && e.Start > end.Unix() {
+		return false
+	}
+	return true
+}
+
+// tracksIndexer maintains the sparse index of a YYYY-MM archive while it is written.
+// A new gzip member is started whenever track time crosses a params.TracksIndexInterval boundary.
+type tracksIndexer struct {
+	w       *catz.GZFileWriter
+	sidecar *os.File
+	enc     *json.Encoder
+	cur     TracksIndexEntry
+	bucket  int64
+}
+
+func newTracksIndexer(w *catz.GZFileWriter) (*tracksIndexer, error) {
+	offset, err := w.NewMember()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(w.Path()+params.CatTracksIndexSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, err
+	}
+	return &tracksIndexer{
+		w:       w,
+		sidecar: f,
+		enc:     json.NewEncoder(f),
+		cur:     TracksIndexEntry{Offset: offset},
+	}, nil
+}
+
+// add registers a track time with the index. It must be called before the track is written.
+func (x *tracksIndexer) add(t time.Time) error {
+	bucket := t.Truncate(params.TracksIndexInterval).Unix()
+	if x.cur.Count > 0 && bucket != x.bucket {
+		if err := x.rotate(); err != nil {
+			return err
+		}
+	}
+	x.bucket = bucket
+	unix := t.Unix()
+	if x.cur.Count == 0 || unix < x.cur.Start {
+		x.cur.Start = unix
+	}
+	if x.cur.Count == 0 || unix > x.cur.End {
+		x.cur.End = unix
+	}
+	x.cur.Count++
+	return nil
+}
+
+// rotate finishes the current member, recording its entry, and begins the next.
+func (x *tracksIndexer) rotate() error {
+	offset, err := x.w.NewMember()
+	if err != nil {
+		return err
+	}
+	if x.cur.Count > 0 {
+		x.cur.Length = offset - x.cur.Offset
+		if err := x.enc.Encode(x.cur); err != nil {
+			return err
+		}
+	}
+	x.cur = TracksIndexEntry{Offset: offset}
+	return nil
+}
+
+// finish records the last entry and closes the sidecar.
+// The archive writer must be closed by the caller afterward.
+func (x *tracksIndexer) finish() error {
+	if err := x.rotate(); err != nil {
+		_ = x.sidecar.Close()
+		return err
+	}
+	return x.sidecar.Close()
+}
+
+// readTracksIndex reads the sidecar index for the archive at path.
+// It returns ok=false if there is no index, or the index does not exactly cover the archive,
+// as for archives written before indexing existed, or by an interrupted writer.
+// In that case the archive must be scanned in full.
+func readTracksIndex(path string) (entries []TracksIndexEntry, ok bool, err error) {
+	f, err := os.Open(path + params.CatTracksIndexSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		e := TracksIndexEntry{}
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, false, err
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, false, nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Offset < entries[j].Offset
+	})
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	next := int64(0)
+	for _, e := range entries {
+		if e.Offset != next {
+			return nil, false, nil
+		}
+		next = e.Offset + e.Length
+	}
+	if next != fi.Size() {
+		return nil, false, nil
+	}
+	return entries, true, nil
+}
+
+// errTracksArchiveBusy is returned by reindexTracksArchive when the archive is being written.
+var errTracksArchiveBusy = errors.New("tracks archive is being written")
+
+// countingReader counts the bytes read through it.
+// It is a flate.Reader, so gzip reads no further than the member it decompresses.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// reindexTracksArchive rebuilds the sidecar index for the archive at path from the archive's gzip members,
+// replacing any existing index. It is for archives whose index does not cover them, see readTracksIndex.
+// Members are indexed as they are, so the entries of archives written before indexing existed
+// are as coarse as the archives' members, eg. one per populate.
+// It returns errTracksArchiveBusy, without waiting, if the archive is locked by a writer.
+func reindexTracksArchive(path string) ([]TracksIndexEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Writers hold an exclusive lock on the archive while they append to it and its index.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errTracksArchiveBusy
+		}
+		return nil, err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	cr := &countingReader{r: bufio.NewReader(f)}
+	gzr := new(gzip.Reader)
+	entries := []TracksIndexEntry{}
+	for {
+		offset := cr.n
+		if err := gzr.Reset(cr); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("member at %d: %w", offset, err)
+		}
+		gzr.Multistream(false)
+		e := TracksIndexEntry{Offset: offset}
+		dec := json.NewDecoder(gzr)
+		for {
+			ct := cattrack.CatTrack{}
+			if err := dec.Decode(&ct); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("member at %d: %w", offset, err)
+			}
+			t, err := ct.Time()
+			if err != nil {
+				continue
+			}
+			unix := t.Unix()
+			if e.Count == 0 || unix < e.Start {
+				e.Start = unix
+			}
+			if e.Count == 0 || unix > e.End {
+				e.End = unix
+			}
+			e.Count++
+		}
+		e.Length = cr.n - offset
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no gzip members")
+	}
+
+	tmp := path + params.CatTracksIndexSuffix + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(out)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			_ = out.Close()
+			_ = os.Remove(tmp)
+			return nil, err
+		}
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path+params.CatTracksIndexSuffix); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/stream"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCat_StoreTracksYYYYMM_Index(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+
+	// 2 days of tracks, every 10 minutes, written in two populates.
+	start := time.Date(2024, 12, 10, 0, 0, 0, 0, time.Local)
+	tracks := syntheticTracks(t, start, 10*time.Minute, 6*48)
+	for _, batch := range [][]int{{0, 100}, {100, len(tracks)}} {
+		if err := <-c.StoreTracksYYYYMM(ctx, stream.Slice(ctx, tracks[batch[0]:batch[1]])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(c.State.Flat.Path(), tracksYYYYMMPath(start))
+	entries, ok, err := readTracksIndex(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("index does not cover archive")
+	}
+	// One member per hour, plus one for the hour split across populates.
+	if len(entries) != 49 {
+		t.Errorf("expected 49 index entries, got %d", len(entries))
+	}
+	count := 0
+	for _, e := range entries {
+		count += e.Count
+	}
+	if count != len(tracks) {
+		t.Errorf("index count %d != %d tracks", count, len(tracks))
+	}
+
+	// Indexed query reads only overlapping members and finds the same tracks as a full scan.
+	q := &TracksQuery{Start: start.Add(30 * time.Hour), End: start.Add(31*time.Hour + 30*time.Minute)}
+	out, errs := c.QueryTracks(ctx, q)
+	got := stream.Collect(ctx, out)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Errorf("expected 10 tracks, got %d", len(got))
+	}
+
+	// A broken index is rebuilt on first use, the same as written.
+	if err := os.Truncate(archive+params.CatTracksIndexSuffix, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := readTracksIndex(archive); ok {
+		t.Fatal("expected empty index to be unusable")
+	}
+	out, errs = c.QueryTracks(ctx, q)
+	got = stream.Collect(ctx, out)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Errorf("expected 10 tracks on reindex, got %d", len(got))
+	}
+	rebuilt, ok, err := readTracksIndex(archive)
+	if err != nil || !ok {
+		t.Fatalf("expected rebuilt index, got ok=%v err=%v", ok, err)
+	}
+	if !reflect.DeepEqual(rebuilt, entries) {
+		t.Errorf("rebuilt index differs from written index")
+	}
+}
+
+func TestCat_QueryTracks_LegacyArchive(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+	start := time.Date(2024, 12, 10, 0, 0, 0, 0, time.Local)
+	tracks := syntheticTracks(t, start, 10*time.Minute, 6*24)
+
+	// A legacy archive is one member of tracks, without an index.
+	w, err := c.State.Flat.NewGZFileWriter(tracksYYYYMMPath(start), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(w)
+	for _, ct := range tracks[:100] {
+		if err := enc.Encode(ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Indexed tracks appended since do not cover the archive.
+	if err := <-c.StoreTracksYYYYMM(ctx, stream.Slice(ctx, tracks[100:])); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(c.State.Flat.Path(), tracksYYYYMMPath(start))
+	if _, ok, _ := readTracksIndex(archive); ok {
+		t.Fatal("expected legacy archive index to be unusable")
+	}
+
+	q := &TracksQuery{Start: start.Add(2 * time.Hour), End: start.Add(3*time.Hour - time.Second)}
+	out, errs := c.QueryTracks(ctx, q)
+	got := stream.Collect(ctx, out)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 {
+		t.Errorf("expected 6 tracks, got %d", len(got))
+	}
+
+	entries, ok, err := readTracksIndex(archive)
+	if err != nil || !ok {
+		t.Fatalf("expected rebuilt index, got ok=%v err=%v", ok, err)
+	}
+	// The legacy member, then one per hour appended.
+	if len(entries) != 1+(len(tracks)-100+5)/6 {
+		t.Errorf("got %d entries", len(entries))
+	}
+	if e := entries[0]; e.Offset != 0 || e.Count != 100 || e.Start != start.Unix() {
+		t.Errorf("got legacy entry %+v", e)
+	}
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tracksYYYYMMLayout is the time layout used to name the monthly track archives.
+const tracksYYYYMMLayout = "2006-01"
+
+// tracksYYYYMMPath returns the cat-relative path of the monthly archive holding tracks at time t.
+func tracksYYYYMMPath(t time.Time) string {
+	return filepath.Join(params.CatTracksDir, t.Format(tracksYYYYMMLayout)+".geojson.gz")
+}
+
+// TracksQuery describes a filter over a cat's monthly track archives.
+// Zero values are unbounded.
+type TracksQuery struct {
+	Start time.Time
+	End   time.Time
+
+	// Bound, if non-nil, limits tracks to those with points within it.
+	Bound *orb.Bound
+
+	// Activities, if non-empty, limits tracks to those with any of these activities.
+	Activities []activity.Activity
+
+	// Limit is the maximum number of tracks to return. Zero is no limit.
+	Limit int
+}
+
+// Match returns true if the track satisfies the query's time, bound, and activity filters.
+// It does not consider the limit.
+func (q *TracksQuery) Match(ct cattrack.CatTrack) bool {
+	t, err := ct.Time()
+	if err != nil {
+		return false
+	}
+	if !q.Start.IsZero() && t.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && t.After(q.End) {
+		return false
+	}
+	if q.Bound != nil && !q.Bound.Contains(ct.Point()) {
+		return false
+	}
+	if len(q.Activities) > 0 {
+		act := ct.MustActivity()
+		ok := false
+		for _, a := range q.Activities {
+			if a == act {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsMonth returns true if the query's time range overlaps the month beginning at month.
+// Archives are named for the local time of the writer, so the month is padded by a day
+// on either side to avoid missing tracks near the edges.
+func (q *TracksQuery) overlapsMonth(month time.Time) bool {
+	lo := month.AddDate(0, 0, -1)
+	hi := month.AddDate(0, 1, 1)
+	if !q.Start.IsZero() && q.Start.After(hi) {
+		return false
+	}
+	if !q.End.IsZero() && q.End.Before(lo) {
+		return false
+	}
+	return true
+}
+
+// TracksArchivePaths returns the absolute paths of the cat's monthly track archives
+// which may hold tracks matching the query's time range, in chronological order.
+func (c *Cat) TracksArchivePaths(q *TracksQuery) ([]string, error) {
+	c.getOrInitState(true)
+
+	matches, err := filepath.Glob(filepath.Join(c.State.Flat.Path(), params.CatTracksDir, "*.geojson.gz"))
+	if err != nil {
+		return nil, err
+	}
+	type archive struct {
+		path  string
+		month time.Time
+	}
+	archives := []archive{}
+	for _, p := range matches {
+		name := strings.TrimSuffix(filepath.Base(p), ".geojson.gz")
+		month, err := time.ParseInLocation(tracksYYYYMMLayout, name, time.Local)
+		if err != nil {
+			c.logger.Warn("Skipping unrecognized tracks archive", "path", p)
+			continue
+		}
+		if !q.overlapsMonth(month) {
+			continue
+		}
+		archives = append(archives, archive{path: p, month: month})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].month.Before(archives[j].month)
+	})
+	out := make([]string, len(archives))
+	for i, a := range archives {
+		out[i] = a.path
+	}
+	return out, nil
+}
+
+// QueryTracks streams tracks from the cat's monthly archives matching the query.
+// Only archives overlapping the query's time range are read.
+// Tracks are returned in archive (storage) order.
+// The error channel is closed when the stream is done.
+func (c *Cat) QueryTracks(ctx context.Context, q *TracksQuery) (<-chan cattrack.CatTrack, chan error) {
+	out := make(chan cattrack.CatTrack)
+	errs := make(chan error, 1)
+	if q == nil {
+		q = &TracksQuery{}
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && q.End.Before(q.Start) {
+		close(out)
+		errs <- fmt.Errorf("query end %v before start %v", q.End, q.Start)
+		close(errs)
+		return out, errs
+	}
+	paths, err := c.TracksArchivePaths(q)
+	if err != nil {
+		close(out)
+		errs <- err
+		close(errs)
+		return out, errs
+	}
+	c.logger.Debug("Querying tracks archives", "archives", len(paths))
+
+	go func() {
+		defer close(errs)
+		defer close(out)
+		sent := 0
+		for _, p := range paths {
+			done, err := c.queryTracksArchive(ctx, p, q, out, &sent)
+			if err != nil {
+				c.logger.Error("Failed to query tracks archive", "path", p, "error", err)
+				errs <- err
+				return
+			}
+			if done {
+				return
+			}
+		}
+	}()
+	return out, errs
+}
+
+// queryTracksArchive sends matching tracks from one archive to out.
+// If the query is time-bounded, only the gzip members overlapping the query's time range are read,
+// per the archive's sparse index, which is rebuilt first if it does not cover the archive.
+// It returns done=true if the query limit has been reached or the context canceled.
+func (c *Cat) queryTracksArchive(ctx context.Context, path string, q *TracksQuery, out chan<- cattrack.CatTrack, sent *int) (done bool, err error) {
+	r, err := c.State.Flat.NewGZFileReader(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer r.Close()
+
+	if q.Start.IsZero() && q.End.IsZero() {
+		return sendMatchingTracks(ctx, r, q, out, sent)
+	}
+	entries, ok, err := readTracksIndex(path)
+	if err != nil {
+		c.logger.Warn("Failed to read tracks index, reindexing", "path", path, "error", err)
+	}
+	if !ok {
+		// Rebuild the index on first use, eg. for archives written before indexing existed.
+		entries, err = reindexTracksArchive(path)
+		if err != nil {
+			c.logger.Warn("Failed to reindex tracks archive, scanning", "path", path, "error", err)
+			return sendMatchingTracks(ctx, r, q, out, sent)
+		}
+		c.logger.Info("Reindexed tracks archive", "path", path, "entries", len(entries))
+	}
+	for _, e := range entries {
+		if !e.Overlaps(q.Start, q.End) {
+			continue
+		}
+		if err := r.SeekMember(e.Offset); err != nil {
+			return false, err
+		}
+		if done, err := sendMatchingTracks(ctx, r, q, out, sent); done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+// sendMatchingTracks decodes tracks from r until EOF, sending those matching the query to out.
+func sendMatchingTracks(ctx context.Context, r io.Reader, q *TracksQuery, out chan<- cattrack.CatTrack, sent *int) (done bool, err error) {
+	dec := json.NewDecoder(r)
+	for {
+		ct := cattrack.CatTrack{}
+		if err := dec.Decode(&ct); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		if !q.Match(ct) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case out <- ct:
+		}
+		*sent++
+		if q.Limit > 0 && *sent >= q.Limit {
+			return true, nil
+		}
+	}
+}
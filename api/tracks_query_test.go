@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/testing/testdata"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/rotblauer/catd/types/cattrack"
+	"testing"
+	"time"
+)
+
+// syntheticTracks returns n tracks spaced by step, starting at start,
+// walking north-east from the iOS stationary test track.
+func syntheticTracks(t *testing.T, start time.Time, step time.Duration, n int) []cattrack.CatTrack {
+	out := make([]cattrack.CatTrack, 0, n)
+	for i := 0; i < n; i++ {
+		ct := cattrack.CatTrack{}
+		if err := json.Unmarshal([]byte(testdata.Track_iOS_stationary_1), &ct); err != nil {
+			t.Fatal(err)
+		}
+		ts := start.Add(time.Duration(i) * step)
+		ct.SetPropertySafe("Time", ts.Format(time.RFC3339))
+		ct.SetPropertySafe("UnixTime", ts.Unix())
+		pt := ct.Point()
+		ct.Geometry = orb.Point{pt.Lon() + float64(i)*0.001, pt.Lat() + float64(i)*0.001}
+		if i%2 == 0 {
+			ct.SetPropertySafe("Activity", "Walking")
+		}
+		out = append(out, ct)
+	}
+	return out
+}
+
+func TestCat_QueryTracks(t *testing.T) {
+	tc := NewTestCatWriter(t, "rye", nil)
+	c := tc.Cat()
+	defer tc.CloseAndDestroy()
+
+	ctx := context.Background()
+
+	// 90 tracks, one per day, spanning 3-4 monthly archives.
+	start := time.Date(2024, 10, 15, 12, 0, 0, 0, time.Local)
+	tracks := syntheticTracks(t, start, 24*time.Hour, 90)
+	if err := <-c.StoreTracksYYYYMM(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := c.TracksArchivePaths(&TracksQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 archives, got %d: %v", len(all), all)
+	}
+
+	cases := []struct {
+		name     string
+		query    *TracksQuery
+		archives int
+		want     int
+	}{
+		{"all", &TracksQuery{}, 4, 90},
+		{"limit", &TracksQuery{Limit: 7}, 4, 7},
+		{"range", &TracksQuery{
+			Start: start.Add(10 * 24 * time.Hour),
+			End:   start.Add(19 * 24 * time.Hour),
+		}, 2, 10},
+		{"activity", &TracksQuery{Activities: []activity.Activity{activity.TrackerStateWalking}}, 4, 45},
+		{"bbox", &TracksQuery{Bound: &orb.Bound{
+			Min: orb.Point{-94, 44},
+			Max: orb.Point{tracks[4].Point().Lon() + 0.0001, 46},
+		}}, 4, 5},
+		{"none", &TracksQuery{Start: start.AddDate(1, 0, 0)}, 0, 0},
+	}
+	for _, cs := range cases {
+		paths, err := c.TracksArchivePaths(cs.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != cs.archives {
+			t.Errorf("%s: expected %d archives, got %d", cs.name, cs.archives, len(paths))
+		}
+		out, errs := c.QueryTracks(ctx, cs.query)
+		got := stream.Collect(ctx, out)
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != cs.want {
+			t.Errorf("%s: expected %d tracks, got %d", cs.name, cs.want, len(got))
+		}
+		for _, ct := range got {
+			if !cs.query.Match(ct) {
+				t.Errorf("%s: unexpected track %v", cs.name, ct.MustTime())
+			}
+		}
+	}
+}
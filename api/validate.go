@@ -22,18 +22,21 @@ func (c *Cat) Validate(ctx context.Context, in <-chan cattrack.CatTrack) (valid
 			if ct.IsEmpty() {
 				c.logger.Error("Invalid track: track is empty")
 				ct.SetPropertySafe(PropKeyInvalid, "empty")
+				c.receipt.noteInvalid("empty")
 				invalid <- ct
 				continue
 			}
 			if err := ct.Validate(); err != nil {
 				c.logger.Error("Invalid track", "error", err)
 				ct.SetPropertySafe(PropKeyInvalid, err.Error())
+				c.receipt.noteInvalid(err.Error())
 				invalid <- ct
 				continue
 			}
 			if id := ct.CatID(); c.CatID != id {
 				c.logger.Error("Invalid track, mismatched cat", "want", fmt.Sprintf("%q", c.CatID), "got", fmt.Sprintf("%q", id))
 				ct.SetPropertySafe(PropKeyInvalid, "mismatched cat")
+				c.receipt.noteInvalid("mismatched cat")
 				invalid <- ct
 				continue
 			}
@@ -74,6 +77,7 @@ func (c *Cat) dedupe(ctx context.Context, size int, in <-chan cattrack.CatTrack)
 	return stream.Filter(ctx, func(ct cattrack.CatTrack) bool {
 		if !dedupeCache(ct) {
 			c.logger.Warn("Deduped track", "track", ct.StringPretty())
+			c.receipt.noteDeduped()
 			return false
 		}
 		return true
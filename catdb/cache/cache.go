@@ -16,3 +16,7 @@ var LastKnownTTLCache = ttlcache.New[string, *cattrack.CatTrack](
 func SetLastKnownTTL(catID conceptual.CatID, ct *cattrack.CatTrack) {
 	LastKnownTTLCache.Set(catID.String(), ct, ttlcache.DefaultTTL)
 }
+
+func SetLastPushTTL(catID conceptual.CatID, tracks []*cattrack.CatTrack) {
+	LastPushTTLCache.Set(catID.String(), tracks, ttlcache.DefaultTTL)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"compress/gzip"
 	"github.com/rotblauer/catd/params"
+	"io"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -17,6 +18,12 @@ type GZFileWriter struct {
 	locked atomic.Bool
 	closed atomic.Bool
 
+	// dirty is true when the current gzip member has been written to.
+	// rotated is true once NewMember has been called.
+	// Together they let Close avoid writing an empty trailing member.
+	dirty   bool
+	rotated bool
+
 	GZFileWriterConfig
 }
 
@@ -58,9 +65,32 @@ func NewGZFileWriter(path string, config *GZFileWriterConfig) (*GZFileWriter, er
 
 func (g *GZFileWriter) Write(p []byte) (int, error) {
 	g.tryLock()
+	g.dirty = true
 	return g.gzw.Write(p)
 }
 
+// NewMember finishes the current gzip member, if it has been written to,
+// and begins a new one, returning the file offset at which the new member starts.
+// Concatenated members are a valid gzip stream, and GZFileReader.SeekMember
+// can start reading at any offset returned here.
+// NewMember locks the file, since offsets are meaningless under concurrent appends.
+func (g *GZFileWriter) NewMember() (offset int64, err error) {
+	g.tryLock()
+	g.rotated = true
+	if g.dirty {
+		if err := g.gzw.Close(); err != nil {
+			return 0, err
+		}
+		g.gzw.Reset(g.f)
+		g.dirty = false
+	}
+	fi, err := g.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
 func (g *GZFileWriter) Writer() *gzip.Writer {
 	return g.gzw
 }
@@ -90,6 +120,11 @@ func (g *GZFileWriter) unlock() {
 func (g *GZFileWriter) Close() error {
 	defer g.closed.Store(true)
 	defer g.unlock()
+	if g.rotated && !g.dirty {
+		// The last member was finished by NewMember and nothing has been written since.
+		g.unlock()
+		return g.f.Close()
+	}
 	err := g.gzw.Flush()
 	if err != nil {
 		return err
@@ -108,8 +143,10 @@ func (g *GZFileWriter) Close() error {
 
 func (g *GZFileWriter) TryClose() error {
 	defer g.closed.Store(true)
-	_ = g.gzw.Flush()
-	_ = g.gzw.Close()
+	if !g.rotated || g.dirty {
+		_ = g.gzw.Flush()
+		_ = g.gzw.Close()
+	}
 	_ = g.f.Sync()
 	g.unlock()
 	return g.f.Close()
@@ -117,8 +154,10 @@ func (g *GZFileWriter) TryClose() error {
 
 func (g *GZFileWriter) CloseNoReply() {
 	defer g.closed.Store(true)
-	_ = g.gzw.Flush()
-	_ = g.gzw.Close()
+	if !g.rotated || g.dirty {
+		_ = g.gzw.Flush()
+		_ = g.gzw.Close()
+	}
 	_ = g.f.Sync()
 	g.unlock()
 	_ = g.f.Close()
@@ -187,6 +226,21 @@ func (g *GZFileReader) Read(p []byte) (int, error) {
 	return g.gzr.Read(p)
 }
 
+// SeekMember positions the reader at the gzip member starting at offset,
+// as returned by GZFileWriter.NewMember.
+// Subsequent reads return only that member's content, then io.EOF;
+// call SeekMember again to read another member.
+func (g *GZFileReader) SeekMember(offset int64) error {
+	if _, err := g.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := g.gzr.Reset(g.f); err != nil {
+		return err
+	}
+	g.gzr.Multistream(false)
+	return nil
+}
+
 // Reader returns the gzip reader for the file.
 func (g *GZFileReader) Reader() *gzip.Reader {
 	return g.gzr
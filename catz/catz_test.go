@@ -166,3 +166,61 @@ func TestGZFileWriter_Write(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGZFileWriter_NewMember(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "members.gz")
+
+	w, err := NewGZFileWriter(target, DefaultGZFileWriterConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{}
+	for i := 0; i < 3; i++ {
+		off, err := w.NewMember()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A second call without writes must not start an empty member.
+		if again, err := w.NewMember(); err != nil || again != off {
+			t.Fatalf("unexpected offset %d != %d, err: %v", again, off, err)
+		}
+		offsets = append(offsets, off)
+		if _, err := w.Write([]byte(fmt.Sprintf("member %d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if offsets[0] != 0 || offsets[1] <= offsets[0] || offsets[2] <= offsets[1] {
+		t.Fatalf("unexpected offsets: %v", offsets)
+	}
+
+	// The whole file reads as one multi-member stream.
+	r, err := NewGZFileReader(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	all, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "member 0\nmember 1\nmember 2\n" {
+		t.Fatalf("unexpected content: %q", all)
+	}
+
+	// Seeking reads exactly one member.
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if err := r.SeekMember(offsets[i]); err != nil {
+			t.Fatal(err)
+		}
+		one, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("member %d\n", i); string(one) != want {
+			t.Fatalf("unexpected member content: %q, want %q", one, want)
+		}
+	}
+}
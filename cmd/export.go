@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/export"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var optExportFormat string
+var optExportOutput string
+var optExportStart string
+var optExportEnd string
+var optExportActivities []string
+var optExportPublic bool
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export CAT",
+	Short: "Export a cat's tracks, laps and naps as GPX, KML or CSV",
+	Long: `Export a cat's tracks, laps and naps for a time range as GPX, KML or CSV,
+eg. to hand a bike ride to Strava or Google Earth.
+
+GPX has a waypoint for each nap, and a track with a segment for each lap,
+and for the tracks between laps. Track points have elevation, and heart rate
+and speed extensions.
+KML has folders of naps, laps as LineStrings styled by activity, and tracks.
+CSV has a row for each track, with the number of its lap, if any.
+
+The format defaults to the output file's extension, else gpx.
+Times are RFC3339 or YYYY-MM-DD, local.
+
+webd serves the same at /{cat}/tracks.gpx, /{cat}/tracks.kml and /{cat}/tracks.csv,
+with start, end, bbox, limit and activity query parameters.
+
+Examples:
+
+  catd export rye --start 2024-06-01 --end 2024-06-02 --activity Bike -o ride.gpx
+  catd export rye --start 2024-06-01T08:00:00-05:00 --format csv > rye.csv
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+
+		if optExportFormat == "" {
+			optExportFormat = strings.TrimPrefix(filepath.Ext(optExportOutput), ".")
+			if optExportFormat == "" {
+				optExportFormat = string(export.FormatGPX)
+			}
+		}
+		format, err := export.ParseFormat(optExportFormat)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		q := &api.TracksQuery{}
+		if q.Start, err = parseExportTime(optExportStart); err != nil {
+			log.Fatalln("start:", err)
+		}
+		if q.End, err = parseExportTime(optExportEnd); err != nil {
+			log.Fatalln("end:", err)
+		}
+		for _, a := range optExportActivities {
+			act := activity.FromString(a)
+			if act.IsUnknown() && !strings.EqualFold(a, "unknown") {
+				log.Fatalf("invalid activity: %q\n", a)
+			}
+			q.Activities = append(q.Activities, act)
+		}
+
+		cat := openCatState(args[0], true)
+		defer cat.Close()
+		var zones api.PrivacyZones
+		if optExportPublic {
+			if zones, err = cat.PrivacyZones(); err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		var w io.Writer = os.Stdout
+		if optExportOutput != "" && optExportOutput != "-" {
+			f, err := os.Create(optExportOutput)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := cat.Export(ctx, w, format, q, zones); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+// parseExportTime parses a time flag as RFC3339, or a local date YYYY-MM-DD.
+// Dates are midnight, so an end of 2024-06-02 ends as that day begins.
+func parseExportTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("want RFC3339 or YYYY-MM-DD: %w", err)
+	}
+	return t, nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	flags := exportCmd.Flags()
+	flags.StringVarP(&optExportFormat, "format", "f", "",
+		`Export format: gpx, kml or csv. Default the output's extension, else gpx.`)
+	flags.StringVarP(&optExportOutput, "output", "o", "",
+		`Output file. Default stdout.`)
+	flags.StringVar(&optExportStart, "start", "",
+		`Export tracks from this time. Default the first.`)
+	flags.StringVar(&optExportEnd, "end", "",
+		`Export tracks until this time. Default the last.`)
+	flags.StringSliceVar(&optExportActivities, "activity", nil,
+		`Only export tracks and laps with these activities, eg. Bike,Running. Default all.`)
+	flags.BoolVar(&optExportPublic, "public", false,
+		`Apply the cat's privacy zones, as webd does.`)
+}
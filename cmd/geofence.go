@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var optGeofenceEventsSince time.Duration
+var optGeofenceEventsLimit int
+
+// geofenceCmd represents the geofence command group
+var geofenceCmd = &cobra.Command{
+	Use:   "geofence",
+	Short: "Manage cat geofences",
+	Long: `Manage a cat's geofences, stored in its cat state.
+
+Geofences are named polygons. As a cat's tracks are populated, they are evaluated against
+its geofences, emitting events when the cat enters or exits a fence,
+and, for fences with a dwell, when the cat has been inside the fence for that long.
+
+Events are stored in the cat state, published to webd's /{cat}/events stream,
+broadcast on /socat to clients subscribed to "geofence" events,
+and POSTed to the webd --geofence.webhook URL, if set.
+
+Geofences are a JSON array, eg.
+
+  [
+    {"name": "home", "polygon": [[[-93.26, 44.97], [-93.24, 44.97], [-93.24, 44.99], [-93.26, 44.97]]], "dwell_seconds": 300}
+  ]
+
+Examples:
+
+  catd geofence set rye fences.json
+  catd geofence ls rye
+  catd geofence events rye --since 24h
+  catd geofence clear rye
+`,
+}
+
+func openCatState(catID string, readOnly bool) *api.Cat {
+	cat, err := api.NewCat(conceptual.CatID(catID), params.DefaultCatDataDir(catID), nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := cat.LockOrLoadState(readOnly); err != nil {
+		log.Fatalln(err)
+	}
+	return cat
+}
+
+var geofenceSetCmd = &cobra.Command{
+	Use:   "set CAT [FILE]",
+	Short: "Set a cat's geofences from a JSON file, or stdin",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		var r io.Reader = os.Stdin
+		if len(args) == 2 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		fences := api.Geofences{}
+		if err := json.NewDecoder(r).Decode(&fences); err != nil {
+			log.Fatalln("Failed to decode geofences:", err)
+		}
+		cat := openCatState(args[0], false)
+		defer cat.Close()
+		if err := cat.SetGeofences(fences); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var geofenceLsCmd = &cobra.Command{
+	Use:   "ls CAT",
+	Short: "List a cat's geofences",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cat := openCatState(args[0], true)
+		defer cat.Close()
+		fences, err := cat.Geofences()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPOINTS\tDWELL")
+		for _, g := range fences {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", g.Name, len(g.Polygon[0]), g.Dwell())
+		}
+		tw.Flush()
+	},
+}
+
+var geofenceClearCmd = &cobra.Command{
+	Use:   "clear CAT",
+	Short: "Remove all of a cat's geofences",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cat := openCatState(args[0], false)
+		defer cat.Close()
+		if err := cat.SetGeofences(nil); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var geofenceEventsCmd = &cobra.Command{
+	Use:   "events CAT",
+	Short: "Print a cat's geofence events as NDJSON, oldest first",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cat := openCatState(args[0], true)
+		defer cat.Close()
+		since := time.Time{}
+		if optGeofenceEventsSince > 0 {
+			since = time.Now().Add(-optGeofenceEventsSince)
+		}
+		events, err := cat.GeofenceEvents(since, optGeofenceEventsLimit)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(geofenceCmd)
+	geofenceCmd.AddCommand(geofenceSetCmd, geofenceLsCmd, geofenceClearCmd, geofenceEventsCmd)
+
+	flags := geofenceEventsCmd.Flags()
+	flags.DurationVar(&optGeofenceEventsSince, "since", 0,
+		`Only print events within this duration of now, eg. 24h. Default all.`)
+	flags.IntVar(&optGeofenceEventsLimit, "limit", 0,
+		`Print at most this many events. Default all.`)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/reducer"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+var optIndexCats []string
+var optIndexReencode bool
+
+// indexCmd represents the index command group
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Inspect and maintain the reducer index dbs",
+	Long: `Inspect and maintain the reducer index dbs, s2.db and rgeo.db.
+
+Dbs are given as paths, or by cat with --cats, which names each cat's ` + params.S2DBName + ` and ` + params.RgeoDBName + `.
+The ` + conceptual.AllCatsID.String() + ` cat names the all-cats ` + params.S2DBName + `, under the datadir root.
+
+Stats and verify open the dbs read-only, but wait on dbs open elsewhere, eg. by a populating webd.
+Compact needs the dbs to itself. Values in the legacy gzipped JSON encoding, counted by stats,
+are re-encoded in the binary encoding by compact --reencode.
+
+Examples:
+
+  catd index stats --cats rye,ia,all-cats
+  catd index verify ~/tdata/cats/rye/s2.db
+  catd index compact --cats rye --reencode
+`,
+}
+
+var indexStatsCmd = &cobra.Command{
+	Use:   "stats [DB...]",
+	Short: "Report per-bucket cell counts, sizes and LastTime ranges",
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "DB\tBUCKET\tCELLS\tKEYS\tVALUES\tOLDEST\tNEWEST\tLEGACY\tCORRUPT")
+		for _, db := range indexDBPaths(args) {
+			stats, err := reducer.Stats(db)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			for _, st := range stats {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%d\t%d\n", db, st.Bucket, st.Cells,
+					humanize.Bytes(uint64(st.KeyBytes)), humanize.Bytes(uint64(st.ValueBytes)),
+					formatIndexTime(st.OldestLastTime), formatIndexTime(st.NewestLastTime), st.Legacy, st.Corrupt)
+			}
+		}
+		tw.Flush()
+	},
+}
+
+var indexVerifyCmd = &cobra.Command{
+	Use:   "verify [DB...]",
+	Short: "Decode every value, reporting corrupt values",
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		corrupt := 0
+		for _, db := range indexDBPaths(args) {
+			bad, err := reducer.Verify(db)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			for _, v := range bad {
+				fmt.Printf("%s: %v\n", db, v)
+			}
+			corrupt += len(bad)
+		}
+		if corrupt > 0 {
+			log.Fatalf("%d corrupt values", corrupt)
+		}
+	},
+}
+
+var indexCompactCmd = &cobra.Command{
+	Use:   "compact [DB...]",
+	Short: "Rewrite the dbs, reclaiming free pages",
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		for _, db := range indexDBPaths(args) {
+			before, after, err := reducer.Compact(db, &reducer.CompactConfig{Reencode: optIndexReencode})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fmt.Printf("%s: %s -> %s\n", db, humanize.Bytes(uint64(before)), humanize.Bytes(uint64(after)))
+		}
+	},
+}
+
+// indexDBPaths returns the db paths of the args and the --cats flag.
+// Cats' dbs which do not exist are skipped.
+func indexDBPaths(args []string) []string {
+	out := append([]string{}, args...)
+	for _, cat := range optIndexCats {
+		id := conceptual.CatID(cat)
+		names := []string{
+			filepath.Join(params.DefaultCatDataDir(id.String()), params.S2DBName),
+			filepath.Join(params.DefaultCatDataDir(id.String()), params.RgeoDBName),
+		}
+		if id == conceptual.AllCatsID {
+			names = []string{filepath.Join(params.DefaultDatadirRoot, params.S2DBName)}
+		}
+		for _, name := range names {
+			if _, err := os.Stat(name); err == nil {
+				out = append(out, name)
+			}
+		}
+	}
+	if len(out) == 0 {
+		log.Fatalln("No dbs, give db paths or --cats")
+	}
+	return out
+}
+
+func formatIndexTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func init() {
+	rootCmd.AddCommand(indexCmd)
+	indexCmd.AddCommand(indexStatsCmd, indexVerifyCmd, indexCompactCmd)
+
+	indexCmd.PersistentFlags().StringSliceVar(&optIndexCats, "cats", nil,
+		`Cats whose dbs to use, in addition to any db paths.`)
+	indexCompactCmd.Flags().BoolVar(&optIndexReencode, "reencode", false,
+		`Re-encode every value in the current value encoding, eg. after the encoding changes.`)
+}
@@ -16,7 +16,9 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/paulmach/orb/geojson"
@@ -28,6 +30,7 @@ import (
 	"github.com/rotblauer/catd/names"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types"
 	"github.com/rotblauer/catd/types/cattrack"
 	"github.com/spf13/cobra"
 	"github.com/tidwall/gjson"
@@ -49,6 +52,8 @@ var optPushLimitN int = 0 // 0 to disable.
 var optAutoTilingOff bool
 var optAutoRgeoDOff bool
 var optWhitelistCats []string
+var optPopulateFormat string
+var optPopulateCat string
 
 // populateCmd represents the import command
 var populateCmd = &cobra.Command{
@@ -65,9 +70,13 @@ This command can run idempotently, or incrementally, given the same source.
 Cats will refuse to import "backtracks", where those are tracks which do not extend
 their first:last track time range.
 
+GPX, TCX and raw NMEA ($GPRMC, $GPGGA) records, eg. from dedicated GPS units,
+are converted to tracks of the cat named by --cat. The format is sniffed, unless given by --format.
+
 Examples:
 
   zcat master.json.gz | catd populate --workers 12 --batch-size 9_000 --sort true
+  cat boston-bike-2012-05-01.gpx | catd populate --cat ia
 
 Notes:
 
@@ -153,6 +162,12 @@ Missoula, Montana
 			dConfig.AwaitPendingOnShutdown = optTilingAwaitPending
 			dConfig.TilingPendingExpiry = optTilingPendingExpiry
 			dConfig.SkipEdge = optTilingSkipEdge
+			if err := setTilingBackends(dConfig, optTilingBackends); err != nil {
+				log.Fatalln(err)
+			}
+			if err := setTilingScheduling(dConfig); err != nil {
+				log.Fatalln(err)
+			}
 			var err error
 			d, err = tiled.NewDaemon(dConfig)
 			if err != nil {
@@ -176,9 +191,14 @@ Missoula, Montana
 			whiteCats = nil
 		}
 
+		input, err := populateInput(os.Stdin)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
 		quitScanner := make(chan struct{}, 4)
 		catChCh, scanErrCh := stream.ScanLinesUnbatchedCats(
-			os.Stdin, quitScanner,
+			input, quitScanner,
 			// Small buffer to keep scanner running while workers catch up.
 			// A small buffer is faster than a large one,
 			// but too small is slower. These numbers are magic. Around 5MB/s.
@@ -297,6 +317,35 @@ Missoula, Montana
 	},
 }
 
+// populateInput returns the input as JSON lines.
+// GPX, TCX and NMEA input, per --format or as sniffed, is converted to NDJSON features of the --cat cat.
+func populateInput(r io.Reader) (io.Reader, error) {
+	format, err := types.ParseImportFormat(optPopulateFormat)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewReader(r)
+	if format == "" {
+		peek, _ := buf.Peek(1024)
+		format = types.SniffImportFormat(peek)
+	}
+	if format == types.ImportFormatJSON {
+		return buf, nil
+	}
+	if optPopulateCat == "" {
+		return nil, fmt.Errorf("--cat is required to import %s", format)
+	}
+	slog.Info("Importing tracks", "format", format, "cat", optPopulateCat)
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		pw.CloseWithError(types.DecodeImportTracks(buf, format, optPopulateCat, func(ct *cattrack.CatTrack) error {
+			return enc.Encode(ct)
+		}))
+	}()
+	return pr, nil
+}
+
 type catWorker struct {
 	ctx     context.Context
 	backend *params.CatRPCServices
@@ -419,6 +468,11 @@ Using a zero value causes Populate to read the entire input stream, unless inter
 	flags.StringSliceVar(&optWhitelistCats, "whitelist", nil,
 		`Only these cats will be populated.`)
 
+	flags.StringVar(&optPopulateFormat, "format", "auto",
+		`Input format: json, gpx, tcx, nmea, or auto to sniff.`)
+	flags.StringVar(&optPopulateCat, "cat", "",
+		`Cat name for GPX, TCX and NMEA input, which don't name their cats.`)
+
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// populateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// privacyCmd represents the privacy command group
+var privacyCmd = &cobra.Command{
+	Use:   "privacy",
+	Short: "Manage cat privacy zones",
+	Long: `Manage a cat's privacy zones, stored in its cat state.
+
+Privacy zones are areas, like home or work, where a cat's real points are not published.
+Points inside a zone are snapped to its center, or omitted, per the zone mode,
+before tiled pushes, websocket broadcasts, and webd read routes, eg. /{cat}/last.json.
+Stored tracks keep their real points.
+
+Zones are a JSON array, eg.
+
+  [
+    {"name": "home", "center": [-93.25, 44.98], "radius_meters": 300, "mode": "snap"},
+    {"name": "work", "polygon": [[[-93.1, 44.9], [-93.0, 44.9], [-93.0, 45.0], [-93.1, 44.9]]], "mode": "omit"}
+  ]
+
+A running webd picks up zone changes on the cat's next populate.
+Existing tiles are only privatized when next pushed to tiled.
+
+Examples:
+
+  catd privacy set rye zones.json
+  catd privacy ls rye
+  catd privacy clear rye
+`,
+}
+
+func openCatForPrivacy(catID string, readOnly bool) *api.Cat {
+	cat, err := api.NewCat(conceptual.CatID(catID), params.DefaultCatDataDir(catID), nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := cat.LockOrLoadState(readOnly); err != nil {
+		log.Fatalln(err)
+	}
+	return cat
+}
+
+var privacySetCmd = &cobra.Command{
+	Use:   "set CAT [FILE]",
+	Short: "Set a cat's privacy zones from a JSON file, or stdin",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		var r io.Reader = os.Stdin
+		if len(args) == 2 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		zones := api.PrivacyZones{}
+		if err := json.NewDecoder(r).Decode(&zones); err != nil {
+			log.Fatalln("Failed to decode privacy zones:", err)
+		}
+		cat := openCatForPrivacy(args[0], false)
+		defer cat.Close()
+		if err := cat.SetPrivacyZones(zones); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+var privacyLsCmd = &cobra.Command{
+	Use:   "ls CAT",
+	Short: "List a cat's privacy zones",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cat := openCatForPrivacy(args[0], true)
+		defer cat.Close()
+		zones, err := cat.PrivacyZones()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tMODE\tCENTER\tAREA")
+		for _, z := range zones {
+			area := fmt.Sprintf("radius %.0fm", z.RadiusMeters)
+			if len(z.Polygon) > 0 {
+				area = fmt.Sprintf("polygon %d points", len(z.Polygon[0]))
+			}
+			c := z.Centroid()
+			fmt.Fprintf(tw, "%s\t%s\t%.5f,%.5f\t%s\n", z.Name, z.Mode, c.Lon(), c.Lat(), area)
+		}
+		tw.Flush()
+	},
+}
+
+var privacyClearCmd = &cobra.Command{
+	Use:   "clear CAT",
+	Short: "Remove all of a cat's privacy zones",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cat := openCatForPrivacy(args[0], false)
+		defer cat.Close()
+		if err := cat.SetPrivacyZones(nil); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(privacyCmd)
+	privacyCmd.AddCommand(privacySetCmd, privacyLsCmd, privacyClearCmd)
+}
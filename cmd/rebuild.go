@@ -0,0 +1,112 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/common"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/spf13/cobra"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+var optRebuildCat string
+var optRebuildFrom string
+var optRebuildOnly []string
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Rebuild derived cat state from stored tracks",
+	Long: `Rebuild resets and regenerates derived state for one cat
+by replaying the cat's own tracks/YYYY-MM.geojson.gz archives through the producer pipelines.
+
+This is much faster than re-piping master.json.gz through populate,
+and is meant for iterating on act detection and clean filters.
+
+Rebuildable producers: ` + strings.Join(api.RebuildableProducers(), ", ") + `
+
+Selected producers' state is reset in full: lap, nap, and offset-index KV state,
+laps and naps gz files, and s2.db and rgeo.db buckets.
+With --from, derived state will reflect only tracks since then.
+Rebuilt features are not pushed to tiled.
+
+Examples:
+
+  catd rebuild --cat rye
+  catd rebuild --cat rye --from 2024-01 --only s2,laps
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		if len(optRebuildOnly) == 0 || slices.Contains(optRebuildOnly, api.ProducerRgeo) {
+			slog.Info("Checking auto-start on rgeod")
+			tryGetOrInitRgeoD(cmd, args)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		slog.Info("rebuild.Run")
+
+		if optRebuildCat == "" {
+			log.Fatalln("--cat is required")
+		}
+		config := &api.RebuildConfig{Only: optRebuildOnly}
+		if optRebuildFrom != "" {
+			from, err := time.ParseInLocation("2006-01", optRebuildFrom, time.Local)
+			if err != nil {
+				log.Fatalln("--from must be YYYY-MM:", err)
+			}
+			config.From = from
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			sig := <-common.Interrupted()
+			slog.Warn("Received signal, canceling rebuild", "signal", sig)
+			cancel()
+		}()
+
+		// No tiled backend; rebuilt features would be appended to existing tile sources.
+		backend := &params.CatRPCServices{RgeoD: params.InProcRgeoDaemonConfig}
+		id := conceptual.CatID(optRebuildCat)
+		cat, err := api.NewCat(id, params.DefaultCatDataDir(id.String()), backend)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := cat.Rebuild(ctx, config); err != nil {
+			log.Fatalln(err)
+		}
+		slog.Info("Rebuild complete", "cat", id)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebuildCmd)
+
+	flags := rebuildCmd.Flags()
+	flags.StringVar(&optRebuildCat, "cat", "", `Cat to rebuild (required)`)
+	flags.StringVar(&optRebuildFrom, "from", "",
+		`Replay tracks from this month, YYYY-MM. Default is all tracks.`)
+	flags.StringSliceVar(&optRebuildOnly, "only", nil,
+		`Only rebuild these producers. Default is all rebuildable producers.`)
+}
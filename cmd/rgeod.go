@@ -85,6 +85,7 @@ This flag configures a public inproc configuration structure instance.`)
 	// Share this flagset with other commands.
 	webdCmd.Flags().AddFlagSet(rgeodListenerFlags)
 	populateCmd.Flags().AddFlagSet(rgeodListenerFlags)
+	rebuildCmd.Flags().AddFlagSet(rgeodListenerFlags)
 
 	// Here you will define your flags and configuration settings.
 
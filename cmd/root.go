@@ -77,6 +77,13 @@ This value is used for buffering incoming tracks, for sorting, and for indexing.
 Bigger is not better. Less is not more.
 `)
 
+	pFlags.StringSliceVar(&params.ProducersEnabled, "producers", nil,
+		`Only run these cat producers (default all registered).
+Built-in producers: tracks, improved, influxdb, s2, rgeo, laps, naps, offsets, geofence`)
+
+	pFlags.StringSliceVar(&params.ProducersDisabled, "producers.disable", nil,
+		`Do not run these cat producers`)
+
 	pFlags.Int("verbosity", 0,
 		`Verbosity level -5, -4..8 (golang/slog) 
 https://pkg.go.dev/log/slog#Level`)
@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/dustin/go-humanize"
 	"github.com/rotblauer/catd/common"
 	"github.com/rotblauer/catd/daemon/tiled"
 	"github.com/rotblauer/catd/params"
@@ -25,6 +26,9 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,6 +40,12 @@ var optTilingListenAddress = params.DefaultTileDaemonConfig().Address
 var optTilingSkipEdge bool
 var optTilingPendingExpiry time.Duration
 var optTilingAwaitPending bool
+var optTilingBackends []string
+var optTilingNativeSourceLimit string
+var optTilingConcurrency = params.DefaultTileDaemonConfig().TilingConcurrency
+var optTilingCanonicalConcurrency = params.DefaultTileDaemonConfig().TilingCanonicalConcurrency
+var optTilingSourceBudget string
+var optTilingPriorities []string
 
 // tiledCmd represents the tiled command
 var tiledCmd = &cobra.Command{
@@ -57,6 +67,12 @@ Pushes will trigger internal tiling requests. (FIXME: Configurable.)
 		config.SkipEdge = optTilingSkipEdge
 		config.TilingPendingExpiry = optTilingPendingExpiry
 		config.AwaitPendingOnShutdown = optTilingAwaitPending
+		if err := setTilingBackends(config, optTilingBackends); err != nil {
+			log.Fatalln(err)
+		}
+		if err := setTilingScheduling(config); err != nil {
+			log.Fatalln(err)
+		}
 
 		d, err := tiled.NewDaemon(config)
 		if err != nil {
@@ -98,6 +114,76 @@ Pushes will trigger internal tiling requests. (FIXME: Configurable.)
 //	},
 //}
 
+// setTilingBackends sets the config's tiling backends from --tiled.backend values,
+// each a backend, eg. native, or a tippe config name and its backend, eg. laps=native,
+// and the native backend's source limit from --tiled.native.source-limit.
+func setTilingBackends(config *params.TileDaemonConfig, values []string) error {
+	parse := func(s string) (params.TilingBackend, error) {
+		b := params.TilingBackend(s)
+		if !slices.Contains(params.TilingBackends, b) {
+			return "", fmt.Errorf("unknown tiling backend %q (valid: %v)", s, params.TilingBackends)
+		}
+		return b, nil
+	}
+	for _, v := range values {
+		name, backend, ok := strings.Cut(v, "=")
+		if !ok {
+			b, err := parse(v)
+			if err != nil {
+				return err
+			}
+			config.TilingBackend = b
+			continue
+		}
+		b, err := parse(backend)
+		if err != nil {
+			return err
+		}
+		if config.TilingBackends == nil {
+			config.TilingBackends = map[params.TippeConfigName]params.TilingBackend{}
+		}
+		config.TilingBackends[params.TippeConfigName(name)] = b
+	}
+	if optTilingNativeSourceLimit != "" {
+		b, err := humanize.ParseBytes(optTilingNativeSourceLimit)
+		if err != nil {
+			return fmt.Errorf("invalid native source limit: %w", err)
+		}
+		config.NativeTilingSourceBytesLimit = int64(b)
+	}
+	return nil
+}
+
+// setTilingScheduling sets the config's tiling concurrency limits and priorities
+// from the --tiled.concurrency, --tiled.source-budget and --tiled.priority flags.
+// Priorities are tippe config names and priorities, eg. laps=2.
+func setTilingScheduling(config *params.TileDaemonConfig) error {
+	config.TilingConcurrency = optTilingConcurrency
+	config.TilingCanonicalConcurrency = optTilingCanonicalConcurrency
+	if optTilingSourceBudget != "" {
+		b, err := humanize.ParseBytes(optTilingSourceBudget)
+		if err != nil {
+			return fmt.Errorf("invalid source budget: %w", err)
+		}
+		config.TilingSourceBytesBudget = int64(b)
+	}
+	for _, v := range optTilingPriorities {
+		name, priority, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid tiling priority %q (want name=priority, eg. laps=2)", v)
+		}
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return fmt.Errorf("invalid tiling priority %q: %w", v, err)
+		}
+		if config.TilingPriorities == nil {
+			config.TilingPriorities = map[params.TippeConfigName]int{}
+		}
+		config.TilingPriorities[params.TippeConfigName(name)] = p
+	}
+	return nil
+}
+
 var tiledListenerFlags = pflag.NewFlagSet("tiled.listen", pflag.ContinueOnError)
 
 func init() {
@@ -127,6 +213,31 @@ All PushFeatures requests will have their version params overridden to Canonical
 This is useful for development and initial runs, where long-deferred edge tiling 
 could amass large amounts of edge data needlessly.`)
 
+	flags.StringSliceVar(&optTilingBackends, "tiled.backend", nil,
+		`Tiling backend, tippecanoe (default) or native, for all tippe configs,
+or for one, eg. --tiled.backend laps=native.
+The native backend tiles in-process, honoring only tippecanoe's zoom, include,
+drop rate, simplification, buffer, and maximum tile bytes flags.
+It holds a run's features in memory, so it is for edge runs and small layers,
+see --tiled.native.source-limit.`)
+	flags.StringVar(&optTilingNativeSourceLimit, "tiled.native.source-limit", "",
+		`Most source data, eg. 64MB, a native tiling run takes; bigger runs fail,
+and should use tippecanoe. Empty is the default, 64MB; 0 is unlimited.`)
+
+	flags.IntVar(&optTilingConcurrency, "tiled.concurrency", optTilingConcurrency,
+		`Most tiling runs at once, as pending requests come up and on the shutdown drain.
+Zero is unlimited.`)
+	flags.IntVar(&optTilingCanonicalConcurrency, "tiled.concurrency.canonical", optTilingCanonicalConcurrency,
+		`Most canonical tiling runs at once. Canonical runs tile all of a source,
+taking the longest and using the most memory. Zero is limited only by --tiled.concurrency.`)
+	flags.StringVar(&optTilingSourceBudget, "tiled.source-budget", "",
+		`Most source data tiled at once, eg. 2GB, as a proxy for memory use.
+A run over the budget runs alone. Empty is unlimited.`)
+	flags.StringSliceVar(&optTilingPriorities, "tiled.priority", nil,
+		`Priorities of tippe configs' tiling runs, eg. --tiled.priority tracks=-1,cells=2.
+Waiting runs are admitted highest priority first, then first come first served.
+By default laps, naps and snaps are 1, others 0.`)
+
 	// Both webd and populate commands can re-use the listener flags.
 	// They want connections, and in the case of populate, to do an auto-start.
 	webdCmd.Flags().AddFlagSet(tiledListenerFlags)
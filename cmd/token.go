@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/daemon/webd"
+	"github.com/rotblauer/catd/params"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var optTokenCats []string
+var optTokenActions []string
+
+// tokenCmd represents the token command group
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage webd API tokens",
+	Long: `Manage the API tokens webd accepts, stored in <datadir>/` + params.WebDBName + `.
+
+Tokens are scoped to a set of cats (or * for all cats), and to actions:
+  populate  post tracks for the cats
+  read      read the cats' routes, eg. /{cat}/last.json, if webd requires read tokens
+  admin     all actions, and admin routes, eg. /queue
+
+Clients send tokens in the AuthorizationOfCats header, or an api_token query param.
+The global COTOKEN env var, if set, remains a token allowing everything.
+webd picks up token changes without restart.
+
+Each action is enforced once a token allowing it exists, or COTOKEN is set.
+Until then, its routes stay open. Adding the first populate (or admin) token
+therefore requires a token of every client posting to /populate;
+read-only tokens leave populate open.
+
+Examples:
+
+  catd token add friend --cats rye --actions read
+  catd token ls
+  catd token rm friend
+`,
+}
+
+var tokenAddCmd = &cobra.Command{
+	Use:   "add NAME",
+	Short: "Add a token, printing its secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		cats := make([]conceptual.CatID, len(optTokenCats))
+		for i, c := range optTokenCats {
+			cats[i] = conceptual.CatID(c)
+		}
+		actions := make([]webd.TokenAction, len(optTokenActions))
+		for i, a := range optTokenActions {
+			actions[i] = webd.TokenAction(a)
+		}
+		secret, err := webd.NewTokenStore(params.DefaultDatadirRoot).Add(args[0], cats, actions)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// The secret is not stored, and cannot be shown again.
+		fmt.Println(secret)
+	},
+}
+
+var tokenLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		tokens, err := webd.NewTokenStore(params.DefaultDatadirRoot).List()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCATS\tACTIONS\tCREATED")
+		for _, t := range tokens {
+			cats := make([]string, len(t.Cats))
+			for i, c := range t.Cats {
+				cats[i] = c.String()
+			}
+			actions := make([]string, len(t.Actions))
+			for i, a := range t.Actions {
+				actions[i] = string(a)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, strings.Join(cats, ","), strings.Join(actions, ","),
+				t.CreatedAt.Local().Format(time.DateTime))
+		}
+		tw.Flush()
+	},
+}
+
+var tokenRmCmd = &cobra.Command{
+	Use:   "rm NAME",
+	Short: "Remove a token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setDefaultSlog(cmd, args)
+		if err := webd.NewTokenStore(params.DefaultDatadirRoot).Remove(args[0]); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenAddCmd, tokenLsCmd, tokenRmCmd)
+
+	flags := tokenAddCmd.Flags()
+	flags.StringSliceVar(&optTokenCats, "cats", nil,
+		`Cats the token is scoped to. Use * for all cats.`)
+	flags.StringSliceVar(&optTokenActions, "actions", []string{string(webd.TokenActionRead)},
+		`Actions the token allows: populate, read, admin.`)
+}
@@ -27,6 +27,10 @@ import (
 
 var optHTTPAddr string
 var optHTTPPort int
+var optRequireReadToken bool
+var optPopulateQueue bool
+var optPopulateQueueConfig = params.DefaultPopulateQueueConfig()
+var optGeofenceWebhookURL string
 
 // webdCmd represents the serve command
 var webdCmd = &cobra.Command{
@@ -37,6 +41,10 @@ var webdCmd = &cobra.Command{
 		setDefaultSlog(cmd, args)
 		slog.Info("webd.Run")
 		backend := params.DefaultCatBackendConfig()
+		var queue *params.PopulateQueueConfig
+		if optPopulateQueue {
+			queue = optPopulateQueueConfig
+		}
 		server, err := webd.NewWebDaemon(&params.WebDaemonConfig{
 			DataDir: params.DefaultDatadirRoot,
 			ListenerConfig: params.ListenerConfig{
@@ -44,6 +52,10 @@ var webdCmd = &cobra.Command{
 				Network: "tcp",
 			},
 			CatBackendConfig: backend,
+			RequireReadToken: optRequireReadToken,
+			PopulateQueue:    queue,
+
+			GeofenceWebhookURL: optGeofenceWebhookURL,
 		})
 		if err != nil {
 			log.Fatalln(err)
@@ -69,6 +81,25 @@ func init() {
 	pFlags.AddFlagSet(&pflag.FlagSet{})
 	pFlags.StringVar(&optHTTPAddr, "address", defaults.Address, "HTTP address to listen on")
 
+	flags := webdCmd.Flags()
+	flags.BoolVar(&optRequireReadToken, "auth.read", false,
+		`Require a read token scoped to the cat for cat routes, eg. /{cat}/last.json,
+and a read token to connect to /socat, which then broadcasts only the token's cats.
+Otherwise cat reads are public. See 'catd token'.`)
+	flags.BoolVar(&optPopulateQueue, "queue", false,
+		`Populate asynchronously.
+Request bodies are spooled to <datadir>/queue and acknowledged immediately,
+then populated by a worker pool, in order per cat.
+Clients requesting a receipt still wait for their request to be populated.`)
+	flags.IntVar(&optPopulateQueueConfig.Workers, "queue.workers", optPopulateQueueConfig.Workers,
+		`Number of cats populated concurrently from the queue.`)
+	flags.IntVar(&optPopulateQueueConfig.MaxAttempts, "queue.attempts", optPopulateQueueConfig.MaxAttempts,
+		`Attempts per spooled request before it is set aside in <datadir>/queue/failed.`)
+	flags.DurationVar(&optPopulateQueueConfig.RetryDelay, "queue.retry-delay", optPopulateQueueConfig.RetryDelay,
+		`Delay before retrying a failed spooled request, doubling for each attempt.`)
+	flags.StringVar(&optGeofenceWebhookURL, "geofence.webhook", "",
+		`URL to POST cat geofence events to, as JSON. See 'catd geofence'.`)
+
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// webdCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -3,6 +3,8 @@ package common
 import (
 	"bytes"
 	"encoding/base64"
+	"image"
+	"image/color"
 	"image/jpeg"
 )
 
@@ -26,3 +28,50 @@ func DecodeB64ToJPGBytes(b64 string) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// JPGThumbnail returns a JPG of the image scaled down to fit maxDim pixels on its longer side,
+// averaging the source pixels under each thumbnail pixel.
+// Images that already fit are re-encoded as they are.
+func JPGThumbnail(jpgBytes []byte, maxDim int) ([]byte, error) {
+	src, err := jpeg.Decode(bytes.NewReader(jpgBytes))
+	if err != nil {
+		return nil, err
+	}
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := sw, sh
+	if sw > maxDim || sh > maxDim {
+		if sw >= sh {
+			dw, dh = maxDim, max(1, sh*maxDim/sw)
+		} else {
+			dw, dh = max(1, sw*maxDim/sh), maxDim
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := sb.Min.Y+y*sh/dh, sb.Min.Y+(y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := sb.Min.X+x*sw/dw, sb.Min.X+(x+1)*sw/dw
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					r, g, b, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -18,3 +18,7 @@ func CatIDIn(id CatID, cats []CatID) bool {
 	}
 	return false
 }
+
+// AllCatsID names the pseudo-cat standing for all cats together,
+// eg. the all-cats S2 cell index and its tiles.
+const AllCatsID CatID = "all-cats"
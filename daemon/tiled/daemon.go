@@ -20,7 +20,6 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -47,6 +46,7 @@ type TileDaemon struct {
 	db *bbolt.DB
 
 	logger          *slog.Logger
+	scheduler       *scheduler
 	tilingRunningM  sync.Map
 	pendingTTLCache *ttlcache.Cache[string, *TilingRequestArgs]
 	running         sync.WaitGroup
@@ -87,6 +87,7 @@ func NewDaemon(config *params.TileDaemonConfig) (*TileDaemon, error) {
 		flat:            f,
 		db:              db,
 		logger:          logger,
+		scheduler:       newScheduler(config),
 		tilingRunningM:  sync.Map{},
 		pendingTTLCache: ttlcache.New[string, *TilingRequestArgs](ttlcache.WithTTL[string, *TilingRequestArgs](config.TilingPendingExpiry)),
 		tilingEvents:    &event.FeedOf[TilingResponse]{},
@@ -238,32 +239,20 @@ func (d *TileD) awaitPendingTileRequests() (nextPending int) {
 	}
 	results := make(chan result, len(requests))
 
-	// Use work pool.
+	// The scheduler limits the runs at once.
 	// Running all concurrently slams the RAM.
 	// Running serially is slower than necessary (most sets are small-ish, a few big).
-	workers := 4
-	work := make(chan *TilingRequestArgs, len(requests))
-	for i := 0; i < workers; i++ {
-		go func(wi int) {
-			for req := range work {
-				d.logger.Info("Picked pending tiling request",
-					"worker", fmt.Sprintf("%d/%d", wi, workers), "args", req.id())
-				err := d.callTiling(req, nil)
-				results <- result{req, err}
-			}
-		}(i)
-	}
 	for _, req := range requests {
-		_, ok := d.tilingRunningM.Load(req.id())
-		for ok {
+		for d.scheduler.isScheduled(req.id()) {
 			d.logger.Warn("Tiling still running...", "args", req.id(), "await", "true")
 			time.Sleep(time.Second)
-			_, ok = d.tilingRunningM.Load(req.id())
 		}
 		d.logger.Debug("Promoting pending tiling request", "args", req.id())
-		work <- req
+		go func(req *TilingRequestArgs) {
+			err := d.callTiling(req, nil)
+			results <- result{req, err}
+		}(req)
 	}
-	close(work)
 
 	for i := 0; i < len(requests); i++ {
 		res := <-results
@@ -348,16 +337,6 @@ func (d *TileDaemon) recover() error {
 	return nil
 }
 
-// mbtileserverHUP tries to reload a/any 'mbtileserver' instance
-// by sending in a HUP signal which it uses a signal to reload tiles.
-func (d *TileDaemon) mbtileserverHUP() ([]byte, error) {
-	// Execute the following command, which will signal any
-	// running mbtileserver processes to reload serviced tilesets.
-	// pgrep mbtileserver | tail -1 | xargs kill -HUP
-	return exec.Command("sh", "-c",
-		`pgrep mbtileserver | tail -1 | xargs kill -HUP`).Output()
-}
-
 type SourceSchema struct {
 	CatID conceptual.CatID
 
@@ -381,7 +360,18 @@ func (s *TilingRequestArgs) id() string {
 }
 
 func (d *TileD) SourcePathFor(schema SourceSchema, version TileSourceVersion) (string, error) {
-	root := d.flat.Path()
+	return SourcePathIn(d.flat.Path(), schema, version)
+}
+
+// TargetPathFor returns the final output path for some source schema and version.
+// It will have a .mbtiles extension
+func (d *TileD) TargetPathFor(schema SourceSchema, version TileSourceVersion) (string, error) {
+	return TargetPathIn(d.flat.Path(), schema, version)
+}
+
+// SourcePathIn returns the source path for some source schema and version
+// of a tile daemon rooted at root.
+func SourcePathIn(root string, schema SourceSchema, version TileSourceVersion) (string, error) {
 	var out string
 	switch version {
 	case SourceVersionCanonical:
@@ -397,15 +387,19 @@ func (d *TileD) SourcePathFor(schema SourceSchema, version TileSourceVersion) (s
 	return filepath.Abs(clean)
 }
 
-// TargetPathFor returns the final output path for some source schema and version.
-// It will have a .mbtiles extension
-func (d *TileD) TargetPathFor(schema SourceSchema, version TileSourceVersion) (string, error) {
-	source, err := d.SourcePathFor(schema, version)
+// TargetPathIn returns the final output path for some source schema and version
+// of a tile daemon rooted at root, eg. for servers of its tiles.
+func TargetPathIn(root string, schema SourceSchema, version TileSourceVersion) (string, error) {
+	root, err := filepath.Abs(filepath.Clean(root))
+	if err != nil {
+		return "", err
+	}
+	source, err := SourcePathIn(root, schema, version)
 	if err != nil {
 		return "", err
 	}
 	// base=root/source  rel=catid/source/layer/...geojson.gz
-	base := filepath.Join(d.flat.Path(), "source")
+	base := filepath.Join(root, "source")
 	rel, err := filepath.Rel(base, source)
 	if err != nil {
 		return "", err
@@ -414,7 +408,7 @@ func (d *TileD) TargetPathFor(schema SourceSchema, version TileSourceVersion) (s
 	rel = strings.TrimSuffix(rel, ".json.gz")
 	rel = strings.TrimSuffix(rel, ".gz")
 	rel += ".mbtiles"
-	return filepath.Join(d.flat.Path(), "tiles", rel), nil
+	return filepath.Join(root, "tiles", rel), nil
 }
 
 // TmpTargetPathFor returns a deterministic temporary target path for a source schema and version.
@@ -741,6 +735,7 @@ type TilingRequestArgs struct {
 	parsedSourcePath string
 
 	requestedAt time.Time
+	startedAt   time.Time
 
 	cliArgs params.CLIFlagsT
 }
@@ -861,14 +856,6 @@ func (d *TileD) callTiling(args *TilingRequestArgs, reply *TilingResponse) error
 	d.logger.Debug("callTiling", "args", args.id())
 
 	d.unPending(args)
-	if _, ok := d.tilingRunningM.Load(args.id()); ok {
-		return fmt.Errorf("%w: %s", errTilingAlreadyRunning, args.id())
-	}
-	d.tilingRunningM.Store(args.id(), args)
-	defer d.tilingRunningM.Delete(args.id())
-
-	d.running.Add(1)
-	defer d.running.Done()
 
 	if reply == nil {
 		reply = &TilingResponse{}
@@ -886,6 +873,19 @@ func (d *TileD) callTiling(args *TilingRequestArgs, reply *TilingResponse) error
 		return fmt.Errorf("failed to get source path: %w", err)
 	}
 
+	// Wait for the scheduler to admit the run.
+	d.running.Add(1)
+	defer d.running.Done()
+	release, err := d.scheduler.acquire(args, sourceBytes(source, args.Version))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	args.startedAt = time.Now()
+	d.tilingRunningM.Store(args.id(), args)
+	defer d.tilingRunningM.Delete(args.id())
+
 	args.parsedSourcePath = source
 
 	// If we're about to run tippe for the canonical data set,
@@ -895,19 +895,19 @@ func (d *TileD) callTiling(args *TilingRequestArgs, reply *TilingResponse) error
 	if args.Version == SourceVersionCanonical {
 		if err := d.rollEdgeToBackup(args); err != nil {
 			d.logger.Error("Failed to roll edge files to backup", "error", err)
+			d.recordTiling(args, reply, err)
 			return err
 		}
 	}
 
 	// Actually do tippecanoe.
-	if err := d.tiling(args, reply); err != nil {
-		return err
+	err = d.tiling(args, reply)
+	if err != nil {
+		reply.Error = err.Error()
 	}
-
-	// TODO
-	//// Try reload mbtileserver.
-	if out, err := d.mbtileserverHUP(); err != nil {
-		d.logger.Debug("Failed to HUP mbtileserver", "error", err, "output", string(out))
+	d.recordTiling(args, reply, err)
+	if err != nil {
+		return err
 	}
 
 	// We can safely return now if this was canon;
@@ -1027,10 +1027,15 @@ func (d *TileD) tiling(args *TilingRequestArgs, reply *TilingResponse) error {
 		}
 	}
 
-	// Run tippecanoe!
+	// Run tippecanoe, or tile natively.
+	backend := d.Config.TilingBackendFor(args.TippeConfigName)
+	tip := d.tip
+	if backend == params.TilingBackendNative {
+		tip = d.tipNative
+	}
 	start := time.Now()
-	if err := d.tip(args, sources...); err != nil {
-		d.logger.Error("Failed to tip", "error", err)
+	if err := tip(args, sources...); err != nil {
+		d.logger.Error("Failed to tip", "backend", backend, "error", err)
 		return err
 	}
 	elapsed := time.Since(start)
@@ -1046,7 +1051,7 @@ func (d *TileD) tiling(args *TilingRequestArgs, reply *TilingResponse) error {
 		return err
 	}
 
-	d.logger.Info("🗺 Tiling done", "args", args.id(), "to", mbtilesOutput,
+	d.logger.Info("🗺 Tiling done", "args", args.id(), "backend", backend, "to", mbtilesOutput,
 		"took", elapsed.Round(time.Millisecond))
 
 	reply.RequestArgs = args
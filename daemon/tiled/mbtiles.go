@@ -0,0 +1,210 @@
+package tiled
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// mbtilesApplicationID is the SQLite application ID of MBTiles files, "MPBX".
+const mbtilesApplicationID = 0x4d504258
+
+var mbtilesSchema = []string{
+	fmt.Sprintf("PRAGMA application_id = %d", mbtilesApplicationID),
+	"CREATE TABLE metadata (name text, value text)",
+	"CREATE UNIQUE INDEX name ON metadata (name)",
+	"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+	"CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)",
+}
+
+// mbtilesTileQuery looks up a tile by the tiles table's unique index,
+// or, in tippecanoe's deduplicating layout, by the primary keys of the tables the tiles view joins.
+const mbtilesTileQuery = "SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?"
+
+// tmsRow flips an XYZ tile row to the TMS row of MBTiles, and back.
+func tmsRow(z, y int) int {
+	return (1 << z) - 1 - y
+}
+
+// mbtilesWriter writes an MBTiles 1.3 file, see https://github.com/mapbox/mbtiles-spec.
+// Tiles are written in one transaction, committed by close.
+type mbtilesWriter struct {
+	path string
+	db   *sql.DB
+	tx   *sql.Tx
+	put  *sql.Stmt
+}
+
+// createMBTiles creates the file, replacing any existing one.
+// It is written without a journal, since the tile daemon writes to a temporary file
+// and moves it into place when done.
+func createMBTiles(path string) (*mbtilesWriter, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	w := &mbtilesWriter{path: path, db: db}
+	if err := w.init(); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *mbtilesWriter) init() error {
+	for _, stmt := range append([]string{"PRAGMA journal_mode = OFF", "PRAGMA synchronous = OFF"}, mbtilesSchema...) {
+		if _, err := w.db.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	var err error
+	if w.tx, err = w.db.Begin(); err != nil {
+		return err
+	}
+	w.put, err = w.tx.Prepare("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	return err
+}
+
+// putTile writes the tile at XYZ coordinates z/x/y.
+func (w *mbtilesWriter) putTile(z, x, y int, data []byte) error {
+	if _, err := w.put.Exec(z, x, tmsRow(z, y), data); err != nil {
+		return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+	}
+	return nil
+}
+
+// close writes the metadata, commits the tiles, and closes the file.
+func (w *mbtilesWriter) close(metadata map[string]string) error {
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if _, err := w.tx.Exec("INSERT INTO metadata (name, value) VALUES (?, ?)", name, metadata[name]); err != nil {
+			return err
+		}
+	}
+	if err := w.put.Close(); err != nil {
+		return err
+	}
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	return w.db.Close()
+}
+
+// abort closes and removes the partially written file.
+func (w *mbtilesWriter) abort() {
+	if w.tx != nil {
+		_ = w.tx.Rollback()
+	}
+	_ = w.db.Close()
+	_ = os.Remove(w.path)
+}
+
+// MBTiles is a read-only MBTiles file, as written by tippecanoe or the native tiler.
+// Tiles are looked up by the tiles table or view's index as they are read,
+// so tippecanoe's deduplicating layout, a view joining WITHOUT ROWID tables, is read as well as a plain table.
+type MBTiles struct {
+	db       *sql.DB
+	tile     *sql.Stmt
+	metadata map[string]string
+}
+
+// OpenMBTiles opens an MBTiles file, reading only its metadata; no tiles are read until asked for.
+// The file is opened immutable, without locking, since tiling runs replace tilesets
+// by moving new files into place, never writing to a served file.
+func OpenMBTiles(path string) (*MBTiles, error) {
+	uri := &url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro&immutable=1"}
+	db, err := sql.Open("sqlite", uri.String())
+	if err != nil {
+		return nil, err
+	}
+	m := &MBTiles{db: db, metadata: map[string]string{}}
+	if err := m.open(); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *MBTiles) open() error {
+	rows, err := m.db.Query("SELECT name, type FROM sqlite_master WHERE name IN ('tiles', 'metadata')")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	kinds := map[string]string{}
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return err
+		}
+		kinds[name] = kind
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if k := kinds["tiles"]; k != "table" && k != "view" {
+		return errors.New("mbtiles: no tiles table")
+	}
+	m.tile, err = m.db.Prepare(mbtilesTileQuery)
+	if err != nil {
+		return fmt.Errorf("mbtiles: unexpected tiles table: %w", err)
+	}
+	if kinds["metadata"] != "table" {
+		return nil
+	}
+	meta, err := m.db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		return err
+	}
+	defer meta.Close()
+	for meta.Next() {
+		var name, value sql.NullString
+		if err := meta.Scan(&name, &value); err != nil {
+			return err
+		}
+		m.metadata[name.String] = value.String
+	}
+	return meta.Err()
+}
+
+// Metadata returns the metadata table's values, eg. name, format, minzoom, maxzoom, bounds, and json.
+func (m *MBTiles) Metadata() map[string]string {
+	return m.metadata
+}
+
+// Tile returns the data of the tile at XYZ coordinates z/x/y, or false if there is none.
+// Vector tiles are usually gzipped.
+func (m *MBTiles) Tile(z, x, y int) ([]byte, bool, error) {
+	if z < 0 || z > 30 {
+		return nil, false, nil
+	}
+	var data []byte
+	err := m.tile.QueryRow(z, x, tmsRow(z, y)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Close closes the file.
+func (m *MBTiles) Close() error {
+	if m.tile != nil {
+		m.tile.Close()
+	}
+	return m.db.Close()
+}
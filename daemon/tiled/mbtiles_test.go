@@ -0,0 +1,235 @@
+package tiled
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTileData(z, x, y int) []byte {
+	data := []byte(fmt.Sprintf("%d/%d/%d", z, x, y))
+	// Some tiles overflow their pages, some many times.
+	switch {
+	case x == 0 && y == 1:
+		return bytes.Repeat(data, 1000)
+	case x == 1 && y == 0:
+		return bytes.Repeat(data, 100000)
+	}
+	return data
+}
+
+// testCountTiles counts the tileset's tiles.
+func testCountTiles(t *testing.T, m *MBTiles) int {
+	t.Helper()
+	var n int
+	if err := m.db.QueryRow("SELECT count(*) FROM tiles").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testTileQueryPlan checks that tiles are looked up by index, not by scanning a table.
+func testTileQueryPlan(t *testing.T, m *MBTiles) {
+	t.Helper()
+	rows, err := m.db.Query("EXPLAIN QUERY PLAN "+mbtilesTileQuery, 5, 3, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(detail, "SCAN") {
+			t.Errorf("tile query plan: %s", detail)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMBTiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+	w, err := createMBTiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough tiles for interior table and index pages, a few levels deep.
+	n := 0
+	for z := 0; z <= 8; z++ {
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				if err := w.putTile(z, x, y, testTileData(z, x, y)); err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+		}
+	}
+	if err := w.putTile(0, 0, 0, nil); err == nil {
+		t.Error("duplicate tile written")
+	}
+	metadata := map[string]string{"name": "test", "format": "pbf", "json": strings.Repeat("x", 10000)}
+	if err := w.close(metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenMBTiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if got := testCountTiles(t, m); got != n {
+		t.Errorf("got %d tiles, want %d", got, n)
+	}
+	testTileQueryPlan(t, m)
+	for k, v := range metadata {
+		if m.Metadata()[k] != v {
+			t.Errorf("metadata %s: got %q", k, m.Metadata()[k])
+		}
+	}
+	for _, c := range [][3]int{{0, 0, 0}, {1, 0, 1}, {1, 1, 0}, {5, 3, 17}, {8, 255, 0}, {8, 1, 0}} {
+		data, ok, err := m.Tile(c[0], c[1], c[2])
+		if err != nil || !ok {
+			t.Fatalf("%v: %v %v", c, ok, err)
+		}
+		if !bytes.Equal(data, testTileData(c[0], c[1], c[2])) {
+			t.Errorf("%v: got %d bytes, want %d", c, len(data), len(testTileData(c[0], c[1], c[2])))
+		}
+	}
+	if _, ok, _ := m.Tile(9, 0, 0); ok {
+		t.Error("missing tile found")
+	}
+
+	var check string
+	if err := m.db.QueryRow("PRAGMA integrity_check").Scan(&check); err != nil || check != "ok" {
+		t.Errorf("integrity check: %s %v", check, err)
+	}
+	var id int
+	if err := m.db.QueryRow("PRAGMA application_id").Scan(&id); err != nil || id != mbtilesApplicationID {
+		t.Errorf("application id: %x %v", id, err)
+	}
+}
+
+func TestMBTiles_abort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+	w, err := createMBTiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.putTile(0, 0, 0, []byte("tile")); err != nil {
+		t.Fatal(err)
+	}
+	w.abort()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected aborted file removed, got %v", err)
+	}
+}
+
+// tippecanoeSchema is the schema tippecanoe writes .mbtiles with, deduplicating tiles:
+// tile data is stored once per hash, and the tiles view joins it to the tile coordinates.
+// See tippecanoe's mbtiles.cpp, mbtiles_open.
+var tippecanoeSchema = []string{
+	"CREATE TABLE metadata (name text, value text)",
+	"CREATE UNIQUE INDEX name on metadata (name)",
+	"CREATE TABLE tiles_shallow (zoom_level integer, tile_column integer, tile_row integer, tile_data_hash integer, " +
+		"primary key(zoom_level, tile_column, tile_row)) without rowid",
+	"CREATE TABLE tiles_data (tile_data_hash integer, tile_data blob, primary key(tile_data_hash)) without rowid",
+	"CREATE VIEW tiles AS SELECT tiles_shallow.zoom_level AS zoom_level, tiles_shallow.tile_column AS tile_column, " +
+		"tiles_shallow.tile_row AS tile_row, tiles_data.tile_data AS tile_data " +
+		"FROM tiles_shallow JOIN tiles_data ON tiles_shallow.tile_data_hash = tiles_data.tile_data_hash",
+}
+
+// TestMBTiles_tippecanoe reads a tileset in tippecanoe's layout.
+// The file is written with tippecanoe's schema, rather than by tippecanoe,
+// so the test runs without it installed.
+func TestMBTiles_tippecanoe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tippecanoe.mbtiles")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range tippecanoeSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(stmt, err)
+		}
+	}
+	metadata := map[string]string{"name": "laps", "format": "pbf", "minzoom": "0", "maxzoom": "6"}
+	for k, v := range metadata {
+		if _, err := db.Exec("INSERT INTO metadata (name, value) VALUES (?, ?)", k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every other tile is the same, an empty ocean, stored once.
+	ocean := []byte("ocean")
+	data := func(z, x, y int) []byte {
+		if (x+y)%2 == 0 {
+			return ocean
+		}
+		return testTileData(z, x, y)
+	}
+	n := 0
+	for z := 0; z <= 6; z++ {
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				d := data(z, x, y)
+				h := fnv.New64a()
+				h.Write(d)
+				hash := int64(h.Sum64())
+				if _, err := tx.Exec("INSERT OR IGNORE INTO tiles_data (tile_data_hash, tile_data) VALUES (?, ?)", hash, d); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tx.Exec("INSERT INTO tiles_shallow (zoom_level, tile_column, tile_row, tile_data_hash) VALUES (?, ?, ?, ?)",
+					z, x, tmsRow(z, y), hash); err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenMBTiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !maps.Equal(m.Metadata(), metadata) {
+		t.Errorf("got metadata %v, want %v", m.Metadata(), metadata)
+	}
+	if got := testCountTiles(t, m); got != n {
+		t.Errorf("got %d tiles, want %d", got, n)
+	}
+	testTileQueryPlan(t, m)
+	for _, c := range [][3]int{{0, 0, 0}, {1, 0, 1}, {1, 1, 0}, {5, 3, 17}, {6, 63, 0}, {6, 1, 0}} {
+		got, ok, err := m.Tile(c[0], c[1], c[2])
+		if err != nil || !ok {
+			t.Fatalf("%v: %v %v", c, ok, err)
+		}
+		if want := data(c[0], c[1], c[2]); !bytes.Equal(got, want) {
+			t.Errorf("%v: got %d bytes, want %d", c, len(got), len(want))
+		}
+	}
+	if _, ok, err := m.Tile(7, 0, 0); ok || err != nil {
+		t.Errorf("missing tile: got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,303 @@
+package tiled
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/paulmach/orb"
+	"math"
+	"slices"
+)
+
+// This file encodes Mapbox Vector Tiles (v2) with hand-rolled protobuf,
+// see https://github.com/mapbox/vector-tile-spec/tree/master/2.1.
+
+// mvtExtent is the width and height of a tile in tile coordinates.
+const mvtExtent = 4096
+
+const (
+	mvtGeomPoint      = 1
+	mvtGeomLineString = 2
+	mvtGeomPolygon    = 3
+
+	mvtCmdMoveTo    = 1
+	mvtCmdLineTo    = 2
+	mvtCmdClosePath = 7
+)
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+)
+
+func pbAppendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func pbAppendBytes(b []byte, field int, v []byte) []byte {
+	b = pbAppendTag(b, field, pbBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbAppendVarint(b []byte, field int, v uint64) []byte {
+	b = pbAppendTag(b, field, pbVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func pbAppendPacked(b []byte, field int, vs []uint32) []byte {
+	packed := []byte{}
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	return pbAppendBytes(b, field, packed)
+}
+
+func zigzag32(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+// mvtValue encodes a property value as a Value message.
+// Integral numbers are sint values, other numbers doubles,
+// and values other than strings and bools their JSON.
+func mvtValue(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return pbAppendBytes(nil, 1, []byte(v)), true
+	case bool:
+		b := uint64(0)
+		if v {
+			b = 1
+		}
+		return pbAppendVarint(nil, 7, b), true
+	case int:
+		return mvtValue(float64(v))
+	case int64:
+		return mvtValue(float64(v))
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			// AppendVarint zigzags, as sint64.
+			return binary.AppendVarint(pbAppendTag(nil, 6, pbVarint), int64(v)), true
+		}
+		b := pbAppendTag(nil, 3, pbFixed64)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v)), true
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	return mvtValue(string(j))
+}
+
+// mvtFeature is a feature in tile coordinates.
+type mvtFeature struct {
+	id    uint64
+	geom  orb.Geometry
+	props map[string]any
+}
+
+// mvtLayer encodes a layer of features.
+type mvtLayer struct {
+	name       string
+	features   [][]byte
+	keys       []string
+	keyIndex   map[string]uint32
+	values     [][]byte
+	valueIndex map[string]uint32
+}
+
+func newMVTLayer(name string) *mvtLayer {
+	return &mvtLayer{name: name, keyIndex: map[string]uint32{}, valueIndex: map[string]uint32{}}
+}
+
+// add encodes the feature, returning false if nothing of its geometry remains at tile resolution.
+func (l *mvtLayer) add(f mvtFeature) bool {
+	typ, geom := mvtGeometry(f.geom)
+	if len(geom) == 0 {
+		return false
+	}
+	keys := make([]string, 0, len(f.props))
+	for k := range f.props {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	tags := []uint32{}
+	for _, k := range keys {
+		v, ok := mvtValue(f.props[k])
+		if !ok {
+			continue
+		}
+		ki, ok := l.keyIndex[k]
+		if !ok {
+			ki = uint32(len(l.keys))
+			l.keys = append(l.keys, k)
+			l.keyIndex[k] = ki
+		}
+		vi, ok := l.valueIndex[string(v)]
+		if !ok {
+			vi = uint32(len(l.values))
+			l.values = append(l.values, v)
+			l.valueIndex[string(v)] = vi
+		}
+		tags = append(tags, ki, vi)
+	}
+	b := []byte{}
+	if f.id > 0 {
+		b = pbAppendVarint(b, 1, f.id)
+	}
+	if len(tags) > 0 {
+		b = pbAppendPacked(b, 2, tags)
+	}
+	b = pbAppendVarint(b, 3, uint64(typ))
+	b = pbAppendPacked(b, 4, geom)
+	l.features = append(l.features, b)
+	return true
+}
+
+// marshalMVT encodes the layers as a tile. Empty layers are omitted.
+func marshalMVT(layers ...*mvtLayer) []byte {
+	tile := []byte{}
+	for _, l := range layers {
+		if len(l.features) == 0 {
+			continue
+		}
+		b := pbAppendVarint(nil, 15, 2)
+		b = pbAppendBytes(b, 1, []byte(l.name))
+		for _, f := range l.features {
+			b = pbAppendBytes(b, 2, f)
+		}
+		for _, k := range l.keys {
+			b = pbAppendBytes(b, 3, []byte(k))
+		}
+		for _, v := range l.values {
+			b = pbAppendBytes(b, 4, v)
+		}
+		b = pbAppendVarint(b, 5, mvtExtent)
+		tile = pbAppendBytes(tile, 3, b)
+	}
+	return tile
+}
+
+// mvtGeometryEncoder encodes geometry commands with cursor-relative, zigzagged parameters.
+type mvtGeometryEncoder struct {
+	cmds []uint32
+	x, y int32
+}
+
+func (e *mvtGeometryEncoder) command(id, count int) {
+	e.cmds = append(e.cmds, uint32(id&0x7)|uint32(count)<<3)
+}
+
+func (e *mvtGeometryEncoder) point(p [2]int32) {
+	e.cmds = append(e.cmds, zigzag32(p[0]-e.x), zigzag32(p[1]-e.y))
+	e.x, e.y = p[0], p[1]
+}
+
+// mvtPoints rounds the points to tile coordinates, dropping consecutive duplicates.
+func mvtPoints(pts []orb.Point) [][2]int32 {
+	out := make([][2]int32, 0, len(pts))
+	for _, p := range pts {
+		q := [2]int32{int32(math.Round(p[0])), int32(math.Round(p[1]))}
+		if len(out) > 0 && out[len(out)-1] == q {
+			continue
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func (e *mvtGeometryEncoder) lineString(ls orb.LineString) {
+	pts := mvtPoints(ls)
+	if len(pts) < 2 {
+		return
+	}
+	e.command(mvtCmdMoveTo, 1)
+	e.point(pts[0])
+	e.command(mvtCmdLineTo, len(pts)-1)
+	for _, p := range pts[1:] {
+		e.point(p)
+	}
+}
+
+// ring encodes the ring, wound clockwise (positive area, y pointing down) if exterior,
+// or counter-clockwise if interior.
+func (e *mvtGeometryEncoder) ring(r orb.Ring, exterior bool) bool {
+	pts := mvtPoints(r)
+	if len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+		pts = pts[:len(pts)-1]
+	}
+	if len(pts) < 3 {
+		return false
+	}
+	var area int64
+	for i := range pts {
+		a, b := pts[i], pts[(i+1)%len(pts)]
+		area += int64(a[0])*int64(b[1]) - int64(b[0])*int64(a[1])
+	}
+	if area == 0 {
+		return false
+	}
+	if (area > 0) != exterior {
+		slices.Reverse(pts)
+	}
+	e.command(mvtCmdMoveTo, 1)
+	e.point(pts[0])
+	e.command(mvtCmdLineTo, len(pts)-1)
+	for _, p := range pts[1:] {
+		e.point(p)
+	}
+	e.command(mvtCmdClosePath, 1)
+	return true
+}
+
+func (e *mvtGeometryEncoder) polygon(p orb.Polygon) {
+	for i, r := range p {
+		if !e.ring(r, i == 0) && i == 0 {
+			// Without an exterior, the holes are meaningless.
+			return
+		}
+	}
+}
+
+// mvtGeometry returns the type and encoded commands of a geometry in tile coordinates.
+func mvtGeometry(g orb.Geometry) (int, []uint32) {
+	e := &mvtGeometryEncoder{}
+	switch g := g.(type) {
+	case orb.Point:
+		e.command(mvtCmdMoveTo, 1)
+		e.point(mvtPoints([]orb.Point{g})[0])
+		return mvtGeomPoint, e.cmds
+	case orb.MultiPoint:
+		pts := mvtPoints(g)
+		if len(pts) == 0 {
+			return 0, nil
+		}
+		e.command(mvtCmdMoveTo, len(pts))
+		for _, p := range pts {
+			e.point(p)
+		}
+		return mvtGeomPoint, e.cmds
+	case orb.LineString:
+		e.lineString(g)
+		return mvtGeomLineString, e.cmds
+	case orb.MultiLineString:
+		for _, ls := range g {
+			e.lineString(ls)
+		}
+		return mvtGeomLineString, e.cmds
+	case orb.Ring:
+		e.polygon(orb.Polygon{g})
+		return mvtGeomPolygon, e.cmds
+	case orb.Polygon:
+		e.polygon(g)
+		return mvtGeomPolygon, e.cmds
+	case orb.MultiPolygon:
+		for _, p := range g {
+			e.polygon(p)
+		}
+		return mvtGeomPolygon, e.cmds
+	}
+	return 0, nil
+}
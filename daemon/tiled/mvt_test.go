@@ -0,0 +1,184 @@
+package tiled
+
+import (
+	"encoding/binary"
+	"github.com/paulmach/orb"
+	"slices"
+	"testing"
+)
+
+// testPBFields decodes the fields of a protobuf message, as field number and raw value:
+// varints as uint64, and length-delimited fields as []byte.
+func testPBFields(t *testing.T, b []byte) (fields []int, values []any) {
+	t.Helper()
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad tag")
+		}
+		b = b[n:]
+		fields = append(fields, int(tag>>3))
+		switch tag & 7 {
+		case pbVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad varint")
+			}
+			b = b[n:]
+			values = append(values, v)
+		case pbFixed64:
+			values = append(values, binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b[n:]) {
+				t.Fatal("bad length")
+			}
+			values = append(values, b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return
+}
+
+// testMVTLayer is a decoded layer, with the keys of each feature.
+type testMVTLayer struct {
+	name        string
+	extent      uint64
+	featureKeys [][]string
+	types       []uint64
+}
+
+func decodeTestMVT(t *testing.T, tile []byte) []testMVTLayer {
+	t.Helper()
+	layers := []testMVTLayer{}
+	fields, values := testPBFields(t, tile)
+	for i, f := range fields {
+		if f != 3 {
+			continue
+		}
+		layer := testMVTLayer{}
+		keys := []string{}
+		features := [][]byte{}
+		lf, lv := testPBFields(t, values[i].([]byte))
+		for j, f := range lf {
+			switch f {
+			case 1:
+				layer.name = string(lv[j].([]byte))
+			case 2:
+				features = append(features, lv[j].([]byte))
+			case 3:
+				keys = append(keys, string(lv[j].([]byte)))
+			case 5:
+				layer.extent = lv[j].(uint64)
+			}
+		}
+		for _, feature := range features {
+			ff, fv := testPBFields(t, feature)
+			fkeys := []string{}
+			for k, f := range ff {
+				switch f {
+				case 2:
+					tags := fv[k].([]byte)
+					for m := 0; len(tags) > 0; m++ {
+						v, n := binary.Uvarint(tags)
+						tags = tags[n:]
+						if m%2 == 0 {
+							fkeys = append(fkeys, keys[v])
+						}
+					}
+				case 3:
+					layer.types = append(layer.types, fv[k].(uint64))
+				}
+			}
+			layer.featureKeys = append(layer.featureKeys, fkeys)
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+func TestMVTGeometry(t *testing.T) {
+	// Examples from the vector tile spec, section 4.3.5.
+	cases := []struct {
+		name string
+		geom orb.Geometry
+		typ  int
+		want []uint32
+	}{
+		{"point", orb.Point{25, 17}, mvtGeomPoint, []uint32{9, 50, 34}},
+		{"multipoint", orb.MultiPoint{{5, 7}, {3, 2}}, mvtGeomPoint, []uint32{17, 10, 14, 3, 9}},
+		{"linestring", orb.LineString{{2, 2}, {2, 10}, {10, 10}}, mvtGeomLineString,
+			[]uint32{9, 4, 4, 18, 0, 16, 16, 0}},
+		{"multilinestring", orb.MultiLineString{{{2, 2}, {2, 10}, {10, 10}}, {{1, 1}, {3, 5}}}, mvtGeomLineString,
+			[]uint32{9, 4, 4, 18, 0, 16, 16, 0, 9, 17, 17, 10, 4, 8}},
+		{"polygon", orb.Polygon{{{3, 6}, {8, 12}, {20, 34}, {3, 6}}}, mvtGeomPolygon,
+			[]uint32{9, 6, 12, 18, 10, 12, 24, 44, 15}},
+		// Counter-clockwise exteriors are rewound.
+		{"polygon rewound", orb.Polygon{{{3, 6}, {20, 34}, {8, 12}, {3, 6}}}, mvtGeomPolygon,
+			[]uint32{9, 16, 24, 18, 24, 44, 33, 55, 15}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			typ, got := mvtGeometry(c.geom)
+			if typ != c.typ {
+				t.Errorf("type: got %d, want %d", typ, c.typ)
+			}
+			if !slices.Equal(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	// Degenerate geometries encode nothing.
+	if _, got := mvtGeometry(orb.LineString{{1, 1}, {1.2, 1.1}}); len(got) != 0 {
+		t.Errorf("collapsed line encoded: %v", got)
+	}
+	if _, got := mvtGeometry(orb.Polygon{{{0, 0}, {1, 1}, {2, 2}, {0, 0}}}); len(got) != 0 {
+		t.Errorf("zero area polygon encoded: %v", got)
+	}
+}
+
+func TestMarshalMVT(t *testing.T) {
+	layer := newMVTLayer("laps")
+	if !layer.add(mvtFeature{id: 1, geom: orb.Point{1, 2}, props: map[string]any{
+		"Activity": "Walking",
+		"Distance": 1234.5,
+		"Count":    3.0,
+		"Ok":       true,
+		"Nested":   map[string]any{"a": 1.0},
+		"Nil":      nil,
+	}}) {
+		t.Fatal("feature not added")
+	}
+	if layer.add(mvtFeature{id: 2, geom: orb.LineString{{1, 1}}}) {
+		t.Fatal("empty feature added")
+	}
+	if !layer.add(mvtFeature{id: 3, geom: orb.LineString{{1, 1}, {5, 5}}, props: map[string]any{"Activity": "Walking"}}) {
+		t.Fatal("feature not added")
+	}
+	// Keys and values are shared.
+	if len(layer.keys) != 5 || len(layer.values) != 5 {
+		t.Errorf("keys %v, values %d", layer.keys, len(layer.values))
+	}
+
+	layers := decodeTestMVT(t, marshalMVT(layer, newMVTLayer("empty")))
+	if len(layers) != 1 {
+		t.Fatalf("got %d layers, want 1", len(layers))
+	}
+	got := layers[0]
+	if got.name != "laps" || got.extent != mvtExtent {
+		t.Errorf("got layer %q extent %d", got.name, got.extent)
+	}
+	if !slices.Equal(got.types, []uint64{mvtGeomPoint, mvtGeomLineString}) {
+		t.Errorf("got types %v", got.types)
+	}
+	if !slices.Equal(got.featureKeys[0], []string{"Activity", "Count", "Distance", "Nested", "Ok"}) {
+		t.Errorf("got keys %v", got.featureKeys[0])
+	}
+	if !slices.Equal(got.featureKeys[1], []string{"Activity"}) {
+		t.Errorf("got keys %v", got.featureKeys[1])
+	}
+}
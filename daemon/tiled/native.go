@@ -0,0 +1,483 @@
+package tiled
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/project"
+	"github.com/paulmach/orb/simplify"
+	"github.com/rotblauer/catd/catz"
+	"github.com/rotblauer/catd/params"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// nativeConfig is the subset of tippecanoe flags honored by the native tiling backend.
+// Other flags, eg. clustering, coalescing, and ordering, are ignored.
+type nativeConfig struct {
+	output, layer, name string
+	minZoom, maxZoom    int
+
+	// includes are the properties to keep. All are kept if none are named.
+	includes []string
+
+	// dropRate drops points at zooms below the maximum zoom,
+	// keeping 1 in dropRate^(maxZoom-zoom). A rate of 1 or less keeps all.
+	dropRate float64
+
+	// simplification is the Douglas-Peucker tolerance for lines and polygons, in tile units.
+	simplification float64
+
+	// buffer is the tile buffer, in 1/256ths of a tile.
+	buffer int
+
+	// maxTileBytes limits gzipped tiles; features are dropped from tiles exceeding it.
+	maxTileBytes int
+
+	generateIDs bool
+}
+
+// nativeShortFlags maps the honored short tippecanoe flags to their long names.
+var nativeShortFlags = map[string]string{
+	"-o": "--output",
+	"-l": "--layer",
+	"-n": "--name",
+	"-z": "--maximum-zoom",
+	"-Z": "--minimum-zoom",
+	"-y": "--include",
+	"-r": "--drop-rate",
+	"-S": "--simplification",
+	"-b": "--buffer",
+	"-M": "--maximum-tile-bytes",
+}
+
+var nativeLongFlags = func() map[string]bool {
+	m := map[string]bool{}
+	for _, long := range nativeShortFlags {
+		m[long] = true
+	}
+	return m
+}()
+
+// parseNativeConfig parses tippecanoe flags, using tippecanoe's defaults for those missing.
+func parseNativeConfig(flags params.CLIFlagsT) (*nativeConfig, error) {
+	c := &nativeConfig{
+		minZoom:        0,
+		maxZoom:        14,
+		dropRate:       2.5,
+		simplification: 1,
+		buffer:         5,
+		maxTileBytes:   500000,
+	}
+	for i := 0; i < len(flags); i++ {
+		flag := flags[i]
+		if flag == "--generate-ids" {
+			c.generateIDs = true
+			continue
+		}
+		key, value, hasValue := strings.Cut(flag, "=")
+		if !strings.HasPrefix(flag, "--") {
+			// Short flags may have attached values, eg. -z14.
+			key, value, hasValue = flag, "", false
+			if len(flag) > 2 {
+				key, value, hasValue = flag[:2], flag[2:], true
+			}
+			var ok bool
+			if key, ok = nativeShortFlags[key]; !ok {
+				continue
+			}
+		}
+		if !nativeLongFlags[key] {
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(flags) {
+				return nil, fmt.Errorf("missing value for %s", flag)
+			}
+			i++
+			value = flags[i]
+		}
+		var err error
+		switch key {
+		case "--output":
+			c.output = value
+		case "--layer":
+			c.layer = value
+		case "--name":
+			c.name = value
+		case "--maximum-zoom":
+			// Guessing (-zg) is not supported, so the default is kept.
+			if value != "g" {
+				c.maxZoom, err = strconv.Atoi(value)
+			}
+		case "--minimum-zoom":
+			c.minZoom, err = strconv.Atoi(value)
+		case "--include":
+			c.includes = append(c.includes, value)
+		case "--drop-rate":
+			c.dropRate, err = strconv.ParseFloat(value, 64)
+		case "--simplification":
+			c.simplification, err = strconv.ParseFloat(value, 64)
+		case "--buffer":
+			c.buffer, err = strconv.Atoi(value)
+		case "--maximum-tile-bytes":
+			c.maxTileBytes, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", flag, err)
+		}
+	}
+	if c.output == "" {
+		return nil, errors.New("missing --output")
+	}
+	if c.minZoom < 0 || c.maxZoom > 24 || c.minZoom > c.maxZoom {
+		return nil, fmt.Errorf("invalid zoom range %d-%d", c.minZoom, c.maxZoom)
+	}
+	if c.layer == "" {
+		c.layer = c.name
+	}
+	return c, nil
+}
+
+// nativeFeature is a feature to tile.
+type nativeFeature struct {
+	id uint64
+
+	// geom is projected to Web Mercator, scaled to the unit square, see nativeProject.
+	geom  orb.Geometry
+	props map[string]any
+}
+
+// nativeTiler cuts features into vector tiles, see params.TilingBackendNative.
+type nativeTiler struct {
+	config   *nativeConfig
+	features []nativeFeature
+
+	// bound is the WGS84 bound of all features.
+	bound orb.Bound
+
+	// fields are the types of the features' properties, as TileJSON vector_layers fields.
+	fields map[string]string
+}
+
+func newNativeTiler(config *nativeConfig) *nativeTiler {
+	return &nativeTiler{config: config, fields: map[string]string{}}
+}
+
+// nativeProject projects a WGS84 point to Web Mercator, scaled to the unit square with y pointing south.
+func nativeProject(p orb.Point) orb.Point {
+	lat := math.Max(-85.0511287798, math.Min(85.0511287798, p[1]))
+	sin := math.Sin(lat * math.Pi / 180)
+	return orb.Point{(p[0] + 180) / 360, 0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)}
+}
+
+// read adds the GeoJSON features of a gzipped source file.
+func (t *nativeTiler) read(source string) error {
+	r, err := catz.NewGZFileReader(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	for {
+		f := &geojson.Feature{}
+		if err := dec.Decode(f); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		t.add(f)
+	}
+}
+
+// add adds a feature, keeping the included properties.
+// Features without geometry, or with collections, are skipped.
+func (t *nativeTiler) add(f *geojson.Feature) {
+	switch f.Geometry.(type) {
+	case nil, orb.Collection, orb.Bound:
+		return
+	}
+	props := map[string]any{}
+	for k, v := range f.Properties {
+		if len(t.config.includes) > 0 && !slices.Contains(t.config.includes, k) {
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		props[k] = v
+		typ := "String"
+		switch v.(type) {
+		case float64, int, int64:
+			typ = "Number"
+		case bool:
+			typ = "Boolean"
+		}
+		if prev, ok := t.fields[k]; ok && prev != typ {
+			typ = "Mixed"
+		}
+		t.fields[k] = typ
+	}
+	var id uint64
+	if t.config.generateIDs {
+		id = uint64(len(t.features) + 1)
+	} else if v, ok := f.ID.(float64); ok && v > 0 && v == math.Trunc(v) {
+		id = uint64(v)
+	}
+	if len(t.features) == 0 {
+		t.bound = f.Geometry.Bound()
+	} else {
+		t.bound = t.bound.Union(f.Geometry.Bound())
+	}
+	t.features = append(t.features, nativeFeature{
+		id:    id,
+		geom:  project.Geometry(orb.Clone(f.Geometry), nativeProject),
+		props: props,
+	})
+}
+
+// write writes the tiles of every zoom.
+func (t *nativeTiler) write(w *mbtilesWriter) error {
+	for z := t.config.minZoom; z <= t.config.maxZoom; z++ {
+		if err := t.writeZoom(w, z); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZoom cuts and writes the tiles of one zoom, holding them all in memory until written.
+func (t *nativeTiler) writeZoom(w *mbtilesWriter, z int) error {
+	scale := float64(uint64(1)<<z) * mvtExtent
+	buffer := float64(t.config.buffer) * mvtExtent / 256
+	clipBound := orb.Bound{Min: orb.Point{-buffer, -buffer}, Max: orb.Point{mvtExtent + buffer, mvtExtent + buffer}}
+
+	// Keep every keep'th point.
+	keep := 1
+	if t.config.dropRate > 1 && z < t.config.maxZoom {
+		keep = int(math.Min(math.Round(math.Pow(t.config.dropRate, float64(t.config.maxZoom-z))), math.MaxInt32))
+	}
+	simplifier := simplify.DouglasPeucker(t.config.simplification)
+
+	tiles := map[maptile.Tile][]mvtFeature{}
+	points := 0
+	for _, f := range t.features {
+		g := project.Geometry(orb.Clone(f.geom), func(p orb.Point) orb.Point {
+			return orb.Point{p[0] * scale, p[1] * scale}
+		})
+		switch g.(type) {
+		case orb.Point, orb.MultiPoint:
+			points++
+			if (points-1)%keep != 0 {
+				continue
+			}
+		default:
+			if t.config.simplification > 0 {
+				g = simplifier.Simplify(g)
+			}
+		}
+		for _, tile := range nativeCover(g, z, buffer) {
+			ox, oy := float64(tile.X)*mvtExtent, float64(tile.Y)*mvtExtent
+			local := project.Geometry(orb.Clone(g), func(p orb.Point) orb.Point {
+				return orb.Point{p[0] - ox, p[1] - oy}
+			})
+			if local = clip.Geometry(clipBound, local); local == nil {
+				continue
+			}
+			tiles[tile] = append(tiles[tile], mvtFeature{id: f.id, geom: local, props: f.props})
+		}
+	}
+
+	keys := make([]maptile.Tile, 0, len(tiles))
+	for tile := range tiles {
+		keys = append(keys, tile)
+	}
+	slices.SortFunc(keys, func(a, b maptile.Tile) int {
+		return cmp.Or(cmp.Compare(a.X, b.X), cmp.Compare(a.Y, b.Y))
+	})
+	for _, tile := range keys {
+		data, err := t.encodeTile(tiles[tile])
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		if err := w.putTile(z, int(tile.X), int(tile.Y), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nativeCoverStep is the longest step, in tile units, a line is covered by.
+const nativeCoverStep = mvtExtent / 64
+
+// nativeCover returns the tiles at zoom z that a geometry, in world tile coordinates, touches
+// within the buffer. Lines are covered segment by segment, so long lines don't cover their whole bounds.
+func nativeCover(g orb.Geometry, z int, buffer float64) []maptile.Tile {
+	n := float64(uint64(1) << z)
+	set := map[maptile.Tile]struct{}{}
+	cover := func(b orb.Bound) {
+		lo := func(v float64) uint32 { return uint32(math.Max(0, math.Floor((v-buffer)/mvtExtent))) }
+		hi := func(v float64) uint32 { return uint32(math.Min(n-1, math.Floor((v+buffer)/mvtExtent))) }
+		for x := lo(b.Min[0]); x <= hi(b.Max[0]); x++ {
+			for y := lo(b.Min[1]); y <= hi(b.Max[1]); y++ {
+				set[maptile.New(x, y, maptile.Zoom(z))] = struct{}{}
+			}
+		}
+	}
+	lineString := func(ls orb.LineString) {
+		if len(ls) == 1 {
+			cover(ls.Bound())
+		}
+		for i := 1; i < len(ls); i++ {
+			// Long segments are covered in short steps, so diagonals don't cover their whole bound.
+			a, b := ls[i-1], ls[i]
+			steps := math.Ceil(math.Max(math.Abs(b[0]-a[0]), math.Abs(b[1]-a[1])) / nativeCoverStep)
+			prev := a
+			for s := 1.0; s <= steps; s++ {
+				next := orb.Point{a[0] + (b[0]-a[0])*s/steps, a[1] + (b[1]-a[1])*s/steps}
+				cover(orb.LineString{prev, next}.Bound())
+				prev = next
+			}
+			if steps == 0 {
+				cover(a.Bound())
+			}
+		}
+	}
+	switch g := g.(type) {
+	case orb.LineString:
+		lineString(g)
+	case orb.MultiLineString:
+		for _, ls := range g {
+			lineString(ls)
+		}
+	default:
+		cover(g.Bound())
+	}
+	tiles := make([]maptile.Tile, 0, len(set))
+	for tile := range set {
+		tiles = append(tiles, tile)
+	}
+	return tiles
+}
+
+// encodeTile encodes and gzips the features of a tile, returning nil if none remain.
+// Every other feature is dropped until the tile is within the maximum tile bytes.
+func (t *nativeTiler) encodeTile(features []mvtFeature) ([]byte, error) {
+	for {
+		layer := newMVTLayer(t.config.layer)
+		for _, f := range features {
+			layer.add(f)
+		}
+		if len(layer.features) == 0 {
+			return nil, nil
+		}
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(marshalMVT(layer)); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if t.config.maxTileBytes <= 0 || buf.Len() <= t.config.maxTileBytes || len(features) == 1 {
+			return buf.Bytes(), nil
+		}
+		thinned := make([]mvtFeature, 0, len(features)/2+1)
+		for i := 0; i < len(features); i += 2 {
+			thinned = append(thinned, features[i])
+		}
+		features = thinned
+	}
+}
+
+// metadata returns the MBTiles metadata of the tileset.
+func (t *nativeTiler) metadata() map[string]string {
+	b := t.bound
+	if len(t.features) == 0 {
+		b = orb.Bound{Min: orb.Point{-180, -85.0511287798}, Max: orb.Point{180, 85.0511287798}}
+	}
+	layers, _ := json.Marshal(map[string]any{
+		"vector_layers": []map[string]any{{
+			"id":          t.config.layer,
+			"description": "",
+			"minzoom":     t.config.minZoom,
+			"maxzoom":     t.config.maxZoom,
+			"fields":      t.fields,
+		}},
+	})
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 6, 64)
+	}
+	c := b.Center()
+	return map[string]string{
+		"name":        t.config.name,
+		"description": t.config.name,
+		"format":      "pbf",
+		"type":        "overlay",
+		"version":     "2",
+		"generator":   "catd native",
+		"minzoom":     strconv.Itoa(t.config.minZoom),
+		"maxzoom":     strconv.Itoa(t.config.maxZoom),
+		"bounds":      strings.Join([]string{f(b.Min[0]), f(b.Min[1]), f(b.Max[0]), f(b.Max[1])}, ","),
+		"center":      strings.Join([]string{f(c[0]), f(c[1]), strconv.Itoa(t.config.minZoom)}, ","),
+		"json":        string(layers),
+	}
+}
+
+// errNativeSourceTooBig is the error for a native tiling run over the source bytes limit.
+var errNativeSourceTooBig = errors.New("source too big for native tiling, use tippecanoe")
+
+// tipNative tiles the sources in-process, writing MBTiles to the args' --output,
+// see params.TilingBackendNative.
+// Runs over the config's NativeTilingSourceBytesLimit fail with errNativeSourceTooBig.
+func (d *TileDaemon) tipNative(args *TilingRequestArgs, sources ...string) error {
+	config, err := parseNativeConfig(args.cliArgs)
+	if err != nil {
+		return err
+	}
+	if limit := d.Config.NativeTilingSourceBytesLimit; limit > 0 {
+		var n int64
+		for _, source := range sources {
+			n += sourceBytes(source, SourceVersionCanonical)
+		}
+		if n > limit {
+			return fmt.Errorf("%w: %s: %d bytes, limit %d", errNativeSourceTooBig, args.id(), n, limit)
+		}
+	}
+	d.logger.Info("Tiling natively...", "source", sources)
+
+	tiler := newNativeTiler(config)
+	for _, source := range sources {
+		if err := tiler.read(source); err != nil {
+			return err
+		}
+	}
+	w, err := createMBTiles(config.output)
+	if err != nil {
+		return err
+	}
+	if err := tiler.write(w); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.close(tiler.metadata()); err != nil {
+		w.abort()
+		return err
+	}
+	d.logger.Debug("Tiled natively", "source", args.id(), "features", len(tiler.features))
+	return nil
+}
@@ -0,0 +1,311 @@
+package tiled
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/rotblauer/catd/params"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseNativeConfig(t *testing.T) {
+	flags := params.DefaultTippeConfigs.Laps().
+		MustSetPair("--layer", "laps").
+		MustSetPair("--name", "mycat-laps").
+		MustSetPair("--output", "/tmp/laps.mbtiles")
+	c, err := parseNativeConfig(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.output != "/tmp/laps.mbtiles" || c.layer != "laps" || c.name != "mycat-laps" {
+		t.Errorf("got output %q layer %q name %q", c.output, c.layer, c.name)
+	}
+	if c.minZoom != 3 || c.maxZoom != 18 {
+		t.Errorf("got zooms %d-%d", c.minZoom, c.maxZoom)
+	}
+	if !slices.Contains(c.includes, "Activity") || slices.Contains(c.includes, "Time_Start_Unix:sum") {
+		t.Errorf("got includes %v", c.includes)
+	}
+	if c.dropRate != 2.5 || c.maxTileBytes != 500000 || !c.generateIDs {
+		t.Errorf("got drop rate %v, max tile bytes %d, ids %v", c.dropRate, c.maxTileBytes, c.generateIDs)
+	}
+
+	c, err = parseNativeConfig(params.CLIFlagsT{"-z12", "-Z", "2", "-o", "out.mbtiles", "--drop-rate=1", "-EDuration:sum", "-l", "naps"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.minZoom != 2 || c.maxZoom != 12 || c.dropRate != 1 || c.output != "out.mbtiles" || c.layer != "naps" {
+		t.Errorf("got %+v", c)
+	}
+
+	for _, bad := range []params.CLIFlagsT{
+		{"--maximum-zoom", "3"},
+		{"-o", "out.mbtiles", "--minimum-zoom", "4", "--maximum-zoom", "3"},
+		{"-o", "out.mbtiles", "--maximum-zoom", "x"},
+		{"-o"},
+	} {
+		if _, err := parseNativeConfig(bad); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+}
+
+func TestNativeCover(t *testing.T) {
+	// A diagonal line across a 4x4 grid touches 7 tiles, plus neighbors within the buffer.
+	line := orb.LineString{{100, 300}, {4*mvtExtent - 100, 4*mvtExtent + 100}}
+	if got := nativeCover(line, 2, 0); len(got) != 7 {
+		t.Errorf("got %d tiles, want 7: %v", len(got), got)
+	}
+	if got := nativeCover(line, 2, 200); len(got) != 10 {
+		t.Errorf("got %d tiles, want 10: %v", len(got), got)
+	}
+	// Covers are clamped to the world.
+	if got := nativeCover(orb.Point{1, 1}, 0, 80); len(got) != 1 {
+		t.Errorf("got %v", got)
+	}
+}
+
+// testNativeTileD returns a tile daemon tiling everything natively in temporary dirs.
+func testNativeTileD(t *testing.T) *TileD {
+	t.Helper()
+	config := params.DefaultTileDaemonConfig()
+	config.RootDir = t.TempDir()
+	config.TilingTmpDir = t.TempDir()
+	config.SkipEdge = true
+	config.TilingBackend = params.TilingBackendNative
+	d, err := NewDaemon(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.db.Close()
+	})
+	return &TileD{d}
+}
+
+func TestTileD_nativeTiling(t *testing.T) {
+	d := testNativeTileD(t)
+
+	// A lap walking east, with the points of its tracks.
+	start := orb.Point{-93.25, 44.98}
+	lap := orb.LineString{}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for i := 0; i < 100; i++ {
+		pt := orb.Point{start[0] + float64(i)*0.0001, start[1] + float64(i%3)*0.00001}
+		lap = append(lap, pt)
+		if err := enc.Encode(map[string]any{
+			"type":       "Feature",
+			"geometry":   map[string]any{"type": "Point", "coordinates": pt},
+			"properties": map[string]any{"Name": "rye", "Speed": 1.4, "Secret": "shh", "UnixTime": 1700000000 + i},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracks := buf.Bytes()
+	buf = &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(map[string]any{
+		"type":       "Feature",
+		"geometry":   map[string]any{"type": "LineString", "coordinates": lap},
+		"properties": map[string]any{"Activity": "Walking", "Distance_Traversed": 800.0, "Secret": "shh"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	laps := buf.Bytes()
+
+	for _, c := range []struct {
+		config   params.TippeConfigName
+		source   string
+		data     []byte
+		geomType uint64
+		keys     []string
+	}{
+		{params.TippeConfigNameTracks, "tracks", tracks, mvtGeomPoint, []string{"Name", "Speed", "UnixTime"}},
+		{params.TippeConfigNameLaps, "laps", laps, mvtGeomLineString, []string{"Activity", "Distance_Traversed"}},
+	} {
+		t.Run(c.source, func(t *testing.T) {
+			schema := SourceSchema{CatID: "rye", SourceName: c.source, LayerName: c.source}
+			err := d.PushFeatures(&PushFeaturesRequestArgs{
+				SourceSchema:    schema,
+				TippeConfigName: c.config,
+				JSONBytes:       c.data,
+				Versions:        []TileSourceVersion{SourceVersionCanonical},
+				SourceModes:     []SourceMode{SourceModeTruncate},
+			}, &PushFeaturesResponse{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reply := &TilingResponse{}
+			err = d.callTiling(&TilingRequestArgs{
+				SourceSchema:    schema,
+				TippeConfigName: c.config,
+				Version:         SourceVersionCanonical,
+			}, reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, _ := d.TargetPathFor(schema, SourceVersionCanonical)
+			if !reply.Success || reply.MBTilesPath != target || filepath.Ext(target) != ".mbtiles" {
+				t.Fatalf("got reply %+v, want %s", reply, target)
+			}
+
+			m, err := OpenMBTiles(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			config, _ := parseNativeConfig(params.DefaultTippeConfigs.Tracks().MustSetPair("--output", "x"))
+			if c.config == params.TippeConfigNameLaps {
+				config, _ = parseNativeConfig(params.DefaultTippeConfigs.Laps().MustSetPair("--output", "x"))
+			}
+			meta := m.Metadata()
+			if meta["format"] != "pbf" || meta["name"] != c.source ||
+				meta["minzoom"] != strconv.Itoa(config.minZoom) || meta["maxzoom"] != strconv.Itoa(config.maxZoom) {
+				t.Errorf("got metadata %v", meta)
+			}
+
+			// Every zoom has a tile of the features, holding only the included properties.
+			for z := config.minZoom; z <= config.maxZoom; z++ {
+				tile := maptile.At(start, maptile.Zoom(z))
+				data, ok, err := m.Tile(z, int(tile.X), int(tile.Y))
+				if err != nil || !ok {
+					t.Fatalf("z%d: missing tile %v: %v", z, tile, err)
+				}
+				layers := decodeTestMVT(t, testGunzip(t, data))
+				if len(layers) != 1 || layers[0].name != c.source {
+					t.Fatalf("z%d: got layers %v", z, layers)
+				}
+				for i, keys := range layers[0].featureKeys {
+					if layers[0].types[i] != c.geomType {
+						t.Errorf("z%d: got geometry type %d", z, layers[0].types[i])
+					}
+					if !slices.Equal(keys, c.keys) {
+						t.Errorf("z%d: got keys %v, want %v", z, keys, c.keys)
+					}
+				}
+				// Tracks' drop rate is 1, keeping all points.
+				if c.config == params.TippeConfigNameTracks && z == config.minZoom && len(layers[0].featureKeys) != 100 {
+					t.Errorf("z%d: got %d points, want all", z, len(layers[0].featureKeys))
+				}
+			}
+		})
+	}
+}
+
+func TestTileD_nativeSourceLimit(t *testing.T) {
+	d := testNativeTileD(t)
+	d.Config.NativeTilingSourceBytesLimit = 64
+
+	schema := SourceSchema{CatID: "rye", SourceName: "tracks", LayerName: "tracks"}
+	err := d.PushFeatures(&PushFeaturesRequestArgs{
+		SourceSchema:    schema,
+		TippeConfigName: params.TippeConfigNameTracks,
+		JSONBytes:       []byte(`{"type":"Feature","geometry":{"type":"Point","coordinates":[-93.25,44.98]},"properties":{"Name":"rye"}}` + "\n"),
+		Versions:        []TileSourceVersion{SourceVersionCanonical},
+		SourceModes:     []SourceMode{SourceModeTruncate},
+	}, &PushFeaturesResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := &TilingResponse{}
+	err = d.callTiling(&TilingRequestArgs{
+		SourceSchema:    schema,
+		TippeConfigName: params.TippeConfigNameTracks,
+		Version:         SourceVersionCanonical,
+	}, reply)
+	if !errors.Is(err, errNativeSourceTooBig) || reply.Success {
+		t.Fatalf("got %v, reply %+v, want %v", err, reply, errNativeSourceTooBig)
+	}
+	target, _ := d.TargetPathFor(schema, SourceVersionCanonical)
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("got tileset %s: %v", target, err)
+	}
+}
+
+// testGunzip gunzips a tile.
+func testGunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testNativeGrid writes a tileset of a 100x10 degree grid of points with the flags,
+// returning the features in its z0 tile.
+func testNativeGrid(t *testing.T, flags ...string) (data []byte, features int) {
+	t.Helper()
+	config, err := parseNativeConfig(append(params.CLIFlagsT{"-o", filepath.Join(t.TempDir(), "grid.mbtiles"), "-l", "grid"}, flags...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiler := newNativeTiler(config)
+	for i := 0; i < 1000; i++ {
+		f, err := geojson.UnmarshalFeature([]byte(fmt.Sprintf(
+			`{"type":"Feature","geometry":{"type":"Point","coordinates":[%d,%d]},"properties":{"i":%d}}`, i%100, i/100, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tiler.add(f)
+	}
+	w, err := createMBTiles(config.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tiler.write(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(tiler.metadata()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenMBTiles(config.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	data, ok, err := m.Tile(0, 0, 0)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	layers := decodeTestMVT(t, testGunzip(t, data))
+	if len(layers) != 1 {
+		t.Fatalf("got %d layers", len(layers))
+	}
+	return data, len(layers[0].featureKeys)
+}
+
+func TestNativeTiler_dropRate(t *testing.T) {
+	// 1 in 2^3 points are kept at z0.
+	if _, n := testNativeGrid(t, "-z", "3", "-r", "2"); n != 125 {
+		t.Errorf("got %d points, want 125", n)
+	}
+	if _, n := testNativeGrid(t, "-z", "3", "-r", "1"); n != 1000 {
+		t.Errorf("got %d points, want 1000", n)
+	}
+}
+
+func TestNativeTiler_maxTileBytes(t *testing.T) {
+	data, n := testNativeGrid(t, "-z", "0", "-M", "2000")
+	if len(data) > 2000 {
+		t.Errorf("got %d bytes, want at most 2000", len(data))
+	}
+	if n == 0 || n == 1000 {
+		t.Errorf("got %d points", n)
+	}
+}
@@ -0,0 +1,158 @@
+package tiled
+
+import (
+	"fmt"
+	"github.com/rotblauer/catd/params"
+	"sort"
+	"sync"
+)
+
+// scheduler admits tiling runs within the daemon's concurrency limits,
+// highest priority first, then first come first served.
+// A waiting run that doesn't fit, eg. a canonical run while the canonical limit is reached,
+// doesn't hold up the runs behind it that do, except for the source bytes budget:
+// the first waiting run over the budget reserves it, so that a stream of smaller runs
+// can't starve it.
+type scheduler struct {
+	config *params.TileDaemonConfig
+
+	mu               sync.Mutex
+	seq              uint64
+	waiting          []*scheduledRun
+	running          map[string]*scheduledRun
+	runningCanonical int
+	runningBytes     int64
+}
+
+type scheduledRun struct {
+	args        *TilingRequestArgs
+	priority    int
+	seq         uint64
+	sourceBytes int64
+	admit       chan struct{}
+}
+
+func newScheduler(config *params.TileDaemonConfig) *scheduler {
+	return &scheduler{config: config, running: map[string]*scheduledRun{}}
+}
+
+// acquire waits for the run to be admitted, returning a func to call when it is done.
+// It returns errTilingAlreadyRunning if the request is already waiting or running.
+// The source bytes of the run count toward the source bytes budget.
+func (s *scheduler) acquire(args *TilingRequestArgs, sourceBytes int64) (release func(), err error) {
+	id := args.id()
+	s.mu.Lock()
+	if s.has(id) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", errTilingAlreadyRunning, id)
+	}
+	s.seq++
+	r := &scheduledRun{
+		args:        args,
+		priority:    s.config.TilingPriorities[args.TippeConfigName],
+		seq:         s.seq,
+		sourceBytes: sourceBytes,
+		admit:       make(chan struct{}),
+	}
+	s.waiting = append(s.waiting, r)
+	s.dispatch()
+	s.mu.Unlock()
+
+	<-r.admit
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.running, id)
+		s.runningBytes -= r.sourceBytes
+		if r.args.Version == SourceVersionCanonical {
+			s.runningCanonical--
+		}
+		s.dispatch()
+	}, nil
+}
+
+// has returns true if the request is waiting or running. The lock must be held.
+func (s *scheduler) has(id string) bool {
+	if _, ok := s.running[id]; ok {
+		return true
+	}
+	for _, r := range s.waiting {
+		if r.args.id() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isScheduled returns true if the request is waiting or running.
+func (s *scheduler) isScheduled(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.has(id)
+}
+
+// admits returns true if the run fits within the limits. The lock must be held.
+func (s *scheduler) admits(r *scheduledRun) bool {
+	if n := s.config.TilingConcurrency; n > 0 && len(s.running) >= n {
+		return false
+	}
+	if n := s.config.TilingCanonicalConcurrency; n > 0 && r.args.Version == SourceVersionCanonical && s.runningCanonical >= n {
+		return false
+	}
+	return true
+}
+
+// fitsBudget returns true if the run fits within the source bytes budget. The lock must be held.
+// A run bigger than the budget still runs alone.
+func (s *scheduler) fitsBudget(r *scheduledRun) bool {
+	b := s.config.TilingSourceBytesBudget
+	return b <= 0 || len(s.running) == 0 || s.runningBytes+r.sourceBytes <= b
+}
+
+// dispatch admits the waiting runs that fit. The lock must be held.
+func (s *scheduler) dispatch() {
+	sort.SliceStable(s.waiting, func(i, j int) bool {
+		if s.waiting[i].priority != s.waiting[j].priority {
+			return s.waiting[i].priority > s.waiting[j].priority
+		}
+		return s.waiting[i].seq < s.waiting[j].seq
+	})
+	waiting := s.waiting[:0]
+	// reserved is set by the first run waiting on the budget;
+	// the runs behind it don't take any of the budget until it is admitted.
+	reserved := false
+	for _, r := range s.waiting {
+		if !s.admits(r) {
+			waiting = append(waiting, r)
+			continue
+		}
+		if !s.fitsBudget(r) || (reserved && r.sourceBytes > 0) {
+			reserved = true
+			waiting = append(waiting, r)
+			continue
+		}
+		s.running[r.args.id()] = r
+		s.runningBytes += r.sourceBytes
+		if r.args.Version == SourceVersionCanonical {
+			s.runningCanonical++
+		}
+		close(r.admit)
+	}
+	// Clear the tail so admitted runs aren't retained.
+	for i := len(waiting); i < len(s.waiting); i++ {
+		s.waiting[i] = nil
+	}
+	s.waiting = waiting
+}
+
+// waitingRuns returns the waiting runs, in admission order.
+// They are read with the lock held, since admitted runs' args are written as they start.
+func (s *scheduler) waitingRuns() []TilingRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TilingRun, 0, len(s.waiting))
+	for _, r := range s.waiting {
+		out = append(out, newTilingRun(r.args))
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package tiled
+
+import (
+	"errors"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"testing"
+	"time"
+)
+
+// testSchedulerRun acquires a run in the background, sending its release func on admission.
+func testSchedulerRun(t *testing.T, s *scheduler, args *TilingRequestArgs, sourceBytes int64) <-chan func() {
+	t.Helper()
+	admitted := make(chan func(), 1)
+	waiting := len(s.waitingRuns())
+	go func() {
+		release, err := s.acquire(args, sourceBytes)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		admitted <- release
+	}()
+	// Wait for it to be admitted or waiting, to keep arrival order.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if len(admitted) > 0 || len(s.waitingRuns()) > waiting {
+			return admitted
+		}
+	}
+	t.Fatalf("%s: not scheduled", args.id())
+	return nil
+}
+
+func testSchedulerArgs(cat string, config params.TippeConfigName, version TileSourceVersion) *TilingRequestArgs {
+	return &TilingRequestArgs{
+		SourceSchema:    SourceSchema{CatID: conceptual.CatID(cat), SourceName: string(config), LayerName: string(config)},
+		TippeConfigName: config,
+		Version:         version,
+	}
+}
+
+func testAdmitted(t *testing.T, name string, admitted <-chan func(), want bool) func() {
+	t.Helper()
+	select {
+	case release := <-admitted:
+		if !want {
+			t.Fatalf("%s: admitted", name)
+		}
+		return release
+	case <-time.After(50 * time.Millisecond):
+		if want {
+			t.Fatalf("%s: not admitted", name)
+		}
+	}
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler(&params.TileDaemonConfig{
+		TilingConcurrency:          2,
+		TilingCanonicalConcurrency: 1,
+		TilingPriorities:           map[params.TippeConfigName]int{params.TippeConfigNameLaps: 1},
+	})
+
+	tracks := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameTracks, SourceVersionCanonical), 0)
+	releaseTracks := testAdmitted(t, "tracks", tracks, true)
+	edge := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameTracks, SourceVersionEdge), 0)
+	releaseEdge := testAdmitted(t, "tracks edge", edge, true)
+
+	// Running runs can't be scheduled again.
+	if _, err := s.acquire(testSchedulerArgs("rye", params.TippeConfigNameTracks, SourceVersionEdge), 0); !errors.Is(err, errTilingAlreadyRunning) {
+		t.Errorf("got %v, want already running", err)
+	}
+
+	// At the concurrency limit, runs wait.
+	iaTracks := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameTracks, SourceVersionCanonical), 0)
+	naps := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameNaps, SourceVersionEdge), 0)
+	laps := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameLaps, SourceVersionEdge), 0)
+	testAdmitted(t, "ia tracks", iaTracks, false)
+	if got := s.waitingRuns(); len(got) != 3 || got[0].SourceName != "laps" || got[1].SourceName != "tracks" {
+		t.Errorf("got waiting %+v", got)
+	}
+
+	// Laps have priority.
+	releaseEdge()
+	releaseLaps := testAdmitted(t, "laps", laps, true)
+	testAdmitted(t, "naps", naps, false)
+
+	// The canonical limit is reached, so naps go before the earlier canonical ia tracks.
+	releaseLaps()
+	releaseNaps := testAdmitted(t, "naps", naps, true)
+	testAdmitted(t, "ia tracks", iaTracks, false)
+
+	releaseTracks()
+	releaseIATracks := testAdmitted(t, "ia tracks", iaTracks, true)
+	releaseNaps()
+	releaseIATracks()
+	if len(s.running) != 0 || s.runningCanonical != 0 || len(s.waiting) != 0 {
+		t.Errorf("got running %v, canonical %d, waiting %d", s.running, s.runningCanonical, len(s.waiting))
+	}
+}
+
+func TestScheduler_sourceBytesBudget(t *testing.T) {
+	s := newScheduler(&params.TileDaemonConfig{TilingSourceBytesBudget: 100})
+
+	big := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameTracks, SourceVersionCanonical), 80)
+	releaseBig := testAdmitted(t, "big", big, true)
+	medium := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameLaps, SourceVersionCanonical), 30)
+	testAdmitted(t, "medium", medium, false)
+
+	// The waiting medium run holds the budget, so the small run fitting beside big waits behind it.
+	small := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameNaps, SourceVersionCanonical), 10)
+	testAdmitted(t, "small", small, false)
+	// Runs without source bytes take none of the budget.
+	empty := testSchedulerRun(t, s, testSchedulerArgs("rye", params.TippeConfigNameTracks, SourceVersionEdge), 0)
+	testAdmitted(t, "empty", empty, true)()
+
+	releaseBig()
+	releaseMedium := testAdmitted(t, "medium", medium, true)
+	releaseSmall := testAdmitted(t, "small", small, true)
+	releaseSmall()
+	releaseMedium()
+
+	// A stream of small runs doesn't starve a big one.
+	first := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameNaps, SourceVersionCanonical), 40)
+	releaseFirst := testAdmitted(t, "first", first, true)
+	large := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameTracks, SourceVersionCanonical), 70)
+	testAdmitted(t, "large", large, false)
+	second := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameLaps, SourceVersionCanonical), 40)
+	testAdmitted(t, "second", second, false)
+	releaseFirst()
+	releaseLarge := testAdmitted(t, "large", large, true)
+	testAdmitted(t, "second", second, false)
+	releaseLarge()
+	testAdmitted(t, "second", second, true)()
+
+	// Runs over the budget run alone.
+	huge := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameTracks, SourceVersionCanonical), 500)
+	releaseHuge := testAdmitted(t, "huge", huge, true)
+	next := testSchedulerRun(t, s, testSchedulerArgs("ia", params.TippeConfigNameLaps, SourceVersionCanonical), 1)
+	testAdmitted(t, "next", next, false)
+	releaseHuge()
+	testAdmitted(t, "next", next, true)()
+}
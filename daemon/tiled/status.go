@@ -0,0 +1,228 @@
+package tiled
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// tilingHistoryBucket holds the last runs of each source version,
+// in a nested bucket per request id, keyed by sequence.
+var tilingHistoryBucket = []byte("history")
+
+// TilingRun is a pending, running, or completed tiling request.
+type TilingRun struct {
+	CatID           conceptual.CatID
+	SourceName      string
+	LayerName       string
+	Version         TileSourceVersion
+	TippeConfigName params.TippeConfigName
+	Backend         params.TilingBackend
+
+	RequestedAt time.Time
+	// DueAt is when a pending request will run, unless requested again first.
+	DueAt      time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Elapsed is the time running, so far or in all.
+	Elapsed time.Duration
+
+	Success      bool
+	Error        string
+	MBTilesPath  string
+	SourceBytes  int64
+	MBTilesBytes int64
+}
+
+func newTilingRun(args *TilingRequestArgs) TilingRun {
+	return TilingRun{
+		CatID:           args.CatID,
+		SourceName:      args.SourceName,
+		LayerName:       args.LayerName,
+		Version:         args.Version,
+		TippeConfigName: args.TippeConfigName,
+		RequestedAt:     args.requestedAt,
+		StartedAt:       args.startedAt,
+	}
+}
+
+// StatusRequestArgs are the arguments to a status request.
+type StatusRequestArgs struct {
+	// CatID, if set, limits the status to the cat's sources.
+	CatID conceptual.CatID
+}
+
+// StatusResponse is the response to a status request.
+type StatusResponse struct {
+	// Pending runs are soonest due first.
+	Pending []TilingRun
+	// Waiting runs are due, but wait for the scheduler to admit them, next first.
+	Waiting []TilingRun
+	// Running runs are longest running first.
+	Running []TilingRun
+	// History has the last runs of each source version, most recent first.
+	History []TilingRun
+}
+
+// Status reports what the daemon is doing:
+// its pending, waiting and running tiling requests, and its recently completed runs.
+func (d *TileD) Status(args *StatusRequestArgs, reply *StatusResponse) error {
+	if args == nil {
+		args = &StatusRequestArgs{}
+	}
+	match := func(catID conceptual.CatID) bool {
+		return args.CatID == "" || args.CatID == catID
+	}
+
+	reply.Pending = []TilingRun{}
+	for _, item := range d.pendingTTLCache.Items() {
+		req := item.Value()
+		if !match(req.CatID) {
+			continue
+		}
+		run := newTilingRun(req)
+		run.DueAt = item.ExpiresAt()
+		reply.Pending = append(reply.Pending, run)
+	}
+	sort.Slice(reply.Pending, func(i, j int) bool {
+		return reply.Pending[i].DueAt.Before(reply.Pending[j].DueAt)
+	})
+
+	reply.Waiting = []TilingRun{}
+	for _, run := range d.scheduler.waitingRuns() {
+		if match(run.CatID) {
+			reply.Waiting = append(reply.Waiting, run)
+		}
+	}
+
+	reply.Running = []TilingRun{}
+	d.tilingRunningM.Range(func(key, value any) bool {
+		req := value.(*TilingRequestArgs)
+		if !match(req.CatID) {
+			return true
+		}
+		run := newTilingRun(req)
+		run.Backend = d.Config.TilingBackendFor(req.TippeConfigName)
+		run.Elapsed = time.Since(req.startedAt)
+		reply.Running = append(reply.Running, run)
+		return true
+	})
+	sort.Slice(reply.Running, func(i, j int) bool {
+		return reply.Running[i].StartedAt.Before(reply.Running[j].StartedAt)
+	})
+
+	history, err := d.history(args.CatID)
+	if err != nil {
+		return err
+	}
+	reply.History = history
+	return nil
+}
+
+// recordTiling records a run's outcome in the history, keeping the last TilingHistoryLimit runs of its source version.
+func (d *TileDaemon) recordTiling(args *TilingRequestArgs, reply *TilingResponse, tilingErr error) {
+	limit := d.Config.TilingHistoryLimit
+	if limit <= 0 {
+		return
+	}
+	run := newTilingRun(args)
+	run.Backend = d.Config.TilingBackendFor(args.TippeConfigName)
+	run.FinishedAt = time.Now()
+	run.Elapsed = run.FinishedAt.Sub(args.startedAt)
+	run.Success = tilingErr == nil
+	if tilingErr != nil {
+		run.Error = tilingErr.Error()
+	}
+	run.SourceBytes = sourceBytes(args.parsedSourcePath, args.Version)
+	if reply != nil && reply.MBTilesPath != "" {
+		run.MBTilesPath = reply.MBTilesPath
+		if fi, err := os.Stat(reply.MBTilesPath); err == nil {
+			run.MBTilesBytes = fi.Size()
+		}
+	}
+	v, err := json.Marshal(run)
+	if err != nil {
+		d.logger.Error("Failed to marshal tiling run", "error", err)
+		return
+	}
+
+	err = d.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(tilingHistoryBucket)
+		if err != nil {
+			return err
+		}
+		sb, err := b.CreateBucketIfNotExists([]byte(args.id()))
+		if err != nil {
+			return err
+		}
+		seq, err := sb.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := sb.Put(binary.BigEndian.AppendUint64(nil, seq), v); err != nil {
+			return err
+		}
+		// Forget the runs before the last limit.
+		c := sb.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k)+uint64(limit) <= seq; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.logger.Error("Failed to record tiling run", "error", err)
+	}
+}
+
+// history returns the recorded runs, of all cats or the one, most recent first.
+func (d *TileDaemon) history(catID conceptual.CatID) ([]TilingRun, error) {
+	runs := []TilingRun{}
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tilingHistoryBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEachBucket(func(k []byte) error {
+			return b.Bucket(k).ForEach(func(_, v []byte) error {
+				run := TilingRun{}
+				if err := json.Unmarshal(v, &run); err != nil {
+					return err
+				}
+				if catID == "" || run.CatID == catID {
+					runs = append(runs, run)
+				}
+				return nil
+			})
+		})
+	})
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].FinishedAt.After(runs[j].FinishedAt)
+	})
+	return runs, err
+}
+
+// sourceBytes returns the size of a source version's file(s), or 0.
+func sourceBytes(source string, version TileSourceVersion) int64 {
+	if source == "" {
+		return 0
+	}
+	paths := []string{source}
+	if version == SourceVersionEdge {
+		paths, _ = filepath.Glob(source + "*")
+	}
+	var n int64
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
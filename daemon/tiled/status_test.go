@@ -0,0 +1,81 @@
+package tiled
+
+import (
+	"github.com/rotblauer/catd/params"
+	"testing"
+)
+
+func TestTileD_Status(t *testing.T) {
+	d := testNativeTileD(t)
+	d.Config.TilingHistoryLimit = 2
+
+	schema := SourceSchema{CatID: "rye", SourceName: "tracks", LayerName: "tracks"}
+	point := []byte(`{"type":"Feature","geometry":{"type":"Point","coordinates":[-93.25,44.98]},"properties":{}}` + "\n")
+	err := d.PushFeatures(&PushFeaturesRequestArgs{
+		SourceSchema:    schema,
+		TippeConfigName: params.TippeConfigNameTracks,
+		JSONBytes:       point,
+		Versions:        []TileSourceVersion{SourceVersionCanonical},
+		SourceModes:     []SourceMode{SourceModeTruncate},
+	}, &PushFeaturesResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := &StatusResponse{}
+	if err := d.Status(&StatusRequestArgs{}, status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 1 || status.Pending[0].SourceName != "tracks" || status.Pending[0].DueAt.IsZero() {
+		t.Errorf("got pending %+v", status.Pending)
+	}
+	if len(status.Running) != 0 || len(status.History) != 0 {
+		t.Errorf("got running %v, history %v", status.Running, status.History)
+	}
+
+	req := &TilingRequestArgs{SourceSchema: schema, TippeConfigName: params.TippeConfigNameTracks, Version: SourceVersionCanonical}
+	for i := 0; i < 3; i++ {
+		if err := d.callTiling(req, &TilingResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A run of a source that was never pushed fails.
+	laps := &TilingRequestArgs{
+		SourceSchema:    SourceSchema{CatID: "ia", SourceName: "laps", LayerName: "laps"},
+		TippeConfigName: params.TippeConfigNameLaps,
+		Version:         SourceVersionCanonical,
+	}
+	if err := d.callTiling(laps, &TilingResponse{}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	status = &StatusResponse{}
+	if err := d.Status(&StatusRequestArgs{}, status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 0 {
+		t.Errorf("got pending %+v", status.Pending)
+	}
+	// The last 2 runs of tracks are kept, after the most recent failed laps run.
+	if len(status.History) != 3 {
+		t.Fatalf("got %d runs, want 3", len(status.History))
+	}
+	failed := status.History[0]
+	if failed.CatID != "ia" || failed.Success || failed.Error == "" || failed.Backend != params.TilingBackendNative {
+		t.Errorf("got failed run %+v", failed)
+	}
+	for _, run := range status.History[1:] {
+		if !run.Success || run.CatID != "rye" || run.SourceBytes == 0 || run.MBTilesBytes == 0 ||
+			run.Elapsed <= 0 || run.StartedAt.After(run.FinishedAt) {
+			t.Errorf("got run %+v", run)
+		}
+	}
+
+	status = &StatusResponse{}
+	if err := d.Status(&StatusRequestArgs{CatID: "rye"}, status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.History) != 2 {
+		t.Errorf("got %d rye runs, want 2", len(status.History))
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -18,6 +20,24 @@ type WebDaemon struct {
 	melodyInstance *melody.Melody
 	feedPopulated  event.FeedOf[[]*cattrack.CatTrack]
 	started        time.Time
+
+	// tokens is the API token store.
+	tokens *TokenStore
+
+	// queue, if non-nil, spools /populate bodies for async population.
+	queue *populateQueue
+
+	// catEvents buffers and streams the cats' server-sent events.
+	catEvents *catEvents
+
+	// tilesets are the open tile daemon tilesets served on /tiles.
+	tilesets *tilesets
+
+	// privacyZones caches cats' privacy zones for broadcasts, by cat ID.
+	privacyZones sync.Map
+
+	// geofenceWebhook, if non-nil, delivers geofence events to the configured webhook.
+	geofenceWebhook *geofenceWebhook
 }
 
 func NewWebDaemon(config *params.WebDaemonConfig) (*WebDaemon, error) {
@@ -30,17 +50,40 @@ func NewWebDaemon(config *params.WebDaemonConfig) (*WebDaemon, error) {
 		config.DataDir = params.DefaultDatadirRoot
 		logger.Warn("No data dir provided, using default", "datadir", config.DataDir)
 	}
-	return &WebDaemon{
+	s := &WebDaemon{
 		Config:        config,
 		logger:        logger,
 		feedPopulated: event.FeedOf[[]*cattrack.CatTrack]{},
-	}, nil
+		tokens:        NewTokenStore(config.DataDir),
+		catEvents:     newCatEvents(),
+		tilesets:      newTilesets(),
+	}
+	if config.PopulateQueue != nil {
+		q, err := newPopulateQueue(filepath.Join(config.DataDir, params.PopulateQueueDir), config.PopulateQueue, s.populateSpooled)
+		if err != nil {
+			return nil, err
+		}
+		s.queue = q
+	}
+	if config.GeofenceWebhookURL != "" {
+		s.geofenceWebhook = newGeofenceWebhook(config.GeofenceWebhookURL, logger)
+	}
+	return s, nil
 }
 
 // Run starts the HTTP server (ListenAndServe) and waits for it,
 // returning any server error.
 func (s *WebDaemon) Run() error {
 	s.started = time.Now()
+	// Closed last, delivering events of populates still queued.
+	if s.geofenceWebhook != nil {
+		defer s.geofenceWebhook.close()
+	}
+	if s.queue != nil {
+		s.queue.start()
+		defer s.queue.close()
+	}
+	defer s.tilesets.close()
 	router := s.NewRouter()
 	http.Handle("/", router)
 	log.Printf("Starting web daemon on %s", s.Config.Address)
@@ -49,11 +92,7 @@ func (s *WebDaemon) Run() error {
 
 func (s *WebDaemon) NewRouter() *mux.Router {
 
-	// Handle websocket.
 	s.initMelody()
-	http.HandleFunc("/socat", func(w http.ResponseWriter, r *http.Request) {
-		_ = s.melodyInstance.HandleRequest(w, r)
-	})
 
 	/*
 		StrictSlash defines the trailing slash behavior for new routes. The initial value is false.
@@ -89,21 +128,62 @@ func (s *WebDaemon) NewRouter() *mux.Router {
 	apiNDJSON := apiRoutes.NewRoute().Subrouter()
 	apiNDJSON.Use(contentTypeMiddlewareFunc("application/x-ndjson"))
 
-	apiJSON.Path("/{cat}/last.json").HandlerFunc(s.catIndex).Methods(http.MethodGet)
-	apiJSON.Path("/{cat}/pushed.json").HandlerFunc(s.catPushedJSON).Methods(http.MethodGet)
-	apiNDJSON.Path("/{cat}/pushed.ndjson").HandlerFunc(s.catPushedNDJSON).Methods(http.MethodGet)
-	apiJSON.Path("/{cat}/snaps.json").HandlerFunc(s.getCatSnaps).Methods(http.MethodGet)
-	apiJSON.Path("/{cat}/s2/{level}/tracks.json").HandlerFunc(s.s2Collect).Methods(http.MethodGet)
-	apiNDJSON.Path("/{cat}/s2/{level}/tracks.ndjson").HandlerFunc(s.s2Dump).Methods(http.MethodGet)
-	apiJSON.Path("/{cat}/rgeo/{datasetRe}/plats.json").HandlerFunc(s.rGeoCollect).Methods(http.MethodGet)
-
-	authenticatedAPIRoutes := apiJSON.NewRoute().Subrouter()
-	authenticatedAPIRoutes.Use(tokenAuthenticationMiddleware)
-	populateRoutes := authenticatedAPIRoutes.NewRoute().Subrouter()
+	// Cat read routes are authenticated only if configured to require read tokens.
+	readJSON := apiJSON.NewRoute().Subrouter()
+	readJSON.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readNDJSON := apiNDJSON.NewRoute().Subrouter()
+	readNDJSON.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+
+	readJSON.Path("/{cat}/last.json").HandlerFunc(s.catIndex).Methods(http.MethodGet)
+	readJSON.Path("/{cat}/pushed.json").HandlerFunc(s.catPushedJSON).Methods(http.MethodGet)
+	readNDJSON.Path("/{cat}/pushed.ndjson").HandlerFunc(s.catPushedNDJSON).Methods(http.MethodGet)
+	readNDJSON.Path("/{cat}/tracks.ndjson").HandlerFunc(s.catTracksNDJSON).Methods(http.MethodGet)
+	readJSON.Path("/{cat}/snaps.json").HandlerFunc(s.getCatSnaps).Methods(http.MethodGet)
+	readJSON.Path("/{cat}/s2/{level}/tracks.json").HandlerFunc(s.s2Collect).Methods(http.MethodGet)
+	readJSON.Path("/{cat}/s2/{level}/query").HandlerFunc(s.s2Query).Methods(http.MethodGet)
+	readNDJSON.Path("/{cat}/s2/{level}/tracks.ndjson").HandlerFunc(s.s2Dump).Methods(http.MethodGet)
+	readJSON.Path("/{cat}/rgeo/{datasetRe}/plats.json").HandlerFunc(s.rGeoCollect).Methods(http.MethodGet)
+
+	// Exports set their own content type, per format.
+	readExport := apiRoutes.NewRoute().Subrouter()
+	readExport.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readExport.Path("/{cat}/tracks.{format:gpx|kml|csv}").HandlerFunc(s.catTracksExport).Methods(http.MethodGet)
+
+	// Tiles set their own content type, and TileJSON is JSON.
+	// The source defaults to the layer, eg. /tiles/rye/laps/{z}/{x}/{y}.pbf,
+	// and is otherwise given, eg. /tiles/rye/s2_cells/level-06-polygons/{z}/{x}/{y}.pbf.
+	readTiles := apiRoutes.NewRoute().Subrouter()
+	readTiles.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readTiles.Path("/tiles/{cat}/{layer}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf").HandlerFunc(s.catTile).Methods(http.MethodGet)
+	readTiles.Path("/tiles/{cat}/{source}/{layer}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf").HandlerFunc(s.catTile).Methods(http.MethodGet)
+	readJSON.Path("/tiles/{cat}/{layer:[^/.]+}.json").HandlerFunc(s.catTileJSON).Methods(http.MethodGet)
+	readJSON.Path("/tiles/{cat}/{source}/{layer:[^/.]+}.json").HandlerFunc(s.catTileJSON).Methods(http.MethodGet)
+
+	// Snap images are JPEGs, served from the cat state.
+	readImages := apiRoutes.NewRoute().Subrouter()
+	readImages.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readImages.Path("/{cat}/snaps/{key}.jpg").HandlerFunc(s.catSnapImage).Methods(http.MethodGet)
+
+	readSSE := apiRoutes.NewRoute().Subrouter()
+	readSSE.Use(contentTypeMiddlewareFunc("text/event-stream"))
+	readSSE.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readSSE.Path("/{cat}/events").HandlerFunc(s.catEventsSSE).Methods(http.MethodGet)
+
+	// The websocket is a read route; its broadcasts are filtered by the token's cats.
+	readSocket := router.NewRoute().Subrouter()
+	readSocket.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	readSocket.Path("/socat").HandlerFunc(s.socat)
+
+	// Populate checks each posted cat against the token's scope.
+	populateRoutes := apiJSON.NewRoute().Subrouter()
+	populateRoutes.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionPopulate))
 	populateRoutes.Path("/populate/").HandlerFunc(s.populate).Methods(http.MethodPost)
 	populateRoutes.Path("/populate").HandlerFunc(s.populate).Methods(http.MethodPost)
 
-	// TODO: Proxy to the tiler daemon's RPC server?
+	adminRoutes := apiJSON.NewRoute().Subrouter()
+	adminRoutes.Use(s.tokenAuthenticationMiddlewareFunc(TokenActionAdmin))
+	adminRoutes.Path("/queue").HandlerFunc(s.queueStatus).Methods(http.MethodGet)
+	adminRoutes.Path("/tiled/status").HandlerFunc(s.tiledStatus).Methods(http.MethodGet)
 
 	return router
 }
@@ -0,0 +1,193 @@
+package webd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rotblauer/catd/common"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// catEventsHeartbeat is the interval of comments sent to idle /{cat}/events clients,
+// keeping proxies from closing the connection.
+var catEventsHeartbeat = 15 * time.Second
+
+// catEvent is a server-sent event.
+type catEvent struct {
+	ID     uint64
+	Action websocketAction
+	Data   []byte
+}
+
+// write writes the event in the event stream format.
+func (e catEvent) write(w http.ResponseWriter) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Action, e.Data)
+	return err
+}
+
+// catEventStream is a cat's recent events.
+type catEventStream struct {
+	seq  uint64
+	ring *common.RingBuffer[catEvent]
+}
+
+// catEvents is the hub of the cats' server-sent events.
+// Event IDs are sequential per cat, starting at 1 when webd starts.
+// A cat's stream is created by its first event; subscribers to other cats,
+// eg. names which are not cats, wait without one.
+type catEvents struct {
+	mu      sync.Mutex
+	streams map[conceptual.CatID]*catEventStream
+	// subs are the live subscribers, by cat, removed as they leave.
+	subs map[conceptual.CatID]map[chan catEvent]struct{}
+}
+
+func newCatEvents() *catEvents {
+	return &catEvents{
+		streams: map[conceptual.CatID]*catEventStream{},
+		subs:    map[conceptual.CatID]map[chan catEvent]struct{}{},
+	}
+}
+
+// unsubscribe removes and closes the subscriber's channel, if it is still subscribed.
+func (ce *catEvents) unsubscribe(catID conceptual.CatID, ch chan catEvent) {
+	subs, ok := ce.subs[catID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(ce.subs, catID)
+	}
+}
+
+// publish adds the broadcast to the cat's events, sending it to subscribers.
+// Subscribers too slow to keep up are dropped; they can resume with Last-Event-ID.
+func (ce *catEvents) publish(catID conceptual.CatID, bc broadcats) {
+	data, err := json.Marshal(bc)
+	if err != nil {
+		slog.Error("Failed to marshal cat event", "cat", catID, "action", bc.Action, "error", err)
+		return
+	}
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	st, ok := ce.streams[catID]
+	if !ok {
+		st = &catEventStream{ring: common.NewRingBuffer[catEvent](params.CatEventsBufferSize)}
+		ce.streams[catID] = st
+	}
+	st.seq++
+	e := catEvent{ID: st.seq, Action: bc.Action, Data: data}
+	st.ring.Add(e)
+	for ch := range ce.subs[catID] {
+		select {
+		case ch <- e:
+		default:
+			slog.Warn("Dropping slow cat events client", "cat", catID)
+			ce.unsubscribe(catID, ch)
+		}
+	}
+}
+
+// subscribe returns the cat's buffered events after lastID, and a channel of later events.
+// A lastID unknown to the buffer, eg. from before a restart, replays all buffered events.
+// The channel is closed if the subscriber falls behind. Cancel unsubscribes.
+func (ce *catEvents) subscribe(catID conceptual.CatID, lastID uint64) (replay []catEvent, ch chan catEvent, cancel func()) {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	if st, ok := ce.streams[catID]; ok {
+		for _, e := range st.ring.Get() {
+			if lastID <= st.seq && e.ID <= lastID {
+				continue
+			}
+			replay = append(replay, e)
+		}
+	}
+	ch = make(chan catEvent, params.CatEventsBufferSize)
+	if ce.subs[catID] == nil {
+		ce.subs[catID] = map[chan catEvent]struct{}{}
+	}
+	ce.subs[catID][ch] = struct{}{}
+	cancel = func() {
+		ce.mu.Lock()
+		defer ce.mu.Unlock()
+		ce.unsubscribe(catID, ch)
+	}
+	return replay, ch, cancel
+}
+
+// catEventsSSE streams a cat's populate, lap, nap, snap and geofence events
+// as server-sent events, like /socat. Data are the JSON messages /socat sends.
+// Clients reconnecting with a Last-Event-ID header, or lastEventId query param,
+// are first sent the buffered events they missed.
+func (s *WebDaemon) catEventsSSE(w http.ResponseWriter, r *http.Request) {
+	catID := getRequestCatID(r)
+	if catID.IsEmpty() {
+		slog.Warn("Missing cat", "url", r.URL)
+		http.Error(w, "Missing cat", http.StatusBadRequest)
+		return
+	}
+	lastID := uint64(0)
+	if v := r.Header.Get("Last-Event-ID"); v != "" || r.URL.Query().Has("lastEventId") {
+		if v == "" {
+			v = r.URL.Query().Get("lastEventId")
+		}
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			slog.Warn("Invalid Last-Event-ID", "value", v, "error", err)
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	replay, events, cancel := s.catEvents.subscribe(catID, lastID)
+	defer cancel()
+	for _, e := range replay {
+		if err := e.write(w); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		slog.Warn("Failed to flush cat events", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(catEventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// Dropped as too slow; the client may resume.
+				return
+			}
+			if err := e.write(w); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package webd
+
+import (
+	"bufio"
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/params"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatEvents_subscribe(t *testing.T) {
+	old := params.CatEventsBufferSize
+	params.CatEventsBufferSize = 3
+	defer func() { params.CatEventsBufferSize = old }()
+
+	ce := newCatEvents()
+	for i := 0; i < 5; i++ {
+		ce.publish("rye", broadcats{Action: websocketActionLap})
+	}
+	ce.publish("ia", broadcats{Action: websocketActionNap})
+
+	ids := func(events []catEvent) (out []uint64) {
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	cases := []struct {
+		lastID uint64
+		want   []uint64
+	}{
+		{0, []uint64{3, 4, 5}},
+		{3, []uint64{4, 5}},
+		{5, nil},
+		// Unknown, eg. from before a restart.
+		{42, []uint64{3, 4, 5}},
+	}
+	for _, c := range cases {
+		replay, _, cancel := ce.subscribe("rye", c.lastID)
+		cancel()
+		if got := ids(replay); !slices.Equal(got, c.want) {
+			t.Errorf("lastID=%d: got %v, want %v", c.lastID, got, c.want)
+		}
+	}
+
+	_, ch, cancel := ce.subscribe("rye", 5)
+	defer cancel()
+	ce.publish("ia", broadcats{Action: websocketActionNap})
+	ce.publish("rye", broadcats{Action: websocketActionSnap})
+	select {
+	case e := <-ch:
+		if e.ID != 6 || e.Action != websocketActionSnap {
+			t.Errorf("expected rye snap 6, got %d %s", e.ID, e.Action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected live event")
+	}
+}
+
+func TestCatEvents_subscribeUnknownCat(t *testing.T) {
+	ce := newCatEvents()
+	replay, ch, cancel := ce.subscribe("kitty", 0)
+	if len(replay) != 0 || len(ce.streams) != 0 {
+		t.Fatalf("expected no stream for kitty, got replay %v, streams %d", replay, len(ce.streams))
+	}
+
+	// The subscriber waits for kitty's first event.
+	ce.publish("kitty", broadcats{Action: websocketActionLap})
+	select {
+	case e := <-ch:
+		if e.ID != 1 || e.Action != websocketActionLap {
+			t.Errorf("expected kitty lap 1, got %d %s", e.ID, e.Action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected live event")
+	}
+	cancel()
+	if len(ce.subs) != 0 {
+		t.Errorf("expected no subscribers left, got %d", len(ce.subs))
+	}
+
+	// Subscribers that leave without events leave nothing behind.
+	_, _, cancel = ce.subscribe("nobody", 0)
+	cancel()
+	if len(ce.subs) != 0 || len(ce.streams) != 1 {
+		t.Errorf("expected only kitty's stream, got %d subs, %d streams", len(ce.subs), len(ce.streams))
+	}
+}
+
+func TestWebDaemon_catEventsSSE(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+
+	d.catEvents.publish("rye", broadcats{Action: websocketActionLap})
+	d.catEvents.publish("rye", broadcats{Action: websocketActionNap})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/rye/events", nil).WithContext(ctx)
+	req = mux.SetURLVars(req, map[string]string{"cat": "rye"})
+	req.Header.Set("Last-Event-ID", "1")
+
+	pr, pw := io.Pipe()
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), w: pw}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer pw.Close()
+		d.catEventsSSE(rec, req)
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	next := func() string {
+		lines := []string{}
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				break
+			}
+			lines = append(lines, scanner.Text())
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	// Resumed after 1.
+	if got := next(); !strings.HasPrefix(got, "id: 2\nevent: nap\ndata: {") {
+		t.Errorf("expected replayed nap, got %q", got)
+	}
+	d.catEvents.publish("rye", broadcats{Action: websocketActionSnap})
+	if got := next(); !strings.HasPrefix(got, "id: 3\nevent: snap\n") {
+		t.Errorf("expected live snap, got %q", got)
+	}
+	cancel()
+	<-done
+}
+
+func TestWebDaemon_catEventsSSE_badLastEventID(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+
+	req := httptest.NewRequest(http.MethodGet, "/rye/events?lastEventId=nope", nil)
+	req = mux.SetURLVars(req, map[string]string{"cat": "rye"})
+	rec := httptest.NewRecorder()
+	d.catEventsSSE(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+// flushRecorder pipes the response body to a reader as it is written.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	w io.Writer
+}
+
+func (r *flushRecorder) Write(b []byte) (int, error) {
+	return r.w.Write(b)
+}
+
+func (r *flushRecorder) Flush() {}
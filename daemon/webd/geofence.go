@@ -0,0 +1,103 @@
+package webd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var geofenceWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// geofenceWebhook POSTs geofence events to a URL from one worker, in the order queued,
+// so that consecutive populates' events, eg. an enter and then an exit, arrive in order.
+type geofenceWebhook struct {
+	url    string
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []api.GeofenceEvent
+	closed bool
+	done   chan struct{}
+}
+
+// newGeofenceWebhook starts the worker delivering to the url.
+func newGeofenceWebhook(url string, logger *slog.Logger) *geofenceWebhook {
+	w := &geofenceWebhook{url: url, logger: logger, done: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// enqueue queues the events for delivery after any queued before.
+// It does not block on delivery.
+func (w *geofenceWebhook) enqueue(events []api.GeofenceEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		w.logger.Warn("Geofence webhook closed, dropping events", "count", len(events))
+		return
+	}
+	w.queue = append(w.queue, events...)
+	w.cond.Signal()
+}
+
+func (w *geofenceWebhook) run() {
+	defer close(w.done)
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		if err := postGeofenceWebhook(w.url, e); err != nil {
+			w.logger.Warn("Failed to post geofence webhook", "cat", e.CatID, "fence", e.Fence, "type", e.Type, "error", err)
+		}
+	}
+}
+
+// close delivers the events already queued, and stops the worker.
+func (w *geofenceWebhook) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Signal()
+	w.mu.Unlock()
+	<-w.done
+}
+
+// deliverGeofenceEvents queues the events for the webhook, if configured.
+func (s *WebDaemon) deliverGeofenceEvents(events []api.GeofenceEvent) {
+	if len(events) == 0 || s.geofenceWebhook == nil {
+		return
+	}
+	s.geofenceWebhook.enqueue(events)
+}
+
+// postGeofenceWebhook POSTs the event as JSON to the url.
+func postGeofenceWebhook(url string, e api.GeofenceEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	res, err := geofenceWebhookClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package webd
+
+import (
+	"encoding/json"
+	"github.com/rotblauer/catd/api"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebDaemon_deliverGeofenceEvents(t *testing.T) {
+	mu := sync.Mutex{}
+	got := []api.GeofenceEvent{}
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected webhook request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		e := api.GeofenceEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got = append(got, e)
+		mu.Unlock()
+	}))
+	defer stub.Close()
+
+	// No webhook configured: nothing to deliver.
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	now := time.Now().UTC()
+	d.deliverGeofenceEvents([]api.GeofenceEvent{{CatID: "rye", Fence: "home", Type: api.GeofenceEventEnter, Time: now}})
+	if d.geofenceWebhook != nil {
+		t.Fatal("unexpected webhook")
+	}
+
+	d.geofenceWebhook = newGeofenceWebhook(stub.URL, d.logger)
+	// Consecutive populates' events are delivered in order.
+	events := []api.GeofenceEvent{}
+	for i := 0; i < 20; i++ {
+		at := now.Add(time.Duration(i) * time.Minute)
+		batch := []api.GeofenceEvent{{CatID: "rye", Fence: "home", Type: api.GeofenceEventEnter, Time: at}}
+		if i%2 == 1 {
+			batch = []api.GeofenceEvent{{CatID: "rye", Fence: "home", Type: api.GeofenceEventExit, Time: at, Since: &now}}
+		}
+		events = append(events, batch...)
+		d.deliverGeofenceEvents(batch)
+	}
+	d.geofenceWebhook.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != len(events) {
+		t.Fatalf("expected %d webhook posts, got %d", len(events), len(got))
+	}
+	for i := range events {
+		if got[i].Type != events[i].Type || !got[i].Time.Equal(events[i].Time) || got[i].Fence != "home" || got[i].CatID != "rye" {
+			t.Errorf("post %d: got %+v, want %+v", i, got[i], events[i])
+		}
+	}
+}
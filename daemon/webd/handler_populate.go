@@ -3,33 +3,185 @@ package webd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/catz"
 	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/types"
 	"github.com/rotblauer/catd/types/cattrack"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// populateReceiptMediaType is the Accept media type requesting a JSON receipt from /populate.
+const populateReceiptMediaType = "application/vnd.catd.receipt+json"
+
+// wantsPopulateReceipt returns true if the request opts in to a JSON receipt,
+// with the Accept header or a truthy ?receipt= query param.
+// Legacy clients get the legacy response.
+func wantsPopulateReceipt(r *http.Request) bool {
+	if v := r.URL.Query().Get("receipt"); v != "" {
+		ok, _ := strconv.ParseBool(v)
+		return ok
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mt == populateReceiptMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// populateResult is the result of populating one cat.
+type populateResult struct {
+	receipt *api.PopulateReceipt
+	err     error
+}
+
+// writePopulateReceipts writes the cats' receipts as a JSON array,
+// appending each cat's populate error, if any, to its receipt's errors.
+// The receipt of a single-cat body is written as an object, as it was before multi-cat bodies.
+// The status is 500 if any cat failed, else 403 if any cat was forbidden.
+func (s *WebDaemon) writePopulateReceipts(w http.ResponseWriter, results []populateResult) {
+	status := http.StatusOK
+	receipts := make([]*api.PopulateReceipt, 0, len(results))
+	for _, res := range results {
+		if errors.Is(res.err, errCatForbidden) {
+			res.receipt.AddError(res.err.Error())
+			if status == http.StatusOK {
+				status = http.StatusForbidden
+			}
+		} else if res.err != nil {
+			res.receipt.AddError(res.err.Error())
+			status = http.StatusInternalServerError
+		}
+		receipts = append(receipts, res.receipt)
+	}
+	var out any = receipts
+	if len(receipts) == 1 {
+		out = receipts[0]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		s.logger.Warn("Failed to write response", "error", err)
+	}
+}
+
+// writePopulateOK writes the legacy populate response.
+func (s *WebDaemon) writePopulateOK(w http.ResponseWriter) {
+	// This weirdness satisfies the legacy clients.
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("[]")); err != nil {
+		s.logger.Warn("Failed to write response", "error", err)
+	}
+}
+
+// writePopulateResults writes the receipts, if wanted, or the legacy response.
+func (s *WebDaemon) writePopulateResults(w http.ResponseWriter, r *http.Request, results []populateResult) {
+	if wantsPopulateReceipt(r) {
+		s.writePopulateReceipts(w, results)
+		return
+	}
+	for _, res := range results {
+		if errors.Is(res.err, errCatForbidden) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	for _, res := range results {
+		if res.err != nil {
+			http.Error(w, "Failed to populate", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writePopulateOK(w)
+}
+
+// importPopulateBody returns the request body as JSON.
+// GPX, TCX and NMEA bodies, per the format query param or as sniffed,
+// are converted to NDJSON features of the cat named by the cat query param.
+func importPopulateBody(r *http.Request) (io.Reader, error) {
+	format, err := types.ParseImportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewReader(r.Body)
+	if format == "" {
+		peek, _ := buf.Peek(1024)
+		format = types.SniffImportFormat(peek)
+	}
+	if format == types.ImportFormatJSON {
+		return buf, nil
+	}
+	out := new(bytes.Buffer)
+	enc := json.NewEncoder(out)
+	err = types.DecodeImportTracks(buf, format, r.URL.Query().Get("cat"), func(ct *cattrack.CatTrack) error {
+		return enc.Encode(ct)
+	})
+	return out, err
+}
+
 // populate is a handler for the /populate endpoint.
 // It is where Cat Tracks get posted.
 // It supports a variety of input formats;
 // Android (GCPS) posts a GeoJSON FeatureCollection (object).
 // iOS (v.CustomizeableCatHat) posts an array of O.G. TrackPoints.
+// GPX, TCX and NMEA records, eg. from dedicated GPS units, are imported for the cat named
+// by the cat query param, and stored to master as NDJSON; see importPopulateBody.
+// A body may hold tracks for many cats, as from a relay device; each cat is populated concurrently.
+// The tracks of cats outside the request token's scope are forbidden, and not stored, even to master,
+// as are the tracks of cats named for a pseudo-cat, eg. all-cats.
+// Clients may opt in to a JSON api.PopulateReceipt, see wantsPopulateReceipt;
+// multi-cat bodies get a JSON array of per-cat receipts.
+// If the populate queue is configured, the body is spooled and acknowledged
+// before it is populated, unless receipts are wanted.
 func (s *WebDaemon) populate(w http.ResponseWriter, r *http.Request) {
-	var err error
 	if r.Body == nil {
 		s.logger.Error("No request body", "method", r.Method, "url", r.URL)
 		http.Error(w, "Please send a request body", 500)
 		return
 	}
 
-	cp := new(bytes.Buffer)
-	tee := io.TeeReader(r.Body, cp)
-	i, err := api.Master(s.Config.DataDir, tee)
+	body, err := importPopulateBody(r)
+	if err != nil {
+		s.logger.Error("Failed to import request body", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to import request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		s.logger.Error("Failed to read request body", "error", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	// Out-of-scope cats' tracks are dropped before they get to master,
+	// which is the source for rebuilding cats.
+	raw, forbidden, err := s.scopePopulateBody(r.Context(), raw)
+	if err != nil {
+		s.logger.Error("Failed to scan messages", "error", err)
+		http.Error(w, "Failed to scan messages", http.StatusBadRequest)
+		return
+	}
+	if len(raw) == 0 && len(forbidden) > 0 {
+		s.writePopulateResults(w, r, forbidden)
+		return
+	}
+
+	i, err := api.Master(s.Config.DataDir, bytes.NewReader(raw))
 	if err != nil {
 		s.logger.Error("Failed to store master tracks", "error", err)
 		http.Error(w, "Failed to store master tracks", http.StatusInternalServerError)
@@ -38,75 +190,286 @@ func (s *WebDaemon) populate(w http.ResponseWriter, r *http.Request) {
 		s.logger.Info("Stored master tracks", "bytes", i, "path", params.MasterGZFileName)
 	}
 
-	buf := bufio.NewReader(cp)
+	if s.queue != nil {
+		s.populateQueued(w, r, raw, forbidden)
+		return
+	}
+
+	buf := bufio.NewReader(bytes.NewReader(raw))
 	peek, _ := buf.Peek(80)
 	s.logger.Info("Peeked request body", "peek", fmt.Sprintf("%s...", peek))
 
-	var cat *api.Cat
-	var catID conceptual.CatID
+	tracks, errs := scanTracks(buf)
+	results := s.populateCats(r.Context(), tracks)
+	if err := <-errs; err != nil {
+		s.logger.Error("Failed to scan cat pop message/s", "error", err)
+		if len(results) == 0 {
+			http.Error(w, "Failed to scan messages", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(results) == 0 {
+		s.logger.Error("No tracks", "method", r.Method, "url", r.URL)
+		http.Error(w, "No tracks", http.StatusBadRequest)
+		return
+	}
+	s.writePopulateResults(w, r, append(results, forbidden...))
+}
 
-	first := make(chan cattrack.CatTrack, 1)
+// populateForbids returns errCatForbidden if the request token may not populate the cat,
+// or errCatReserved if the cat is named for a pseudo-cat.
+func (s *WebDaemon) populateForbids(token *Token, catID conceptual.CatID) error {
+	if catID == conceptual.AllCatsID {
+		s.logger.Warn("Reserved cat name", "cat", catID)
+		return errCatReserved
+	}
+	if token != nil && !token.Allows(TokenActionPopulate, catID) {
+		s.logger.Warn("Token not scoped to cat", "token", token.Name, "cat", catID)
+		return errCatForbidden
+	}
+	return nil
+}
+
+// scopePopulateBody returns the body with only the tracks of the cats the request token may populate,
+// and a forbidden result for each other cat, see populateForbids.
+// Bodies of only allowed cats are returned as-is.
+// Bodies failing to scan are returned as-is for tokens scoped to all cats, or without a token,
+// leaving populateCats to drop what it can't scan.
+func (s *WebDaemon) scopePopulateBody(ctx context.Context, body []byte) ([]byte, []populateResult, error) {
+	token := tokenFromContext(ctx)
+	catIDs, bodies, err := splitBodyByCat(body)
+	if err != nil {
+		if token == nil || token.AllowsCat(TokenAllCats) {
+			return body, nil, nil
+		}
+		return nil, nil, err
+	}
+	allowed := new(bytes.Buffer)
+	forbidden := []populateResult{}
+	for _, catID := range catIDs {
+		if err := s.populateForbids(token, catID); err != nil {
+			forbidden = append(forbidden, populateResult{receipt: &api.PopulateReceipt{CatID: catID}, err: err})
+			continue
+		}
+		allowed.Write(bodies[catID])
+	}
+	if len(forbidden) == 0 {
+		return body, nil, nil
+	}
+	return allowed.Bytes(), forbidden, nil
+}
+
+// scanTracks decodes the tracks in a populate request body.
+// The error channel yields the scan error, if any, once the tracks are done.
+func scanTracks(body io.Reader) (<-chan cattrack.CatTrack, chan error) {
 	tracks := make(chan cattrack.CatTrack, params.DefaultChannelCap)
 	errs := make(chan error, 1)
-	n := 0
 	go func() {
 		defer close(tracks)
 		defer close(errs)
-		s.logger.Debug("Scanning cat pop request")
-		defer s.logger.Debug("Scanned cat pop request")
-		er := types.ScanJSONMessages(buf, func(message json.RawMessage) error {
+		errs <- types.ScanJSONMessages(body, func(message json.RawMessage) error {
 			return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
-				if n == 0 {
-					s.logger.Info("First track", "track", ct)
-					first <- *ct
-					close(first)
-				}
-				n++
 				tracks <- *ct
 				return nil
 			})
 		})
-		if er != nil {
-			s.logger.Error("Failed to scan cat pop message/s", "error", er)
+	}()
+	return tracks, errs
+}
+
+// populateCats splits the tracks by cat, populating each cat concurrently.
+// Results are returned in order of each cat's first track.
+// Empty tracks, having no cat, are dropped,
+// as are the tracks of cats the request token may not populate, see populateForbids.
+func (s *WebDaemon) populateCats(ctx context.Context, tracks <-chan cattrack.CatTrack) []populateResult {
+	token := tokenFromContext(ctx)
+	catIDs := []conceptual.CatID{}
+	catChs := map[conceptual.CatID]chan cattrack.CatTrack{}
+	results := map[conceptual.CatID]*populateResult{}
+	wg := sync.WaitGroup{}
+	for ct := range tracks {
+		if ct.IsEmpty() {
+			s.logger.Error("Empty track")
+			continue
+		}
+		catID := ct.CatID()
+		ch, ok := catChs[catID]
+		if !ok {
+			s.logger.Info("Populating", "cat", catID)
+			ch = make(chan cattrack.CatTrack, params.DefaultChannelCap)
+			catChs[catID] = ch
+			catIDs = append(catIDs, catID)
+			res := &populateResult{}
+			results[catID] = res
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.populateForbids(token, catID); err != nil {
+					res.receipt, res.err = &api.PopulateReceipt{CatID: catID}, err
+					for range ch {
+					}
+					return
+				}
+				res.receipt, res.err = s.populateCat(ctx, catID, ch)
+			}()
+		}
+		ch <- ct
+	}
+	for _, ch := range catChs {
+		close(ch)
+	}
+	wg.Wait()
+
+	out := make([]populateResult, 0, len(catIDs))
+	for _, catID := range catIDs {
+		out = append(out, *results[catID])
+	}
+	return out
+}
+
+// populateCat populates one cat with the tracks, returning its receipt.
+// The tracks are always drained, so that a failed cat does not block others.
+func (s *WebDaemon) populateCat(ctx context.Context, catID conceptual.CatID, tracks <-chan cattrack.CatTrack) (*api.PopulateReceipt, error) {
+	defer func() {
+		for range tracks {
+			// Drain any tracks left by a failed populate.
 		}
-		errs <- er
 	}()
+	cat, err := api.NewCat(catID, params.DefaultCatDataDirRooted(s.Config.DataDir, catID.String()), s.Config.CatBackendConfig)
+	if err != nil {
+		s.logger.Error("Failed to get/create cat", "cat", catID, "error", err)
+		return &api.PopulateReceipt{CatID: catID}, err
+	}
+	pubs := collectCatPublications(cat)
+	receipt, err := cat.PopulateWithReceipt(ctx, true, tracks)
+	if err != nil {
+		s.logger.Error("Failed to populate", "cat", catID, "error", err)
+	}
+	s.rememberPrivacyZones(cat)
+	s.publish(cat, pubs)
+	return receipt, err
+}
 
-	select {
-	case t := <-first:
-		if t.IsEmpty() {
-			s.logger.Error("Empty first track", "method", r.Method, "url", r.URL)
-			http.Error(w, "Empty first track", http.StatusBadRequest)
-			return
+// splitBodyByCat splits a populate request body into per-cat bodies, in order of each cat's first track.
+// A body holding only one cat is returned as-is; otherwise each cat's tracks are re-encoded as NDJSON features.
+func splitBodyByCat(body []byte) ([]conceptual.CatID, map[conceptual.CatID][]byte, error) {
+	catIDs := []conceptual.CatID{}
+	bufs := map[conceptual.CatID]*bytes.Buffer{}
+	err := types.ScanJSONMessages(bytes.NewReader(body), func(message json.RawMessage) error {
+		return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
+			if ct.IsEmpty() {
+				return nil
+			}
+			catID := ct.CatID()
+			buf, ok := bufs[catID]
+			if !ok {
+				buf = new(bytes.Buffer)
+				bufs[catID] = buf
+				catIDs = append(catIDs, catID)
+			}
+			return json.NewEncoder(buf).Encode(ct)
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	bodies := map[conceptual.CatID][]byte{}
+	if len(catIDs) == 1 {
+		bodies[catIDs[0]] = body
+		return catIDs, bodies, nil
+	}
+	for _, catID := range catIDs {
+		bodies[catID] = bufs[catID].Bytes()
+	}
+	return catIDs, bodies, nil
+}
+
+// populateQueued spools the body to the populate queue, one job per cat.
+// Legacy clients are acknowledged as soon as the body is spooled.
+// Clients wanting receipts wait for their jobs to finish.
+// The forbidden results, of cats already dropped from the body, are included in the response.
+func (s *WebDaemon) populateQueued(w http.ResponseWriter, r *http.Request, body []byte, forbidden []populateResult) {
+	catIDs, bodies, err := splitBodyByCat(body)
+	if err != nil {
+		s.logger.Error("Failed to scan messages", "error", err)
+		http.Error(w, "Failed to scan messages", http.StatusBadRequest)
+		return
+	}
+	if len(catIDs) == 0 {
+		s.logger.Error("No tracks", "method", r.Method, "url", r.URL)
+		http.Error(w, "No tracks", http.StatusBadRequest)
+		return
+	}
+	token := tokenFromContext(r.Context())
+	jobs := make([]*populateJob, 0, len(catIDs))
+	for _, catID := range catIDs {
+		if err := s.populateForbids(token, catID); err != nil {
+			forbidden = append(forbidden, populateResult{receipt: &api.PopulateReceipt{CatID: catID}, err: err})
+			continue
 		}
-		catID = t.CatID()
-		cat, err = api.NewCat(catID, params.DefaultCatDataDirRooted(s.Config.DataDir, catID.String()), s.Config.CatBackendConfig)
+		job, err := s.queue.enqueue(catID, bodies[catID])
 		if err != nil {
-			s.logger.Error("Failed to get/create cat", "error", err)
-			http.Error(w, "Failed to get/create cat", http.StatusInternalServerError)
+			s.logger.Error("Failed to spool populate request", "cat", catID, "error", err)
+			http.Error(w, "Failed to spool populate request", http.StatusInternalServerError)
 			return
 		}
-	case err := <-errs:
-		if err != nil {
-			s.logger.Error("Failed to scan messages", "error", err)
-			http.Error(w, "Failed to scan messages", http.StatusInternalServerError)
+		s.logger.Info("Spooled populate request", "cat", catID, "job", job.ID, "bytes", len(bodies[catID]))
+		jobs = append(jobs, job)
+	}
+
+	if !wantsPopulateReceipt(r) {
+		s.writePopulateResults(w, r, forbidden)
+		return
+	}
+	results := make([]populateResult, 0, len(jobs)+len(forbidden))
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+			receipt := job.receipt
+			if receipt == nil {
+				receipt = &api.PopulateReceipt{CatID: job.CatID}
+			}
+			results = append(results, populateResult{receipt: receipt, err: job.err})
+		case <-r.Context().Done():
+			// The jobs stay queued.
 			return
 		}
 	}
+	s.writePopulateReceipts(w, append(results, forbidden...))
+}
 
-	s.logger.Info("Populating", "cat", catID, "tracks", n)
-
-	ctx := r.Context()
-	err = cat.Populate(ctx, true, tracks)
+// populateSpooled populates the cat with the tracks of a spooled request body.
+// It is the populate queue's job handler.
+// Track decoding errors are noted on the receipt, as they are not retryable.
+// Populate errors after the cat has received any tracks are errPopulatePartial,
+// since only failures before then, eg. to open the cat's state, are safe to retry.
+func (s *WebDaemon) populateSpooled(ctx context.Context, job *populateJob) (*api.PopulateReceipt, error) {
+	r, err := catz.NewGZFileReader(job.Path)
 	if err != nil {
-		s.logger.Error("Failed to populate", "error", err)
-		http.Error(w, "Failed to populate", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	defer r.Close()
 
-	// This weirdness satisfies the legacy clients.
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte("[]")); err != nil {
-		s.logger.Warn("Failed to write response", "error", err)
+	tracks, errs := scanTracks(r)
+	receipt, err := s.populateCat(ctx, job.CatID, tracks)
+	if scanErr := <-errs; scanErr != nil {
+		s.logger.Error("Failed to scan spooled messages", "job", job.ID, "error", scanErr)
+		receipt.AddError(scanErr.Error())
+	}
+	if err != nil && receipt.Received > 0 {
+		err = fmt.Errorf("%w: %w", errPopulatePartial, err)
+	}
+	return receipt, err
+}
+
+// queueStatus reports the populate queue status.
+func (s *WebDaemon) queueStatus(w http.ResponseWriter, r *http.Request) {
+	if s.queue == nil {
+		http.Error(w, "Populate queue not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.queue.status()); err != nil {
+		s.logger.Error("Failed to write response", "error", err)
 	}
 }
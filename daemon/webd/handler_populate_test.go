@@ -1,15 +1,26 @@
 package webd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"github.com/dustin/go-humanize"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/rotblauer/catd/api"
 	"github.com/rotblauer/catd/catz"
+	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/testing/testdata"
+	"github.com/rotblauer/catd/types"
+	"github.com/rotblauer/catd/types/cattrack"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestWebDaemon_populate(t *testing.T) {
@@ -100,3 +111,298 @@ func testPopulate_source(d *WebDaemon, cat, source string, nFeatures int) func(t
 		}
 	}
 }
+
+func TestWantsPopulateReceipt(t *testing.T) {
+	cases := []struct {
+		url    string
+		accept string
+		want   bool
+	}{
+		{"/populate", "", false},
+		{"/populate", "*/*", false},
+		{"/populate", "application/json", false},
+		{"/populate", populateReceiptMediaType, true},
+		{"/populate", "text/plain, " + populateReceiptMediaType + "; q=0.9", true},
+		{"/populate?receipt=true", "", true},
+		{"/populate?receipt=1", "", true},
+		{"/populate?receipt=false", populateReceiptMediaType, false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org"+c.url, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		if got := wantsPopulateReceipt(req); got != c.want {
+			t.Errorf("url=%s accept=%q: got %v, want %v", c.url, c.accept, got, c.want)
+		}
+	}
+}
+
+func TestWebDaemon_writePopulateReceipts(t *testing.T) {
+	s := &WebDaemon{logger: slog.Default()}
+
+	// A single cat's receipt is an object.
+	w := httptest.NewRecorder()
+	s.writePopulateReceipts(w, []populateResult{{receipt: &api.PopulateReceipt{CatID: "rye", Stored: 3}}})
+	receipt := &api.PopulateReceipt{}
+	if err := json.NewDecoder(w.Body).Decode(receipt); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || receipt.CatID != "rye" || receipt.Stored != 3 {
+		t.Errorf("unexpected single-cat receipt: %d %+v", w.Code, receipt)
+	}
+
+	// Many cats' receipts are an array.
+	w = httptest.NewRecorder()
+	s.writePopulateReceipts(w, []populateResult{
+		{receipt: &api.PopulateReceipt{CatID: "rye"}},
+		{receipt: &api.PopulateReceipt{CatID: "ia"}, err: errCatForbidden},
+	})
+	receipts := []*api.PopulateReceipt{}
+	if err := json.NewDecoder(w.Body).Decode(&receipts); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusForbidden || len(receipts) != 2 || len(receipts[1].Errors) != 1 {
+		t.Errorf("unexpected multi-cat receipts: %d %+v", w.Code, receipts)
+	}
+}
+
+func TestImportPopulateBody(t *testing.T) {
+	nmea := "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\n"
+	cases := []struct {
+		url    string
+		body   string
+		tracks int
+		ok     bool
+	}{
+		{"/populate?cat=ia", nmea, 1, true},
+		{"/populate?cat=ia&format=nmea", nmea, 1, true},
+		{"/populate", nmea, 0, false},
+		{"/populate?format=shp", nmea, 0, false},
+		{"/populate?cat=ia", `{"type": "FeatureCollection", "features": []}`, 0, true},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org"+c.url, bytes.NewBufferString(c.body))
+		body, err := importPopulateBody(req)
+		if (err == nil) != c.ok {
+			t.Errorf("url=%s: got err=%v, want ok=%v", c.url, err, c.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		n := 0
+		if err := types.ScanJSONMessages(body, func(message json.RawMessage) error {
+			return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
+				if ct.CatID() != "ia" {
+					t.Errorf("url=%s: expected ia, got %s", c.url, ct.CatID())
+				}
+				n++
+				return nil
+			})
+		}); err != nil {
+			t.Errorf("url=%s: %v", c.url, err)
+		}
+		if n != c.tracks {
+			t.Errorf("url=%s: expected %d tracks, got %d", c.url, c.tracks, n)
+		}
+	}
+}
+
+// multiCatBody returns a FeatureCollection body of n tracks per cat, interleaved.
+func multiCatBody(t *testing.T, cats []string, n int) []byte {
+	start := time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local)
+	fc := geojson.NewFeatureCollection()
+	for i := 0; i < n; i++ {
+		for j, cat := range cats {
+			ct := cattrack.CatTrack{}
+			if err := json.Unmarshal([]byte(testdata.Track_iOS_stationary_1), &ct); err != nil {
+				t.Fatal(err)
+			}
+			ts := start.Add(time.Duration(i) * time.Minute)
+			ct.SetPropertySafe("Name", cat)
+			ct.SetPropertySafe("Time", ts.Format(time.RFC3339))
+			ct.SetPropertySafe("UnixTime", ts.Unix())
+			pt := ct.Point()
+			ct.Geometry = orb.Point{pt.Lon() + float64(i)*0.001, pt.Lat() + float64(j)*0.01}
+			fc.Append((*geojson.Feature)(&ct))
+		}
+	}
+	b, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSplitBodyByCat(t *testing.T) {
+	body := multiCatBody(t, []string{"rye", "ia"}, 3)
+	catIDs, bodies, err := splitBodyByCat(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catIDs) != 2 || catIDs[0] != "rye" || catIDs[1] != "ia" {
+		t.Fatalf("unexpected cats: %v", catIDs)
+	}
+	for _, catID := range catIDs {
+		n := 0
+		err := types.ScanJSONMessages(bytes.NewReader(bodies[catID]), func(message json.RawMessage) error {
+			return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
+				if ct.CatID() != catID {
+					t.Errorf("%s: got track for %s", catID, ct.CatID())
+				}
+				n++
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("%s: expected 3 tracks, got %d", catID, n)
+		}
+	}
+
+	// A single cat body is kept as-is.
+	single := multiCatBody(t, []string{"rye"}, 3)
+	_, bodies, err = splitBodyByCat(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bodies["rye"], single) {
+		t.Error("expected single cat body unchanged")
+	}
+}
+
+func TestWebDaemon_populate_tokenScope(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	// The queue is not started, so bodies are only spooled.
+	q, err := newPopulateQueue(t.TempDir(), nil, d.populateSpooled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.queue = q
+	token := &Token{Name: "friend", Cats: []conceptual.CatID{"rye"}, Actions: []TokenAction{TokenActionPopulate}}
+
+	do := func(cats []string) int {
+		body := multiCatBody(t, cats, 10)
+		req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org/populate", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), tokenContextKey{}, token))
+		w := httptest.NewRecorder()
+		d.populate(w, req)
+		return w.Code
+	}
+
+	// Wholly forbidden bodies are not stored to master.
+	if code := do([]string{"ia"}); code != http.StatusForbidden {
+		t.Errorf("forbidden cat: got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(d.Config.DataDir, params.MasterGZFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected no master, got %v", err)
+	}
+
+	// Forbidden cats' tracks are dropped from master, and not queued.
+	if code := do([]string{"rye", "ia"}); code != http.StatusForbidden {
+		t.Errorf("partly forbidden cats: got %d", code)
+	}
+	r, err := catz.NewGZFileReader(filepath.Join(d.Config.DataDir, params.MasterGZFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.MaybeClose()
+	n := 0
+	err = types.ScanJSONMessages(r, func(message json.RawMessage) error {
+		return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
+			if ct.CatID() != "rye" {
+				t.Errorf("master got track for %s", ct.CatID())
+			}
+			n++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("expected 10 master tracks, got %d", n)
+	}
+	if st := q.status(); st.Pending != 1 || st.Cats[0].CatID != "rye" {
+		t.Errorf("unexpected queue status: %+v", st)
+	}
+}
+
+func TestWebDaemon_populate_reservedCat(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	// The queue is not started, so bodies are only spooled.
+	q, err := newPopulateQueue(t.TempDir(), nil, d.populateSpooled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.queue = q
+
+	// Without a token, as with one scoped to all cats, all-cats is still reserved.
+	body := multiCatBody(t, []string{"rye", conceptual.AllCatsID.String()}, 10)
+	req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org/populate", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	d.populate(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("reserved cat: got %d", w.Code)
+	}
+	if st := q.status(); st.Pending != 1 || st.Cats[0].CatID != "rye" {
+		t.Errorf("unexpected queue status: %+v", st)
+	}
+
+	r, err := catz.NewGZFileReader(filepath.Join(d.Config.DataDir, params.MasterGZFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.MaybeClose()
+	n := 0
+	err = types.ScanJSONMessages(r, func(message json.RawMessage) error {
+		return types.DecodingJSONTrackObject(message, func(ct *cattrack.CatTrack) error {
+			if ct.CatID() == conceptual.AllCatsID {
+				t.Error("master got all-cats track")
+			}
+			n++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("expected 10 master tracks, got %d", n)
+	}
+}
+
+func TestWebDaemon_populate_multiCat(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+
+	body := multiCatBody(t, []string{"rye", "ia"}, 10)
+	req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org/populate?receipt=true", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	d.populate(w, req)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code not ok: %d", resp.StatusCode)
+	}
+	receipts := []*api.PopulateReceipt{}
+	if err := json.NewDecoder(resp.Body).Decode(&receipts); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("expected 2 receipts, got %d", len(receipts))
+	}
+	for i, cat := range []conceptual.CatID{"rye", "ia"} {
+		r := receipts[i]
+		if r.CatID != cat {
+			t.Errorf("receipt %d: got cat %s, want %s", i, r.CatID, cat)
+		}
+		if r.Received != 10 || r.Invalid != 0 || r.Stored != 10 {
+			t.Errorf("%s: unexpected receipt: %+v", cat, r)
+		}
+	}
+}
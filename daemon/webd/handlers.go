@@ -72,10 +72,25 @@ func (s *WebDaemon) handleGetCatForRequest(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Missing cat", http.StatusBadRequest)
 		return nil, false
 	}
-	return &api.Cat{
-		CatID:   catID,
-		DataDir: s.Config.DataDir,
-	}, true
+	cat, err := api.NewCat(catID, params.DefaultCatDataDirRooted(s.Config.DataDir, catID.String()), nil)
+	if err != nil {
+		slog.Warn("Invalid cat", "url", r.URL, "error", err)
+		http.Error(w, "Invalid cat", http.StatusBadRequest)
+		return nil, false
+	}
+	return cat, true
+}
+
+// handleGetCatPrivacyZones reads the privacy zones of the cat, with state open.
+// Tracks from the cat's state should be privatized by these before they are written.
+func handleGetCatPrivacyZones(w http.ResponseWriter, cat *api.Cat) (api.PrivacyZones, bool) {
+	zones, err := cat.PrivacyZones()
+	if err != nil {
+		slog.Warn("Failed to read privacy zones", "cat", cat.CatID, "error", err)
+		http.Error(w, "Failed to read privacy zones", http.StatusInternalServerError)
+		return nil, false
+	}
+	return zones, true
 }
 
 // catIndex returns the last known, cumulative offset index for a cat.
@@ -93,12 +108,23 @@ func (s *WebDaemon) catIndex(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cat.State.Close()
 
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+
 	indexed := cattrack.CatTrack{}
 	if err := cat.State.ReadKVUnmarshalJSON(params.CatStateBucket, params.CatStateKey_OffsetIndexer, &indexed); err != nil {
 		slog.Warn("Failed to read offset index", "error", err)
 		http.Error(w, "Failed to read offset index", http.StatusInternalServerError)
 		return
 	}
+	indexed, ok = zones.Track(indexed)
+	if !ok {
+		// Last known in an omitting privacy zone.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(indexed); err != nil {
 		slog.Warn("Failed to write response", "error", err)
 	}
@@ -120,6 +146,11 @@ func (s *WebDaemon) catPushedJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cat.State.Close()
 
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+
 	lr, err := cat.State.Flat.NewGZFileReader(params.LastTracksGZFileName)
 	if err != nil {
 		slog.Warn("Failed to get last tracks", "error", err)
@@ -143,7 +174,9 @@ func (s *WebDaemon) catPushedJSON(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to decode track", http.StatusInternalServerError)
 			return
 		}
-		tracks = append(tracks, track)
+		if track, ok := zones.Track(track); ok {
+			tracks = append(tracks, track)
+		}
 		//buf.Add(track)
 	}
 	//if err := json.NewEncoder(w).Encode(buf.Get()); err != nil {
@@ -170,6 +203,11 @@ func (s *WebDaemon) catPushedNDJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cat.State.Close()
 
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+
 	lr, err := cat.State.Flat.NewGZFileReader(params.LastTracksGZFileName)
 	if err != nil {
 		slog.Warn("Failed to get last tracks", "error", err)
@@ -179,41 +217,34 @@ func (s *WebDaemon) catPushedNDJSON(w http.ResponseWriter, r *http.Request) {
 	defer lr.Close()
 
 	// Stream JSON tracks.
-	_, err = io.Copy(w, lr)
-	if err != nil {
-		slog.Error("Failed to write response", "error", err)
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		return
-	}
-}
-
-func (s *WebDaemon) getCatSnaps(w http.ResponseWriter, r *http.Request) {
-	cat, ok := s.handleGetCatForRequest(w, r)
-	if !ok {
+	if len(zones) == 0 {
+		_, err = io.Copy(w, lr)
+		if err != nil {
+			slog.Error("Failed to write response", "error", err)
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		}
 		return
 	}
-	if err := cat.LockOrLoadState(true); err != nil {
-		slog.Warn("Failed to get cat state", "error", err)
-		http.Error(w, "Failed to get cat state", http.StatusInternalServerError)
-		return
+	dec := json.NewDecoder(lr)
+	enc := json.NewEncoder(w)
+	for {
+		track := cattrack.CatTrack{}
+		if err := dec.Decode(&track); err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Error("Failed to decode track", "error", err)
+				http.Error(w, "Failed to decode track", http.StatusInternalServerError)
+			}
+			return
+		}
+		track, ok := zones.Track(track)
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(track); err != nil {
+			slog.Error("Failed to write response", "error", err)
+			return
+		}
 	}
-	defer cat.State.Close()
-
-	// TODO
-	// Snaps are in the bucket but no way yet of scanning/reading them.
-	//
-	//snaps := []cattrack.CatTrack{}
-	//err := cat.State.ReadKVUnmarshalJSON(params.CatStateBucket, params.CatStateKey_Snaps, &snaps)
-	//if err != nil {
-	//	slog.Warn("Failed to read snaps", "error", err)
-	//	http.Error(w, "Failed to read snaps", http.StatusInternalServerError)
-	//	return
-	//}
-	//if err := json.NewEncoder(w).Encode(snaps); err != nil {
-	//	slog.Warn("Failed to write response", "error", err)
-	//	http.Error(w, "Failed to write response", http.StatusInternalServerError)
-	//	return
-	//}
 }
 
 // lastKnown2 returns the most recent track for a cat using the S2 index.
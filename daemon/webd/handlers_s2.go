@@ -3,13 +3,19 @@ package webd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	geoS2 "github.com/golang/geo/s2"
 	"github.com/gorilla/mux"
+	"github.com/paulmach/orb"
 	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/s2"
+	"github.com/rotblauer/catd/types/cattrack"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 func (s *WebDaemon) handleS2ParseCatLevel(w http.ResponseWriter, r *http.Request) (*api.Cat, s2.CellLevel, bool) {
@@ -44,6 +50,7 @@ func (s *WebDaemon) handleS2ParseCatLevel(w http.ResponseWriter, r *http.Request
 }
 
 // s2Dump streams the S2 indices for a cat at a given level.
+// The all-cats pseudo-cat streams the all-cats S2 index.
 func (s *WebDaemon) s2Dump(w http.ResponseWriter, r *http.Request) {
 	cat, l, ok := s.handleS2ParseCatLevel(w, r)
 	if !ok {
@@ -51,7 +58,12 @@ func (s *WebDaemon) s2Dump(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Dump the level to the response writer.
-	err := cat.S2DumpLevel(w, s2.CellLevel(l))
+	var err error
+	if cat.CatID == conceptual.AllCatsID {
+		err = api.AllCatsS2DumpLevel(s.Config.DataDir, w, l)
+	} else {
+		err = cat.S2DumpLevel(w, l)
+	}
 	if err != nil {
 		slog.Warn("Failed to write S2 index dump", "error", err)
 		http.Error(w, "Failed to write S2 index dump", http.StatusInternalServerError)
@@ -72,7 +84,13 @@ func (s *WebDaemon) s2Collect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Level too high (limit 8)", http.StatusBadRequest)
 		return
 	}
-	indexedTracks, err := cat.S2CollectLevel(context.Background(), s2.CellLevel(l))
+	var indexedTracks []cattrack.CatTrack
+	var err error
+	if cat.CatID == conceptual.AllCatsID {
+		indexedTracks, err = api.AllCatsS2CollectLevel(context.Background(), s.Config.DataDir, l)
+	} else {
+		indexedTracks, err = cat.S2CollectLevel(context.Background(), l)
+	}
 	if err != nil {
 		slog.Warn("Failed to get S2 index dump", "error", err)
 		http.Error(w, "Failed to get S2 index dump", http.StatusInternalServerError)
@@ -82,3 +100,114 @@ func (s *WebDaemon) s2Collect(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("Failed to write response", "error", err)
 	}
 }
+
+// parseS2Region parses the query's region: one of
+// bbox=minLng,minLat,maxLng,maxLat; polygon=lng,lat,lng,lat,...; or lat, lng and radius (meters).
+func parseS2Region(r *http.Request) (geoS2.Region, error) {
+	vals := r.URL.Query()
+	given := 0
+	for _, k := range []string{"bbox", "polygon", "radius"} {
+		if vals.Get(k) != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return nil, fmt.Errorf("one of bbox, polygon, or lat, lng and radius is required")
+	}
+
+	if v := vals.Get("bbox"); v != "" {
+		b, err := parseQueryBBox(v)
+		if err != nil {
+			return nil, err
+		}
+		return s2.RegionFromBound(*b), nil
+	}
+
+	if v := vals.Get("polygon"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts)%2 != 0 {
+			return nil, fmt.Errorf("polygon must be lng,lat pairs")
+		}
+		ring := orb.Ring{}
+		for i := 0; i < len(parts); i += 2 {
+			lng, err1 := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[i+1]), 64)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("polygon: %w", err)
+			}
+			ring = append(ring, orb.Point{lng, lat})
+		}
+		region, err := s2.RegionFromRing(ring)
+		if err != nil {
+			return nil, fmt.Errorf("polygon: %w", err)
+		}
+		return region, nil
+	}
+
+	var fs [3]float64
+	for i, k := range []string{"lat", "lng", "radius"} {
+		f, err := strconv.ParseFloat(vals.Get(k), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		fs[i] = f
+	}
+	if fs[0] < -90 || fs[0] > 90 || fs[1] < -180 || fs[1] > 180 || fs[2] <= 0 {
+		return nil, fmt.Errorf("radius out of range")
+	}
+	return s2.RegionFromRadius(orb.Point{fs[1], fs[0]}, fs[2]), nil
+}
+
+// s2Query writes a JSON array of the S2 indices for a cat at a given level
+// with cells intersecting the region of the bbox, polygon, or lat, lng and radius query parameters.
+// Unlike s2Collect, any level can be queried, since only the region's cells are read.
+// The limit parameter limits the number of indices, and geometry=cell writes them as their cells' polygons.
+func (s *WebDaemon) s2Query(w http.ResponseWriter, r *http.Request) {
+	cat, l, ok := s.handleS2ParseCatLevel(w, r)
+	if !ok {
+		return
+	}
+	region, err := parseS2Region(r)
+	if err != nil {
+		slog.Warn("Invalid S2 query", "url", r.URL, "error", err)
+		http.Error(w, fmt.Sprintf("Invalid S2 query: %v", err), http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	cells := false
+	switch v := r.URL.Query().Get("geometry"); v {
+	case "", "point":
+	case "cell":
+		cells = true
+	default:
+		http.Error(w, "Invalid geometry, supported geometries: point, cell", http.StatusBadRequest)
+		return
+	}
+
+	var indexedTracks []cattrack.CatTrack
+	if cat.CatID == conceptual.AllCatsID {
+		indexedTracks, err = api.AllCatsS2QueryRegion(r.Context(), s.Config.DataDir, l, region, limit)
+	} else {
+		indexedTracks, err = cat.S2QueryRegion(r.Context(), l, region, limit)
+	}
+	if err != nil {
+		slog.Warn("Failed to query S2 index", "error", err)
+		http.Error(w, "Failed to query S2 index", http.StatusInternalServerError)
+		return
+	}
+	if cells {
+		for i := range indexedTracks {
+			indexedTracks[i].Geometry = s2.GetCellGeometry(indexedTracks[i].Point(), l)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(indexedTracks); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,37 @@
+package webd
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseS2Region(t *testing.T) {
+	cases := []struct {
+		query string
+		ok    bool
+	}{
+		{"bbox=-94,44,-93,45", true},
+		{"polygon=-94,44,-93,44,-93.5,45", true},
+		{"polygon=-94,44,-93,44,-93.5,45,-94,44", true},
+		{"lat=44.98&lng=-93.25&radius=500", true},
+		{"", false},
+		{"bbox=-94,44,-93,45&radius=500&lat=44.98&lng=-93.25", false},
+		{"bbox=-94,44,-93", false},
+		{"polygon=-94,44,-93,44", false},
+		{"polygon=-94,44,-93,44,-93.5", false},
+		{"polygon=-94,44,-93,44,-93.5,north", false},
+		{"lat=44.98&radius=500", false},
+		{"lat=144.98&lng=-93.25&radius=500", false},
+		{"lat=44.98&lng=-93.25&radius=-1", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://catsonmaps.org/rye/s2/16/query?"+c.query, nil)
+		region, err := parseS2Region(req)
+		if c.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", c.query, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected error, got %v", c.query, region)
+		}
+	}
+}
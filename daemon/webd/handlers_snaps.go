@@ -0,0 +1,124 @@
+package webd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/params"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// parseSnapsQuery parses the tracks query parameters, and the offset to page with the limit.
+func parseSnapsQuery(r *http.Request) (*api.SnapsQuery, error) {
+	tq, err := parseTracksQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	q := &api.SnapsQuery{TracksQuery: *tq}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil || q.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset: %q", v)
+		}
+	}
+	return q, nil
+}
+
+// getCatSnaps writes a page of a cat's snaps matching the
+// start, end, bbox, activity, limit, and offset query parameters, most recent first.
+func (s *WebDaemon) getCatSnaps(w http.ResponseWriter, r *http.Request) {
+	cat, ok := s.handleGetCatForRequest(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseSnapsQuery(r)
+	if err != nil {
+		slog.Warn("Invalid snaps query", "url", r.URL, "error", err)
+		http.Error(w, fmt.Sprintf("Invalid snaps query: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := cat.LockOrLoadState(true); err != nil {
+		slog.Warn("Failed to get cat state", "error", err)
+		http.Error(w, "Failed to get cat state", http.StatusInternalServerError)
+		return
+	}
+	defer cat.State.Close()
+
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+	page, err := cat.QuerySnaps(q, zones)
+	if err != nil {
+		slog.Error("Failed to query snaps", "cat", cat.CatID, "error", err)
+		http.Error(w, "Failed to query snaps", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+// catSnapImage writes a cat's snap image, eg. /rye/snaps/rye_76170e959f967f40_1731952467.jpg,
+// or its thumbnail with ?size=small, medium or large.
+// Snaps omitted by the cat's privacy zones are not found.
+func (s *WebDaemon) catSnapImage(w http.ResponseWriter, r *http.Request) {
+	cat, ok := s.handleGetCatForRequest(w, r)
+	if !ok {
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if _, ok := params.SnapThumbnailSizes[size]; size != "" && !ok {
+		http.Error(w, "Invalid size, supported sizes: small, medium, large", http.StatusBadRequest)
+		return
+	}
+	if err := cat.LockOrLoadState(true); err != nil {
+		slog.Warn("Failed to get cat state", "error", err)
+		http.Error(w, "Failed to get cat state", http.StatusInternalServerError)
+		return
+	}
+	defer cat.State.Close()
+
+	snap, err := cat.State.ReadSnapKV(mux.Vars(r)["key"])
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "No such snap", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to read snap", "cat", cat.CatID, "error", err)
+		http.Error(w, "Failed to read snap", http.StatusInternalServerError)
+		return
+	}
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+	if _, ok := zones.Track(snap); !ok {
+		http.Error(w, "No such snap", http.StatusNotFound)
+		return
+	}
+
+	path := cat.State.SnapPathImage(snap)
+	if size != "" {
+		path, err = cat.SnapThumbnail(snap, size)
+	}
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "No snap image", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get snap image", "cat", cat.CatID, "size", size, "error", err)
+		http.Error(w, "Failed to get snap image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, path)
+}
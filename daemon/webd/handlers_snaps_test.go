@@ -0,0 +1,105 @@
+package webd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/testing/testdata"
+	"github.com/rotblauer/catd/types/cattrack"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWebDaemon_catSnaps(t *testing.T) {
+	s, teardown := newTestWebDaemon("")
+	defer teardown()
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 400, 200)), nil); err != nil {
+		t.Fatal(err)
+	}
+	fc := geojson.NewFeatureCollection()
+	for i, pt := range []orb.Point{{-93.25, 44.98}, {-114.09, 46.93}, {-93.26, 44.97}} {
+		ct := &cattrack.CatTrack{}
+		if err := json.Unmarshal([]byte(testdata.Track_iOS_stationary_1), ct); err != nil {
+			t.Fatal(err)
+		}
+		ct.Geometry = pt
+		ct.SetPropertySafe("Name", "rye")
+		ct.SetPropertySafe("UUID", "76170e959f967f40")
+		ct.SetPropertySafe("Time", time.Unix(int64(1731952467+i*100), 0).UTC().Format(time.RFC3339))
+		ct.SetPropertySafe("UnixTime", 1731952467+i*100)
+		ct.SetPropertySafe("imgB64", base64.StdEncoding.EncodeToString(buf.Bytes()))
+		fc.Append((*geojson.Feature)(ct))
+	}
+	body, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.populate(w, httptest.NewRequest(http.MethodPost, "http://catsonmaps.org/populate", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("populate: got %d %s", w.Code, w.Body.String())
+	}
+
+	router := mux.NewRouter()
+	router.Path("/{cat}/snaps.json").HandlerFunc(s.getCatSnaps)
+	router.Path("/{cat}/snaps/{key}.jpg").HandlerFunc(s.catSnapImage)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	w = get("/rye/snaps.json?bbox=-94,44,-93,45&limit=1&offset=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	page := api.SnapsPage{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Snaps) != 1 || page.Snaps[0].MustTime().Unix() != 1731952467 {
+		t.Fatalf("got %+v", page)
+	}
+	for _, url := range []string{"/rye/snaps.json?offset=-1", "/rye/snaps.json?bbox=1,2"} {
+		if w := get(url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", url, w.Code)
+		}
+	}
+
+	key := page.Snaps[0].MustS3Key()
+	for size, width := range map[string]int{"": 400, "small": 128, "medium": 400} {
+		w := get("/rye/snaps/" + key + ".jpg?size=" + size)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("%q: got %d %v", size, w.Code, w.Header())
+		}
+		config, err := jpeg.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != width {
+			t.Errorf("%q: got width %d, want %d", size, config.Width, width)
+		}
+	}
+	// Thumbnails are cached with the snaps populate stored, in the cat's dir.
+	thumbs, err := filepath.Glob(filepath.Join(params.DefaultCatDataDirRooted(s.Config.DataDir, "rye"), params.CatSnapsSubdir, "*", "*", key+".small.jpg"))
+	if err != nil || len(thumbs) != 1 {
+		t.Errorf("got thumbnails %v: %v", thumbs, err)
+	}
+	if w := get("/rye/snaps/" + key + ".jpg?size=huge"); w.Code != http.StatusBadRequest {
+		t.Errorf("huge: got %d", w.Code)
+	}
+	if w := get("/rye/snaps/rye_nope_0000000000.jpg"); w.Code != http.StatusNotFound {
+		t.Errorf("missing: got %d", w.Code)
+	}
+}
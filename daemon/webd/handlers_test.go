@@ -78,9 +78,10 @@ func TestWebDaemon_catIndex_NoCatThat(t *testing.T) {
 	}
 }
 
-func prefillPopulate(t *testing.T, catName, source string) *api.TestCat {
+// prefillPopulate populates a cat in the daemon's datadir directly, with api.Populate.
+func prefillPopulate(t *testing.T, d *WebDaemon, catName, source string) *api.TestCat {
 	defer common.SlogResetLevel(slog.Level(slog.LevelWarn + 1))()
-	tc := api.NewTestCatWriter(t, catName, nil)
+	tc := newTestDaemonCat(t, d, catName)
 	c := tc.Cat()
 	//defer tc.CloseAndDestroy()
 	ctx := context.Background()
@@ -120,9 +121,9 @@ func prefillPopulate(t *testing.T, catName, source string) *api.TestCat {
 // through api.Populate (direct). The index is then queried.
 // WebDaemon can run on data it's never seen before.
 func TestWebDaemon_catIndex_Populated(t *testing.T) {
-	tc := prefillPopulate(t, "rye", testdata.Path(testdata.Source_EDGE20241217))
-	defer tc.CloseAndDestroy()
-	d, _ := newTestWebDaemon(tc.DataDir)
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	prefillPopulate(t, d, "rye", testdata.Path(testdata.Source_EDGE20241217))
 	req := httptest.NewRequest("GET", "http://catsonmaps.org/xxx/last.json", nil)
 	w := httptest.NewRecorder()
 	req = mux.SetURLVars(req, map[string]string{"cat": "rye"})
@@ -151,9 +152,9 @@ func TestWebDaemon_catIndex_Populated(t *testing.T) {
 }
 
 func TestWebDaemon_catPushedJSON(t *testing.T) {
-	tc := prefillPopulate(t, "rye", testdata.Path(testdata.Source_EDGE1000))
-	defer tc.CloseAndDestroy()
-	d, _ := newTestWebDaemon(tc.DataDir)
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	prefillPopulate(t, d, "rye", testdata.Path(testdata.Source_EDGE1000))
 	req := httptest.NewRequest("GET", "http://catsonmaps.org/xxx/pushed.json", nil)
 	w := httptest.NewRecorder()
 	req = mux.SetURLVars(req, map[string]string{"cat": "rye"})
@@ -0,0 +1,220 @@
+package webd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/common"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/daemon/tiled"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// handleGetTileset acquires the tileset of a tiles request, eg. /tiles/rye/laps.
+// The source defaults to the layer name, as for laps, naps and snaps.
+// Edge tilesets are served with ?version=edge.
+func (s *WebDaemon) handleGetTileset(w http.ResponseWriter, r *http.Request) (*tiled.MBTiles, func(), bool) {
+	cat, ok := s.handleGetCatForRequest(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if s.Config.CatBackendConfig == nil || s.Config.CatBackendConfig.TileD == nil {
+		http.Error(w, "No tiles", http.StatusNotFound)
+		return nil, nil, false
+	}
+	vars := mux.Vars(r)
+	schema := tiled.SourceSchema{
+		CatID:      cat.CatID,
+		SourceName: vars["source"],
+		LayerName:  vars["layer"],
+	}
+	if schema.SourceName == "" {
+		schema.SourceName = schema.LayerName
+	}
+	for _, name := range []string{schema.CatID.String(), schema.SourceName, schema.LayerName} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			http.Error(w, "Invalid tileset", http.StatusBadRequest)
+			return nil, nil, false
+		}
+	}
+	version := tiled.SourceVersionCanonical
+	if v := r.URL.Query().Get("version"); v != "" {
+		version = tiled.TileSourceVersion(v)
+		if version != tiled.SourceVersionCanonical && version != tiled.SourceVersionEdge {
+			http.Error(w, "Invalid version, supported versions: canonical, edge", http.StatusBadRequest)
+			return nil, nil, false
+		}
+	}
+
+	path, err := tiled.TargetPathIn(s.Config.CatBackendConfig.TileD.RootDir, schema, version)
+	if err != nil {
+		slog.Error("Failed to get tileset path", "error", err)
+		http.Error(w, "Failed to get tileset", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	m, release, err := s.tilesets.acquire(path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "No such tileset", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.Error("Failed to open tileset", "path", path, "error", err)
+		http.Error(w, "Failed to open tileset", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return m, release, true
+}
+
+// catTile writes a vector tile of a cat's tileset, eg. /tiles/rye/laps/12/988/1466.pbf.
+// Missing tiles are 204 No Content, so map clients don't log them as errors.
+func (s *WebDaemon) catTile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var zxy [3]int
+	for i, k := range []string{"z", "x", "y"} {
+		v, err := strconv.Atoi(vars[k])
+		if err != nil {
+			http.Error(w, "Invalid tile", http.StatusBadRequest)
+			return
+		}
+		zxy[i] = v
+	}
+	m, release, ok := s.handleGetTileset(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, ok, err := m.Tile(zxy[0], zxy[1], zxy[2])
+	if err != nil {
+		slog.Error("Failed to read tile", "tile", zxy, "error", err)
+		http.Error(w, "Failed to read tile", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+// tileJSON is the TileJSON 3.0.0 description of a tileset,
+// see https://github.com/mapbox/tilejson-spec/tree/master/3.0.0.
+type tileJSON struct {
+	TileJSON     string          `json:"tilejson"`
+	Name         string          `json:"name,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Version      string          `json:"version,omitempty"`
+	Scheme       string          `json:"scheme"`
+	Tiles        []string        `json:"tiles"`
+	MinZoom      int             `json:"minzoom"`
+	MaxZoom      int             `json:"maxzoom"`
+	Bounds       []float64       `json:"bounds,omitempty"`
+	Center       []float64       `json:"center,omitempty"`
+	VectorLayers json.RawMessage `json:"vector_layers,omitempty"`
+}
+
+// parseMetadataFloats parses a comma-separated metadata value, eg. bounds, or returns nil.
+func parseMetadataFloats(v string, n int) []float64 {
+	parts := strings.Split(v, ",")
+	if len(parts) != n {
+		return nil
+	}
+	out := make([]float64, n)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil
+		}
+		out[i] = f
+	}
+	return out
+}
+
+// catTileJSON writes the TileJSON of a cat's tileset, eg. /tiles/rye/laps.json.
+// Its tiles URL keeps the request's query, eg. its token and version.
+func (s *WebDaemon) catTileJSON(w http.ResponseWriter, r *http.Request) {
+	m, release, ok := s.handleGetTileset(w, r)
+	if !ok {
+		return
+	}
+	meta := m.Metadata()
+	release()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	tiles := fmt.Sprintf("%s://%s%s/{z}/{x}/{y}.pbf", scheme, r.Host, strings.TrimSuffix(r.URL.Path, ".json"))
+	if r.URL.RawQuery != "" {
+		tiles += "?" + r.URL.RawQuery
+	}
+	tj := tileJSON{
+		TileJSON:    "3.0.0",
+		Name:        meta["name"],
+		Description: meta["description"],
+		Version:     meta["version"],
+		Scheme:      "xyz",
+		Tiles:       []string{tiles},
+		MaxZoom:     30,
+		Bounds:      parseMetadataFloats(meta["bounds"], 4),
+		Center:      parseMetadataFloats(meta["center"], 3),
+	}
+	if z, err := strconv.Atoi(meta["minzoom"]); err == nil {
+		tj.MinZoom = z
+	}
+	if z, err := strconv.Atoi(meta["maxzoom"]); err == nil {
+		tj.MaxZoom = z
+	}
+	layers := struct {
+		VectorLayers json.RawMessage `json:"vector_layers"`
+	}{}
+	if err := json.Unmarshal([]byte(meta["json"]), &layers); err == nil {
+		tj.VectorLayers = layers.VectorLayers
+	}
+	if err := json.NewEncoder(w).Encode(tj); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+// tiledStatus proxies the tile daemon's status: its pending, running and recent tiling runs.
+// It can be limited to a cat, eg. /tiled/status?cat=rye.
+func (s *WebDaemon) tiledStatus(w http.ResponseWriter, r *http.Request) {
+	if s.Config.CatBackendConfig == nil || s.Config.CatBackendConfig.TileD == nil {
+		http.Error(w, "No tile daemon", http.StatusNotFound)
+		return
+	}
+	config := s.Config.CatBackendConfig.TileD.ListenerConfig
+	client, err := common.DialRPC(config.Network, config.Address)
+	if err != nil {
+		slog.Warn("Failed to dial tile daemon", "error", err)
+		http.Error(w, "Failed to dial tile daemon", http.StatusBadGateway)
+		return
+	}
+	defer client.Close()
+
+	args := &tiled.StatusRequestArgs{CatID: conceptual.CatID(r.URL.Query().Get("cat"))}
+	reply := &tiled.StatusResponse{}
+	if err := client.Call("TileD.Status", args, reply); err != nil {
+		slog.Warn("Failed to get tile daemon status", "error", err)
+		http.Error(w, "Failed to get tile daemon status", http.StatusBadGateway)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
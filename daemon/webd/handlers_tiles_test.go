@@ -0,0 +1,190 @@
+package webd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/daemon/tiled"
+	"github.com/rotblauer/catd/params"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWriteMBTiles writes an .mbtiles in tippecanoe's deduplicating layout, with a tile at 0/0/0,
+// and moves it into place like the tile daemon.
+func testWriteMBTiles(t *testing.T, path string, tile string) {
+	t.Helper()
+	tmp := filepath.Join(t.TempDir(), "tmp.mbtiles")
+	db, err := sql.Open("sqlite", tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE UNIQUE INDEX name on metadata (name)",
+		"CREATE TABLE tiles_shallow (zoom_level integer, tile_column integer, tile_row integer, tile_data_hash integer, " +
+			"primary key(zoom_level, tile_column, tile_row)) without rowid",
+		"CREATE TABLE tiles_data (tile_data_hash integer, tile_data blob, primary key(tile_data_hash)) without rowid",
+		"CREATE VIEW tiles AS SELECT tiles_shallow.zoom_level AS zoom_level, tiles_shallow.tile_column AS tile_column, " +
+			"tiles_shallow.tile_row AS tile_row, tiles_data.tile_data AS tile_data " +
+			"FROM tiles_shallow JOIN tiles_data ON tiles_shallow.tile_data_hash = tiles_data.tile_data_hash",
+		`INSERT INTO metadata VALUES ('name', 'laps'), ('format', 'pbf'), ('minzoom', '0'), ('maxzoom', '3'),
+			('bounds', '-94,44,-93,45'), ('json', '{"vector_layers":[{"id":"laps","fields":{}}]}')`,
+		"INSERT INTO tiles_shallow VALUES (0, 0, 0, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(stmt, err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO tiles_data VALUES (1, ?)", []byte(tile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebDaemon_catTile(t *testing.T) {
+	old := tilesetCheckInterval
+	tilesetCheckInterval = 0
+	defer func() { tilesetCheckInterval = old }()
+
+	s, teardown := newTestWebDaemon("")
+	defer teardown()
+	s.Config.CatBackendConfig = &params.CatRPCServices{TileD: params.DefaultTileDaemonConfig()}
+	s.Config.CatBackendConfig.TileD.RootDir = t.TempDir()
+	router := mux.NewRouter()
+	router.Path("/tiles/{cat}/{layer}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf").HandlerFunc(s.catTile)
+	router.Path("/tiles/{cat}/{source}/{layer}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.pbf").HandlerFunc(s.catTile)
+	router.Path("/tiles/{cat}/{layer:[^/.]+}.json").HandlerFunc(s.catTileJSON)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	if w := get("/tiles/rye/laps/0/0/0.pbf"); w.Code != http.StatusNotFound {
+		t.Errorf("before tiling: got %d", w.Code)
+	}
+	if len(s.tilesets.all) != 0 {
+		t.Errorf("before tiling: got %d tilesets, want none", len(s.tilesets.all))
+	}
+
+	path := filepath.Join(s.Config.CatBackendConfig.TileD.RootDir, "tiles", "rye", "laps", "laps.mbtiles")
+	testWriteMBTiles(t, path, "first")
+	for _, url := range []string{"/tiles/rye/laps/0/0/0.pbf", "/tiles/rye/laps/laps/0/0/0.pbf"} {
+		w := get(url)
+		if w.Code != http.StatusOK || w.Body.String() != "first" {
+			t.Errorf("%s: got %d %q", url, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Errorf("%s: got content type %q", url, ct)
+		}
+	}
+	if w := get("/tiles/rye/laps/1/0/0.pbf"); w.Code != http.StatusNoContent {
+		t.Errorf("missing tile: got %d", w.Code)
+	}
+	if w := get("/tiles/rye/laps/0/0/0.pbf?version=edge"); w.Code != http.StatusNotFound {
+		t.Errorf("edge: got %d", w.Code)
+	}
+	if len(s.tilesets.all) != 1 {
+		t.Errorf("got %d tilesets, want only canonical laps", len(s.tilesets.all))
+	}
+	if w := get("/tiles/rye/laps/0/0/0.pbf?version=backup"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad version: got %d", w.Code)
+	}
+	if w := get("/tiles/rye/../0/0/0.pbf"); w.Code == http.StatusOK {
+		t.Errorf("traversal: got %d", w.Code)
+	}
+
+	// Another tiling run replaces the tileset.
+	testWriteMBTiles(t, path, "second")
+	if w := get("/tiles/rye/laps/0/0/0.pbf"); w.Body.String() != "second" {
+		t.Errorf("after tiling: got %d %q", w.Code, w.Body.String())
+	}
+
+	w := get("/tiles/rye/laps.json?token=abc")
+	if w.Code != http.StatusOK {
+		t.Fatalf("tilejson: got %d %s", w.Code, w.Body.String())
+	}
+	tj := tileJSON{}
+	if err := json.NewDecoder(w.Body).Decode(&tj); err != nil {
+		t.Fatal(err)
+	}
+	if tj.Name != "laps" || tj.MaxZoom != 3 || len(tj.Bounds) != 4 || tj.Bounds[0] != -94 || len(tj.VectorLayers) == 0 {
+		t.Errorf("got tilejson %+v", tj)
+	}
+	if len(tj.Tiles) != 1 || tj.Tiles[0] != "http://example.com/tiles/rye/laps/{z}/{x}/{y}.pbf?token=abc" {
+		t.Errorf("got tiles %v", tj.Tiles)
+	}
+
+	// A removed tileset is forgotten.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/tiles/rye/laps/0/0/0.pbf"); w.Code != http.StatusNotFound {
+		t.Errorf("after removal: got %d", w.Code)
+	}
+	if len(s.tilesets.all) != 0 {
+		t.Errorf("after removal: got %d tilesets, want none", len(s.tilesets.all))
+	}
+}
+
+func TestWebDaemon_tiledStatus(t *testing.T) {
+	s, teardown := newTestWebDaemon("")
+	defer teardown()
+	s.Config.CatBackendConfig = &params.CatRPCServices{TileD: params.DefaultTileDaemonConfig()}
+
+	w := httptest.NewRecorder()
+	s.Config.CatBackendConfig.TileD.ListenerConfig = params.ListenerConfig{Network: "tcp", Address: "127.0.0.1:1"}
+	s.tiledStatus(w, httptest.NewRequest("GET", "/tiled/status", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("no tile daemon: got %d", w.Code)
+	}
+
+	// Serve a tile daemon's RPC, as tiled does.
+	config := params.DefaultTileDaemonConfig()
+	config.RootDir = t.TempDir()
+	config.TilingTmpDir = t.TempDir()
+	d, err := tiled.NewDaemon(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.Register(&tiled.TileD{TileDaemon: d}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, server)
+	s.Config.CatBackendConfig.TileD.ListenerConfig = params.ListenerConfig{Network: "tcp", Address: l.Addr().String()}
+
+	w = httptest.NewRecorder()
+	s.tiledStatus(w, httptest.NewRequest("GET", "/tiled/status?cat=rye", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	status := tiled.StatusResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 0 || len(status.Running) != 0 || len(status.History) != 0 {
+		t.Errorf("got status %+v", status)
+	}
+}
@@ -0,0 +1,175 @@
+package webd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/export"
+	"github.com/rotblauer/catd/types/activity"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseQueryTime parses a time query parameter as either RFC3339 or unix seconds.
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseQueryBBox parses a bbox query parameter formatted as minLng,minLat,maxLng,maxLat.
+func parseQueryBBox(v string) (*orb.Bound, error) {
+	if v == "" {
+		return nil, nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be minLng,minLat,maxLng,maxLat")
+	}
+	fs := make([]float64, 4)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox: %w", err)
+		}
+		fs[i] = f
+	}
+	if fs[0] > fs[2] || fs[1] > fs[3] {
+		return nil, fmt.Errorf("bbox min exceeds max")
+	}
+	return &orb.Bound{Min: orb.Point{fs[0], fs[1]}, Max: orb.Point{fs[2], fs[3]}}, nil
+}
+
+// parseTracksQuery parses the start, end, bbox, limit, and activity query parameters.
+func parseTracksQuery(r *http.Request) (*api.TracksQuery, error) {
+	q := &api.TracksQuery{}
+	vals := r.URL.Query()
+	var err error
+	if q.Start, err = parseQueryTime(vals.Get("start")); err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	if q.End, err = parseQueryTime(vals.Get("end")); err != nil {
+		return nil, fmt.Errorf("end: %w", err)
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && q.End.Before(q.Start) {
+		return nil, fmt.Errorf("end before start")
+	}
+	if q.Bound, err = parseQueryBBox(vals.Get("bbox")); err != nil {
+		return nil, err
+	}
+	if v := vals.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 0 {
+			return nil, fmt.Errorf("invalid limit: %q", v)
+		}
+	}
+	if v := vals.Get("activity"); v != "" {
+		for _, a := range strings.Split(v, ",") {
+			act := activity.FromString(strings.TrimSpace(a))
+			if act.IsUnknown() && !strings.EqualFold(strings.TrimSpace(a), "unknown") {
+				return nil, fmt.Errorf("invalid activity: %q", a)
+			}
+			q.Activities = append(q.Activities, act)
+		}
+	}
+	return q, nil
+}
+
+// catTracksNDJSON streams a cat's archived tracks matching the
+// start, end, bbox, limit, and activity query parameters.
+func (s *WebDaemon) catTracksNDJSON(w http.ResponseWriter, r *http.Request) {
+	cat, ok := s.handleGetCatForRequest(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseTracksQuery(r)
+	if err != nil {
+		slog.Warn("Invalid tracks query", "url", r.URL, "error", err)
+		http.Error(w, fmt.Sprintf("Invalid tracks query: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := cat.LockOrLoadState(true); err != nil {
+		slog.Warn("Failed to get cat state (no cat that?)", "cat", cat.CatID, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to get cat state '%s' (no cat that?)", cat.CatID.String()), http.StatusNoContent)
+		return
+	}
+	defer cat.State.Close()
+
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+
+	tracks, errs := cat.QueryTracks(r.Context(), q)
+	enc := json.NewEncoder(w)
+	written := 0
+	for track := range tracks {
+		track, ok := zones.Track(track)
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(track); err != nil {
+			slog.Warn("Failed to write response", "error", err)
+			// Drain.
+			for range tracks {
+			}
+			break
+		}
+		written++
+	}
+	if err := <-errs; err != nil {
+		slog.Error("Failed to query tracks", "cat", cat.CatID, "written", written, "error", err)
+		if written == 0 {
+			http.Error(w, "Failed to query tracks", http.StatusInternalServerError)
+		}
+		// Else the headers are already written; the stream is truncated.
+	}
+}
+
+// catTracksExport writes a cat's archived tracks, laps and naps matching the
+// start, end, bbox, limit, and activity query parameters as GPX, KML or CSV, per the route's format.
+func (s *WebDaemon) catTracksExport(w http.ResponseWriter, r *http.Request) {
+	format, err := export.ParseFormat(mux.Vars(r)["format"])
+	if err != nil {
+		slog.Warn("Invalid export format", "url", r.URL, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cat, ok := s.handleGetCatForRequest(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseTracksQuery(r)
+	if err != nil {
+		slog.Warn("Invalid tracks query", "url", r.URL, "error", err)
+		http.Error(w, fmt.Sprintf("Invalid tracks query: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := cat.LockOrLoadState(true); err != nil {
+		slog.Warn("Failed to get cat state (no cat that?)", "cat", cat.CatID, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to get cat state '%s' (no cat that?)", cat.CatID.String()), http.StatusNoContent)
+		return
+	}
+	defer cat.State.Close()
+
+	zones, ok := handleGetCatPrivacyZones(w, cat)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", cat.CatID.String()+"."+string(format)))
+	if err := cat.Export(r.Context(), w, format, q, zones); err != nil {
+		// Headers are likely already written; the body is truncated.
+		slog.Error("Failed to export tracks", "cat", cat.CatID, "format", format, "error", err)
+	}
+}
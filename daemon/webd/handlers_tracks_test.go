@@ -0,0 +1,100 @@
+package webd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/types/cattrack"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTracksQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		ok    bool
+	}{
+		{"", true},
+		{"start=2024-12-01T00:00:00Z&end=2024-12-02T00:00:00Z", true},
+		{"start=1733011200&limit=10", true},
+		{"bbox=-94,44,-93,45&activity=walking,bike", true},
+		{"start=yesterday", false},
+		{"start=2024-12-02T00:00:00Z&end=2024-12-01T00:00:00Z", false},
+		{"bbox=-94,44,-93", false},
+		{"bbox=-93,44,-94,45", false},
+		{"limit=-1", false},
+		{"activity=teleporting", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "http://catsonmaps.org/rye/tracks.ndjson?"+c.query, nil)
+		q, err := parseTracksQuery(req)
+		if c.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", c.query, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected error, got %+v", c.query, q)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://catsonmaps.org/rye/tracks.ndjson?start=1733011200&bbox=-94,44,-93,45&limit=3&activity=Walking", nil)
+	q, err := parseTracksQuery(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.Start.Equal(time.Unix(1733011200, 0)) {
+		t.Errorf("start: %v", q.Start)
+	}
+	if q.Bound == nil || q.Bound.Min.Lon() != -94 || q.Bound.Max.Lat() != 45 {
+		t.Errorf("bbox: %v", q.Bound)
+	}
+	if q.Limit != 3 || len(q.Activities) != 1 {
+		t.Errorf("limit/activity: %+v", q)
+	}
+}
+
+// TestWebDaemon_catTracksNDJSON_populated populates cats through /populate
+// and reads their tracks back from the daemon's datadir.
+func TestWebDaemon_catTracksNDJSON_populated(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+
+	req := httptest.NewRequest(http.MethodPost, "http://catsonmaps.org/populate", bytes.NewReader(multiCatBody(t, []string{"rye", "ia"}, 10)))
+	w := httptest.NewRecorder()
+	d.populate(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("populate: got %d %s", w.Code, w.Body.String())
+	}
+
+	router := mux.NewRouter()
+	router.Path("/{cat}/tracks.ndjson").HandlerFunc(d.catTracksNDJSON)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kitty/tracks.ndjson", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("kitty: got %d", w.Code)
+	}
+	for _, cat := range []string{"rye", "ia"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+cat+"/tracks.ndjson?limit=100", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", cat, w.Code, w.Body.String())
+		}
+		got := 0
+		sc := bufio.NewScanner(w.Body)
+		for sc.Scan() {
+			ct := cattrack.CatTrack{}
+			if err := json.Unmarshal(sc.Bytes(), &ct); err != nil {
+				t.Fatal(err)
+			}
+			if ct.CatID().String() != cat {
+				t.Errorf("%s: got track of %s", cat, ct.CatID())
+			}
+			got++
+		}
+		if got != 10 {
+			t.Errorf("%s: got %d tracks, want 10", cat, got)
+		}
+	}
+}
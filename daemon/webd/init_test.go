@@ -1,8 +1,11 @@
 package webd
 
 import (
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/params"
 	"os"
+	"testing"
 )
 
 /// newTestWebDaemon creates a new WebDaemon for testing purposes.
@@ -27,3 +30,16 @@ func newTestWebDaemon(datadir string) (daemon *WebDaemon, teardown func() error)
 	}
 	return daemon, teardown
 }
+
+// newTestDaemonCat opens a writable cat in the daemon's datadir, where webd populates and reads it.
+func newTestDaemonCat(t *testing.T, d *WebDaemon, name string) *api.TestCat {
+	t.Helper()
+	c, err := api.NewCat(conceptual.CatID(name), params.DefaultCatDataDirRooted(d.Config.DataDir, name), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LockOrLoadState(false); err != nil {
+		t.Fatal(err)
+	}
+	return (*api.TestCat)(c)
+}
@@ -1,8 +1,12 @@
 package webd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	ghandlers "github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/conceptual"
 	"io"
 	"log"
 	"net"
@@ -32,42 +36,116 @@ func (mw MiddlewareFunc) Middleware(handler http.Handler) http.Handler {
 */
 // PS No testing required :$ https://stackoverflow.com/questions/37143935/how-should-i-unit-test-middleware-packages-with-gorilla-context
 
-// tokenAuthenticationMiddleware is a middleware that checks for a valid token in the Authorization header.
+type tokenContextKey struct{}
+
+// errCatForbidden is the error for a cat not in the request token's scope.
+var errCatForbidden = errors.New("forbidden cat")
+
+// errCatReserved is the error for a cat named for a pseudo-cat, eg. conceptual.AllCatsID,
+// which no token may populate.
+var errCatReserved = fmt.Errorf("%w: reserved cat name", errCatForbidden)
+
+// tokenFromContext returns the token authenticated for the request, if any.
+// It is nil if authentication is not enabled.
+func tokenFromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(tokenContextKey{}).(*Token)
+	return t
+}
+
+// requestTokenSecret returns the token from the AuthorizationOfCats header,
+// or, in the alternate protocol, from a query param named api_token.
+// eg. catonmap.info:3001/populate/?api_token=asdfasdfb
+func requestTokenSecret(r *http.Request) string {
+	token := r.Header.Get("AuthorizationOfCats")
+	if token == "" {
+		r.ParseForm()
+		token = r.FormValue("api_token")
+	}
+	return token
+}
+
+// tokenLogID identifies a request token in logs without its secret:
+// by name, if the token is known, else by a short hash of the secret.
+func tokenLogID(token *Token, secret string) string {
+	if token != nil {
+		return token.Name
+	}
+	if secret == "" {
+		return ""
+	}
+	return "sha256:" + hashTokenSecret(secret)[:8]
+}
+
+// authEnabled returns true if the global COTOKEN is set, or some token allows the action.
+// Each action is enforced once it is granted, so that adding, eg., a read token
+// does not lock out the tokenless clients populating.
+func (s *WebDaemon) authEnabled(action TokenAction) bool {
+	if os.Getenv("COTOKEN") != "" {
+		return true
+	}
+	ok, err := s.tokens.Grants(action)
+	if err != nil {
+		// Fail closed.
+		s.logger.Error("Failed to read token store", "error", err)
+		return true
+	}
+	return ok
+}
+
+// tokenAuthenticationMiddlewareFunc returns a middleware that checks for a token allowing the action.
+// If the route has a {cat} var, the token must also be scoped to that cat.
 // If the token is not valid, it returns a 403 Forbidden.
-// If the token is valid, it calls the next middleware (or final handler).
-// If no token is set, it allows all requests.
-func tokenAuthenticationMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		validToken := os.Getenv("COTOKEN")
-		if validToken == "" {
-			log.Printf("WARN: No COTOKEN set, allowing all requests")
-			next.ServeHTTP(w, r)
-			return
-		}
+// If the token is valid, it calls the next middleware (or final handler), with the token in the request context.
+// The global COTOKEN allows everything.
+// If no COTOKEN is set and no token allows the action, it allows all requests,
+// as it does for read requests unless read tokens are required by config.
+func (s *WebDaemon) tokenAuthenticationMiddlewareFunc(action TokenAction) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if action == TokenActionRead && !s.Config.RequireReadToken {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !s.authEnabled(action) {
+				log.Printf("WARN: No COTOKEN or %s tokens set, allowing all %s requests", action, action)
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		token := r.Header.Get("AuthorizationOfCats")
-		if token == "" {
-			// Header token not set. Check alternate protocol, which is using a query param with the name api_token.
-			// eg. catonmap.info:3001/populate/?api_token=asdfasdfb
-			r.ParseForm()
-			token = r.FormValue("api_token")
-		}
+			secret := requestTokenSecret(r)
+			var token *Token
+			if validToken := os.Getenv("COTOKEN"); validToken != "" && secret == validToken {
+				token = legacyToken
+			} else if secret != "" {
+				t, err := s.tokens.Lookup(secret)
+				if err != nil && !errors.Is(err, ErrTokenNotFound) {
+					s.logger.Error("Failed to look up token", "error", err)
+				}
+				token = t
+			}
 
-		// Enforce token validation.
-		if token != validToken {
-			log.Println("Invalid token",
-				"token:", fmt.Sprintf("%q", token), "validToken:", "***REDACTED***",
-				"method:", r.Method, "url:", r.URL, "proto:", r.Proto,
-				"host:", r.Host, "remote-addr:", r.RemoteAddr,
-				"request-URI:", r.RequestURI, "content-length:", r.ContentLength,
-				"user-agent:", r.UserAgent())
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+			// Enforce token validation.
+			allowed := token != nil && token.AllowsAction(action)
+			if catID, ok := mux.Vars(r)["cat"]; ok && allowed {
+				allowed = token.AllowsCat(conceptual.CatID(catID))
+			}
+			if !allowed {
+				// The URL is logged by path only, since the api_token query param is the secret.
+				log.Println("Invalid token",
+					"token:", fmt.Sprintf("%q", tokenLogID(token, secret)),
+					"action:", action,
+					"method:", r.Method, "path:", r.URL.Path, "proto:", r.Proto,
+					"host:", r.Host, "remote-addr:", r.RemoteAddr,
+					"content-length:", r.ContentLength,
+					"user-agent:", r.UserAgent())
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
 
-		// Pass down the request to the next middleware (or final handler)
-		next.ServeHTTP(w, r)
-	})
+			// Pass down the request to the next middleware (or final handler)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+		})
+	}
 }
 
 func permissiveCorsMiddleware(next http.Handler) http.Handler {
@@ -0,0 +1,58 @@
+package webd
+
+import (
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/types/cattrack"
+)
+
+// rememberPrivacyZones caches the cat's privacy zones, as read by its populate,
+// for later broadcasts of the cat's tracks.
+func (s *WebDaemon) rememberPrivacyZones(cat *api.Cat) {
+	zones, err := cat.PrivacyZones()
+	if err != nil {
+		// State closed and zones not read; the next broadcast will read them.
+		s.privacyZones.Delete(cat.CatID)
+		return
+	}
+	s.privacyZones.Store(cat.CatID, zones)
+}
+
+// catPrivacyZones returns the cat's privacy zones, from the cache or the cat's state.
+func (s *WebDaemon) catPrivacyZones(catID conceptual.CatID) (api.PrivacyZones, error) {
+	if v, ok := s.privacyZones.Load(catID); ok {
+		return v.(api.PrivacyZones), nil
+	}
+	cat, err := api.NewCat(catID, params.DefaultCatDataDirRooted(s.Config.DataDir, catID.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cat.LockOrLoadState(true); err != nil {
+		return nil, err
+	}
+	defer cat.State.Close()
+	zones, err := cat.PrivacyZones()
+	if err != nil {
+		return nil, err
+	}
+	s.privacyZones.Store(catID, zones)
+	return zones, nil
+}
+
+// publicTracks returns the tracks privatized by their cats' privacy zones.
+// Tracks of cats whose zones cannot be read are omitted.
+func (s *WebDaemon) publicTracks(tracks []*cattrack.CatTrack) []*cattrack.CatTrack {
+	out := make([]*cattrack.CatTrack, 0, len(tracks))
+	for _, ct := range tracks {
+		zones, err := s.catPrivacyZones(ct.CatID())
+		if err != nil {
+			s.logger.Warn("Failed to read privacy zones, omitting track", "cat", ct.CatID(), "error", err)
+			continue
+		}
+		if pub, ok := zones.Track(*ct); ok {
+			out = append(out, &pub)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package webd
+
+import (
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/catdb/cache"
+	"github.com/rotblauer/catd/types/cattrack"
+)
+
+// collectFeed subscribes to a cat feed, collecting the values sent.
+// The returned func unsubscribes, returning the values collected.
+// Feeds send synchronously, so all values sent before unsubscribing are collected.
+func collectFeed[T any](subscribe func(ch chan<- T) event.Subscription) func() []T {
+	ch := make(chan T)
+	sub := subscribe(ch)
+	values := []T{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case v := <-ch:
+				values = append(values, v)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return func() []T {
+		sub.Unsubscribe()
+		<-done
+		return values
+	}
+}
+
+// catPublications collects what a cat's populate publishes to websocket and event stream clients.
+type catPublications struct {
+	tracks   func() []cattrack.CatTrack
+	snaps    func() []cattrack.CatTrack
+	laps     func() []cattrack.CatLap
+	naps     func() []cattrack.CatNap
+	geofence func() []api.GeofenceEvent
+}
+
+// collectCatPublications subscribes to the cat's feeds, before populate.
+func collectCatPublications(cat *api.Cat) *catPublications {
+	return &catPublications{
+		tracks:   collectFeed(cat.SubscribeStoredTracks),
+		snaps:    collectFeed(cat.SubscribeStoredSnaps),
+		laps:     collectFeed(cat.SubscribeCompletedLaps),
+		naps:     collectFeed(cat.SubscribeCompletedNaps),
+		geofence: collectFeed(cat.SubscribeGeofenceEvents),
+	}
+}
+
+// publish publishes the collected stored tracks, snaps, laps, naps and geofence events,
+// after populate, and caches the stored tracks as the cat's last push.
+func (s *WebDaemon) publish(cat *api.Cat, pubs *catPublications) {
+	if tracks := pubs.tracks(); len(tracks) > 0 {
+		features := make([]*cattrack.CatTrack, len(tracks))
+		for i := range tracks {
+			features[i] = &tracks[i]
+		}
+		cache.SetLastPushTTL(cat.CatID, features)
+		s.feedPopulated.Send(features)
+		s.catEvents.publish(cat.CatID, broadcats{Action: websocketActionPopulate, Features: s.publicTracks(features)})
+	}
+	if snaps := pubs.snaps(); len(snaps) > 0 {
+		features := make([]*cattrack.CatTrack, len(snaps))
+		for i := range snaps {
+			features[i] = &snaps[i]
+		}
+		s.emit(cat, broadcats{Action: websocketActionSnap, Features: s.publicTracks(features)})
+	}
+	if laps := pubs.laps(); len(laps) > 0 {
+		features := make([]*cattrack.CatTrack, len(laps))
+		for i, lap := range laps {
+			ct := cattrack.Lap2Track(lap)
+			features[i] = &ct
+		}
+		s.emit(cat, broadcats{Action: websocketActionLap, Features: s.publicTracks(features)})
+	}
+	if naps := pubs.naps(); len(naps) > 0 {
+		features := make([]*cattrack.CatTrack, len(naps))
+		for i, nap := range naps {
+			ct := cattrack.Nap2Track(nap)
+			features[i] = &ct
+		}
+		s.emit(cat, broadcats{Action: websocketActionNap, Features: s.publicTracks(features)})
+	}
+	if events := pubs.geofence(); len(events) > 0 {
+		s.emit(cat, broadcats{Action: websocketActionGeofence, Events: events})
+		s.deliverGeofenceEvents(events)
+	}
+}
+
+// emit broadcasts the cat's event to websocket clients, and publishes it to its event stream.
+func (s *WebDaemon) emit(cat *api.Cat, bc broadcats) {
+	s.broadcast(bc)
+	s.catEvents.publish(cat.CatID, bc)
+}
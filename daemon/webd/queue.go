@@ -0,0 +1,424 @@
+package webd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/catz"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	populateQueuePendingDir = "pending"
+	populateQueueFailedDir  = "failed"
+	populateQueueSpoolExt   = ".json.gz"
+)
+
+var errPopulateQueueClosed = errors.New("populate queue closed")
+
+// errPopulatePartial marks job errors after which the job is not retried,
+// since the failed attempt may already have stored some of the job's tracks,
+// or indexed them, and a retry would duplicate them.
+var errPopulatePartial = errors.New("populate failed after storing tracks")
+
+// populateJob is one spooled populate request body for one cat.
+type populateJob struct {
+	// ID names the spool file. IDs sort in enqueue order.
+	ID       string
+	CatID    conceptual.CatID
+	Path     string
+	Enqueued time.Time
+	Attempts int
+
+	// done is closed when the job is finished, successfully or not.
+	// The receipt and err are set before.
+	done    chan struct{}
+	receipt *api.PopulateReceipt
+	err     error
+}
+
+// populateJobHandler populates a cat with a spooled job's tracks.
+type populateJobHandler func(ctx context.Context, job *populateJob) (*api.PopulateReceipt, error)
+
+// populateQueue spools populate request bodies to disk, per cat, and populates them with a worker pool.
+// Each cat's jobs are run one at a time in enqueue order, since Populate holds the cat's state write lock,
+// and since track order matters to the producers. Different cats are populated concurrently.
+// Spooled jobs survive restarts; failed jobs are retried with backoff, then set aside in the failed dir.
+// Jobs failing with errPopulatePartial are set aside without retrying.
+type populateQueue struct {
+	config *params.PopulateQueueConfig
+	dir    string
+	handle populateJobHandler
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	lastID  int64
+	pending map[conceptual.CatID][]*populateJob
+	ready   []conceptual.CatID
+	active  map[conceptual.CatID]*populateJob
+	errors  map[conceptual.CatID]string
+	done    int
+	failed  int
+	retried int
+	closed  bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newPopulateQueue returns a queue spooling to dir, recovering any jobs already spooled there.
+// Workers are not started until start is called.
+func newPopulateQueue(dir string, config *params.PopulateQueueConfig, handle populateJobHandler) (*populateQueue, error) {
+	if config == nil {
+		config = params.DefaultPopulateQueueConfig()
+	}
+	if config.Workers < 1 {
+		return nil, fmt.Errorf("invalid populate queue workers: %d", config.Workers)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, populateQueuePendingDir), 0770); err != nil {
+		return nil, err
+	}
+	q := &populateQueue{
+		config:  config,
+		dir:     dir,
+		handle:  handle,
+		logger:  slog.With("daemon", "web", "queue", "populate"),
+		pending: make(map[conceptual.CatID][]*populateJob),
+		active:  make(map[conceptual.CatID]*populateJob),
+		errors:  make(map[conceptual.CatID]string),
+		quit:    make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// recover enqueues the jobs left in the pending spool by a previous run.
+func (q *populateQueue) recover() error {
+	matches, err := filepath.Glob(filepath.Join(q.dir, populateQueuePendingDir, "*", "*"+populateQueueSpoolExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range matches {
+		id := strings.TrimSuffix(filepath.Base(p), populateQueueSpoolExt)
+		var n int64
+		if _, err := fmt.Sscanf(id, "%d", &n); err != nil {
+			q.logger.Warn("Skipping unrecognized spool file", "path", p)
+			continue
+		}
+		if n > q.lastID {
+			q.lastID = n
+		}
+		enqueued := time.Unix(0, n)
+		q.push(&populateJob{
+			ID:       id,
+			CatID:    conceptual.CatID(filepath.Base(filepath.Dir(p))),
+			Path:     p,
+			Enqueued: enqueued,
+			done:     make(chan struct{}),
+		})
+	}
+	if len(matches) > 0 {
+		q.logger.Info("Recovered spooled populate jobs", "count", len(matches))
+	}
+	return nil
+}
+
+// start starts the workers.
+func (q *populateQueue) start() {
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// close stops the workers, waiting for running jobs to finish.
+// Jobs not yet run stay spooled for the next start,
+// and are finished with errPopulateQueueClosed, so that no one waits on them.
+func (q *populateQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.quit)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for catID, jobs := range q.pending {
+		for _, job := range jobs {
+			job.finish(nil, errPopulateQueueClosed)
+		}
+		delete(q.pending, catID)
+	}
+	q.ready = nil
+}
+
+// enqueue spools the body for the cat and queues it.
+// The body is durable when enqueue returns without error.
+// Spooling is serialized so that spool order is queue order, which is kept across restarts.
+func (q *populateQueue) enqueue(catID conceptual.CatID, body []byte) (*populateJob, error) {
+	if catID.IsEmpty() {
+		return nil, errors.New("catID is required")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, errPopulateQueueClosed
+	}
+	id := time.Now().UnixNano()
+	if id <= q.lastID {
+		id = q.lastID + 1
+	}
+	job := &populateJob{
+		ID:       fmt.Sprintf("%020d", id),
+		CatID:    catID,
+		Enqueued: time.Unix(0, id),
+		done:     make(chan struct{}),
+	}
+	job.Path = filepath.Join(q.dir, populateQueuePendingDir, catID.String(), job.ID+populateQueueSpoolExt)
+	if err := os.MkdirAll(filepath.Dir(job.Path), 0770); err != nil {
+		return nil, err
+	}
+	if err := spool(job.Path, body); err != nil {
+		return nil, err
+	}
+	q.lastID = id
+	q.push(job)
+	return job, nil
+}
+
+// spool writes the body to a gz file at path, as api.Master does, atomically.
+func spool(path string, body []byte) error {
+	tmp := path + ".tmp"
+	wr, err := catz.NewGZFileWriter(tmp, catz.DefaultGZFileWriterConfig())
+	if err != nil {
+		return err
+	}
+	if _, err := wr.Write(body); err != nil {
+		_ = wr.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := wr.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// push appends the job to its cat's queue, readying the cat if idle.
+// The caller must hold the lock.
+func (q *populateQueue) push(job *populateJob) {
+	jobs, queued := q.pending[job.CatID]
+	q.pending[job.CatID] = append(jobs, job)
+	if _, running := q.active[job.CatID]; !running && !queued {
+		q.ready = append(q.ready, job.CatID)
+		q.cond.Signal()
+	}
+}
+
+// next blocks until a cat is ready, returning false if the queue is closed.
+func (q *populateQueue) next() (conceptual.CatID, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.ready) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return "", false
+	}
+	catID := q.ready[0]
+	q.ready = q.ready[1:]
+	return catID, true
+}
+
+// pop returns the cat's next job, marking it active,
+// or nil if the cat has no more jobs or the queue is closed.
+func (q *populateQueue) pop(catID conceptual.CatID) *populateJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.active, catID)
+	jobs := q.pending[catID]
+	if len(jobs) == 0 {
+		delete(q.pending, catID)
+		return nil
+	}
+	if q.closed {
+		return nil
+	}
+	job := jobs[0]
+	if len(jobs) == 1 {
+		delete(q.pending, catID)
+	} else {
+		q.pending[catID] = jobs[1:]
+	}
+	q.active[catID] = job
+	return job
+}
+
+func (q *populateQueue) work() {
+	defer q.wg.Done()
+	for {
+		catID, ok := q.next()
+		if !ok {
+			return
+		}
+		for job := q.pop(catID); job != nil; job = q.pop(catID) {
+			q.run(job)
+		}
+	}
+}
+
+// run runs the job until it succeeds, exhausts its attempts, fails partially, or the queue is closed.
+func (q *populateQueue) run(job *populateJob) {
+	logger := q.logger.With("cat", job.CatID, "job", job.ID)
+	for {
+		q.mu.Lock()
+		job.Attempts++
+		q.mu.Unlock()
+		started := time.Now()
+		receipt, err := q.handle(context.Background(), job)
+		if err == nil {
+			logger.Info("Populated spooled job", "attempts", job.Attempts,
+				"elapsed", time.Since(started).Round(time.Millisecond),
+				"waited", started.Sub(job.Enqueued).Round(time.Millisecond))
+			if err := os.Remove(job.Path); err != nil {
+				logger.Error("Failed to remove spool file", "error", err)
+			}
+			q.mu.Lock()
+			q.done++
+			delete(q.errors, job.CatID)
+			q.mu.Unlock()
+			job.finish(receipt, nil)
+			return
+		}
+
+		logger.Error("Failed to populate spooled job", "attempt", job.Attempts, "error", err)
+		q.mu.Lock()
+		q.errors[job.CatID] = err.Error()
+		q.mu.Unlock()
+
+		if errors.Is(err, errPopulatePartial) || job.Attempts >= q.config.MaxAttempts {
+			failed := filepath.Join(q.dir, populateQueueFailedDir, job.CatID.String(), filepath.Base(job.Path))
+			if err := os.MkdirAll(filepath.Dir(failed), 0770); err != nil {
+				logger.Error("Failed to create failed spool dir", "error", err)
+			} else if err := os.Rename(job.Path, failed); err != nil {
+				logger.Error("Failed to set aside failed spool file", "error", err)
+			}
+			q.mu.Lock()
+			q.failed++
+			q.mu.Unlock()
+			job.finish(receipt, err)
+			return
+		}
+
+		delay := q.config.RetryDelay << (job.Attempts - 1)
+		q.mu.Lock()
+		q.retried++
+		q.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-q.quit:
+			// The job stays spooled, and will be recovered on restart.
+			job.finish(receipt, errPopulateQueueClosed)
+			return
+		}
+	}
+}
+
+func (job *populateJob) finish(receipt *api.PopulateReceipt, err error) {
+	job.receipt = receipt
+	job.err = err
+	close(job.done)
+}
+
+type populateQueueCatStatus struct {
+	CatID     conceptual.CatID `json:"cat"`
+	Pending   int              `json:"pending"`
+	Active    bool             `json:"active"`
+	Oldest    *time.Time       `json:"oldest,omitempty"`
+	Attempts  int              `json:"attempts"`
+	LastError string           `json:"last_error,omitempty"`
+}
+
+type populateQueueStatus struct {
+	Workers int                      `json:"workers"`
+	Pending int                      `json:"pending"`
+	Done    int                      `json:"done"`
+	Failed  int                      `json:"failed"`
+	Retried int                      `json:"retried"`
+	Cats    []populateQueueCatStatus `json:"cats"`
+}
+
+// status reports the queue's counters and per-cat backlog.
+// Pending counts include active jobs.
+func (q *populateQueue) status() *populateQueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := &populateQueueStatus{
+		Workers: q.config.Workers,
+		Done:    q.done,
+		Failed:  q.failed,
+		Retried: q.retried,
+		Cats:    []populateQueueCatStatus{},
+	}
+	cats := map[conceptual.CatID]*populateQueueCatStatus{}
+	get := func(catID conceptual.CatID) *populateQueueCatStatus {
+		cs, ok := cats[catID]
+		if !ok {
+			cs = &populateQueueCatStatus{CatID: catID, LastError: q.errors[catID]}
+			cats[catID] = cs
+		}
+		return cs
+	}
+	note := func(cs *populateQueueCatStatus, job *populateJob) {
+		cs.Pending++
+		st.Pending++
+		if cs.Oldest == nil || job.Enqueued.Before(*cs.Oldest) {
+			t := job.Enqueued
+			cs.Oldest = &t
+		}
+	}
+	for catID, job := range q.active {
+		cs := get(catID)
+		cs.Active = true
+		cs.Attempts = job.Attempts
+		note(cs, job)
+	}
+	for catID, jobs := range q.pending {
+		cs := get(catID)
+		for _, job := range jobs {
+			note(cs, job)
+		}
+	}
+	for catID := range q.errors {
+		get(catID)
+	}
+	for _, cs := range cats {
+		st.Cats = append(st.Cats, *cs)
+	}
+	sort.Slice(st.Cats, func(i, j int) bool {
+		return st.Cats[i].CatID < st.Cats[j].CatID
+	})
+	return st
+}
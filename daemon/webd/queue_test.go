@@ -0,0 +1,212 @@
+package webd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/catz"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPopulateQueue(t *testing.T) {
+	dir := t.TempDir()
+	config := &params.PopulateQueueConfig{Workers: 2, MaxAttempts: 3, RetryDelay: time.Millisecond}
+
+	mu := sync.Mutex{}
+	got := map[conceptual.CatID][]string{}
+	running := map[conceptual.CatID]bool{}
+	handle := func(ctx context.Context, job *populateJob) (*api.PopulateReceipt, error) {
+		mu.Lock()
+		if running[job.CatID] {
+			t.Errorf("cat %s populated concurrently", job.CatID)
+		}
+		running[job.CatID] = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running[job.CatID] = false
+			mu.Unlock()
+		}()
+
+		r, err := catz.NewGZFileReader(job.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if string(body) == "bad" {
+			return nil, errors.New("bad body")
+		}
+		if string(body) == "flaky" && job.Attempts < 2 {
+			return nil, errors.New("flaky body")
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		got[job.CatID] = append(got[job.CatID], string(body))
+		mu.Unlock()
+		return &api.PopulateReceipt{CatID: job.CatID}, nil
+	}
+
+	q, err := newPopulateQueue(dir, config, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Spool before starting; the jobs wait.
+	jobs := []*populateJob{}
+	for i := 0; i < 10; i++ {
+		for _, cat := range []conceptual.CatID{"rye", "ia"} {
+			body := fmt.Sprintf("%s-%d", cat, i)
+			if cat == "ia" && i == 3 {
+				body = "flaky"
+			}
+			job, err := q.enqueue(cat, []byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	bad, err := q.enqueue("jl", []byte("bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := q.status(); st.Pending != 21 || len(st.Cats) != 3 {
+		t.Fatalf("unexpected status before start: %+v", st)
+	}
+
+	q.start()
+	for _, job := range jobs {
+		<-job.done
+		if job.err != nil {
+			t.Errorf("job %s: %v", job.ID, job.err)
+		}
+	}
+	<-bad.done
+	if bad.err == nil || bad.Attempts != 3 {
+		t.Errorf("expected bad job to fail after 3 attempts, got attempts=%d err=%v", bad.Attempts, bad.err)
+	}
+	q.close()
+
+	for _, cat := range []conceptual.CatID{"rye", "ia"} {
+		if len(got[cat]) != 10 {
+			t.Fatalf("%s: expected 10 jobs, got %d", cat, len(got[cat]))
+		}
+		for i, body := range got[cat] {
+			want := fmt.Sprintf("%s-%d", cat, i)
+			if cat == "ia" && i == 3 {
+				want = "flaky"
+			}
+			if body != want {
+				t.Errorf("%s: out of order at %d: got %s, want %s", cat, i, body, want)
+			}
+		}
+	}
+
+	st := q.status()
+	if st.Pending != 0 || st.Done != 20 || st.Failed != 1 || st.Retried != 3 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(dir, populateQueueFailedDir, "jl", filepath.Base(bad.Path))); err != nil {
+		t.Errorf("expected failed job set aside: %v", err)
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, populateQueuePendingDir, "*", "*"))
+	if len(left) != 0 {
+		t.Errorf("expected empty spool, got %v", left)
+	}
+}
+
+func TestPopulateQueue_recover(t *testing.T) {
+	dir := t.TempDir()
+	config := &params.PopulateQueueConfig{Workers: 1, MaxAttempts: 1}
+	handle := func(ctx context.Context, job *populateJob) (*api.PopulateReceipt, error) {
+		return &api.PopulateReceipt{CatID: job.CatID}, nil
+	}
+
+	q, err := newPopulateQueue(dir, config, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := q.enqueue("rye", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.enqueue("rye", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	q.close() // Never started.
+	// Jobs left spooled are finished, so no one waits on them.
+	select {
+	case <-first.done:
+		if !errors.Is(first.err, errPopulateQueueClosed) {
+			t.Errorf("expected closed queue error, got %v", first.err)
+		}
+	default:
+		t.Error("expected spooled job finished on close")
+	}
+
+	q, err = newPopulateQueue(dir, config, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := q.status()
+	if st.Pending != 2 {
+		t.Fatalf("expected 2 recovered jobs, got %d", st.Pending)
+	}
+	if next := q.pending["rye"][0]; next.ID != first.ID {
+		t.Errorf("expected first job first, got %s", next.ID)
+	}
+	job, err := q.enqueue("rye", []byte("third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID <= first.ID {
+		t.Errorf("expected new job ID after recovered IDs, got %s", job.ID)
+	}
+	q.start()
+	<-job.done
+	q.close()
+	if st := q.status(); st.Done != 3 {
+		t.Errorf("expected 3 done, got %d", st.Done)
+	}
+}
+
+func TestPopulateQueue_partial(t *testing.T) {
+	dir := t.TempDir()
+	config := &params.PopulateQueueConfig{Workers: 1, MaxAttempts: 3, RetryDelay: time.Millisecond}
+	handle := func(ctx context.Context, job *populateJob) (*api.PopulateReceipt, error) {
+		return &api.PopulateReceipt{CatID: job.CatID}, fmt.Errorf("%w: %w", errPopulatePartial, errors.New("disk full"))
+	}
+	q, err := newPopulateQueue(dir, config, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.enqueue("rye", []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.start()
+	<-job.done
+	q.close()
+	// Partially populated jobs are not retried, since retries would duplicate tracks.
+	if job.Attempts != 1 || !errors.Is(job.err, errPopulatePartial) {
+		t.Errorf("expected one attempt, got attempts=%d err=%v", job.Attempts, job.err)
+	}
+	if st := q.status(); st.Failed != 1 || st.Retried != 0 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(dir, populateQueueFailedDir, "rye", filepath.Base(job.Path))); err != nil {
+		t.Errorf("expected failed job set aside: %v", err)
+	}
+}
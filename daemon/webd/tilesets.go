@@ -0,0 +1,146 @@
+package webd
+
+import (
+	"github.com/rotblauer/catd/daemon/tiled"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// tilesetCheckInterval is how often a served tileset's file is checked for
+// replacement by a tiling run.
+var tilesetCheckInterval = time.Second
+
+// tilesets are the .mbtiles files webd serves, opened on first request.
+// The tile daemon moves each finished run's .mbtiles into place with a rename,
+// so a replaced file is noticed by its file info and swapped in atomically;
+// requests in flight finish reading the old file.
+// Opening a tileset reads only its metadata, and tiles are looked up by index,
+// so swapping in even a large tileset doesn't hold up the request noticing it.
+// Only tilesets whose file opened are kept; requests for missing ones leave nothing behind.
+//
+// Replacements are noticed by requests, statting the file at most every tilesetCheckInterval,
+// rather than notified by the tile daemon or a file watch. The tile daemon may run in
+// another process, and webd is its RPC client, not its server. A stat a second per
+// tileset being viewed is cheap, needs no state for tilesets nobody views, and a second's
+// delay is nothing next to a tiling run.
+type tilesets struct {
+	mu  sync.Mutex
+	all map[string]*tileset
+}
+
+func newTilesets() *tilesets {
+	return &tilesets{all: map[string]*tileset{}}
+}
+
+type tileset struct {
+	path string
+
+	// reload serializes checks of the file.
+	reload  sync.Mutex
+	checked time.Time
+	// evicted tilesets are forgotten by tilesets, and not opened again.
+	evicted bool
+
+	// mu guards the open tileset, read-locked while it is read.
+	mu   sync.RWMutex
+	m    *tiled.MBTiles
+	info os.FileInfo
+}
+
+// acquire returns the tileset at path and a func to release it when done reading.
+// It returns an os.ErrNotExist error if there is no such tileset.
+func (ts *tilesets) acquire(path string) (*tiled.MBTiles, func(), error) {
+	ts.mu.Lock()
+	t, ok := ts.all[path]
+	if !ok {
+		t = &tileset{path: path}
+		ts.all[path] = t
+	}
+	ts.mu.Unlock()
+
+	if err := t.check(); err != nil {
+		ts.evict(t)
+		return nil, nil, err
+	}
+	t.mu.RLock()
+	if t.m == nil {
+		t.mu.RUnlock()
+		ts.evict(t)
+		return nil, nil, os.ErrNotExist
+	}
+	return t.m, t.mu.RUnlock, nil
+}
+
+// evict forgets the tileset if it has no open file.
+func (ts *tilesets) evict(t *tileset) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t.reload.Lock()
+	defer t.reload.Unlock()
+	if t.m != nil || ts.all[t.path] != t {
+		return
+	}
+	delete(ts.all, t.path)
+	t.evicted = true
+}
+
+// check (re)opens the tileset if its file has been replaced since it was last checked.
+func (t *tileset) check() error {
+	t.reload.Lock()
+	defer t.reload.Unlock()
+	if t.evicted {
+		return os.ErrNotExist
+	}
+	if !t.checked.IsZero() && time.Since(t.checked) < tilesetCheckInterval {
+		return nil
+	}
+	t.checked = time.Now()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.swap(nil, nil)
+		}
+		return err
+	}
+	if t.info != nil && os.SameFile(info, t.info) &&
+		info.Size() == t.info.Size() && info.ModTime().Equal(t.info.ModTime()) {
+		return nil
+	}
+	m, err := tiled.OpenMBTiles(t.path)
+	if err != nil {
+		if t.info != nil {
+			// Keep serving the last good tileset.
+			slog.Warn("Failed to reopen tileset", "path", t.path, "error", err)
+			return nil
+		}
+		return err
+	}
+	if t.info != nil {
+		slog.Info("Reloaded tileset", "path", t.path)
+	}
+	t.swap(m, info)
+	return nil
+}
+
+// swap replaces the open tileset, closing the old one once its readers are done.
+func (t *tileset) swap(m *tiled.MBTiles, info os.FileInfo) {
+	t.mu.Lock()
+	old := t.m
+	t.m, t.info = m, info
+	t.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+}
+
+// close closes all open tilesets.
+func (ts *tilesets) close() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range ts.all {
+		t.swap(nil, nil)
+	}
+}
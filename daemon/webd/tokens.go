@@ -0,0 +1,294 @@
+package webd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/params"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TokenAction is an action a token may be scoped to.
+type TokenAction string
+
+const (
+	TokenActionPopulate TokenAction = "populate"
+	TokenActionRead     TokenAction = "read"
+	// TokenActionAdmin allows all actions.
+	TokenActionAdmin TokenAction = "admin"
+)
+
+// TokenActions are all valid token actions.
+var TokenActions = []TokenAction{TokenActionPopulate, TokenActionRead, TokenActionAdmin}
+
+// TokenAllCats is the cat scope allowing all cats.
+const TokenAllCats conceptual.CatID = "*"
+
+var tokensBucket = []byte("tokens")
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExists   = errors.New("token name already exists")
+)
+
+// Token is the scope of an API token.
+// Tokens are stored by the hash of their secret; the secret itself is not stored.
+type Token struct {
+	Name      string             `json:"name"`
+	Cats      []conceptual.CatID `json:"cats"`
+	Actions   []TokenAction      `json:"actions"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// AllowsAction returns true if the token allows the action, for some cat.
+func (t *Token) AllowsAction(action TokenAction) bool {
+	return slices.Contains(t.Actions, action) || slices.Contains(t.Actions, TokenActionAdmin)
+}
+
+// AllowsCat returns true if the token is scoped to the cat.
+func (t *Token) AllowsCat(catID conceptual.CatID) bool {
+	return slices.Contains(t.Cats, TokenAllCats) || slices.Contains(t.Cats, catID)
+}
+
+// Allows returns true if the token allows the action on the cat.
+func (t *Token) Allows(action TokenAction, catID conceptual.CatID) bool {
+	return t.AllowsAction(action) && t.AllowsCat(catID)
+}
+
+// legacyToken is the scope of the global COTOKEN, which allows everything.
+var legacyToken = &Token{
+	Name:    "COTOKEN",
+	Cats:    []conceptual.CatID{TokenAllCats},
+	Actions: []TokenAction{TokenActionAdmin},
+}
+
+// TokenStore is a store of API tokens in a bbolt database.
+// The database is only opened for the duration of each operation,
+// so that the 'catd token' command can manage tokens while webd runs.
+// Lookups are served from a cache, reloaded when the database file changes.
+type TokenStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cache   map[string]*Token
+}
+
+// NewTokenStore returns the token store in the datadir.
+// The database is created on first write.
+func NewTokenStore(datadir string) *TokenStore {
+	return &TokenStore{path: filepath.Join(datadir, params.WebDBName)}
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (ts *TokenStore) update(fn func(b *bbolt.Bucket) error) error {
+	if err := os.MkdirAll(filepath.Dir(ts.path), 0770); err != nil {
+		return err
+	}
+	db, err := bbolt.Open(ts.path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer func() {
+		ts.mu.Lock()
+		ts.cache = nil
+		ts.mu.Unlock()
+	}()
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(tokensBucket)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// load reads all tokens by secret hash. A missing database has no tokens.
+func (ts *TokenStore) load() (map[string]*Token, error) {
+	tokens := map[string]*Token{}
+	if _, err := os.Stat(ts.path); os.IsNotExist(err) {
+		return tokens, nil
+	}
+	db, err := bbolt.Open(ts.path, 0600, &bbolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tokensBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			t := &Token{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			tokens[string(k)] = t
+			return nil
+		})
+	})
+	return tokens, err
+}
+
+// Add creates a token with the scope, returning its secret.
+// The secret is only available now; it cannot be recovered later.
+func (ts *TokenStore) Add(name string, cats []conceptual.CatID, actions []TokenAction) (secret string, err error) {
+	if name == "" {
+		return "", errors.New("token name is required")
+	}
+	if len(cats) == 0 {
+		return "", errors.New("token cats are required")
+	}
+	if len(actions) == 0 {
+		return "", errors.New("token actions are required")
+	}
+	for _, a := range actions {
+		if !slices.Contains(TokenActions, a) {
+			return "", fmt.Errorf("invalid token action %q (valid: %v)", a, TokenActions)
+		}
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret = hex.EncodeToString(raw)
+	t := &Token{
+		Name:      name,
+		Cats:      cats,
+		Actions:   actions,
+		CreatedAt: time.Now().UTC(),
+	}
+	v, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	err = ts.update(func(b *bbolt.Bucket) error {
+		exists := false
+		_ = b.ForEach(func(k, v []byte) error {
+			other := &Token{}
+			if json.Unmarshal(v, other) == nil && other.Name == name {
+				exists = true
+			}
+			return nil
+		})
+		if exists {
+			return fmt.Errorf("%w: %s", ErrTokenExists, name)
+		}
+		return b.Put([]byte(hashTokenSecret(secret)), v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Remove deletes the named token.
+func (ts *TokenStore) Remove(name string) error {
+	return ts.update(func(b *bbolt.Bucket) error {
+		var key []byte
+		_ = b.ForEach(func(k, v []byte) error {
+			t := &Token{}
+			if json.Unmarshal(v, t) == nil && t.Name == name {
+				key = slices.Clone(k)
+			}
+			return nil
+		})
+		if key == nil {
+			return fmt.Errorf("%w: %s", ErrTokenNotFound, name)
+		}
+		return b.Delete(key)
+	})
+}
+
+// List returns all tokens, sorted by name.
+func (ts *TokenStore) List() ([]*Token, error) {
+	tokens, err := ts.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Token, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// refresh reloads the cache if the database file has changed.
+func (ts *TokenStore) refresh() error {
+	var modTime time.Time
+	if fi, err := os.Stat(ts.path); err == nil {
+		modTime = fi.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.cache != nil && modTime.Equal(ts.modTime) {
+		return nil
+	}
+	tokens, err := ts.load()
+	if err != nil {
+		return err
+	}
+	ts.cache = tokens
+	ts.modTime = modTime
+	return nil
+}
+
+// Lookup returns the token for the secret.
+func (ts *TokenStore) Lookup(secret string) (*Token, error) {
+	if err := ts.refresh(); err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t, ok := ts.cache[hashTokenSecret(secret)]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return t, nil
+}
+
+// Len returns the number of tokens.
+func (ts *TokenStore) Len() (int, error) {
+	if err := ts.refresh(); err != nil {
+		return 0, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.cache), nil
+}
+
+// Grants returns true if any token allows the action, for some cat.
+func (ts *TokenStore) Grants(action TokenAction) (bool, error) {
+	if err := ts.refresh(); err != nil {
+		return false, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range ts.cache {
+		if t.AllowsAction(action) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
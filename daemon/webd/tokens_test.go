@@ -0,0 +1,148 @@
+package webd
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/rotblauer/catd/conceptual"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenStore(t *testing.T) {
+	ts := NewTokenStore(t.TempDir())
+	if n, err := ts.Len(); err != nil || n != 0 {
+		t.Fatalf("expected empty store, got n=%d err=%v", n, err)
+	}
+	secret, err := ts.Add("friend", []conceptual.CatID{"rye"}, []TokenAction{TokenActionRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Add("friend", []conceptual.CatID{"ia"}, []TokenAction{TokenActionRead}); !errors.Is(err, ErrTokenExists) {
+		t.Errorf("expected duplicate name error, got %v", err)
+	}
+	if _, err := ts.Add("bad", []conceptual.CatID{"ia"}, []TokenAction{"write"}); err == nil {
+		t.Error("expected invalid action error")
+	}
+
+	tok, err := ts.Lookup(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tok.Allows(TokenActionRead, "rye") {
+		t.Error("expected read rye allowed")
+	}
+	if tok.Allows(TokenActionRead, "ia") || tok.Allows(TokenActionPopulate, "rye") {
+		t.Error("expected token scoped to read rye")
+	}
+	if _, err := ts.Lookup("nope"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	list, err := ts.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "friend" {
+		t.Fatalf("unexpected list: %v", list)
+	}
+	if err := ts.Remove("friend"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Lookup(secret); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("expected removed token not found, got %v", err)
+	}
+}
+
+func TestWebDaemon_tokenAuthenticationMiddleware(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	t.Setenv("COTOKEN", "")
+
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	read := router.NewRoute().Subrouter()
+	read.Use(d.tokenAuthenticationMiddlewareFunc(TokenActionRead))
+	read.Path("/{cat}/last.json").HandlerFunc(ok)
+	admin := router.NewRoute().Subrouter()
+	admin.Use(d.tokenAuthenticationMiddlewareFunc(TokenActionAdmin))
+	admin.Path("/queue").HandlerFunc(ok)
+	populate := router.NewRoute().Subrouter()
+	populate.Use(d.tokenAuthenticationMiddlewareFunc(TokenActionPopulate))
+	populate.Path("/populate").HandlerFunc(ok)
+
+	do := func(url, token string) int {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			req.Header.Set("AuthorizationOfCats", token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// No tokens configured: all allowed.
+	if code := do("/queue", ""); code != http.StatusOK {
+		t.Errorf("no tokens: got %d", code)
+	}
+
+	friend, err := d.tokens.Add("friend", []conceptual.CatID{"rye"}, []TokenAction{TokenActionRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A read token doesn't enforce populate tokens.
+	if code := do("/populate", ""); code != http.StatusOK {
+		t.Errorf("read token only: populate got %d", code)
+	}
+	root, err := d.tokens.Add("root", []conceptual.CatID{TokenAllCats}, []TokenAction{TokenActionAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are public unless required.
+	if code := do("/ia/last.json", ""); code != http.StatusOK {
+		t.Errorf("public read: got %d", code)
+	}
+	d.Config.RequireReadToken = true
+
+	cases := []struct {
+		url, token string
+		want       int
+	}{
+		{"/rye/last.json", "", http.StatusForbidden},
+		{"/rye/last.json", friend, http.StatusOK},
+		{"/rye/last.json?api_token=" + friend, "", http.StatusOK},
+		{"/ia/last.json", friend, http.StatusForbidden},
+		{"/ia/last.json", root, http.StatusOK},
+		{"/queue", friend, http.StatusForbidden},
+		{"/queue", root, http.StatusOK},
+		{"/populate", "", http.StatusForbidden},
+		{"/populate", friend, http.StatusForbidden},
+		{"/populate", root, http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := do(c.url, c.token); code != c.want {
+			t.Errorf("%s token=%q: got %d, want %d", c.url, c.token, code, c.want)
+		}
+	}
+
+	t.Setenv("COTOKEN", "legacy")
+	if code := do("/ia/last.json", "legacy"); code != http.StatusOK {
+		t.Errorf("legacy token: got %d", code)
+	}
+
+	// Forbidden tokens are logged by name or hash, not by secret.
+	friendToken, err := d.tokens.Lookup(friend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := tokenLogID(friendToken, friend); id != "friend" {
+		t.Errorf("got token log id %q, want friend", id)
+	}
+	if id := tokenLogID(nil, "wrong-secret"); id == "" || strings.Contains(id, "wrong-secret") {
+		t.Errorf("got token log id %q for unknown secret", id)
+	}
+}
@@ -2,10 +2,17 @@ package webd
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/api"
 	"github.com/rotblauer/catd/catdb/cache"
+	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/types/cattrack"
 	"log"
 	"log/slog"
+	"net/http"
+	"slices"
 
 	"github.com/olahol/melody"
 )
@@ -14,33 +21,250 @@ type websocketAction string
 
 var (
 	websocketActionPopulate websocketAction = "populate"
+	websocketActionLap      websocketAction = "lap"
+	websocketActionNap      websocketAction = "nap"
+	websocketActionSnap     websocketAction = "snap"
+	websocketActionGeofence websocketAction = "geofence"
+
+	// websocketActionSubscribe and websocketActionUnsubscribe are sent by clients.
+	websocketActionSubscribe   websocketAction = "subscribe"
+	websocketActionUnsubscribe websocketAction = "unsubscribe"
 )
 
+// websocketEventActions are the actions broadcast on the websocket.
+// Geofence events are only sent to clients subscribing to them by name,
+// for the cats they may read.
+var websocketEventActions = []websocketAction{websocketActionPopulate, websocketActionLap, websocketActionNap, websocketActionSnap, websocketActionGeofence}
+
 type broadcats struct {
 	Action   websocketAction      `json:"action"`
 	Features []*cattrack.CatTrack `json:"features"`
+	// Events are the geofence events of a geofence action.
+	Events []api.GeofenceEvent `json:"events,omitempty"`
+}
+
+// websocketSessionKeySubscription is the melody session key of a client's subscription.
+const websocketSessionKeySubscription = "subscription"
+
+// websocketSessionKeyToken is the melody session key of the token the client connected with,
+// nil if read tokens are not required.
+const websocketSessionKeyToken = "token"
+
+// websocketSubscription is a client's filter of broadcasts.
+// Empty fields match everything; a client that never subscribes gets all broadcasts,
+// except geofence events, which must be subscribed to by name.
+//
+// Clients subscribe by sending, eg.
+//
+//	{"action": "subscribe", "cats": ["rye"], "events": ["populate", "lap"], "bbox": [-94, 44, -93, 45]}
+//
+// and reset to everything by sending {"action": "unsubscribe"}.
+type websocketSubscription struct {
+	Action websocketAction    `json:"action"`
+	Cats   []conceptual.CatID `json:"cats,omitempty"`
+	Events []websocketAction  `json:"events,omitempty"`
+	// BBox is [west, south, east, north].
+	BBox []float64 `json:"bbox,omitempty"`
+
+	bound *orb.Bound
+}
+
+// parseWebsocketSubscription parses and validates a client message.
+func parseWebsocketSubscription(msg []byte) (*websocketSubscription, error) {
+	sub := &websocketSubscription{}
+	if err := json.Unmarshal(msg, sub); err != nil {
+		return nil, err
+	}
+	switch sub.Action {
+	case websocketActionSubscribe, websocketActionUnsubscribe:
+	default:
+		return nil, fmt.Errorf("unknown action %q", sub.Action)
+	}
+	for _, e := range sub.Events {
+		if !slices.Contains(websocketEventActions, e) {
+			return nil, fmt.Errorf("unknown event %q (valid: %v)", e, websocketEventActions)
+		}
+	}
+	if sub.BBox != nil {
+		if len(sub.BBox) != 4 {
+			return nil, errors.New("bbox must be [west, south, east, north]")
+		}
+		b := orb.Bound{Min: orb.Point{sub.BBox[0], sub.BBox[1]}, Max: orb.Point{sub.BBox[2], sub.BBox[3]}}
+		if b.Min.Lon() > b.Max.Lon() || b.Min.Lat() > b.Max.Lat() {
+			return nil, errors.New("bbox min exceeds max")
+		}
+		sub.bound = &b
+	}
+	return sub, nil
+}
+
+// filter returns the part of the broadcast the subscription wants, or false for none of it.
+func (sub *websocketSubscription) filter(bc broadcats) (broadcats, bool) {
+	if bc.Action == websocketActionGeofence {
+		return sub.filterGeofence(bc)
+	}
+	if sub == nil {
+		return bc, true
+	}
+	if len(sub.Events) > 0 && !slices.Contains(sub.Events, bc.Action) {
+		return bc, false
+	}
+	features := []*cattrack.CatTrack{}
+	for _, f := range bc.Features {
+		if len(sub.Cats) > 0 && !slices.Contains(sub.Cats, f.CatID()) {
+			continue
+		}
+		if sub.bound != nil && (f.Geometry == nil || !sub.bound.Intersects(f.Geometry.Bound())) {
+			continue
+		}
+		features = append(features, f)
+	}
+	bc.Features = features
+	return bc, len(features) > 0
+}
+
+// filterGeofence returns the geofence events the subscription wants, or false for none of them.
+// Only subscriptions naming geofence events get them. Events have no geometry; the bbox is ignored.
+func (sub *websocketSubscription) filterGeofence(bc broadcats) (broadcats, bool) {
+	if sub == nil || !slices.Contains(sub.Events, websocketActionGeofence) {
+		return bc, false
+	}
+	events := []api.GeofenceEvent{}
+	for _, e := range bc.Events {
+		if len(sub.Cats) > 0 && !slices.Contains(sub.Cats, e.CatID) {
+			continue
+		}
+		events = append(events, e)
+	}
+	bc.Events = events
+	return bc, len(events) > 0
+}
+
+// filterTokenCats returns the part of the broadcast the token may read, or false for none of it.
+// A nil token, as when read tokens are not required, reads everything.
+func filterTokenCats(token *Token, bc broadcats) (broadcats, bool) {
+	if token == nil {
+		return bc, true
+	}
+	features := []*cattrack.CatTrack{}
+	for _, f := range bc.Features {
+		if token.AllowsCat(f.CatID()) {
+			features = append(features, f)
+		}
+	}
+	events := []api.GeofenceEvent{}
+	for _, e := range bc.Events {
+		if token.AllowsCat(e.CatID) {
+			events = append(events, e)
+		}
+	}
+	bc.Features, bc.Events = features, events
+	return bc, len(features) > 0 || len(events) > 0
+}
+
+func sessionToken(session *melody.Session) *Token {
+	v, ok := session.Get(websocketSessionKeyToken)
+	if !ok {
+		return nil
+	}
+	t, _ := v.(*Token)
+	return t
+}
+
+func sessionSubscription(session *melody.Session) *websocketSubscription {
+	v, ok := session.Get(websocketSessionKeySubscription)
+	if !ok {
+		return nil
+	}
+	return v.(*websocketSubscription)
+}
+
+// writeSession writes the broadcast to the session, filtered by its token's cats and its subscription.
+func writeSession(session *melody.Session, bc broadcats) error {
+	bc, ok := filterTokenCats(sessionToken(session), bc)
+	if !ok {
+		return nil
+	}
+	bc, ok = sessionSubscription(session).filter(bc)
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(bc)
+	if err != nil {
+		return err
+	}
+	return session.Write(b)
+}
+
+// broadcast writes the broadcast to all connected clients, filtered by their subscriptions.
+// Features must already be public, see publicTracks.
+func (s *WebDaemon) broadcast(bc broadcats) {
+	if s.melodyInstance == nil {
+		return
+	}
+	sessions, err := s.melodyInstance.Sessions()
+	if err != nil {
+		slog.Warn("Failed to get websocket sessions", "error", err)
+		return
+	}
+	for _, session := range sessions {
+		if err := writeSession(session, bc); err != nil {
+			slog.Warn("Failed to broadcast event", "action", bc.Action, "remote", session.Request.RemoteAddr, "error", err)
+		}
+	}
+}
+
+// replayLastPushes writes the cached last pushes to the session, filtered by its subscription.
+func (s *WebDaemon) replayLastPushes(session *melody.Session) {
+	for _, v := range cache.LastPushTTLCache.Items() {
+		bc := broadcats{
+			Action:   websocketActionPopulate,
+			Features: s.publicTracks(v.Value()),
+		}
+		if err := writeSession(session, bc); err != nil {
+			slog.Warn("Failed to replay last push", "remote", session.Request.RemoteAddr, "error", err)
+		}
+	}
+}
+
+// socat upgrades the request to a websocket, keeping the request's token with the session.
+func (s *WebDaemon) socat(w http.ResponseWriter, r *http.Request) {
+	keys := map[string]any{}
+	if token := tokenFromContext(r.Context()); token != nil {
+		keys[websocketSessionKeyToken] = token
+	}
+	if err := s.melodyInstance.HandleRequestWithKeys(w, r, keys); err != nil {
+		slog.Warn("Failed to handle websocket", "remote", r.RemoteAddr, "error", err)
+	}
 }
 
 // initMelody sets up the websocket handler.
 func (s *WebDaemon) initMelody() {
 	s.melodyInstance = melody.New()
 
-	// Incoming message about updated query params.
-	s.melodyInstance.HandleConnect(func(s *melody.Session) {
-		log.Println("[websocket] connected", s.Request.RemoteAddr)
-		for _, v := range cache.LastPushTTLCache.Items() {
-			features := v.Value()
-			bc := broadcats{
-				Action:   websocketActionPopulate,
-				Features: features,
-			}
-			b, _ := json.Marshal(bc)
-			s.Write(b)
-		}
+	// Replay the last pushes to new clients.
+	s.melodyInstance.HandleConnect(func(session *melody.Session) {
+		log.Println("[websocket] connected", session.Request.RemoteAddr)
+		s.replayLastPushes(session)
 	})
 
-	// Right now don't care about incoming messages from clients. Log and drop.
-	s.melodyInstance.HandleMessage(loggingHandler)
+	// Incoming messages update the client's subscription,
+	// and replay the last pushes it now wants.
+	s.melodyInstance.HandleMessage(func(session *melody.Session, msg []byte) {
+		sub, err := parseWebsocketSubscription(msg)
+		if err != nil {
+			log.Println("[websocket] invalid message", session.Request.RemoteAddr, err)
+			b, _ := json.Marshal(map[string]string{"error": err.Error()})
+			_ = session.Write(b)
+			return
+		}
+		if sub.Action == websocketActionUnsubscribe {
+			session.UnSet(websocketSessionKeySubscription)
+		} else {
+			session.Set(websocketSessionKeySubscription, sub)
+		}
+		s.replayLastPushes(session)
+	})
 
 	s.melodyInstance.HandleDisconnect(func(s *melody.Session) {
 		log.Println("[websocket] disconnected", s.Request.RemoteAddr)
@@ -50,30 +274,19 @@ func (s *WebDaemon) initMelody() {
 		log.Println("[websocket] error", e, s.Request.RemoteAddr)
 	})
 
-	// Broadcast track push events (i.e. 'populate') - as received - to all connected clients.
-	// This can result in invalid or duplicate cat tracks being sent to clients,
-	// if they cat sends them to us.
-	// Cat track population WILL ENFORCE validation and deduplication, etc. -
-	// but THIS DATA IS NOT THE ULTIMATELY STORED DATA.
-	// It is the data the cat sent us.
+	// Broadcast populated tracks to connected clients.
+	// These are the tracks stored by populate, after validation and deduplication,
+	// privatized by the cats' privacy zones.
 	pushes := make(chan []*cattrack.CatTrack)
 	pushSub := s.feedPopulated.Subscribe(pushes)
 	go func() {
 		for {
 			select {
 			case features := <-pushes:
-				bc := broadcats{
+				s.broadcast(broadcats{
 					Action:   websocketActionPopulate,
-					Features: features,
-				}
-				b, err := json.Marshal(bc)
-				if err != nil {
-					slog.Error("Failed to marshal populate event", "error", err)
-					continue
-				}
-				if err := s.melodyInstance.Broadcast(b); err != nil {
-					slog.Warn("Failed to broadcast populate event", "error", err)
-				}
+					Features: s.publicTracks(features),
+				})
 			case err := <-pushSub.Err():
 				slog.Error("Failed to subscribe to HTTPPopulateFeed", "error", err)
 				return
@@ -82,8 +295,3 @@ func (s *WebDaemon) initMelody() {
 	}()
 	return
 }
-
-// on request
-func loggingHandler(s *melody.Session, msg []byte) {
-	log.Println("[websocket] message", string(msg))
-}
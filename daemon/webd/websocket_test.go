@@ -0,0 +1,155 @@
+package webd
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/api"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/types/cattrack"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseWebsocketSubscription(t *testing.T) {
+	cases := []struct {
+		msg string
+		ok  bool
+	}{
+		{`{"action": "subscribe"}`, true},
+		{`{"action": "subscribe", "cats": ["rye"], "events": ["populate", "lap"], "bbox": [-94, 44, -93, 45]}`, true},
+		{`{"action": "unsubscribe"}`, true},
+		{`{"action": "dance"}`, false},
+		{`{"action": "subscribe", "events": ["snap"]}`, true},
+		{`{"action": "subscribe", "events": ["dance"]}`, false},
+		{`{"action": "subscribe", "events": ["geofence"]}`, true},
+		{`{"action": "subscribe", "bbox": [-94, 44, -93]}`, false},
+		{`{"action": "subscribe", "bbox": [-93, 44, -94, 45]}`, false},
+		{`not json`, false},
+	}
+	for _, c := range cases {
+		_, err := parseWebsocketSubscription([]byte(c.msg))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: got err=%v, want ok=%v", c.msg, err, c.ok)
+		}
+	}
+}
+
+func TestWebsocketSubscription_filter(t *testing.T) {
+	track := func(cat string, pt orb.Point) *cattrack.CatTrack {
+		ct := cattrack.NewCatTrack(pt)
+		ct.Properties["Name"] = cat
+		return ct
+	}
+	bc := broadcats{
+		Action: websocketActionPopulate,
+		Features: []*cattrack.CatTrack{
+			track("rye", orb.Point{-93.5, 44.5}),
+			track("rye", orb.Point{-100, 40}),
+			track("ia", orb.Point{-93.5, 44.5}),
+		},
+	}
+
+	var all *websocketSubscription
+	if got, ok := all.filter(bc); !ok || len(got.Features) != 3 {
+		t.Errorf("expected no subscription to get all, got %d", len(got.Features))
+	}
+
+	sub, err := parseWebsocketSubscription([]byte(`{"action": "subscribe", "cats": ["rye"], "bbox": [-94, 44, -93, 45]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := sub.filter(bc)
+	if !ok || len(got.Features) != 1 || got.Features[0] != bc.Features[0] {
+		t.Errorf("expected only rye in bbox, got %v", got.Features)
+	}
+	if len(bc.Features) != 3 {
+		t.Error("expected broadcast unmodified")
+	}
+
+	laps, err := parseWebsocketSubscription([]byte(`{"action": "subscribe", "events": ["lap"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := laps.filter(bc); ok {
+		t.Error("expected populate filtered from lap subscription")
+	}
+
+	// Geofence events are only sent to subscriptions naming them.
+	geofence := broadcats{
+		Action: websocketActionGeofence,
+		Events: []api.GeofenceEvent{
+			{CatID: "rye", Fence: "home", Type: api.GeofenceEventEnter},
+			{CatID: "ia", Fence: "home", Type: api.GeofenceEventEnter},
+		},
+	}
+	for _, s := range []*websocketSubscription{all, sub, laps} {
+		if _, ok := s.filter(geofence); ok {
+			t.Errorf("expected geofence events filtered from %+v", s)
+		}
+	}
+	fences, err := parseWebsocketSubscription([]byte(`{"action": "subscribe", "cats": ["rye"], "events": ["geofence"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok = fences.filter(geofence)
+	if !ok || len(got.Events) != 1 || got.Events[0].CatID != "rye" {
+		t.Errorf("expected rye geofence event, got %v", got.Events)
+	}
+}
+
+func TestFilterTokenCats(t *testing.T) {
+	bc := broadcats{
+		Action: websocketActionPopulate,
+		Features: []*cattrack.CatTrack{
+			{Properties: map[string]any{"Name": "rye"}},
+			{Properties: map[string]any{"Name": "ia"}},
+		},
+	}
+	if got, ok := filterTokenCats(nil, bc); !ok || len(got.Features) != 2 {
+		t.Errorf("expected no token to get all, got %d", len(got.Features))
+	}
+	friend := &Token{Name: "friend", Cats: []conceptual.CatID{"rye"}, Actions: []TokenAction{TokenActionRead}}
+	got, ok := filterTokenCats(friend, bc)
+	if !ok || len(got.Features) != 1 || got.Features[0].CatID() != "rye" {
+		t.Errorf("expected only rye, got %v", got.Features)
+	}
+	if _, ok := filterTokenCats(friend, broadcats{Action: websocketActionLap, Features: bc.Features[1:]}); ok {
+		t.Error("expected ia's lap filtered")
+	}
+	geofence := broadcats{
+		Action: websocketActionGeofence,
+		Events: []api.GeofenceEvent{{CatID: "ia", Fence: "home", Type: api.GeofenceEventExit}},
+	}
+	if _, ok := filterTokenCats(friend, geofence); ok {
+		t.Error("expected ia's geofence event filtered")
+	}
+}
+
+func TestWebDaemon_socatAuthentication(t *testing.T) {
+	d, teardown := newTestWebDaemon("")
+	defer teardown()
+	t.Setenv("COTOKEN", "")
+	d.Config.RequireReadToken = true
+	friend, err := d.tokens.Add("friend", []conceptual.CatID{"rye"}, []TokenAction{TokenActionRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := d.NewRouter()
+	defer d.melodyInstance.Close()
+	// The websocket hub opens asynchronously.
+	for deadline := time.Now().Add(time.Second); d.melodyInstance.IsClosed() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Requests are not upgraded here; authenticated ones reach the websocket handler, which rejects them.
+	for token, want := range map[string]int{"": http.StatusForbidden, "wrong": http.StatusForbidden, friend: http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodGet, "/socat", nil)
+		req.Header.Set("AuthorizationOfCats", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("token %q: got %d, want %d", token, w.Code, want)
+		}
+	}
+}
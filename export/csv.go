@@ -0,0 +1,48 @@
+package export
+
+import (
+	"encoding/csv"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"time", "lon", "lat", "elevation", "accuracy", "speed", "heading", "heart_rate", "activity", "lap"}
+
+func writeCSV(w io.Writer, ex *Export, tracks <-chan cattrack.CatTrack) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	optional := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	segments := newSegmenter(ex.Laps)
+	for ct := range tracks {
+		p := pointOf(ct)
+		lap := ""
+		if _, i := segments.segment(p.time); i >= 0 {
+			lap = strconv.Itoa(i + 1)
+		}
+		if err := cw.Write([]string{
+			p.time.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(p.lon, 'f', -1, 64),
+			strconv.FormatFloat(p.lat, 'f', -1, 64),
+			optional(p.elevation),
+			optional(p.accuracy),
+			optional(p.speed),
+			optional(p.heading),
+			optional(p.heartRate),
+			p.activity,
+			lap,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
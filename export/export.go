@@ -0,0 +1,161 @@
+// Package export writes cat tracks, laps and naps as GPX, KML and CSV,
+// for handing off to other apps, like Strava or Google Earth.
+package export
+
+import (
+	"fmt"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatGPX Format = "gpx"
+	FormatKML Format = "kml"
+	FormatCSV Format = "csv"
+)
+
+var Formats = []Format{FormatGPX, FormatKML, FormatCSV}
+
+// ParseFormat parses a format name, eg. "gpx".
+func ParseFormat(s string) (Format, error) {
+	f := Format(s)
+	if !slices.Contains(Formats, f) {
+		return "", fmt.Errorf("unknown export format %q (valid: %v)", s, Formats)
+	}
+	return f, nil
+}
+
+// ContentType returns the format's MIME type.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatGPX:
+		return "application/gpx+xml"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	case FormatCSV:
+		return "text/csv"
+	}
+	return "application/octet-stream"
+}
+
+// Export is a cat's laps and naps, written alongside its tracks.
+type Export struct {
+	// Name names the document, eg. the cat.
+	Name string
+	Laps []cattrack.CatLap
+	Naps []cattrack.CatNap
+}
+
+// Write writes the export, and the tracks read from the channel until it is closed, in the format.
+// Tracks should be in chronological order.
+//
+// GPX gets a waypoint for each nap, and one track with a segment for each lap's tracks,
+// and for the tracks between laps. Track points have elevation, and heart rate and speed
+// in Garmin TrackPointExtension elements.
+// KML gets folders of naps, laps as LineStrings styled by activity, and tracks.
+// CSV gets a row for each track, with the number of its lap, if any.
+func Write(w io.Writer, f Format, ex *Export, tracks <-chan cattrack.CatTrack) error {
+	if ex == nil {
+		ex = &Export{}
+	}
+	laps := slices.Clone(ex.Laps)
+	sort.SliceStable(laps, func(i, j int) bool {
+		return lapNapStart(laps[i].Properties).Before(lapNapStart(laps[j].Properties))
+	})
+	ex = &Export{Name: ex.Name, Laps: laps, Naps: ex.Naps}
+	switch f {
+	case FormatGPX:
+		return writeGPX(w, ex, tracks)
+	case FormatKML:
+		return writeKML(w, ex, tracks)
+	case FormatCSV:
+		return writeCSV(w, ex, tracks)
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// lapNapStart returns the start time of a lap or nap.
+func lapNapStart(props map[string]any) time.Time {
+	return unixProperty(props, "Time_Start_Unix")
+}
+
+// lapNapEnd returns the end time of a lap or nap.
+func lapNapEnd(props map[string]any) time.Time {
+	return unixProperty(props, "Time_End_Unix")
+}
+
+func unixProperty(props map[string]any, key string) time.Time {
+	switch v := props[key].(type) {
+	case float64:
+		return time.Unix(int64(v), 0)
+	case int64:
+		return time.Unix(v, 0)
+	case int:
+		return time.Unix(int64(v), 0)
+	}
+	return time.Time{}
+}
+
+// point is the exported fields of a track.
+type point struct {
+	lon, lat  float64
+	time      time.Time
+	elevation *float64
+	speed     *float64
+	heartRate *float64
+	accuracy  *float64
+	heading   *float64
+	activity  string
+}
+
+func pointOf(ct cattrack.CatTrack) point {
+	pt := ct.Point()
+	p := point{lon: pt.Lon(), lat: pt.Lat(), time: ct.MustTime()}
+	optional := func(key string) *float64 {
+		v, ok := ct.Properties[key].(float64)
+		if !ok {
+			return nil
+		}
+		return &v
+	}
+	p.elevation = optional("Elevation")
+	p.speed = optional("Speed")
+	p.heartRate = optional("HeartRate")
+	p.accuracy = optional("Accuracy")
+	p.heading = optional("Heading")
+	if act := ct.MustActivity(); act.IsKnown() {
+		p.activity = act.String()
+	}
+	return p
+}
+
+// segmenter assigns tracks to segments: one for each lap, and one for each gap between laps.
+type segmenter struct {
+	starts, ends []time.Time
+}
+
+func newSegmenter(laps []cattrack.CatLap) *segmenter {
+	s := &segmenter{}
+	for _, lap := range laps {
+		s.starts = append(s.starts, lapNapStart(lap.Properties))
+		s.ends = append(s.ends, lapNapEnd(lap.Properties))
+	}
+	return s
+}
+
+// segment returns the track time's segment, and the index of its lap, or -1 if between laps.
+// Segments increase with time.
+func (s *segmenter) segment(t time.Time) (segment, lap int) {
+	i := sort.Search(len(s.ends), func(i int) bool {
+		return !s.ends[i].Before(t)
+	})
+	if i < len(s.starts) && !s.starts[i].After(t) {
+		return 2*i + 1, i
+	}
+	return 2 * i, -1
+}
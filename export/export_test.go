@@ -0,0 +1,154 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/types/cattrack"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTracks(start time.Time, n int) []cattrack.CatTrack {
+	out := []cattrack.CatTrack{}
+	for i := 0; i < n; i++ {
+		ct := cattrack.NewCatTrack(orb.Point{-93 + float64(i)*0.001, 45})
+		ts := start.Add(time.Duration(i) * time.Minute)
+		ct.Properties["Time"] = ts.Format(time.RFC3339)
+		ct.Properties["UnixTime"] = ts.Unix()
+		ct.Properties["Elevation"] = 250.5
+		ct.Properties["Speed"] = 5.0
+		ct.Properties["Activity"] = "Bike"
+		if i%2 == 0 {
+			ct.Properties["HeartRate"] = 120.0
+		}
+		out = append(out, *ct)
+	}
+	return out
+}
+
+func testLap(start, end time.Time) cattrack.CatLap {
+	ct := cattrack.NewCatTrack(orb.LineString{{-93, 45}, {-92.99, 45}})
+	ct.Properties["Time_Start_Unix"] = float64(start.Unix())
+	ct.Properties["Time_End_Unix"] = float64(end.Unix())
+	ct.Properties["Activity"] = "Bike"
+	ct.Properties["Distance_Traversed"] = 1234.0
+	return cattrack.CatLap(*ct)
+}
+
+func chanOf(tracks []cattrack.CatTrack) <-chan cattrack.CatTrack {
+	ch := make(chan cattrack.CatTrack, len(tracks))
+	for _, ct := range tracks {
+		ch <- ct
+	}
+	close(ch)
+	return ch
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range Formats {
+		if got, err := ParseFormat(string(f)); err != nil || got != f {
+			t.Errorf("%s: got %s, %v", f, got, err)
+		}
+	}
+	if _, err := ParseFormat("shp"); err == nil {
+		t.Error("expected unknown format error")
+	}
+}
+
+func TestWrite_GPX(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracks := testTracks(start, 6)
+	ex := &Export{Name: "rye", Laps: []cattrack.CatLap{testLap(start.Add(2*time.Minute), start.Add(10*time.Minute))}}
+	buf := &bytes.Buffer{}
+	if err := Write(buf, FormatGPX, ex, chanOf(tracks)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<gpxtpx:hr>120</gpxtpx:hr>") || !strings.Contains(buf.String(), "<gpxtpx:speed>5</gpxtpx:speed>") {
+		t.Errorf("expected heart rate and speed extensions:\n%s", buf.String())
+	}
+	gpx := struct {
+		Trk struct {
+			Name string `xml:"name"`
+			Segs []struct {
+				Pts []struct {
+					Ele  float64 `xml:"ele"`
+					Time string  `xml:"time"`
+				} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &gpx); err != nil {
+		t.Fatal(err)
+	}
+	if gpx.Trk.Name != "rye" || len(gpx.Trk.Segs) != 2 {
+		t.Fatalf("expected rye track with 2 segments, got %+v", gpx.Trk)
+	}
+	if len(gpx.Trk.Segs[0].Pts) != 2 || len(gpx.Trk.Segs[1].Pts) != 4 {
+		t.Errorf("expected 2 then 4 points, got %d, %d", len(gpx.Trk.Segs[0].Pts), len(gpx.Trk.Segs[1].Pts))
+	}
+	if pt := gpx.Trk.Segs[0].Pts[0]; pt.Ele != 250.5 || pt.Time != "2024-06-01T12:00:00Z" {
+		t.Errorf("unexpected point %+v", pt)
+	}
+}
+
+func TestWrite_KML(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ex := &Export{Name: "rye & ia", Laps: []cattrack.CatLap{testLap(start, start.Add(time.Hour))}}
+	buf := &bytes.Buffer{}
+	if err := Write(buf, FormatKML, ex, chanOf(testTracks(start, 3))); err != nil {
+		t.Fatal(err)
+	}
+	kml := struct {
+		Document struct {
+			Name    string `xml:"name"`
+			Folders []struct {
+				Name       string `xml:"name"`
+				Placemarks []struct {
+					Name     string `xml:"name"`
+					StyleURL string `xml:"styleUrl"`
+				} `xml:"Placemark"`
+			} `xml:"Folder"`
+		} `xml:"Document"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &kml); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if kml.Document.Name != "rye & ia" || len(kml.Document.Folders) != 3 {
+		t.Fatalf("unexpected document %+v", kml.Document)
+	}
+	laps := kml.Document.Folders[1]
+	if len(laps.Placemarks) != 1 || laps.Placemarks[0].StyleURL != "#lap-Bike" || laps.Placemarks[0].Name != "Bike 1.2 km" {
+		t.Errorf("unexpected laps %+v", laps)
+	}
+	if tracks := kml.Document.Folders[2]; len(tracks.Placemarks) != 1 {
+		t.Errorf("expected one track line, got %+v", tracks)
+	}
+}
+
+func TestWrite_CSV(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ex := &Export{Laps: []cattrack.CatLap{testLap(start.Add(time.Minute), start.Add(time.Minute))}}
+	buf := &bytes.Buffer{}
+	if err := Write(buf, FormatCSV, ex, chanOf(testTracks(start, 3))); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected header and 3 rows, got %d", len(rows))
+	}
+	want := []string{"2024-06-01T12:01:00Z", "-92.999", "45", "250.5", "", "5", "", "", "Bike", "1"}
+	for i, v := range want {
+		if rows[2][i] != v {
+			t.Errorf("%s: got %q, want %q", csvHeader[i], rows[2][i], v)
+		}
+	}
+	if rows[1][len(want)-1] != "" {
+		t.Error("expected no lap before the lap")
+	}
+}
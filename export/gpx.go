@@ -0,0 +1,138 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"strings"
+	"time"
+)
+
+const gpxHeader = xml.Header + `<gpx version="1.1" creator="catd" xmlns="http://www.topografix.com/GPX/1/1" ` +
+	`xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">
+`
+
+type gpxWpt struct {
+	XMLName xml.Name `xml:"wpt"`
+	Lat     float64  `xml:"lat,attr"`
+	Lon     float64  `xml:"lon,attr"`
+	Ele     *float64 `xml:"ele,omitempty"`
+	Time    string   `xml:"time,omitempty"`
+	Name    string   `xml:"name,omitempty"`
+	Desc    string   `xml:"desc,omitempty"`
+	Type    string   `xml:"type,omitempty"`
+}
+
+type gpxTrkpt struct {
+	XMLName    xml.Name       `xml:"trkpt"`
+	Lat        float64        `xml:"lat,attr"`
+	Lon        float64        `xml:"lon,attr"`
+	Ele        *float64       `xml:"ele,omitempty"`
+	Time       string         `xml:"time,omitempty"`
+	Type       string         `xml:"type,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+type gpxExtensions struct {
+	TrackPointExtension gpxTrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+}
+
+// gpxTrackPointExtension is a Garmin TrackPointExtension v2, which Strava reads heart rate from.
+type gpxTrackPointExtension struct {
+	HR    *float64 `xml:"gpxtpx:hr,omitempty"`
+	Speed *float64 `xml:"gpxtpx:speed,omitempty"`
+}
+
+func writeGPX(w io.Writer, ex *Export, tracks <-chan cattrack.CatTrack) error {
+	if _, err := io.WriteString(w, gpxHeader); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	// encode writes the element on its own line.
+	encode := func(indent string, v any) error {
+		if _, err := io.WriteString(w, indent); err != nil {
+			return err
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	if ex.Name != "" {
+		if err := encode("  ", struct {
+			XMLName xml.Name `xml:"metadata"`
+			Name    string   `xml:"name"`
+		}{Name: ex.Name}); err != nil {
+			return err
+		}
+	}
+
+	// Waypoints come before tracks.
+	for _, nap := range ex.Naps {
+		ct := cattrack.Nap2Track(nap)
+		pt := ct.Point()
+		wpt := gpxWpt{
+			Lat:  pt.Lat(),
+			Lon:  pt.Lon(),
+			Name: "Nap",
+			Type: "nap",
+		}
+		if v, ok := ct.Properties["Elevation_Median"].(float64); ok {
+			wpt.Ele = &v
+		}
+		if start := lapNapStart(ct.Properties); !start.IsZero() {
+			end := lapNapEnd(ct.Properties)
+			wpt.Time = start.UTC().Format(time.RFC3339)
+			wpt.Desc = fmt.Sprintf("Napped %s until %s", end.Sub(start), end.UTC().Format(time.RFC3339))
+		}
+		if err := encode("  ", wpt); err != nil {
+			return err
+		}
+	}
+
+	segments := newSegmenter(ex.Laps)
+	segment, open := -1, false
+	for ct := range tracks {
+		p := pointOf(ct)
+		seg, _ := segments.segment(p.time)
+		if !open {
+			if _, err := fmt.Fprintf(w, "  <trk>\n    <name>%s</name>\n    <trkseg>\n", xmlEscape(ex.Name)); err != nil {
+				return err
+			}
+			open = true
+		} else if seg != segment {
+			if _, err := io.WriteString(w, "    </trkseg>\n    <trkseg>\n"); err != nil {
+				return err
+			}
+		}
+		segment = seg
+		trkpt := gpxTrkpt{
+			Lat:  p.lat,
+			Lon:  p.lon,
+			Ele:  p.elevation,
+			Time: p.time.UTC().Format(time.RFC3339),
+			Type: p.activity,
+		}
+		if p.heartRate != nil || p.speed != nil {
+			trkpt.Extensions = &gpxExtensions{gpxTrackPointExtension{HR: p.heartRate, Speed: p.speed}}
+		}
+		if err := encode("      ", trkpt); err != nil {
+			return err
+		}
+	}
+	if open {
+		if _, err := io.WriteString(w, "    </trkseg>\n  </trk>\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</gpx>\n")
+	return err
+}
+
+func xmlEscape(s string) string {
+	b := &strings.Builder{}
+	_ = xml.EscapeText(b, []byte(s))
+	return b.String()
+}
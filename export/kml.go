@@ -0,0 +1,133 @@
+package export
+
+import (
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/rotblauer/catd/types/cattrack"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kmlActivityColors are the lap line colors, as KML aabbggrr.
+var kmlActivityColors = map[activity.Activity]string{
+	activity.TrackerStateUnknown:    "ff888888",
+	activity.TrackerStateStationary: "ffbbbbbb",
+	activity.TrackerStateWalking:    "ff00c800",
+	activity.TrackerStateRunning:    "ff00a5ff",
+	activity.TrackerStateBike:       "ffff6400",
+	activity.TrackerStateAutomotive: "ff0000ff",
+	activity.TrackerStateFlying:     "ffff00ff",
+}
+
+func kmlCoordinate(b *strings.Builder, pt orb.Point, elevation *float64) {
+	b.WriteString(strconv.FormatFloat(pt.Lon(), 'f', -1, 64))
+	b.WriteByte(',')
+	b.WriteString(strconv.FormatFloat(pt.Lat(), 'f', -1, 64))
+	if elevation != nil {
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(*elevation, 'f', -1, 64))
+	}
+}
+
+func kmlTimeSpan(start, end time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("<TimeSpan><begin>%s</begin><end>%s</end></TimeSpan>",
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+}
+
+func writeKML(w io.Writer, ex *Export, tracks <-chan cattrack.CatTrack) error {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+`)
+	fmt.Fprintf(b, "  <name>%s</name>\n", xmlEscape(ex.Name))
+	for _, name := range activity.AllActivityNames {
+		act := activity.FromString(name)
+		fmt.Fprintf(b, "  <Style id=\"lap-%s\"><LineStyle><color>%s</color><width>4</width></LineStyle></Style>\n",
+			act, kmlActivityColors[act])
+	}
+	b.WriteString("  <Style id=\"track\"><LineStyle><color>99ffffff</color><width>2</width></LineStyle></Style>\n")
+
+	b.WriteString("  <Folder>\n    <name>Naps</name>\n")
+	for _, nap := range ex.Naps {
+		ct := cattrack.Nap2Track(nap)
+		start, end := lapNapStart(ct.Properties), lapNapEnd(ct.Properties)
+		fmt.Fprintf(b, "    <Placemark><name>Nap</name>%s", kmlTimeSpan(start, end))
+		if !start.IsZero() {
+			fmt.Fprintf(b, "<description>Napped %s</description>", end.Sub(start))
+		}
+		b.WriteString("<Point><coordinates>")
+		kmlCoordinate(b, ct.Point(), nil)
+		b.WriteString("</coordinates></Point></Placemark>\n")
+	}
+	b.WriteString("  </Folder>\n")
+
+	b.WriteString("  <Folder>\n    <name>Laps</name>\n")
+	for _, lap := range ex.Laps {
+		ls, ok := lap.Geometry.(orb.LineString)
+		if !ok || len(ls) == 0 {
+			continue
+		}
+		act := activity.FromAny(lap.Properties["Activity"])
+		start, end := lapNapStart(lap.Properties), lapNapEnd(lap.Properties)
+		name := act.String()
+		if d, ok := lap.Properties["Distance_Traversed"].(float64); ok {
+			name += fmt.Sprintf(" %.1f km", d/1000)
+		}
+		fmt.Fprintf(b, "    <Placemark><name>%s</name>%s<styleUrl>#lap-%s</styleUrl><LineString><tessellate>1</tessellate><coordinates>",
+			xmlEscape(name), kmlTimeSpan(start, end), act)
+		for i, pt := range ls {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			kmlCoordinate(b, pt, nil)
+		}
+		b.WriteString("</coordinates></LineString></Placemark>\n")
+	}
+	b.WriteString("  </Folder>\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	// Tracks are streamed, a LineString for each lap, and each gap between laps.
+	if _, err := io.WriteString(w, "  <Folder>\n    <name>Tracks</name>\n"); err != nil {
+		return err
+	}
+	segments := newSegmenter(ex.Laps)
+	segment, open := -1, false
+	closePlacemark := "</coordinates></LineString></Placemark>\n"
+	for ct := range tracks {
+		p := pointOf(ct)
+		seg, _ := segments.segment(p.time)
+		b.Reset()
+		if open && seg != segment {
+			b.WriteString(closePlacemark)
+			open = false
+		}
+		if !open {
+			fmt.Fprintf(b, "    <Placemark><name>%s</name><styleUrl>#track</styleUrl><LineString><coordinates>",
+				p.time.UTC().Format(time.RFC3339))
+			open = true
+		} else {
+			b.WriteByte(' ')
+		}
+		segment = seg
+		kmlCoordinate(b, orb.Point{p.lon, p.lat}, p.elevation)
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	b.Reset()
+	if open {
+		b.WriteString(closePlacemark)
+	}
+	b.WriteString("  </Folder>\n</Document>\n</kml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -37,6 +37,7 @@ func DefaultCatDataDirRooted(dataDirRoot string, catID string) string {
 
 var CatStateBucket = []byte("state")
 var CatSnapBucket = []byte("snaps")
+var CatGeofenceEventsBucket = []byte("geofence_events")
 
 // CatStateKey_* are the names of the keys of various state objects in the CatState.
 
@@ -46,6 +47,9 @@ var CatStateKey_Unbacktracker = []byte("unbacktracker")
 var CatStateKey_Laps = []byte("laps")
 var CatStateKey_Naps = []byte("naps")
 var CatStateKey_OffsetIndexer = []byte("offset_indexer")
+var CatStateKey_PrivacyZones = []byte("privacy_zones")
+var CatStateKey_Geofences = []byte("geofences")
+var CatStateKey_GeofenceState = []byte("geofence_state")
 
 // v0
 //var CatStateKey_ActImprover = []byte("act-improver")
@@ -18,16 +18,27 @@ func init() {
 const (
 	CatStateDBName = "state.db"
 	RgeoDBName     = "rgeo.db"
-	S2DBName       = "s2.db"
-	TiledDBName    = "tile.db"
+	// S2DBName is each cat's S2 index, and, nested directly under the datadir root,
+	// the all-cats S2 index.
+	S2DBName    = "s2.db"
+	TiledDBName = "tile.db"
+	// WebDBName is webd's database, nested directly under the datadir root.
+	// It holds the API token store.
+	WebDBName = "web.db"
 
 	// CatsDir is the cats/ subdirectory name, nested directly under the datadir root.
 	CatsDir = "cats"
 	// CatTracksDir is the cats/<catID>/"tracks"/ subdirectory name, nested under the catID.
 	// This is used only with YYYY-MM storage.
 	CatTracksDir = "tracks"
+	// CatTracksIndexSuffix is appended to a tracks/YYYY-MM.geojson.gz archive name
+	// to name its sparse time index sidecar file.
+	CatTracksIndexSuffix = ".idx"
 	// CatSnapsDir is the cats/<catID>/"snaps"/ subdirectory name, nested under the catID.
 	CatSnapsSubdir = "snaps"
+	// PopulateQueueDir is the queue/ subdirectory name, nested directly under the datadir root,
+	// holding webd's per-cat spools of populate request bodies.
+	PopulateQueueDir = "queue"
 
 	MasterGZFileName     = "master.json.gz"
 	TracksGZFileName     = "tracks.geojson.gz"
@@ -63,6 +74,16 @@ var DefaultSortSize = Optimal
 // Used widely for channels caps.
 var DefaultChannelCap = Optimal
 
+// TracksIndexInterval is the track-time interval at which YYYY-MM tracks archives
+// begin a new gzip member, and thus the granularity of their sparse time index.
+var TracksIndexInterval = time.Hour
+
+// ProducersEnabled, if non-empty, names the only producers run in cat producer pipelines.
+// ProducersDisabled names producers which will not be run.
+// Producers are registered in package api.
+var ProducersEnabled []string
+var ProducersDisabled []string
+
 // DedupeCacheSize is the default size for the dedupe cache.
 var DedupeCacheSize = int((1 * time.Hour).Seconds())
 
@@ -0,0 +1,73 @@
+package params
+
+import (
+	"os"
+	"strconv"
+)
+
+// SnapStoreKind names a backend storing cat snap images, besides the cat's own state.
+type SnapStoreKind string
+
+const (
+	// SnapStoreNoop stores snaps only in the cat state; snaps get no imgS3 URL.
+	SnapStoreNoop SnapStoreKind = "noop"
+	// SnapStoreLocal stores snaps only in the cat state, but still names them with imgS3 URLs,
+	// so that they can be served or synced to a bucket later.
+	SnapStoreLocal SnapStoreKind = "local"
+	// SnapStoreS3 uploads snaps to an S3 bucket, AWS or any S3-compatible endpoint, eg. MinIO.
+	SnapStoreS3 SnapStoreKind = "s3"
+)
+
+// SnapStoreConfig configures where cat snap images are stored.
+type SnapStoreConfig struct {
+	Kind SnapStoreKind
+
+	// Bucket names the bucket, and is the first part of snaps' imgS3 values: bucket/key.
+	Bucket string
+
+	// Endpoint is the URL of an S3-compatible endpoint, eg. http://localhost:9000.
+	// Empty uses AWS.
+	Endpoint string
+
+	// Region is the S3 region. Empty uses the AWS SDK's environment.
+	Region string
+
+	// PathStyle addresses buckets in the path (endpoint/bucket/key)
+	// instead of the host (bucket.endpoint/key), as MinIO and most self-hosted stores want.
+	PathStyle bool
+}
+
+// SnapStore is the snap store configuration, read from the environment:
+//
+//	CATD_SNAPS_STORE          noop, local or s3. Defaults to s3 with a bucket, else noop.
+//	AWS_BUCKETNAME            the bucket.
+//	CATD_SNAPS_S3_ENDPOINT    an S3-compatible endpoint.
+//	CATD_SNAPS_S3_REGION      the region.
+//	CATD_SNAPS_S3_PATH_STYLE  true for path-style addressing.
+var SnapStore = SnapStoreConfigFromEnv()
+
+// SnapStoreConfigFromEnv reads a snap store configuration from the environment, see SnapStore.
+func SnapStoreConfigFromEnv() *SnapStoreConfig {
+	c := &SnapStoreConfig{
+		Kind:     SnapStoreKind(os.Getenv("CATD_SNAPS_STORE")),
+		Bucket:   AWS_BUCKETNAME,
+		Endpoint: os.Getenv("CATD_SNAPS_S3_ENDPOINT"),
+		Region:   os.Getenv("CATD_SNAPS_S3_REGION"),
+	}
+	c.PathStyle, _ = strconv.ParseBool(os.Getenv("CATD_SNAPS_S3_PATH_STYLE"))
+	if c.Kind == "" {
+		c.Kind = SnapStoreNoop
+		if c.Bucket != "" {
+			c.Kind = SnapStoreS3
+		}
+	}
+	return c
+}
+
+// SnapThumbnailSizes are the thumbnails made of each snap, by name,
+// sized to fit the given pixels on their longer side.
+var SnapThumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 512,
+	"large":  1024,
+}
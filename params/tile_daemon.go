@@ -37,6 +37,52 @@ type TileDaemonConfig struct {
 	// AwaitPendingOnShutdown will cause the daemon to wait for all pending
 	// tiling requests to complete (which may take a long time).
 	AwaitPendingOnShutdown bool
+
+	// TilingBackend is the default tiling backend.
+	TilingBackend TilingBackend
+
+	// TilingBackends override the default TilingBackend for named tippe configs.
+	TilingBackends map[TippeConfigName]TilingBackend
+
+	// NativeTilingSourceBytesLimit, if positive, is the most source bytes a native tiling run takes.
+	// The native backend holds all of a run's features, and a zoom's tiles, in memory,
+	// so it is for edge runs and small layers; runs over the limit fail, and should use tippecanoe.
+	NativeTilingSourceBytesLimit int64
+
+	// TilingHistoryLimit is the number of completed tiling runs kept per source,
+	// for status reports.
+	TilingHistoryLimit int
+
+	// TilingConcurrency is the most tiling runs at once,
+	// both as pending requests come up and on the shutdown drain.
+	// Zero is unlimited.
+	TilingConcurrency int
+
+	// TilingCanonicalConcurrency is the most canonical tiling runs at once.
+	// Canonical runs tile all of a source, so they take the longest and use the most memory.
+	// Zero is limited only by TilingConcurrency.
+	TilingCanonicalConcurrency int
+
+	// TilingSourceBytesBudget, if positive, limits the total source bytes of the runs at once,
+	// as a proxy for their memory use. A run over the budget runs alone.
+	// A run waiting on the budget holds it, so runs behind it wait too.
+	TilingSourceBytesBudget int64
+
+	// TilingPriorities rank the runs of named tippe configs.
+	// Waiting runs are admitted highest priority first, then first come first served.
+	// Unlisted tippe configs have priority 0.
+	TilingPriorities map[TippeConfigName]int
+}
+
+// TilingBackendFor returns the tiling backend for the named tippe config.
+func (c *TileDaemonConfig) TilingBackendFor(name TippeConfigName) TilingBackend {
+	if b, ok := c.TilingBackends[name]; ok {
+		return b
+	}
+	if c.TilingBackend == "" {
+		return TilingBackendTippecanoe
+	}
+	return c.TilingBackend
 }
 
 func DefaultTileDaemonConfig() *TileDaemonConfig {
@@ -65,5 +111,19 @@ func DefaultTileDaemonConfig() *TileDaemonConfig {
 			Address: "localhost:1234",
 		},
 		AwaitPendingOnShutdown: false,
+		TilingBackend:          TilingBackendTippecanoe,
+		TilingHistoryLimit:     10,
+
+		// Gzipped GeoJSON, many times bigger in memory.
+		NativeTilingSourceBytesLimit: 64 << 20,
+
+		// Small, frequent runs go before big ones.
+		TilingConcurrency:          4,
+		TilingCanonicalConcurrency: 2,
+		TilingPriorities: map[TippeConfigName]int{
+			TippeConfigNameLaps:  1,
+			TippeConfigNameNaps:  1,
+			TippeConfigNameSnaps: 1,
+		},
 	}
 }
@@ -2,6 +2,22 @@ package params
 
 var TippecanoeCommand = "/usr/local/bin/tippecanoe"
 
+// TilingBackend names an implementation of tiling, turning GeoJSON sources into .mbtiles.
+type TilingBackend string
+
+const (
+	// TilingBackendTippecanoe pipes sources to the TippecanoeCommand.
+	TilingBackendTippecanoe TilingBackend = "tippecanoe"
+
+	// TilingBackendNative tiles in-process, interpreting only a subset of the tippecanoe flags:
+	// zooms, includes, drop rate, simplification, buffer, and maximum tile bytes.
+	// It needs no external binaries, but holds a run's features in memory,
+	// so it is for edge runs and small layers, see TileDaemonConfig.NativeTilingSourceBytesLimit.
+	TilingBackendNative TilingBackend = "native"
+)
+
+var TilingBackends = []TilingBackend{TilingBackendTippecanoe, TilingBackendNative}
+
 type TippeConfigName string
 
 const (
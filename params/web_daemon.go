@@ -1,9 +1,49 @@
 package params
 
+import "time"
+
 type WebDaemonConfig struct {
 	ListenerConfig
 	DataDir          string
 	CatBackendConfig *CatRPCServices
+
+	// RequireReadToken requires a token scoped to the cat for the cat read routes,
+	// and a read token for the /socat websocket, which broadcasts only the token's cats.
+	// Otherwise, cat reads are public. See 'catd token'.
+	RequireReadToken bool
+
+	// PopulateQueue, if non-nil, makes /populate asynchronous.
+	// Request bodies are spooled to disk and acknowledged immediately,
+	// then populated by a worker pool.
+	PopulateQueue *PopulateQueueConfig
+
+	// GeofenceWebhookURL, if set, is POSTed each cat geofence event as JSON.
+	// Events are also published to the cat's /{cat}/events stream,
+	// and broadcast to websocket clients subscribed to geofence events.
+	GeofenceWebhookURL string
+}
+
+// CatEventsBufferSize is the number of recent events webd keeps per cat,
+// for /{cat}/events clients resuming with Last-Event-ID.
+var CatEventsBufferSize = 256
+
+// PopulateQueueConfig configures webd's async populate queue.
+type PopulateQueueConfig struct {
+	// Workers is the number of cats populated concurrently.
+	// Each cat's spooled requests are always populated one at a time, in order.
+	Workers int
+	// MaxAttempts is the number of times a spooled request is tried before it is set aside as failed.
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry. It doubles for each later attempt.
+	RetryDelay time.Duration
+}
+
+func DefaultPopulateQueueConfig() *PopulateQueueConfig {
+	return &PopulateQueueConfig{
+		Workers:     4,
+		MaxAttempts: 5,
+		RetryDelay:  5 * time.Second,
+	}
 }
 
 func DefaultWebListenerConfig() ListenerConfig {
@@ -0,0 +1,447 @@
+package reducer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/types/cattrack"
+	"math"
+	"time"
+)
+
+/*
+Indexed CatTracks are stored in a compact binary encoding, versioned by its first byte.
+Legacy values are gzipped JSON, and start with the gzip magic bytes (0x1f, 0x8b) instead.
+Values are read in either encoding, and written in the binary encoding,
+except for tracks without point geometry, which stay gzipped JSON.
+
+Version 1 layout:
+
+	version    byte (1)
+	flags      byte (bit 0: has bbox)
+	lon, lat   float64, little endian
+	bbox       4 float64, if flagged
+	id         value
+	properties uvarint count, then count times: key, value
+
+A key is a uvarint i: if i > 0, it is indexedKeysV1[i-1], else an inline string follows.
+Strings are a uvarint length, then the bytes. RFC3339 time strings, like the indexers' times, are tagged times.
+A value is a tag byte, then its data, see the indexedTag constants.
+Integers are zigzag varints. Decoded numbers are float64s, and other values are typed as if
+decoded from JSON, so that tracks read the same as they would have from the legacy encoding.
+*/
+
+const indexedEncodingV1 byte = 1
+
+const (
+	indexedTagNil byte = iota
+	indexedTagFalse
+	indexedTagTrue
+	indexedTagInt
+	indexedTagFloat
+	indexedTagString
+	indexedTagArray  // uvarint length, then values
+	indexedTagObject // uvarint length, then string keys and values
+	indexedTagJSON   // string, JSON of a value of any other type
+	indexedTagInts   // uvarint length, then varints; an array of whole numbers, eg. a histogram
+	indexedTagTime   // varints unix seconds and zone offset seconds; an RFC3339 string
+)
+
+// indexedKeysV1 are the common property keys of indexed tracks,
+// encoded by their index. The list is append-only.
+var indexedKeysV1 = []string{
+	// OffsetIndexT
+	"Count", "VisitCount", "FirstTime", "LastTime", "TotalTimeOffset",
+	"ActivityMode", "ActivityMode.Unknown", "ActivityMode.Stationary", "ActivityMode.Walking",
+	"ActivityMode.Running", "ActivityMode.Bike", "ActivityMode.Automotive", "ActivityMode.Fly",
+	"reducer_key", "TimeOffset",
+
+	// Cat tracks
+	"Name", "UUID", "Time", "UnixTime", "Version", "Alias",
+	"Accuracy", "vAccuracy", "Elevation", "Speed", "speed_accuracy", "Heading", "heading_accuracy",
+	"Activity", "ActivityConfidence", "Distance", "NumberOfSteps", "Pressure", "Lightmeter",
+	"BatteryLevel", "BatteryStatus", "AmbientTemp", "HeartRate", "NetworkInfo",
+	"AverageActivePace", "CurrentCadence", "CurrentPace", "CurrentTripStart", "FloorsAscended", "FloorsDescended",
+	"AccelerometerX", "AccelerometerY", "AccelerometerZ", "GyroscopeX", "GyroscopeY", "GyroscopeZ",
+	"UserAccelerometerX", "UserAccelerometerY", "UserAccelerometerZ",
+
+	// SpeedIndexT, ElevationIndexT, HourOfWeekIndexT, DeviceIndexT
+	"Speed.Count", "Speed.Min", "Speed.Max", "Speed.Sum", "Speed.Mean", "Speed.P50", "Speed.P90", "Speed.Bins",
+	"Elevation.Count", "Elevation.Min", "Elevation.Max", "Elevation.Range",
+	"HourOfWeek", "HourOfWeek.Peak", "HourOfWeek.Weekend",
+	"Devices", "DeviceCount",
+
+	// CatsIndexT
+	"Cats", "CatCount",
+}
+
+var indexedKeysV1Index = func() map[string]uint64 {
+	out := make(map[string]uint64, len(indexedKeysV1))
+	for i, k := range indexedKeysV1 {
+		out[k] = uint64(i + 1)
+	}
+	return out
+}()
+
+// encodeIndexed encodes an indexed CatTrack, see the binary encoding above.
+// The writer is reused for tracks without point geometry, which are encoded as gzipped JSON.
+func encodeIndexed(w *gzip.Writer, ct cattrack.CatTrack) ([]byte, error) {
+	pt, ok := ct.Geometry.(orb.Point)
+	if !ok {
+		return encodeIndexedGZJSON(w, ct)
+	}
+	out := make([]byte, 0, 512)
+	out = append(out, indexedEncodingV1)
+	var flags byte
+	if len(ct.BBox) == 4 {
+		flags |= 1
+	}
+	out = append(out, flags)
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(pt.Lon()))
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(pt.Lat()))
+	if flags&1 != 0 {
+		for _, f := range ct.BBox {
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(f))
+		}
+	}
+	var err error
+	if out, err = appendIndexedValue(out, ct.ID); err != nil {
+		return nil, err
+	}
+	out = binary.AppendUvarint(out, uint64(len(ct.Properties)))
+	for k, v := range ct.Properties {
+		if i, ok := indexedKeysV1Index[k]; ok {
+			out = binary.AppendUvarint(out, i)
+		} else {
+			out = binary.AppendUvarint(out, 0)
+			out = appendIndexedString(out, k)
+		}
+		if out, err = appendIndexedValue(out, v); err != nil {
+			return nil, fmt.Errorf("property %s: %w", k, err)
+		}
+	}
+	return out, nil
+}
+
+func appendIndexedString(out []byte, s string) []byte {
+	out = binary.AppendUvarint(out, uint64(len(s)))
+	return append(out, s...)
+}
+
+func appendIndexedInt(out []byte, i int64) []byte {
+	return binary.AppendVarint(append(out, indexedTagInt), i)
+}
+
+func appendIndexedFloat(out []byte, f float64) []byte {
+	// Whole numbers are denser as varints, and decode the same.
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return appendIndexedInt(out, int64(f))
+	}
+	return binary.LittleEndian.AppendUint64(append(out, indexedTagFloat), math.Float64bits(f))
+}
+
+func appendIndexedValue(out []byte, v any) ([]byte, error) {
+	var err error
+	switch t := v.(type) {
+	case nil:
+		return append(out, indexedTagNil), nil
+	case bool:
+		if t {
+			return append(out, indexedTagTrue), nil
+		}
+		return append(out, indexedTagFalse), nil
+	case int:
+		return appendIndexedInt(out, int64(t)), nil
+	case int64:
+		return appendIndexedInt(out, t), nil
+	case int32:
+		return appendIndexedInt(out, int64(t)), nil
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("unsupported value: %v", t)
+		}
+		return appendIndexedFloat(out, t), nil
+	case string:
+		if tm, ok := rfc3339Time(t); ok {
+			_, offset := tm.Zone()
+			out = binary.AppendVarint(append(out, indexedTagTime), tm.Unix())
+			return binary.AppendVarint(out, int64(offset)), nil
+		}
+		return appendIndexedString(append(out, indexedTagString), t), nil
+	case []int:
+		out = binary.AppendUvarint(append(out, indexedTagInts), uint64(len(t)))
+		for _, i := range t {
+			out = binary.AppendVarint(out, int64(i))
+		}
+		return out, nil
+	case []interface{}:
+		if ints, ok := wholeNumbers(t); ok {
+			return appendIndexedValue(out, ints)
+		}
+		out = binary.AppendUvarint(append(out, indexedTagArray), uint64(len(t)))
+		for _, e := range t {
+			if out, err = appendIndexedValue(out, e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		out = binary.AppendUvarint(append(out, indexedTagObject), uint64(len(t)))
+		for k, e := range t {
+			out = appendIndexedString(out, k)
+			if out, err = appendIndexedValue(out, e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]int:
+		out = binary.AppendUvarint(append(out, indexedTagObject), uint64(len(t)))
+		for k, i := range t {
+			out = appendIndexedInt(appendIndexedString(out, k), int64(i))
+		}
+		return out, nil
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		return appendIndexedString(append(out, indexedTagJSON), string(b)), nil
+	}
+}
+
+// rfc3339Time returns the time of an RFC3339 string with whole seconds,
+// if the time formats back to the same string.
+func rfc3339Time(s string) (time.Time, bool) {
+	if len(s) < len("2006-01-02T15:04:05Z") || len(s) > len(time.RFC3339) || s[4] != '-' {
+		return time.Time{}, false
+	}
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil || tm.Format(time.RFC3339) != s {
+		return time.Time{}, false
+	}
+	return tm, true
+}
+
+// wholeNumbers returns the values as ints if they are all whole float64s, as decoded.
+func wholeNumbers(vs []interface{}) ([]int, bool) {
+	if len(vs) == 0 {
+		return nil, false
+	}
+	out := make([]int, len(vs))
+	for i, v := range vs {
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) || math.Abs(f) >= 1<<53 {
+			return nil, false
+		}
+		out[i] = int(f)
+	}
+	return out, true
+}
+
+// encodeIndexedGZJSON encodes an indexed CatTrack as gzipped JSON, reusing the writer.
+func encodeIndexedGZJSON(w *gzip.Writer, ct cattrack.CatTrack) ([]byte, error) {
+	encoded, err := json.Marshal(ct)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal write: %w", err)
+	}
+	out := new(bytes.Buffer)
+	w.Reset(out)
+	if _, err := w.Write(encoded); err != nil {
+		return nil, fmt.Errorf("gzip write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("gzip close: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// decodeIndexed decodes an indexed CatTrack in either encoding.
+// The reader is reused for legacy gzipped JSON values.
+func decodeIndexed(r *gzip.Reader, v []byte) (cattrack.CatTrack, error) {
+	if len(v) > 0 && v[0] == indexedEncodingV1 {
+		return decodeIndexedV1(v)
+	}
+	return decodeIndexedGZJSON(r, v)
+}
+
+// decodeIndexedGZJSON decodes a gzipped, JSON-encoded indexed CatTrack, reusing the reader.
+func decodeIndexedGZJSON(r *gzip.Reader, v []byte) (cattrack.CatTrack, error) {
+	ct := cattrack.CatTrack{}
+	if err := r.Reset(bytes.NewBuffer(v)); err != nil {
+		return ct, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	if err := json.NewDecoder(r).Decode(&ct); err != nil {
+		return ct, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	if err := r.Close(); err != nil {
+		return ct, fmt.Errorf("failed to close gzip reader: %w", err)
+	}
+	return ct, nil
+}
+
+var errIndexedShort = errors.New("short indexed value")
+
+// indexedDecoder reads a binary-encoded value, keeping the first error.
+type indexedDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *indexedDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.b = nil
+}
+
+func (d *indexedDecoder) readByte() byte {
+	if len(d.b) < 1 {
+		d.fail(errIndexedShort)
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *indexedDecoder) readFloat() float64 {
+	if len(d.b) < 8 {
+		d.fail(errIndexedShort)
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return f
+}
+
+func (d *indexedDecoder) readUvarint() uint64 {
+	u, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail(errIndexedShort)
+		return 0
+	}
+	d.b = d.b[n:]
+	return u
+}
+
+func (d *indexedDecoder) readVarint() int64 {
+	i, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail(errIndexedShort)
+		return 0
+	}
+	d.b = d.b[n:]
+	return i
+}
+
+// readLength reads a length, checking there are at least as many bytes left.
+func (d *indexedDecoder) readLength() int {
+	u := d.readUvarint()
+	if u > uint64(len(d.b)) {
+		d.fail(errIndexedShort)
+		return 0
+	}
+	return int(u)
+}
+
+func (d *indexedDecoder) readString() string {
+	n := d.readLength()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *indexedDecoder) readKey() string {
+	i := d.readUvarint()
+	if i == 0 {
+		return d.readString()
+	}
+	if i > uint64(len(indexedKeysV1)) {
+		d.fail(fmt.Errorf("unknown key %d", i))
+		return ""
+	}
+	return indexedKeysV1[i-1]
+}
+
+func (d *indexedDecoder) readValue() any {
+	switch tag := d.readByte(); tag {
+	case indexedTagNil:
+		return nil
+	case indexedTagFalse:
+		return false
+	case indexedTagTrue:
+		return true
+	case indexedTagInt:
+		return float64(d.readVarint())
+	case indexedTagFloat:
+		return d.readFloat()
+	case indexedTagString:
+		return d.readString()
+	case indexedTagArray:
+		n := d.readLength()
+		out := make([]interface{}, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			out = append(out, d.readValue())
+		}
+		return out
+	case indexedTagInts:
+		n := d.readLength()
+		out := make([]interface{}, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			out = append(out, float64(d.readVarint()))
+		}
+		return out
+	case indexedTagTime:
+		unix, offset := d.readVarint(), d.readVarint()
+		return time.Unix(unix, 0).In(time.FixedZone("", int(offset))).Format(time.RFC3339)
+	case indexedTagObject:
+		n := d.readLength()
+		out := make(map[string]interface{}, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			k := d.readString()
+			out[k] = d.readValue()
+		}
+		return out
+	case indexedTagJSON:
+		var out any
+		if err := json.Unmarshal([]byte(d.readString()), &out); err != nil {
+			d.fail(err)
+		}
+		return out
+	default:
+		d.fail(fmt.Errorf("unknown value tag %d", tag))
+		return nil
+	}
+}
+
+// decodeIndexedV1 decodes a version 1 binary-encoded indexed CatTrack.
+func decodeIndexedV1(v []byte) (cattrack.CatTrack, error) {
+	d := &indexedDecoder{b: v[1:]}
+	flags := d.readByte()
+	ct := cattrack.CatTrack{Type: "Feature"}
+	ct.Geometry = orb.Point{d.readFloat(), d.readFloat()}
+	if flags&1 != 0 {
+		ct.BBox = []float64{d.readFloat(), d.readFloat(), d.readFloat(), d.readFloat()}
+	}
+	ct.ID = d.readValue()
+	n := d.readLength()
+	ct.Properties = make(map[string]interface{}, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.readKey()
+		ct.Properties[k] = d.readValue()
+	}
+	if d.err == nil && len(d.b) > 0 {
+		d.fail(fmt.Errorf("%d trailing bytes", len(d.b)))
+	}
+	if d.err != nil {
+		return cattrack.CatTrack{}, fmt.Errorf("failed to decode indexed value: %w", d.err)
+	}
+	return ct, nil
+}
@@ -44,7 +44,6 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/event"
@@ -57,6 +56,8 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -66,6 +67,8 @@ type Bucket int
 type CellIndexer struct {
 	Config *CellIndexerConfig
 
+	// mu serializes batches, which may come from many Index and IndexBatch callers.
+	mu     sync.Mutex
 	caches map[Bucket]*lru.Cache[string, cattrack.Indexer]
 	db     *bbolt.DB
 
@@ -181,7 +184,7 @@ func (ci *CellIndexer) Index(ctx context.Context, in <-chan cattrack.CatTrack) e
 		}
 		batchIndex++
 		for _, level := range ci.Config.Buckets {
-			if err := ci.index(level, batch); err != nil {
+			if _, err := ci.index(level, batch); err != nil {
 				return err
 			}
 		}
@@ -189,7 +192,21 @@ func (ci *CellIndexer) Index(ctx context.Context, in <-chan cattrack.CatTrack) e
 	return nil
 }
 
-func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) error {
+// IndexBatch indexes one batch of CatTracks for one bucket.
+// Unlike Index, it is meant for feeding an indexer from other indexers' feeds,
+// so it is safe to call concurrently, including with Index.
+// It returns the number of unique tracks, ie. cells new to the index.
+func (ci *CellIndexer) IndexBatch(level Bucket, tracks []cattrack.CatTrack) (uniques int, err error) {
+	if _, ok := ci.indexFeeds[level]; !ok {
+		return 0, fmt.Errorf("level %d not found", level)
+	}
+	return ci.index(level, tracks)
+}
+
+// index indexes the tracks for the level, returning the number of unique tracks.
+func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) (int, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
 	start := time.Now()
 	defer slog.Debug("Reducer batch", "cat", ci.Config.CatID,
 		"bucket", level, "size", len(tracks), "elapsed", time.Since(start).Round(time.Millisecond))
@@ -197,7 +214,7 @@ func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) error {
 	// Reinit the level's cache.
 	cache, err := lru.New[string, cattrack.Indexer](ci.Config.BatchSize)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	ci.caches[level] = cache
 	defer ci.caches[level].Purge()
@@ -252,21 +269,10 @@ func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) error {
 
 			// Non-nil value means non-unique track/index.
 			if v != nil {
-				delete(mapIDUnique, k)   // Strike this value from the unique map; db hit.
-				in := bytes.NewBuffer(v) // Decode the value from the db.
-				err := gzr.Reset(in)
-				if err != nil {
-					return fmt.Errorf("gzip reader: %w", err)
-				}
-				ct := cattrack.CatTrack{}
-				err = json.NewDecoder(gzr).Decode(&ct)
-				if err != nil {
-					return fmt.Errorf("json decode read: %w %d %s",
-						err, len(v), string(v))
-				}
-				err = gzr.Close()
+				delete(mapIDUnique, k) // Strike this value from the unique map; db hit.
+				ct, err := decodeIndexed(gzr, v)
 				if err != nil {
-					return fmt.Errorf("gzip reader close: %w", err)
+					return fmt.Errorf("decode read: %w %d", err, len(v))
 				}
 				// Load the old value into the indexer.
 				old = indexT.FromCatTrack(ct)
@@ -277,18 +283,11 @@ func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) error {
 			indexedTracks[i] = nextTrack // = append(indexedTracks, nextTrack) // Send the latest version of all indexed cells.
 
 			// Encode and store.
-			encoded, err := json.Marshal(nextTrack)
-			if err != nil {
-				return fmt.Errorf("json marshal write: %w", err)
-			}
-			out := new(bytes.Buffer)
-			gzw.Reset(out)
-			gzw.Write(encoded)
-			err = gzw.Close()
+			encoded, err := encodeIndexed(gzw, nextTrack)
 			if err != nil {
-				return fmt.Errorf("gzip close: %w", err)
+				return err
 			}
-			err = b.Put([]byte(k), out.Bytes())
+			err = b.Put([]byte(k), encoded)
 			if err != nil {
 				return fmt.Errorf("bbolt put: %w", err)
 			}
@@ -296,14 +295,27 @@ func (ci *CellIndexer) index(level Bucket, tracks []cattrack.CatTrack) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 	ci.indexFeeds[level].Send(indexedTracks)
 	for _, ct := range mapIDUnique {
 		uniqTracks = append(uniqTracks, ct)
 	}
 	ci.uniqIndexFeeds[level].Send(uniqTracks)
-	return nil
+	return len(uniqTracks), nil
+}
+
+// ResetBuckets deletes all indexed values for the configured buckets.
+func (ci *CellIndexer) ResetBuckets() error {
+	return ci.db.Update(func(tx *bbolt.Tx) error {
+		for _, level := range ci.Config.Buckets {
+			err := tx.DeleteBucket([]byte{byte(level)})
+			if err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (ci *CellIndexer) Close() error {
@@ -323,8 +335,8 @@ func (ci *CellIndexer) DumpLevel(level Bucket) (chan cattrack.CatTrack, chan err
 		defer close(out)
 		defer close(errs)
 
+		// decodeIndexed closes the reader after each track.
 		r1 := new(gzip.Reader)
-		defer r1.Close()
 
 		err := ci.db.View(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte{byte(level)})
@@ -332,18 +344,9 @@ func (ci *CellIndexer) DumpLevel(level Bucket) (chan cattrack.CatTrack, chan err
 				return fmt.Errorf("bucket not found")
 			}
 			if err := b.ForEach(func(k, v []byte) error {
-				ct := cattrack.CatTrack{}
-				buf := bytes.NewBuffer(v)
-				err := r1.Reset(buf)
+				ct, err := decodeIndexed(r1, v)
 				if err != nil {
-					return fmt.Errorf("failed to create gzip reader: %w", err)
-				}
-				if err := json.NewDecoder(r1).Decode(&ct); err != nil {
-					return fmt.Errorf("failed to unmarshal JSON: %w", err)
-				}
-				err = r1.Close()
-				if err != nil {
-					return fmt.Errorf("failed to close gzip reader: %w", err)
+					return err
 				}
 				out <- ct
 				return nil
@@ -360,3 +363,60 @@ func (ci *CellIndexer) DumpLevel(level Bucket) (chan cattrack.CatTrack, chan err
 
 	return out, errs
 }
+
+// KeyRange is an inclusive range of index keys, from First to Last.
+type KeyRange struct {
+	First, Last string
+}
+
+// ScanRanges sends the indexed CatTracks for the given level with keys in any of the ranges,
+// seeking a cursor to each range instead of scanning the whole level.
+// Tracks are sent in key order, once each, even if ranges overlap.
+// A level with nothing indexed has nothing to send.
+// Only non-nil errors are sent.
+func (ci *CellIndexer) ScanRanges(level Bucket, ranges []KeyRange) (chan cattrack.CatTrack, chan error) {
+	out := make(chan cattrack.CatTrack, params.DefaultChannelCap)
+	errs := make(chan error, 2)
+	ranges = append([]KeyRange{}, ranges...)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].First < ranges[j].First
+	})
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		// decodeIndexed closes the reader after each track.
+		r1 := new(gzip.Reader)
+
+		err := ci.db.View(func(tx *bbolt.Tx) error {
+			b := tx.Bucket([]byte{byte(level)})
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			var last []byte
+			for _, r := range ranges {
+				from := []byte(r.First)
+				// Start after what an overlapping range already sent.
+				if last != nil && bytes.Compare(from, last) <= 0 {
+					from = append(last[:len(last):len(last)], 0)
+				}
+				for k, v := c.Seek(from); k != nil && bytes.Compare(k, []byte(r.Last)) <= 0; k, v = c.Next() {
+					ct, err := decodeIndexed(r1, v)
+					if err != nil {
+						return err
+					}
+					out <- ct
+					last = append(last[:0], k...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			errs <- err
+			return
+		}
+	}()
+
+	return out, errs
+}
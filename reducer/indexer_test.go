@@ -1,17 +1,23 @@
 package reducer
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/paulmach/orb"
 	"github.com/rotblauer/catd/conceptual"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/stream"
 	"github.com/rotblauer/catd/testing/testdata"
 	"github.com/rotblauer/catd/types/cattrack"
+	bbolt "go.etcd.io/bbolt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func myNewTestCellIndexer(t *testing.T) *CellIndexer {
@@ -135,3 +141,361 @@ func TestCellIndexer(t *testing.T) {
 		}
 	}
 }
+
+func TestCellIndexer_ScanRanges(t *testing.T) {
+	ci, err := NewCellIndexer(&CellIndexerConfig{
+		CatID:       conceptual.CatID("any"),
+		DBPath:      filepath.Join(t.TempDir(), "reducer_test.catdb"),
+		BatchSize:   10,
+		Buckets:     []Bucket{3},
+		BucketKeyFn: myBucketKeyFn,
+		Logger:      slog.With("reducer_test", "ranges"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+
+	tracks := make([]cattrack.CatTrack, 100)
+	for i := range tracks {
+		ct := cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+		ct.SetPropertySafe("Time", time.Unix(int64(i), 0).UTC().Format(time.RFC3339))
+		tracks[i] = *ct
+	}
+	ctx := context.Background()
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+
+	scan, errs := ci.ScanRanges(3, []KeyRange{
+		{First: "tmod100-90", Last: "tmod100-95"},
+		{First: "tmod100-10", Last: "tmod100-19"},
+		{First: "tmod100-15", Last: "tmod100-24"}, // Overlapping.
+		{First: "tmod100-x", Last: "tmod100-z"},   // Empty.
+	})
+	got := stream.Collect(ctx, scan)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 21 {
+		t.Fatalf("got %d tracks, want 21", len(got))
+	}
+	for i, ct := range got {
+		want := int64(10 + i)
+		if i >= 15 {
+			want = int64(90 + i - 15)
+		}
+		if u := ct.MustTime().Unix(); u != want {
+			t.Errorf("got track %d at %d, want %d", i, u, want)
+		}
+	}
+
+	// Unindexed levels have nothing to scan.
+	scan, errs = ci.ScanRanges(4, []KeyRange{{First: "a", Last: "z"}})
+	if got := stream.Collect(ctx, scan); len(got) != 0 {
+		t.Errorf("got %d tracks", len(got))
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCellIndexer_IndexBatch(t *testing.T) {
+	ci, err := NewCellIndexer(&CellIndexerConfig{
+		CatID:       conceptual.CatID("any"),
+		DBPath:      filepath.Join(t.TempDir(), "reducer_test.catdb"),
+		BatchSize:   10,
+		Buckets:     []Bucket{4},
+		BucketKeyFn: myBucketKeyFn,
+		Logger:      slog.With("reducer_test", "batch"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+
+	batch := func(from, to int) []cattrack.CatTrack {
+		tracks := []cattrack.CatTrack{}
+		for i := from; i < to; i++ {
+			ct := cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+			ct.SetPropertySafe("Time", time.Unix(int64(i), 0).UTC().Format(time.RFC3339))
+			tracks = append(tracks, *ct)
+		}
+		return tracks
+	}
+	for _, c := range []struct {
+		from, to, want int
+	}{
+		{0, 5, 5},   // New cells.
+		{0, 5, 0},   // Same cells again.
+		{3, 8, 3},   // Some new.
+		{10, 20, 2}, // The rest of the cells.
+		{20, 30, 0}, // All cells seen.
+	} {
+		got, err := ci.IndexBatch(4, batch(c.from, c.to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("tracks %d-%d: got %d uniques, want %d", c.from, c.to, got, c.want)
+		}
+	}
+	if _, err := ci.IndexBatch(3, batch(0, 1)); err == nil {
+		t.Error("expected error for unconfigured level")
+	}
+}
+
+// myIndexedTrack returns a track as indexed by the multi indexer, with properties of every kind.
+func myIndexedTrack(t testing.TB) cattrack.CatTrack {
+	ct := cattrack.CatTrack{}
+	if err := json.Unmarshal([]byte(testdata.Track_iOS_stationary_1), &ct); err != nil {
+		t.Fatal(err)
+	}
+	ix := &cattrack.MultiIndexT{Indexers: []cattrack.Indexer{
+		&cattrack.OffsetIndexT{},
+		&cattrack.SpeedIndexT{},
+		&cattrack.ElevationIndexT{},
+		&cattrack.HourOfWeekIndexT{},
+		&cattrack.DeviceIndexT{},
+	}}
+	ct.SetPropertySafe("reducer_key", "tmod100-42")
+	ct.SetPropertySafe("Unlisted", map[string]interface{}{"nested": []interface{}{true, nil, 1.5}})
+	return ix.ApplyToCatTrack(ix.Index(nil, ix.FromCatTrack(ct)), ct)
+}
+
+func TestIndexedEncoding(t *testing.T) {
+	ct := myIndexedTrack(t)
+	gzr, gzw := new(gzip.Reader), gzip.NewWriter(nil)
+
+	legacy, err := encodeIndexedGZJSON(gzw, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodeIndexed(gzw, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded[0] != indexedEncodingV1 {
+		t.Fatalf("got encoding %d", encoded[0])
+	}
+	if len(encoded) >= len(legacy) {
+		t.Errorf("got %d bytes, legacy %d bytes", len(encoded), len(legacy))
+	}
+
+	// Both decode the same, as if from JSON.
+	want, err := decodeIndexed(gzr, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeIndexed(gzr, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	// Truncated values fail.
+	for _, n := range []int{1, 10, len(encoded) / 2, len(encoded) - 1} {
+		if _, err := decodeIndexed(gzr, encoded[:n]); err == nil {
+			t.Errorf("decoded %d truncated bytes", n)
+		}
+	}
+
+	// Non-point tracks stay legacy.
+	line := ct
+	line.Geometry = orb.LineString{{0, 0}, {1, 1}}
+	encoded, err = encodeIndexed(gzw, line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded[0] == indexedEncodingV1 {
+		t.Error("got binary encoding for line")
+	}
+}
+
+func TestCellIndexer_LegacyValues(t *testing.T) {
+	ci, err := NewCellIndexer(&CellIndexerConfig{
+		CatID:       conceptual.CatID("any"),
+		DBPath:      filepath.Join(t.TempDir(), "reducer_test.catdb"),
+		BatchSize:   10,
+		Buckets:     []Bucket{3},
+		BucketKeyFn: myBucketKeyFn,
+		Logger:      slog.With("reducer_test", "legacy"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+
+	tracks := make([]cattrack.CatTrack, 200)
+	for i := range tracks {
+		ct := cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+		ct.SetPropertySafe("Time", time.Unix(int64(i), 0).UTC().Format(time.RFC3339))
+		tracks[i] = *ct
+	}
+	ctx := context.Background()
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks[:100])); err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the values in the legacy encoding.
+	err = ci.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte{3})
+		gzr, gzw := new(gzip.Reader), gzip.NewWriter(nil)
+		return b.ForEach(func(k, v []byte) error {
+			ct, err := decodeIndexed(gzr, v)
+			if err != nil {
+				return err
+			}
+			legacy, err := encodeIndexedGZJSON(gzw, ct)
+			if err != nil {
+				return err
+			}
+			return b.Put(k, legacy)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks[100:])); err != nil {
+		t.Fatal(err)
+	}
+	dump, errs := ci.DumpLevel(3)
+	got := stream.Collect(ctx, dump)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100 {
+		t.Fatalf("got %d cells", len(got))
+	}
+	for _, ct := range got {
+		if n := ct.Properties.MustInt("Count", 0); n != 2 {
+			t.Errorf("got count %d, want 2", n)
+		}
+	}
+}
+
+// TestCellIndexer_addedIndexers reopens a db indexed with only the offset indexer
+// with the multi indexer, as when upgrading, eg., the tiled S2 levels.
+// The old cells are not tallied as one track each by the added indexers.
+func TestCellIndexer_addedIndexers(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "reducer_test.catdb")
+	config := func(level cattrack.Indexer) *CellIndexerConfig {
+		return &CellIndexerConfig{
+			CatID:         conceptual.CatID("any"),
+			DBPath:        dbPath,
+			BatchSize:     10,
+			Buckets:       []Bucket{4},
+			LevelIndexerT: map[Bucket]cattrack.Indexer{4: level},
+			BucketKeyFn:   myBucketKeyFn,
+			Logger:        slog.With("reducer_test", "added"),
+		}
+	}
+	tracks := func(from, to int, uuid string) []cattrack.CatTrack {
+		out := []cattrack.CatTrack{}
+		for i := from; i < to; i++ {
+			ct := cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+			ct.SetPropertiesSafe(map[string]interface{}{
+				"Time":      time.Unix(int64(i), 0).UTC().Format(time.RFC3339),
+				"Speed":     float64(i),
+				"Elevation": 250.0,
+				"UUID":      uuid,
+			})
+			out = append(out, *ct)
+		}
+		return out
+	}
+	ctx := context.Background()
+
+	ci, err := NewCellIndexer(config(&cattrack.OffsetIndexT{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks(0, 100, "a"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ci, err = NewCellIndexer(config(&cattrack.MultiIndexT{Indexers: []cattrack.Indexer{
+		&cattrack.OffsetIndexT{},
+		&cattrack.SpeedIndexT{},
+		&cattrack.ElevationIndexT{},
+		&cattrack.HourOfWeekIndexT{},
+		&cattrack.DeviceIndexT{},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ci.Close()
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks(100, 120, "b"))); err != nil {
+		t.Fatal(err)
+	}
+	dump, errs := ci.DumpLevel(4)
+	got := stream.Collect(ctx, dump)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Fatalf("got %d cells", len(got))
+	}
+	for _, ct := range got {
+		props := ct.Properties
+		if n := props.MustInt("Count", 0); n != 12 {
+			t.Errorf("got Count %d, want 12", n)
+		}
+		if n := props.MustInt("Speed.Count", 0); n != 2 {
+			t.Errorf("got Speed.Count %d, want 2", n)
+		}
+		if v := props.MustFloat64("Speed.Min", 0); v < 100 {
+			t.Errorf("got Speed.Min %v, want only new tracks", v)
+		}
+		if n := props.MustInt("Elevation.Count", 0); n != 2 {
+			t.Errorf("got Elevation.Count %d, want 2", n)
+		}
+		hours := 0
+		for _, h := range props["HourOfWeek"].([]interface{}) {
+			hours += int(h.(float64))
+		}
+		if hours != 2 {
+			t.Errorf("got %d hours of week, want 2", hours)
+		}
+		if n := props.MustInt("DeviceCount", 0); n != 1 {
+			t.Errorf("got DeviceCount %d, want 1", n)
+		}
+	}
+}
+
+// BenchmarkIndexedEncoding compares the legacy gzipped JSON encoding with the binary encoding,
+// reading and writing a value as CellIndexer.index does for each db hit.
+func BenchmarkIndexedEncoding(b *testing.B) {
+	ct := myIndexedTrack(b)
+	gzr, gzw := new(gzip.Reader), gzip.NewWriter(nil)
+	for _, enc := range []struct {
+		name   string
+		encode func(*gzip.Writer, cattrack.CatTrack) ([]byte, error)
+	}{
+		{"gzjson", encodeIndexedGZJSON},
+		{"binary", encodeIndexed},
+	} {
+		b.Run(enc.name, func(b *testing.B) {
+			v, err := enc.encode(gzw, ct)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				read, err := decodeIndexed(gzr, v)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if v, err = enc.encode(gzw, read); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(v)), "bytes/value")
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package reducer
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/rotblauer/catd/types/cattrack"
+	bbolt "go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+// DBOpenTimeout is how long the db inspection functions wait for a db
+// which is open elsewhere, eg. by a populating daemon, before giving up.
+var DBOpenTimeout = 5 * time.Second
+
+// BucketStats are the stats of one bucket of an indexer db.
+type BucketStats struct {
+	Bucket     Bucket
+	Cells      int
+	KeyBytes   int64
+	ValueBytes int64
+
+	// OldestLastTime and NewestLastTime are the range of the cells' LastTime properties.
+	OldestLastTime time.Time
+	NewestLastTime time.Time
+
+	// Legacy is the number of values in the legacy gzipped JSON encoding.
+	Legacy int
+
+	// Corrupt is the number of values which failed to decode.
+	Corrupt int
+}
+
+// CorruptValue is a value of an indexer db which failed to decode.
+type CorruptValue struct {
+	Bucket Bucket
+	Key    string
+	Err    error
+}
+
+func (c CorruptValue) Error() string {
+	return fmt.Sprintf("bucket %d key %q: %v", c.Bucket, c.Key, c.Err)
+}
+
+func openDBReadOnly(dbPath string) (*bbolt.DB, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(dbPath, 0660, &bbolt.Options{ReadOnly: true, Timeout: DBOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", dbPath, err)
+	}
+	return db, nil
+}
+
+// forEachIndexed decodes every value of every bucket of the db, calling fn with each
+// key, value, and decoded track, or decoding error. An error returned by fn stops the iteration.
+func forEachIndexed(tx *bbolt.Tx, fn func(bucket Bucket, k, v []byte, ct cattrack.CatTrack, decodeErr error) error) error {
+	// decodeIndexed closes the reader after each track.
+	r := new(gzip.Reader)
+	return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		if len(name) != 1 {
+			return fmt.Errorf("unexpected bucket name %q", name)
+		}
+		bucket := Bucket(name[0])
+		return b.ForEach(func(k, v []byte) error {
+			ct, err := decodeIndexed(r, v)
+			return fn(bucket, k, v, ct, err)
+		})
+	})
+}
+
+// Stats returns the stats of each bucket of the indexer db at the path, in bucket order.
+// The db is opened read-only.
+func Stats(dbPath string) ([]BucketStats, error) {
+	db, err := openDBReadOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	out := []BucketStats{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		return forEachIndexed(tx, func(bucket Bucket, k, v []byte, ct cattrack.CatTrack, decodeErr error) error {
+			if len(out) == 0 || out[len(out)-1].Bucket != bucket {
+				out = append(out, BucketStats{Bucket: bucket})
+			}
+			st := &out[len(out)-1]
+			st.Cells++
+			st.KeyBytes += int64(len(k))
+			st.ValueBytes += int64(len(v))
+			if len(v) > 0 && v[0] != indexedEncodingV1 {
+				st.Legacy++
+			}
+			if decodeErr != nil {
+				st.Corrupt++
+				return nil
+			}
+			last, err := time.Parse(time.RFC3339, ct.Properties.MustString("LastTime", ""))
+			if err != nil {
+				return nil
+			}
+			if st.OldestLastTime.IsZero() || last.Before(st.OldestLastTime) {
+				st.OldestLastTime = last
+			}
+			if last.After(st.NewestLastTime) {
+				st.NewestLastTime = last
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+// Verify decodes every value of the indexer db at the path, returning the values which fail to,
+// and checks the consistency of the db itself. Unlike DumpLevel, it does not stop at the first corrupt value.
+// The db is opened read-only.
+func Verify(dbPath string) ([]CorruptValue, error) {
+	db, err := openDBReadOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	out := []CorruptValue{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		var errs error
+		for err := range tx.Check() {
+			errs = errors.Join(errs, err)
+		}
+		if errs != nil {
+			return fmt.Errorf("db check: %w", errs)
+		}
+		return forEachIndexed(tx, func(bucket Bucket, k, v []byte, ct cattrack.CatTrack, decodeErr error) error {
+			if decodeErr != nil {
+				out = append(out, CorruptValue{Bucket: bucket, Key: string(k), Err: decodeErr})
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+// CompactConfig configures Compact.
+type CompactConfig struct {
+	// Reencode re-encodes every value in the current value encoding.
+	// Values which fail to decode are copied as-is.
+	Reencode bool
+
+	// TxMaxSize is the maximum size of a compaction transaction, in bytes.
+	// Zero means a default size.
+	TxMaxSize int64
+}
+
+// Compact rewrites the indexer db at the path, reclaiming its free pages,
+// and replaces the db with the rewrite. The db must not be open elsewhere.
+// It returns the sizes of the db before and after.
+func Compact(dbPath string, config *CompactConfig) (before, after int64, err error) {
+	if config == nil {
+		config = &CompactConfig{}
+	}
+	if config.TxMaxSize == 0 {
+		config.TxMaxSize = 64 << 20
+	}
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+
+	src, err := bbolt.Open(dbPath, 0660, &bbolt.Options{Timeout: DBOpenTimeout})
+	if err != nil {
+		return before, 0, fmt.Errorf("open %s: %w", dbPath, err)
+	}
+	defer src.Close()
+
+	tmpPath := dbPath + ".compact"
+	_ = os.Remove(tmpPath)
+	dst, err := bbolt.Open(tmpPath, fi.Mode(), nil)
+	if err != nil {
+		return before, 0, err
+	}
+	if config.Reencode {
+		err = compactReencode(dst, src, config.TxMaxSize)
+	} else {
+		err = bbolt.Compact(dst, src, config.TxMaxSize)
+	}
+	if err := errors.Join(err, dst.Close()); err != nil {
+		_ = os.Remove(tmpPath)
+		return before, 0, err
+	}
+	fi, err = os.Stat(tmpPath)
+	if err != nil {
+		return before, 0, err
+	}
+	after = fi.Size()
+	// Still holding the source lock, so no one opens it mid-replace.
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return before, 0, err
+	}
+	return before, after, nil
+}
+
+// compactReencode copies the values of the src db to the dst db, re-encoded,
+// committing every txMaxSize bytes.
+func compactReencode(dst, src *bbolt.DB, txMaxSize int64) error {
+	gzw := gzip.NewWriter(nil)
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var size int64
+	err = src.View(func(srcTx *bbolt.Tx) error {
+		return forEachIndexed(srcTx, func(bucket Bucket, k, v []byte, ct cattrack.CatTrack, decodeErr error) error {
+			if decodeErr == nil {
+				encoded, err := encodeIndexed(gzw, ct)
+				if err != nil {
+					return err
+				}
+				v = encoded
+			}
+			if size+int64(len(k)+len(v)) > txMaxSize {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				next, err := dst.Begin(true)
+				if err != nil {
+					return err
+				}
+				tx, size = next, 0
+			}
+			size += int64(len(k) + len(v))
+			b, err := tx.CreateBucketIfNotExists([]byte{byte(bucket)})
+			if err != nil {
+				return err
+			}
+			// Keys are put in order, so fill pages, like bbolt.Compact.
+			b.FillPercent = 1
+			// Keys and values of the source tx are only valid during it.
+			return b.Put(append([]byte{}, k...), append([]byte{}, v...))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,92 @@
+package reducer
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/conceptual"
+	"github.com/rotblauer/catd/stream"
+	"github.com/rotblauer/catd/types/cattrack"
+	bbolt "go.etcd.io/bbolt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsVerifyCompact(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "reducer_test.catdb")
+	ci, err := NewCellIndexer(&CellIndexerConfig{
+		CatID:       conceptual.CatID("any"),
+		DBPath:      dbPath,
+		BatchSize:   10,
+		Buckets:     []Bucket{3, 4},
+		BucketKeyFn: myBucketKeyFn,
+		Logger:      slog.With("reducer_test", "inspect"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := make([]cattrack.CatTrack, 100)
+	for i := range tracks {
+		ct := cattrack.NewCatTrack(orb.Point{-93.25, 44.98})
+		ct.SetPropertySafe("Time", time.Unix(int64(i), 0).UTC().Format(time.RFC3339))
+		tracks[i] = *ct
+	}
+	ctx := context.Background()
+	if err := ci.Index(ctx, stream.Slice(ctx, tracks)); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt one value.
+	err = ci.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte{3}).Put([]byte("tmod100-42"), []byte("not gzip"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ci.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Stats(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d buckets", len(stats))
+	}
+	if st := stats[0]; st.Bucket != 3 || st.Cells != 100 || st.Corrupt != 1 || st.ValueBytes == 0 {
+		t.Errorf("got bucket stats %+v", st)
+	}
+	if st := stats[1]; st.Bucket != 4 || st.Cells != 10 || st.Corrupt != 0 {
+		t.Errorf("got bucket stats %+v", st)
+	}
+	if st := stats[1]; !st.OldestLastTime.Equal(time.Unix(90, 0)) || !st.NewestLastTime.Equal(time.Unix(99, 0)) {
+		t.Errorf("got LastTime range %v - %v", st.OldestLastTime, st.NewestLastTime)
+	}
+
+	for _, reencode := range []bool{false, true} {
+		bad, err := Verify(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bad) != 1 || bad[0].Bucket != 3 || bad[0].Key != "tmod100-42" {
+			t.Fatalf("got corrupt values %v", bad)
+		}
+		before, after, err := Compact(dbPath, &CompactConfig{Reencode: reencode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after == 0 || after > before {
+			t.Errorf("compacted %d to %d bytes", before, after)
+		}
+		compacted, err := Stats(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range compacted {
+			if compacted[i].Cells != stats[i].Cells || compacted[i].Corrupt != stats[i].Corrupt {
+				t.Errorf("got compacted bucket stats %+v, want %+v", compacted[i], stats[i])
+			}
+		}
+	}
+}
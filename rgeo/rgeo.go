@@ -11,6 +11,7 @@ import (
 	"net/rpc"
 	"slices"
 	"sort"
+	"sync"
 )
 
 // R gets a ReverseGeocoder instance.
@@ -61,6 +62,12 @@ func R(datasets ...string) ReverseGeocoder {
 
 	// Everything failed. Load datasets.
 	// Once datasets are loaded, there's no reason to go back to RPC.
+	// Concurrent callers (eg. cats populating concurrently) wait for the first load.
+	rInitMu.Lock()
+	defer rInitMu.Unlock()
+	if r != nil {
+		return r
+	}
 	slog.Info("Loading rgeo datasets...")
 	err := Init(defaultDatasets...)
 	if err != nil {
@@ -82,6 +89,9 @@ type ReverseGeocoder interface {
 // which implements the defined interface.
 var r *rR
 
+// rInitMu serializes loading the inproc instance in R.
+var rInitMu sync.Mutex
+
 // rR is the type of our wrapped rgeo.Rgeo instance, which implements the ReverseGeocoder interface.
 type rR srgeo.Rgeo
 
@@ -3,6 +3,7 @@ package s2
 import (
 	"github.com/rotblauer/catd/common"
 	"github.com/rotblauer/catd/params"
+	"github.com/rotblauer/catd/reducer"
 	"github.com/rotblauer/catd/types/cattrack"
 )
 
@@ -12,6 +13,39 @@ var DefaultIndexerT = &cattrack.OffsetIndexT{
 	VisitThreshold: DefaultVisitThreshold,
 }
 
+// DefaultMultiIndexerT adds speed, elevation, hour-of-week and device tallies
+// to the default offset index, so that cells can be colored by them on the map.
+// Cells indexed before these tallies were added start them empty, tallying only newer tracks;
+// 'catd rebuild --only s2' tallies all of a cat's tracks.
+var DefaultMultiIndexerT = &cattrack.MultiIndexT{
+	Indexers: []cattrack.Indexer{
+		DefaultIndexerT,
+		&cattrack.SpeedIndexT{},
+		&cattrack.ElevationIndexT{},
+		&cattrack.HourOfWeekIndexT{},
+		&cattrack.DeviceIndexT{},
+	},
+}
+
+// TilingOmittedProperties are the properties of the DefaultMultiIndexerT
+// which stay in the index but are not sent to tiled.
+// They are the hour-of-week and speed histograms and the device UUIDs, from which
+// the tiled summaries, eg. HourOfWeek.Peak, Speed.P50 and DeviceCount, are derived.
+// Arrays bloat the tiles, and device UUIDs don't belong on a public map.
+var TilingOmittedProperties = []string{"HourOfWeek", "Speed.Bins", "Devices"}
+
+// DefaultLevelIndexerT are the indexers for the cell levels which are tiled.
+// Other levels use the DefaultIndexerT.
+var DefaultLevelIndexerT = func() map[reducer.Bucket]cattrack.Indexer {
+	out := map[reducer.Bucket]cattrack.Indexer{}
+	for _, level := range DefaultCellLevels {
+		if level >= CellLevelTilingMinimum && level <= CellLevelTilingMaximum {
+			out[reducer.Bucket(level)] = DefaultMultiIndexerT
+		}
+	}
+	return out
+}()
+
 // CellLevelTilingMinimum is the minimum cell level for tiling, inclusive.
 var CellLevelTilingMinimum = CellLevel6
 
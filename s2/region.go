@@ -0,0 +1,71 @@
+package s2
+
+import (
+	"errors"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/orb"
+	"github.com/rotblauer/catd/reducer"
+	"github.com/rotblauer/catd/types/cattrack"
+)
+
+// DefaultRegionMaxCells is the default maximum number of cells covering a queried region.
+// More cells cover the region more closely, for more, but smaller, index range scans.
+var DefaultRegionMaxCells = 32
+
+// RegionFromBound returns the region of a lng/lat bound.
+func RegionFromBound(b orb.Bound) s2.Region {
+	rect := s2.RectFromLatLng(s2.LatLngFromDegrees(b.Min.Lat(), b.Min.Lon()))
+	return rect.AddPoint(s2.LatLngFromDegrees(b.Max.Lat(), b.Max.Lon()))
+}
+
+// RegionFromRing returns the region inside a closed or open lng/lat ring, of either orientation.
+func RegionFromRing(ring orb.Ring) (s2.Region, error) {
+	if len(ring) > 1 && ring[0].Equal(ring[len(ring)-1]) {
+		ring = ring[:len(ring)-1]
+	}
+	if len(ring) < 3 {
+		return nil, errors.New("ring needs at least 3 distinct points")
+	}
+	points := make([]s2.Point, len(ring))
+	for i, pt := range ring {
+		points[i] = s2.PointFromLatLng(s2.LatLngFromDegrees(pt.Lat(), pt.Lon()))
+	}
+	loop := s2.LoopFromPoints(points)
+	if err := loop.Validate(); err != nil {
+		return nil, err
+	}
+	// Loops are counter-clockwise around their inside; take the smaller side for the inside.
+	loop.Normalize()
+	return s2.PolygonFromLoops([]*s2.Loop{loop}), nil
+}
+
+// RegionFromRadius returns the region within the radius, in meters, of a lng/lat point.
+func RegionFromRadius(center orb.Point, meters float64) s2.Region {
+	return s2.CapFromCenterAngle(
+		s2.PointFromLatLng(s2.LatLngFromDegrees(center.Lat(), center.Lon())),
+		s1.Angle(meters/orb.EarthRadius))
+}
+
+// RegionKeyRanges returns the ranges of the level's index keys (cell tokens) covering the region.
+// The region is covered by at most maxCells cells of the level or bigger,
+// each standing for the range of its descendants at the level.
+// Tokens sort as their cell IDs do, so the ranges can be scanned in the index.
+func RegionKeyRanges(region s2.Region, level CellLevel, maxCells int) []reducer.KeyRange {
+	coverer := &s2.RegionCoverer{MinLevel: 0, MaxLevel: int(level), MaxCells: maxCells}
+	covering := coverer.Covering(region)
+	ranges := make([]reducer.KeyRange, 0, len(covering))
+	for _, cell := range covering {
+		ranges = append(ranges, reducer.KeyRange{
+			First: cell.ChildBeginAtLevel(int(level)).ToToken(),
+			Last:  cell.ChildEndAtLevel(int(level)).Prev().ToToken(),
+		})
+	}
+	return ranges
+}
+
+// RegionIntersectsTrackCell returns true if the region intersects the track's cell at the level.
+// Coverings are approximate, so this tells the cells actually in the region.
+func RegionIntersectsTrackCell(region s2.Region, ct cattrack.CatTrack, level CellLevel) bool {
+	return region.IntersectsCell(s2.CellFromCellID(CellIDForTrackLevel(ct, level)))
+}
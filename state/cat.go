@@ -131,6 +131,20 @@ func (s *State) ReadKV(bucket []byte, key []byte) ([]byte, error) {
 	return s.readKV(bucket, key)
 }
 
+// DeleteKV deletes the key from the bucket. Missing buckets and keys are not errors.
+func (s *State) DeleteKV(bucket []byte, key []byte) error {
+	if key == nil {
+		return fmt.Errorf("deleteKV: nil key")
+	}
+	return s.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+}
+
 func (s *State) storeKV(bucket []byte, key []byte, data []byte) error {
 	if key == nil {
 		return fmt.Errorf("storeKV: nil key")
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/rotblauer/catd/params"
 	"github.com/rotblauer/catd/types/cattrack"
+	"go.etcd.io/bbolt"
 	"os"
 	"path/filepath"
 )
@@ -16,6 +17,7 @@ import (
 // 2. As two files in a subdirectory of the cat's 'snaps' directory;
 //    1. The .jpeg image itself.
 //    2. The cat track in JSON.
+//    Thumbnails of the image are cached beside it, as <key>.<size>.jpg.
 // (The KV value is redundant to the .json file in the subdir.)
 
 func (cs *CatState) snapFolderHolderPath(ct cattrack.CatTrack) string {
@@ -35,6 +37,11 @@ func (cs *CatState) SnapPathJSONFile(ct cattrack.CatTrack) string {
 	return filepath.Join(cs.snapFolderHolderPath(ct), ct.MustS3Key()+".json")
 }
 
+// SnapPathThumbnail returns the path of the snap's thumbnail of the named size, see params.SnapThumbnailSizes.
+func (cs *CatState) SnapPathThumbnail(ct cattrack.CatTrack, size string) string {
+	return filepath.Join(cs.snapFolderHolderPath(ct), ct.MustS3Key()+"."+size+".jpg")
+}
+
 // ValidateSnapLocalStore returns true if both the image and track exist
 func (cs *CatState) ValidateSnapLocalStore(ct cattrack.CatTrack) error {
 	stat, err := os.Stat(cs.SnapPathImage(ct))
@@ -76,6 +83,15 @@ func (cs *CatState) StoreSnapImage(ct cattrack.CatTrack, jpegData []byte) error
 	return cs.StoreSnapJSONFile(ct)
 }
 
+// StoreSnapThumbnail stores the snap's thumbnail of the named size.
+func (cs *CatState) StoreSnapThumbnail(ct cattrack.CatTrack, size string, jpegData []byte) error {
+	target := cs.SnapPathThumbnail(ct, size)
+	if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(target, jpegData, 0660)
+}
+
 func (cs *CatState) StoreSnapJSONFile(ct cattrack.CatTrack) error {
 	// Second, store the track.
 	target := cs.SnapPathJSONFile(ct)
@@ -96,3 +112,45 @@ func (cs *CatState) StoreSnapJSONFile(ct cattrack.CatTrack) error {
 func (cs *CatState) StoreSnapKV(ct cattrack.CatTrack) error {
 	return cs.StoreKVMarshalJSON(params.CatSnapBucket, []byte(ct.MustS3Key()), ct)
 }
+
+// ReadSnapKV reads the snap stored by its key, see cattrack.CatTrack.MustS3Key.
+// It returns os.ErrNotExist if there is no such snap, or no snaps at all;
+// other errors, eg. reading the state, are returned as-is.
+func (cs *CatState) ReadSnapKV(key string) (cattrack.CatTrack, error) {
+	ct := cattrack.CatTrack{}
+	err := cs.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(params.CatSnapBucket)
+		if b == nil {
+			return os.ErrNotExist
+		}
+		// The value returned by Get is only valid in the scope of the transaction,
+		// so unmarshal it here.
+		data := b.Get([]byte(key))
+		if len(data) == 0 {
+			return os.ErrNotExist
+		}
+		if err := json.Unmarshal(data, &ct); err != nil {
+			return fmt.Errorf("snap %s: %w", key, err)
+		}
+		return nil
+	})
+	return ct, err
+}
+
+// ScanSnapsKV calls fn with each stored snap, in key order.
+// Cats without snaps have none to scan.
+func (cs *CatState) ScanSnapsKV(fn func(ct cattrack.CatTrack) error) error {
+	return cs.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(params.CatSnapBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			ct := cattrack.CatTrack{}
+			if err := json.Unmarshal(v, &ct); err != nil {
+				return fmt.Errorf("snap %s: %w", k, err)
+			}
+			return fn(ct)
+		})
+	})
+}
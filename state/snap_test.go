@@ -0,0 +1,39 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/rotblauer/catd/params"
+)
+
+func TestCatState_ReadSnapKV(t *testing.T) {
+	cs := NewCatState("rye", t.TempDir(), false)
+	if err := cs.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// No snaps bucket yet.
+	if _, err := cs.ReadSnapKV("nope"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist without snaps, got %v", err)
+	}
+
+	if err := cs.StoreKV(params.CatSnapBucket, []byte("bad"), []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.ReadSnapKV("nope"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist for missing snap, got %v", err)
+	}
+	if _, err := cs.ReadSnapKV("bad"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected decode error for bad snap, got %v", err)
+	}
+
+	// Errors reading the state are not missing snaps.
+	if err := cs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.ReadSnapKV("bad"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected state error for closed state, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package cattrack
+
+import (
+	"encoding/json"
+	"github.com/rotblauer/catd/conceptual"
+	"time"
+)
+
+// CatVisits are one cat's tallies for a cell, as of the cat's latest indexed value for the cell.
+type CatVisits struct {
+	Count      int
+	VisitCount int
+	FirstTime  time.Time
+	LastTime   time.Time
+}
+
+// CatsIndexT is an Indexer implementation that aggregates many cats' indexed
+// tracks (see OffsetIndexT) for a cell, keeping each cat's visits.
+// Each cat's visits are replaced, not summed, by its next indexed value,
+// since the cats' indexed values are already cumulative.
+type CatsIndexT struct {
+	Cats map[conceptual.CatID]CatVisits
+}
+
+func (ix *CatsIndexT) IsEmpty() bool {
+	return ix == nil || len(ix.Cats) == 0
+}
+
+// FromCatTrack reads the cats' visits of an aggregated track,
+// or else the visits of the track's own cat, from its OffsetIndexT properties.
+func (*CatsIndexT) FromCatTrack(ct CatTrack) Indexer {
+	out := &CatsIndexT{Cats: map[conceptual.CatID]CatVisits{}}
+	if v, ok := ct.Properties["Cats"]; ok {
+		// Round trip the decoded JSON object into its types.
+		if b, err := json.Marshal(v); err == nil && json.Unmarshal(b, &out.Cats) == nil {
+			return out
+		}
+	}
+	offset := (&OffsetIndexT{}).FromCatTrack(ct).(*OffsetIndexT)
+	visits := offset.VisitCount
+	if visits == 0 {
+		visits = 1
+	}
+	out.Cats[ct.CatID()] = CatVisits{
+		Count:      offset.Count,
+		VisitCount: visits,
+		FirstTime:  offset.FirstTime,
+		LastTime:   offset.LastTime,
+	}
+	return out
+}
+
+func (*CatsIndexT) Index(old, next Indexer) Indexer {
+	if old == nil || old.IsEmpty() {
+		return next
+	}
+	oldT, nextT := old.(*CatsIndexT), next.(*CatsIndexT)
+	out := &CatsIndexT{Cats: make(map[conceptual.CatID]CatVisits, len(oldT.Cats)+len(nextT.Cats))}
+	for id, v := range oldT.Cats {
+		out.Cats[id] = v
+	}
+	for id, v := range nextT.Cats {
+		out.Cats[id] = v
+	}
+	return out
+}
+
+// ApplyToCatTrack returns the cell's track with the cats' visits and totals for properties.
+// Other properties of the given track, which belong to whichever cat it came from, are not kept.
+func (*CatsIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*CatsIndexT)
+	count, visits := 0, 0
+	var first, last time.Time
+	for _, v := range ixr.Cats {
+		count += v.Count
+		visits += v.VisitCount
+		if first.IsZero() || v.FirstTime.Before(first) {
+			first = v.FirstTime
+		}
+		if v.LastTime.After(last) {
+			last = v.LastTime
+		}
+	}
+	props := map[string]interface{}{
+		"Name":       conceptual.AllCatsID.String(),
+		"Time":       last.Format(time.RFC3339),
+		"UnixTime":   last.Unix(),
+		"Cats":       ixr.Cats,
+		"CatCount":   len(ixr.Cats),
+		"Count":      count,
+		"VisitCount": visits,
+		"FirstTime":  first.Format(time.RFC3339),
+		"LastTime":   last.Format(time.RFC3339),
+	}
+	if v, ok := ct.Properties["reducer_key"]; ok {
+		props["reducer_key"] = v
+	}
+	out := ct
+	out.Properties = props
+	return out
+}
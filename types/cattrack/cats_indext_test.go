@@ -0,0 +1,67 @@
+package cattrack
+
+import (
+	"encoding/json"
+	"github.com/paulmach/orb"
+	"testing"
+	"time"
+)
+
+func TestCatsIndexT_Index(t *testing.T) {
+	t0 := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	catTrack := func(name string, count int, last time.Time) CatTrack {
+		ct := *NewCatTrack(orb.Point{-93.25, 44.98})
+		ct.SetPropertiesSafe(map[string]interface{}{
+			"Name":       name,
+			"UnixTime":   last.Unix(),
+			"Count":      count,
+			"VisitCount": 1,
+			"FirstTime":  t0.Format(time.RFC3339),
+			"LastTime":   last.Format(time.RFC3339),
+		})
+		return ct
+	}
+
+	ix := &CatsIndexT{}
+	var idx Indexer
+	for _, ct := range []CatTrack{
+		catTrack("rye", 1, t0),
+		catTrack("ia", 3, t0.Add(time.Hour)),
+		// Rye's next value is cumulative, replacing the first.
+		catTrack("rye", 5, t0.Add(2*time.Hour)),
+	} {
+		idx = ix.Index(idx, ix.FromCatTrack(ct))
+	}
+	out := ix.ApplyToCatTrack(idx, catTrack("rye", 5, t0.Add(2*time.Hour)))
+
+	// Round trip, as if stored.
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := CatTrack{}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if v := stored.Properties.MustInt("CatCount", 0); v != 2 {
+		t.Errorf("got CatCount %d, want 2", v)
+	}
+	if v := stored.Properties.MustInt("Count", 0); v != 8 {
+		t.Errorf("got Count %d, want 8", v)
+	}
+	if v := stored.Properties.MustString("LastTime", ""); v != t0.Add(2*time.Hour).Format(time.RFC3339) {
+		t.Errorf("got LastTime %s", v)
+	}
+
+	// Ia comes back.
+	next := ix.Index(ix.FromCatTrack(stored), ix.FromCatTrack(catTrack("ia", 4, t0.Add(3*time.Hour)))).(*CatsIndexT)
+	if len(next.Cats) != 2 {
+		t.Fatalf("got %d cats", len(next.Cats))
+	}
+	if v := next.Cats["ia"].Count; v != 4 {
+		t.Errorf("got ia count %d, want 4", v)
+	}
+	if v := next.Cats["rye"].Count; v != 5 {
+		t.Errorf("got rye count %d, want 5", v)
+	}
+}
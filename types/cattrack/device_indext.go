@@ -0,0 +1,67 @@
+package cattrack
+
+// DeviceIndexT is an Indexer implementation that counts the tracks in a cell
+// per device (UUID). Tracks without a UUID are not counted.
+type DeviceIndexT struct {
+	Devices map[string]int
+}
+
+func (ix *DeviceIndexT) IsEmpty() bool {
+	return ix == nil || len(ix.Devices) == 0
+}
+
+func (*DeviceIndexT) FromCatTrack(ct CatTrack) Indexer {
+	out := &DeviceIndexT{Devices: map[string]int{}}
+	if v, ok := ct.Properties["Devices"]; ok {
+		switch devices := v.(type) {
+		case map[string]int:
+			for k, n := range devices {
+				out.Devices[k] = n
+			}
+		case map[string]interface{}:
+			for k, n := range devices {
+				if f, ok := n.(float64); ok {
+					out.Devices[k] = int(f)
+				}
+			}
+		}
+		return out
+	}
+	if isIndexedCell(ct) {
+		return out
+	}
+	if uuid := ct.Properties.MustString("UUID", ""); uuid != "" {
+		out.Devices[uuid] = 1
+	}
+	return out
+}
+
+func (*DeviceIndexT) Index(old, next Indexer) Indexer {
+	if old == nil || old.IsEmpty() {
+		return next
+	}
+	if next == nil || next.IsEmpty() {
+		return old
+	}
+	oldT, nextT := old.(*DeviceIndexT), next.(*DeviceIndexT)
+	out := &DeviceIndexT{Devices: make(map[string]int, len(oldT.Devices)+len(nextT.Devices))}
+	for k, n := range oldT.Devices {
+		out.Devices[k] += n
+	}
+	for k, n := range nextT.Devices {
+		out.Devices[k] += n
+	}
+	return out
+}
+
+func (*DeviceIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*DeviceIndexT)
+	if ixr.IsEmpty() {
+		return ct
+	}
+	ct.SetPropertiesSafe(map[string]interface{}{
+		"Devices":     ixr.Devices,
+		"DeviceCount": len(ixr.Devices),
+	})
+	return ct
+}
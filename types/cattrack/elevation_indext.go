@@ -0,0 +1,64 @@
+package cattrack
+
+import (
+	"math"
+)
+
+// ElevationIndexT is an Indexer implementation that tracks the range of elevations
+// of the tracks in a cell. Tracks without an Elevation are not counted.
+type ElevationIndexT struct {
+	Count int
+	Min   float64
+	Max   float64
+}
+
+func (ix *ElevationIndexT) IsEmpty() bool {
+	return ix == nil || ix.Count == 0
+}
+
+func (*ElevationIndexT) FromCatTrack(ct CatTrack) Indexer {
+	if _, ok := ct.Properties["Elevation.Count"]; ok {
+		return &ElevationIndexT{
+			Count: ct.Properties.MustInt("Elevation.Count", 0),
+			Min:   ct.Properties.MustFloat64("Elevation.Min", 0),
+			Max:   ct.Properties.MustFloat64("Elevation.Max", 0),
+		}
+	}
+	if isIndexedCell(ct) {
+		return &ElevationIndexT{}
+	}
+	v, ok := ct.Properties["Elevation"].(float64)
+	if !ok {
+		return &ElevationIndexT{}
+	}
+	return &ElevationIndexT{Count: 1, Min: v, Max: v}
+}
+
+func (*ElevationIndexT) Index(old, next Indexer) Indexer {
+	if old == nil || old.IsEmpty() {
+		return next
+	}
+	if next == nil || next.IsEmpty() {
+		return old
+	}
+	oldT, nextT := old.(*ElevationIndexT), next.(*ElevationIndexT)
+	return &ElevationIndexT{
+		Count: oldT.Count + nextT.Count,
+		Min:   math.Min(oldT.Min, nextT.Min),
+		Max:   math.Max(oldT.Max, nextT.Max),
+	}
+}
+
+func (*ElevationIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*ElevationIndexT)
+	if ixr.IsEmpty() {
+		return ct
+	}
+	ct.SetPropertiesSafe(map[string]interface{}{
+		"Elevation.Count": ixr.Count,
+		"Elevation.Min":   ixr.Min,
+		"Elevation.Max":   ixr.Max,
+		"Elevation.Range": ixr.Max - ixr.Min,
+	})
+	return ct
+}
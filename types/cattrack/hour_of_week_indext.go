@@ -0,0 +1,90 @@
+package cattrack
+
+import (
+	"math"
+	"time"
+)
+
+// HoursOfWeek is the number of hours in a week, and the length of a HourOfWeekIndexT histogram.
+const HoursOfWeek = 7 * 24
+
+// HourOfWeekIndexT is an Indexer implementation that tracks a histogram of the
+// hours of the week (Sunday 00h is 0) the tracks in a cell were made, in their
+// approximate local time. Tracks do not know their time zone,
+// so local time is the solar time zone of the track's longitude.
+type HourOfWeekIndexT struct {
+	Hours []int
+}
+
+func (ix *HourOfWeekIndexT) IsEmpty() bool {
+	if ix == nil {
+		return true
+	}
+	for _, n := range ix.Hours {
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (*HourOfWeekIndexT) FromCatTrack(ct CatTrack) Indexer {
+	if v, ok := ct.Properties["HourOfWeek"]; ok {
+		return &HourOfWeekIndexT{Hours: propertyInts(v, HoursOfWeek)}
+	}
+	if isIndexedCell(ct) {
+		return &HourOfWeekIndexT{}
+	}
+	t, err := ct.Time()
+	if err != nil {
+		return &HourOfWeekIndexT{}
+	}
+	hours := make([]int, HoursOfWeek)
+	hours[HourOfWeek(t, ct.Point().Lon())]++
+	return &HourOfWeekIndexT{Hours: hours}
+}
+
+func (*HourOfWeekIndexT) Index(old, next Indexer) Indexer {
+	if old == nil || old.IsEmpty() {
+		return next
+	}
+	if next == nil || next.IsEmpty() {
+		return old
+	}
+	oldT, nextT := old.(*HourOfWeekIndexT), next.(*HourOfWeekIndexT)
+	out := &HourOfWeekIndexT{Hours: make([]int, HoursOfWeek)}
+	for i := range out.Hours {
+		out.Hours[i] = oldT.Hours[i] + nextT.Hours[i]
+	}
+	return out
+}
+
+func (*HourOfWeekIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*HourOfWeekIndexT)
+	if ixr.IsEmpty() {
+		return ct
+	}
+	total, weekend, peak := 0, 0, 0
+	for i, n := range ixr.Hours {
+		total += n
+		if day := time.Weekday(i / 24); day == time.Saturday || day == time.Sunday {
+			weekend += n
+		}
+		if n > ixr.Hours[peak] {
+			peak = i
+		}
+	}
+	ct.SetPropertiesSafe(map[string]interface{}{
+		"HourOfWeek":         ixr.Hours,
+		"HourOfWeek.Peak":    peak,
+		"HourOfWeek.Weekend": float64(weekend) / float64(total),
+	})
+	return ct
+}
+
+// HourOfWeek returns the hour of the week (Sunday 00h is 0) of the time
+// in the solar time zone of the longitude.
+func HourOfWeek(t time.Time, lon float64) int {
+	local := t.UTC().Add(time.Duration(math.Round(lon/15)) * time.Hour)
+	return int(local.Weekday())*24 + local.Hour()
+}
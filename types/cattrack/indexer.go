@@ -33,3 +33,15 @@ type Indexer interface {
 	// or do whatever cat track magic you need to do.
 	ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack
 }
+
+// isIndexedCell returns true if the track is an indexed cell, with the Count set by an OffsetIndexT,
+// rather than a raw track.
+// An indexed cell's raw properties, eg. Speed and Time, are those of its last track.
+// Indexers read their own properties from a cell instead, and a cell without them,
+// indexed before the indexer was added, starts the indexer empty:
+// its raw properties are not one sample, but a stand-in for its Count tracks.
+// Rebuilding the index, eg. 'catd rebuild --only s2', tallies all its tracks.
+func isIndexedCell(ct CatTrack) bool {
+	_, ok := ct.Properties["Count"]
+	return ok
+}
@@ -0,0 +1,50 @@
+package cattrack
+
+// MultiIndexT is an Indexer implementation composing many Indexers,
+// each indexing and applying its own properties to the same track,
+// eg. an OffsetIndexT with a SpeedIndexT and a HourOfWeekIndexT.
+// The Indexers should not share property names.
+type MultiIndexT struct {
+	Indexers []Indexer
+}
+
+func (ix *MultiIndexT) IsEmpty() bool {
+	if ix == nil {
+		return true
+	}
+	for _, idxr := range ix.Indexers {
+		if idxr != nil && !idxr.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+func (ix *MultiIndexT) FromCatTrack(ct CatTrack) Indexer {
+	out := &MultiIndexT{Indexers: make([]Indexer, len(ix.Indexers))}
+	for i, idxr := range ix.Indexers {
+		out.Indexers[i] = idxr.FromCatTrack(ct)
+	}
+	return out
+}
+
+func (ix *MultiIndexT) Index(old, next Indexer) Indexer {
+	nextT := next.(*MultiIndexT)
+	out := &MultiIndexT{Indexers: make([]Indexer, len(ix.Indexers))}
+	for i, idxr := range ix.Indexers {
+		var oldI Indexer
+		if old != nil {
+			oldI = old.(*MultiIndexT).Indexers[i]
+		}
+		out.Indexers[i] = idxr.Index(oldI, nextT.Indexers[i])
+	}
+	return out
+}
+
+func (ix *MultiIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*MultiIndexT)
+	for i, child := range ix.Indexers {
+		ct = child.ApplyToCatTrack(ixr.Indexers[i], ct)
+	}
+	return ct
+}
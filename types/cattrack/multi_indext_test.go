@@ -0,0 +1,100 @@
+package cattrack
+
+import (
+	"encoding/json"
+	"github.com/paulmach/orb"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMultiIndexT_Index(t *testing.T) {
+	ix := &MultiIndexT{Indexers: []Indexer{
+		&OffsetIndexT{},
+		&SpeedIndexT{},
+		&ElevationIndexT{},
+		&HourOfWeekIndexT{},
+		&DeviceIndexT{},
+	}}
+
+	// Saturday noon, solar time, in Minneapolis (-93.25 = UTC-6).
+	t0 := time.Date(2024, 12, 7, 18, 0, 0, 0, time.UTC)
+	var idx Indexer
+	var last CatTrack
+	for i := 0; i < 10; i++ {
+		ct := *NewCatTrack(orb.Point{-93.25, 44.98})
+		ct.SetPropertiesSafe(map[string]interface{}{
+			"Name":      "rye",
+			"UUID":      []string{"a", "b"}[i%2],
+			"Time":      t0.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+			"Speed":     float64(i),
+			"Elevation": 250.0 + float64(i),
+		})
+		if i == 9 {
+			ct.SetPropertySafe("Speed", -1.0) // Invalid.
+			ct.DeletePropertySafe("Elevation")
+		}
+		idx = ix.Index(idx, ix.FromCatTrack(ct))
+		last = ix.ApplyToCatTrack(idx, ct)
+
+		// Round trip, as if stored.
+		b, err := json.Marshal(last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = CatTrack{}
+		if err := json.Unmarshal(b, &last); err != nil {
+			t.Fatal(err)
+		}
+		idx = ix.FromCatTrack(last)
+	}
+
+	props := last.Properties
+	if v := props.MustInt("Count", 0); v != 10 {
+		t.Errorf("got Count %d, want 10", v)
+	}
+	if v := props.MustInt("Speed.Count", 0); v != 9 {
+		t.Errorf("got Speed.Count %d, want 9", v)
+	}
+	if v := props.MustFloat64("Speed.Mean", 0); v != 4 {
+		t.Errorf("got Speed.Mean %v, want 4", v)
+	}
+	if v := props.MustFloat64("Speed.Max", 0); v != 8 {
+		t.Errorf("got Speed.Max %v, want 8", v)
+	}
+	if v := props.MustFloat64("Speed.P50", 0); v < 3 || v > 5 {
+		t.Errorf("got Speed.P50 %v, want about 4", v)
+	}
+	if v := props.MustFloat64("Elevation.Range", 0); v != 8 {
+		t.Errorf("got Elevation.Range %v, want 8", v)
+	}
+	if v := props.MustInt("HourOfWeek.Peak", 0); v != 6*24+12 {
+		t.Errorf("got HourOfWeek.Peak %d, want Saturday noon", v)
+	}
+	if v := props.MustFloat64("HourOfWeek.Weekend", 0); v != 1 {
+		t.Errorf("got HourOfWeek.Weekend %v, want 1", v)
+	}
+	if v := props.MustInt("DeviceCount", 0); v != 2 {
+		t.Errorf("got DeviceCount %d, want 2", v)
+	}
+}
+
+func TestSpeedIndexT_Percentile(t *testing.T) {
+	ix := &SpeedIndexT{}
+	var idx Indexer
+	for i := 0; i < 100; i++ {
+		ct := *NewCatTrack(orb.Point{})
+		ct.SetPropertySafe("Speed", 30.0)
+		if i < 80 {
+			ct.SetPropertySafe("Speed", 1.2)
+		}
+		idx = ix.Index(idx, ix.FromCatTrack(ct))
+	}
+	speeds := idx.(*SpeedIndexT)
+	if v := speeds.Percentile(0.5); v < 1 || v > 1.5 {
+		t.Errorf("got p50 %v, want in the 1-1.5 bin", v)
+	}
+	if v := speeds.Percentile(0.9); math.Abs(v-30) > 0.001 {
+		t.Errorf("got p90 %v, want 30", v)
+	}
+}
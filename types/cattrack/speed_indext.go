@@ -0,0 +1,136 @@
+package cattrack
+
+import (
+	"math"
+)
+
+// SpeedIndexTBins are the lower edges of the SpeedIndexT histogram bins, in meters per second.
+// The last bin is open-ended.
+var SpeedIndexTBins = []float64{
+	0, 0.5, 1, 1.5, 2, 3, 4, 5, 7, 10, 13, 17, 22, 28, 35, 45, 60, 80, 110, 150, 250,
+}
+
+// SpeedIndexT is an Indexer implementation that tracks the speeds of the tracks in a cell:
+// min, mean and max, and a histogram sketch of the speeds (see SpeedIndexTBins)
+// from which percentiles are estimated.
+// Tracks without a valid (non-negative) Speed are not counted.
+type SpeedIndexT struct {
+	Count int
+	Min   float64
+	Max   float64
+	Sum   float64
+	Bins  []int
+}
+
+func (ix *SpeedIndexT) IsEmpty() bool {
+	return ix == nil || ix.Count == 0
+}
+
+func (*SpeedIndexT) FromCatTrack(ct CatTrack) Indexer {
+	if _, ok := ct.Properties["Speed.Count"]; ok {
+		return &SpeedIndexT{
+			Count: ct.Properties.MustInt("Speed.Count", 0),
+			Min:   ct.Properties.MustFloat64("Speed.Min", 0),
+			Max:   ct.Properties.MustFloat64("Speed.Max", 0),
+			Sum:   ct.Properties.MustFloat64("Speed.Sum", 0),
+			Bins:  propertyInts(ct.Properties["Speed.Bins"], len(SpeedIndexTBins)),
+		}
+	}
+	if isIndexedCell(ct) {
+		return &SpeedIndexT{}
+	}
+	speed := ct.Properties.MustFloat64("Speed", -1)
+	if speed < 0 {
+		return &SpeedIndexT{}
+	}
+	bins := make([]int, len(SpeedIndexTBins))
+	bins[speedIndexTBin(speed)]++
+	return &SpeedIndexT{Count: 1, Min: speed, Max: speed, Sum: speed, Bins: bins}
+}
+
+func (*SpeedIndexT) Index(old, next Indexer) Indexer {
+	if old == nil || old.IsEmpty() {
+		return next
+	}
+	if next == nil || next.IsEmpty() {
+		return old
+	}
+	oldT, nextT := old.(*SpeedIndexT), next.(*SpeedIndexT)
+	out := &SpeedIndexT{
+		Count: oldT.Count + nextT.Count,
+		Min:   math.Min(oldT.Min, nextT.Min),
+		Max:   math.Max(oldT.Max, nextT.Max),
+		Sum:   oldT.Sum + nextT.Sum,
+		Bins:  make([]int, len(SpeedIndexTBins)),
+	}
+	for i := range out.Bins {
+		out.Bins[i] = oldT.Bins[i] + nextT.Bins[i]
+	}
+	return out
+}
+
+func (*SpeedIndexT) ApplyToCatTrack(idxr Indexer, ct CatTrack) CatTrack {
+	ixr := idxr.(*SpeedIndexT)
+	if ixr.IsEmpty() {
+		return ct
+	}
+	ct.SetPropertiesSafe(map[string]interface{}{
+		"Speed.Count": ixr.Count,
+		"Speed.Min":   ixr.Min,
+		"Speed.Max":   ixr.Max,
+		"Speed.Sum":   ixr.Sum,
+		"Speed.Mean":  ixr.Sum / float64(ixr.Count),
+		"Speed.P50":   ixr.Percentile(0.5),
+		"Speed.P90":   ixr.Percentile(0.9),
+		"Speed.Bins":  ixr.Bins,
+	})
+	return ct
+}
+
+// Percentile estimates the q (0-1) percentile speed from the histogram,
+// interpolating within the bin and clamping to the min and max.
+func (ix *SpeedIndexT) Percentile(q float64) float64 {
+	if ix.IsEmpty() {
+		return 0
+	}
+	rank := q * float64(ix.Count)
+	seen := 0.0
+	for i, n := range ix.Bins {
+		if n == 0 || seen+float64(n) < rank {
+			seen += float64(n)
+			continue
+		}
+		lo, hi := SpeedIndexTBins[i], ix.Max
+		if i+1 < len(SpeedIndexTBins) {
+			hi = SpeedIndexTBins[i+1]
+		}
+		v := lo + (hi-lo)*(rank-seen)/float64(n)
+		return math.Max(ix.Min, math.Min(ix.Max, v))
+	}
+	return ix.Max
+}
+
+func speedIndexTBin(speed float64) int {
+	for i := len(SpeedIndexTBins) - 1; i > 0; i-- {
+		if speed >= SpeedIndexTBins[i] {
+			return i
+		}
+	}
+	return 0
+}
+
+// propertyInts reads a slice of ints property, as set or as decoded from JSON, with length n.
+func propertyInts(v any, n int) []int {
+	out := make([]int, n)
+	switch vs := v.(type) {
+	case []int:
+		copy(out, vs)
+	case []interface{}:
+		for i := 0; i < len(vs) && i < n; i++ {
+			if f, ok := vs[i].(float64); ok {
+				out[i] = int(f)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,336 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/rotblauer/catd/types/activity"
+	"github.com/rotblauer/catd/types/cattrack"
+	"github.com/rotblauer/catd/types/trackpoint"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ImportFormat is a format of posted or populated tracks.
+type ImportFormat string
+
+const (
+	// ImportFormatJSON is GeoJSON features, or O.G. TrackPoints, see DecodingJSONTrackObject.
+	ImportFormatJSON ImportFormat = "json"
+	ImportFormatGPX  ImportFormat = "gpx"
+	ImportFormatTCX  ImportFormat = "tcx"
+	// ImportFormatNMEA is raw NMEA 0183 $GPRMC and $GPGGA sentences, one per line.
+	ImportFormatNMEA ImportFormat = "nmea"
+)
+
+var ImportFormats = []ImportFormat{ImportFormatJSON, ImportFormatGPX, ImportFormatTCX, ImportFormatNMEA}
+
+// ParseImportFormat parses an import format name. The empty string is sniffed, see SniffImportFormat.
+func ParseImportFormat(s string) (ImportFormat, error) {
+	if s == "" || s == "auto" {
+		return "", nil
+	}
+	f := ImportFormat(strings.ToLower(s))
+	if !slices.Contains(ImportFormats, f) {
+		return "", fmt.Errorf("unknown import format %q (valid: %v)", s, ImportFormats)
+	}
+	return f, nil
+}
+
+// importDefaultAccuracy is the accuracy, in meters, of imported points without a reported HDOP.
+const importDefaultAccuracy = 10.0
+
+// importUERE is the user equivalent range error, in meters, scaling HDOP to accuracy.
+const importUERE = 5.0
+
+// SniffImportFormat guesses the format of the leading bytes of a body.
+// JSON is the default.
+func SniffImportFormat(peek []byte) ImportFormat {
+	peek = bytes.TrimPrefix(peek, []byte("\xef\xbb\xbf"))
+	peek = bytes.TrimLeft(peek, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(peek, []byte("$")):
+		return ImportFormatNMEA
+	case bytes.HasPrefix(peek, []byte("<")):
+		if bytes.Contains(peek, []byte("<TrainingCenterDatabase")) {
+			return ImportFormatTCX
+		}
+		return ImportFormatGPX
+	}
+	return ImportFormatJSON
+}
+
+// DecodeImportTracks decodes tracks from the body in the format, sniffing it if empty,
+// calling onEach for each track.
+// GPX, TCX and NMEA tracks are attributed to the named cat, which is required for them.
+// JSON tracks name their own cats.
+func DecodeImportTracks(body io.Reader, format ImportFormat, catName string, onEach func(ct *cattrack.CatTrack) error) error {
+	buf := bufio.NewReaderSize(body, 4096)
+	if format == "" {
+		peek, err := buf.Peek(1024)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+		format = SniffImportFormat(peek)
+	}
+	if format == ImportFormatJSON {
+		return ScanJSONMessages(buf, func(message json.RawMessage) error {
+			return DecodingJSONTrackObject(message, onEach)
+		})
+	}
+	if catName == "" {
+		return fmt.Errorf("a cat name is required to import %s", format)
+	}
+	imp := &importer{name: catName, format: format, onEach: onEach}
+	switch format {
+	case ImportFormatGPX:
+		return imp.decodeGPX(buf)
+	case ImportFormatTCX:
+		return imp.decodeTCX(buf)
+	case ImportFormatNMEA:
+		return imp.decodeNMEA(buf)
+	}
+	return fmt.Errorf("unknown import format %q", format)
+}
+
+// importer converts the points of an imported format to cat tracks.
+type importer struct {
+	name   string
+	format ImportFormat
+	onEach func(ct *cattrack.CatTrack) error
+
+	prev *trackpoint.TrackPoint
+}
+
+// importPoint is a point decoded from an imported format.
+// Zero optional values are unreported.
+type importPoint struct {
+	lon, lat  float64
+	time      time.Time
+	elevation float64
+	hdop      float64
+	speed     float64 // m/s
+	heading   float64
+	heartRate float64
+	activity  activity.Activity
+}
+
+// send converts the point to a track.
+// Unreported speeds and headings are calculated from the previous point.
+func (imp *importer) send(p importPoint) error {
+	if p.time.IsZero() {
+		return nil
+	}
+	tp := &trackpoint.TrackPoint{
+		Uuid:      "import-" + string(imp.format),
+		Version:   "catd-import",
+		Name:      imp.name,
+		Lat:       p.lat,
+		Lng:       p.lon,
+		Accuracy:  importDefaultAccuracy,
+		Elevation: p.elevation,
+		Speed:     p.speed,
+		Heading:   p.heading,
+		HeartRate: p.heartRate,
+		Time:      p.time,
+	}
+	if p.hdop > 0 {
+		tp.Accuracy = p.hdop * importUERE
+	}
+	if prev := imp.prev; prev != nil && p.time.After(prev.Time) {
+		a, b := orb.Point{prev.Lng, prev.Lat}, orb.Point{tp.Lng, tp.Lat}
+		if tp.Speed == 0 {
+			tp.Speed = geo.Distance(a, b) / p.time.Sub(prev.Time).Seconds()
+		}
+		if tp.Heading == 0 && a != b {
+			tp.Heading = geo.Bearing(a, b)
+			if tp.Heading < 0 {
+				tp.Heading += 360
+			}
+		}
+	}
+	imp.prev = tp
+	ct := (*cattrack.CatTrack)(TrackToFeature(tp))
+	if p.activity != activity.TrackerStateUnknown {
+		ct.Properties["Activity"] = p.activity.String()
+	}
+	return imp.onEach(ct)
+}
+
+// importActivity returns the activity named by a GPX track type or TCX sport, eg. "Biking".
+func importActivity(s string) activity.Activity {
+	if strings.EqualFold(s, "cycling") || strings.EqualFold(s, "ride") {
+		return activity.TrackerStateBike
+	}
+	return activity.FromString(s)
+}
+
+// xmlAny is any XML element, used to search extensions.
+type xmlAny struct {
+	XMLName  xml.Name
+	Value    string   `xml:",chardata"`
+	Children []xmlAny `xml:",any"`
+}
+
+// find returns the value of the first descendant with the local name.
+func (x xmlAny) find(local string) (string, bool) {
+	for _, c := range x.Children {
+		if c.XMLName.Local == local {
+			return strings.TrimSpace(c.Value), true
+		}
+		if v, ok := c.find(local); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (x xmlAny) findFloat(local string) float64 {
+	v, ok := x.find(local)
+	if !ok {
+		return 0
+	}
+	var f float64
+	if _, err := fmt.Sscan(v, &f); err != nil {
+		return 0
+	}
+	return f
+}
+
+type gpxTrkpt struct {
+	Lat        float64 `xml:"lat,attr"`
+	Lon        float64 `xml:"lon,attr"`
+	Ele        float64 `xml:"ele"`
+	Time       string  `xml:"time"`
+	HDOP       float64 `xml:"hdop"`
+	Extensions xmlAny  `xml:"extensions"`
+}
+
+// decodeGPX decodes GPX 1.0 and 1.1 track points, streaming.
+// Heart rate and speed are read from extensions, eg. Garmin's TrackPointExtension.
+// Waypoints and routes, lacking times, are ignored.
+func (imp *importer) decodeGPX(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	act := activity.TrackerStateUnknown
+	inTrk := false
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("gpx: %w", err)
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "trk":
+				inTrk, act = true, activity.TrackerStateUnknown
+			case "type":
+				if !inTrk {
+					continue
+				}
+				var v string
+				if err := dec.DecodeElement(&v, &el); err != nil {
+					return fmt.Errorf("gpx: %w", err)
+				}
+				act = importActivity(strings.TrimSpace(v))
+			case "trkpt":
+				pt := gpxTrkpt{}
+				if err := dec.DecodeElement(&pt, &el); err != nil {
+					return fmt.Errorf("gpx: %w", err)
+				}
+				t, err := time.Parse(time.RFC3339, strings.TrimSpace(pt.Time))
+				if err != nil {
+					continue
+				}
+				if err := imp.send(importPoint{
+					lon:       pt.Lon,
+					lat:       pt.Lat,
+					time:      t,
+					elevation: pt.Ele,
+					hdop:      pt.HDOP,
+					speed:     pt.Extensions.findFloat("speed"),
+					heartRate: pt.Extensions.findFloat("hr"),
+					activity:  act,
+				}); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local == "trk" {
+				inTrk = false
+			}
+		}
+	}
+}
+
+type tcxTrackpoint struct {
+	Time     string `xml:"Time"`
+	Position *struct {
+		Lat float64 `xml:"LatitudeDegrees"`
+		Lon float64 `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+	Altitude   float64 `xml:"AltitudeMeters"`
+	HeartRate  float64 `xml:"HeartRateBpm>Value"`
+	Extensions xmlAny  `xml:"Extensions"`
+}
+
+// decodeTCX decodes Garmin Training Center track points, streaming.
+// Activities' sports become the tracks' activities. Trackpoints without positions are ignored.
+func (imp *importer) decodeTCX(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	act := activity.TrackerStateUnknown
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tcx: %w", err)
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch el.Name.Local {
+		case "Activity":
+			act = activity.TrackerStateUnknown
+			for _, attr := range el.Attr {
+				if attr.Name.Local == "Sport" {
+					act = importActivity(attr.Value)
+				}
+			}
+		case "Trackpoint":
+			tp := tcxTrackpoint{}
+			if err := dec.DecodeElement(&tp, &el); err != nil {
+				return fmt.Errorf("tcx: %w", err)
+			}
+			if tp.Position == nil {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(tp.Time))
+			if err != nil {
+				continue
+			}
+			if err := imp.send(importPoint{
+				lon:       tp.Position.Lon,
+				lat:       tp.Position.Lat,
+				time:      t,
+				elevation: tp.Altitude,
+				speed:     tp.Extensions.findFloat("Speed"),
+				heartRate: tp.HeartRate,
+				activity:  act,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
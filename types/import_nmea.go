@@ -0,0 +1,172 @@
+package types
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knotsToMetersPerSecond converts NMEA speeds.
+const knotsToMetersPerSecond = 0.514444
+
+// nmeaFix is a position fix, merged from the RMC and GGA sentences of one time of day.
+type nmeaFix struct {
+	timeOfDay string
+	importPoint
+	ok bool
+}
+
+// decodeNMEA decodes $GPRMC and $GPGGA sentences, and their GNSS ($GN..) equivalents.
+// Sentences sharing a time of day are merged into one track:
+// RMC reports the date, speed and course; GGA the elevation and HDOP.
+// GGA sentences are dated by the last RMC sentence; fixes before any date are dropped.
+// Sentences with bad checksums, or without a valid fix, are ignored.
+func (imp *importer) decodeNMEA(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var date time.Time
+	fix := &nmeaFix{}
+	flush := func() error {
+		defer func() { fix = &nmeaFix{} }()
+		if !fix.ok || date.IsZero() {
+			return nil
+		}
+		tod, err := time.Parse("150405.999999999", fix.timeOfDay)
+		if err != nil {
+			return nil
+		}
+		fix.time = time.Date(date.Year(), date.Month(), date.Day(),
+			tod.Hour(), tod.Minute(), tod.Second(), tod.Nanosecond(), time.UTC)
+		return imp.send(fix.importPoint)
+	}
+	for scanner.Scan() {
+		fields, ok := parseNMEASentence(scanner.Text())
+		if !ok || len(fields[0]) != 5 {
+			continue
+		}
+		kind := fields[0][2:]
+		if kind != "RMC" && kind != "GGA" {
+			continue
+		}
+		if len(fields) < 7 {
+			continue
+		}
+		if fields[1] != fix.timeOfDay {
+			if err := flush(); err != nil {
+				return err
+			}
+			fix.timeOfDay = fields[1]
+		}
+		switch kind {
+		case "RMC":
+			// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,...
+			if len(fields) < 10 || fields[2] != "A" {
+				continue
+			}
+			d, err := time.Parse("020106", fields[9])
+			if err != nil {
+				continue
+			}
+			lat, lon, ok := parseNMEALatLon(fields[3], fields[4], fields[5], fields[6])
+			if !ok {
+				continue
+			}
+			date = d
+			fix.lat, fix.lon, fix.ok = lat, lon, true
+			if v, err := strconv.ParseFloat(fields[7], 64); err == nil {
+				fix.speed = v * knotsToMetersPerSecond
+			}
+			if v, err := strconv.ParseFloat(fields[8], 64); err == nil {
+				fix.heading = v
+			}
+		case "GGA":
+			// $GPGGA,hhmmss.ss,llll.ll,a,yyyyy.yy,a,q,nn,h.h,aaa.a,M,...
+			if len(fields) < 10 || fields[6] == "" || fields[6] == "0" {
+				continue
+			}
+			lat, lon, ok := parseNMEALatLon(fields[2], fields[3], fields[4], fields[5])
+			if !ok {
+				continue
+			}
+			fix.lat, fix.lon, fix.ok = lat, lon, true
+			if v, err := strconv.ParseFloat(fields[8], 64); err == nil {
+				fix.hdop = v
+			}
+			if v, err := strconv.ParseFloat(fields[9], 64); err == nil {
+				fix.elevation = v
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("nmea: %w", err)
+	}
+	return flush()
+}
+
+// parseNMEASentence returns the comma-separated fields of a sentence, the first being its talker and type, eg. GPRMC.
+// It returns false if the sentence is malformed, or its checksum, if any, is wrong.
+func parseNMEASentence(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return nil, false
+	}
+	body := line[1:]
+	if i := strings.LastIndexByte(body, '*'); i >= 0 {
+		want, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		body = body[:i]
+		var sum byte
+		for j := 0; j < len(body); j++ {
+			sum ^= body[j]
+		}
+		if uint64(sum) != want {
+			return nil, false
+		}
+	}
+	return strings.Split(body, ","), true
+}
+
+// parseNMEALatLon parses NMEA ddmm.mmmm,N and dddmm.mmmm,E coordinates to degrees.
+func parseNMEALatLon(lat, ns, lon, ew string) (float64, float64, bool) {
+	parse := func(v string, degDigits int) (float64, bool) {
+		if len(v) < degDigits+2 {
+			return 0, false
+		}
+		deg, err := strconv.ParseFloat(v[:degDigits], 64)
+		if err != nil {
+			return 0, false
+		}
+		min, err := strconv.ParseFloat(v[degDigits:], 64)
+		if err != nil {
+			return 0, false
+		}
+		return deg + min/60, true
+	}
+	la, ok := parse(lat, 2)
+	if !ok {
+		return 0, 0, false
+	}
+	lo, ok := parse(lon, 3)
+	if !ok {
+		return 0, 0, false
+	}
+	switch ns {
+	case "S":
+		la = -la
+	case "N":
+	default:
+		return 0, 0, false
+	}
+	switch ew {
+	case "W":
+		lo = -lo
+	case "E":
+	default:
+		return 0, 0, false
+	}
+	return la, lo, true
+}
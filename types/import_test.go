@@ -0,0 +1,166 @@
+package types
+
+import (
+	"bytes"
+	"github.com/rotblauer/catd/types/cattrack"
+	"math"
+	"testing"
+	"time"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="Garmin" xmlns="http://www.topografix.com/GPX/1/1"
+  xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1">
+  <wpt lat="42.36" lon="-71.06"><name>Home</name></wpt>
+  <trk>
+    <name>Morning Ride</name>
+    <type>cycling</type>
+    <trkseg>
+      <trkpt lat="42.3601" lon="-71.0589"><ele>12.5</ele><time>2012-05-01T12:00:00Z</time><hdop>1.2</hdop>
+        <extensions><gpxtpx:TrackPointExtension><gpxtpx:hr>131</gpxtpx:hr></gpxtpx:TrackPointExtension></extensions>
+      </trkpt>
+      <trkpt lat="42.3611" lon="-71.0589"><ele>13</ele><time>2012-05-01T12:00:20Z</time></trkpt>
+      <trkpt lat="42.3621" lon="-71.0589"><ele>13</ele></trkpt>
+    </trkseg>
+  </trk>
+</gpx>
+`
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Running">
+      <Id>2012-05-01T12:00:00Z</Id>
+      <Lap StartTime="2012-05-01T12:00:00Z">
+        <Track>
+          <Trackpoint>
+            <Time>2012-05-01T12:00:00Z</Time>
+            <Position><LatitudeDegrees>42.3601</LatitudeDegrees><LongitudeDegrees>-71.0589</LongitudeDegrees></Position>
+            <AltitudeMeters>12.5</AltitudeMeters>
+            <HeartRateBpm><Value>140</Value></HeartRateBpm>
+            <Extensions><TPX xmlns="http://www.garmin.com/xmlschemas/ActivityExtension/v2"><Speed>3.1</Speed></TPX></Extensions>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2012-05-01T12:00:05Z</Time>
+            <HeartRateBpm><Value>141</Value></HeartRateBpm>
+          </Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>
+`
+
+// testNMEA has a GGA before any date, a merged RMC+GGA fix, a bad checksum, and an RMC-only fix.
+const testNMEA = `$GPGGA,123518,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*46
+$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A
+$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47
+$GPRMC,123520,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*00
+$GPRMC,123521,A,4807.100,S,01131.000,W,010.0,090.0,230394,003.1,W
+$GPRMC,123522,V,4807.100,S,01131.000,W,010.0,090.0,230394,003.1,W
+`
+
+func decodeImportTest(t *testing.T, input string, format ImportFormat) []*cattrack.CatTrack {
+	t.Helper()
+	out := []*cattrack.CatTrack{}
+	err := DecodeImportTracks(bytes.NewBufferString(input), format, "ia", func(ct *cattrack.CatTrack) error {
+		if err := ct.Validate(); err != nil {
+			t.Errorf("invalid track: %v", err)
+		}
+		out = append(out, ct)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSniffImportFormat(t *testing.T) {
+	cases := map[string]ImportFormat{
+		testGPX:                     ImportFormatGPX,
+		testTCX:                     ImportFormatTCX,
+		testNMEA:                    ImportFormatNMEA,
+		"\xef\xbb\xbf  " + testNMEA: ImportFormatNMEA,
+		`{"type": "Feature"}`:       ImportFormatJSON,
+		`[{"uuid": "a"}]`:           ImportFormatJSON,
+		``:                          ImportFormatJSON,
+	}
+	for input, want := range cases {
+		if got := SniffImportFormat([]byte(input)); got != want {
+			t.Errorf("%.20q: got %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestDecodeImportTracks_GPX(t *testing.T) {
+	tracks := decodeImportTest(t, testGPX, "")
+	if len(tracks) != 2 {
+		t.Fatalf("expected 2 timed track points, got %d", len(tracks))
+	}
+	first, second := tracks[0], tracks[1]
+	if first.CatID() != "ia" || !first.MustTime().Equal(time.Date(2012, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first track %s", first.StringPretty())
+	}
+	if first.Properties.MustFloat64("HeartRate") != 131 || first.Properties.MustFloat64("Elevation") != 12.5 {
+		t.Errorf("expected heart rate and elevation, got %v", first.Properties)
+	}
+	if first.Properties.MustFloat64("Accuracy") != 6 || second.Properties.MustFloat64("Accuracy") != importDefaultAccuracy {
+		t.Errorf("expected accuracy from hdop, else default, got %v, %v",
+			first.Properties["Accuracy"], second.Properties["Accuracy"])
+	}
+	// About 111m in 20s, due north.
+	if speed := second.Properties.MustFloat64("Speed"); math.Abs(speed-5.56) > 0.1 {
+		t.Errorf("expected calculated speed, got %v", speed)
+	}
+	if heading := second.Properties.MustFloat64("Heading"); heading > 1 {
+		t.Errorf("expected calculated heading north, got %v", heading)
+	}
+	if first.Properties["Activity"] != "Bike" {
+		t.Errorf("expected Bike activity from track type, got %v", first.Properties["Activity"])
+	}
+
+	if err := DecodeImportTracks(bytes.NewBufferString(testGPX), ImportFormatGPX, "", func(ct *cattrack.CatTrack) error {
+		return nil
+	}); err == nil {
+		t.Error("expected missing cat name error")
+	}
+}
+
+func TestDecodeImportTracks_TCX(t *testing.T) {
+	tracks := decodeImportTest(t, testTCX, "")
+	if len(tracks) != 1 {
+		t.Fatalf("expected 1 positioned track point, got %d", len(tracks))
+	}
+	ct := tracks[0]
+	if ct.Properties.MustFloat64("HeartRate") != 140 || ct.Properties.MustFloat64("Speed") != 3.1 {
+		t.Errorf("expected heart rate and speed, got %v", ct.Properties)
+	}
+	if ct.Properties["Activity"] != "Running" {
+		t.Errorf("expected Running activity from sport, got %v", ct.Properties["Activity"])
+	}
+}
+
+func TestDecodeImportTracks_NMEA(t *testing.T) {
+	tracks := decodeImportTest(t, testNMEA, "")
+	if len(tracks) != 2 {
+		t.Fatalf("expected 2 fixes, got %d", len(tracks))
+	}
+	merged := tracks[0]
+	if !merged.MustTime().Equal(time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", merged.MustTime())
+	}
+	pt := merged.Point()
+	if math.Abs(pt.Lat()-48.1173) > 1e-4 || math.Abs(pt.Lon()-11.516667) > 1e-4 {
+		t.Errorf("unexpected point %v", pt)
+	}
+	if merged.Properties.MustFloat64("Elevation") != 545.4 || merged.Properties.MustFloat64("Accuracy") != 4.5 {
+		t.Errorf("expected merged GGA elevation and accuracy, got %v", merged.Properties)
+	}
+	if speed := merged.Properties.MustFloat64("Speed"); math.Abs(speed-11.52) > 0.01 {
+		t.Errorf("expected speed from knots, got %v", speed)
+	}
+	if pt := tracks[1].Point(); pt.Lat() > 0 || pt.Lon() > 0 {
+		t.Errorf("expected south-west point, got %v", pt)
+	}
+}